@@ -1,16 +1,27 @@
 package api
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/tl"
 	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/provider"
 	"github.com/xssnick/tonutils-storage/storage"
 	"math/bits"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 )
 
+var startedAt = time.Now()
+
 type Error struct {
 	Error string `json:"error"`
 }
@@ -34,14 +45,79 @@ type Peer struct {
 	ID            string `json:"id"`
 	UploadSpeed   uint64 `json:"upload_speed"`
 	DownloadSpeed uint64 `json:"download_speed"`
+	Client        string `json:"client,omitempty"`
+	AnnounceData  []byte `json:"announce_data,omitempty"`
+	// Pieces - number of pieces of the bag which peer has
+	Pieces uint32 `json:"pieces"`
+}
+
+// Concurrency - download parallelism of bag, zero values are defaults of node
+type Concurrency struct {
+	Threads      int `json:"threads"`
+	Prefetch     int `json:"prefetch"`
+	PeerRequests int `json:"peer_requests"`
+	Peers        int `json:"peers"`
+}
+
+func (c *Concurrency) toStorage() storage.DownloadConcurrency {
+	return storage.DownloadConcurrency{
+		Threads:      c.Threads,
+		Prefetch:     c.Prefetch,
+		PeerRequests: c.PeerRequests,
+		Peers:        c.Peers,
+	}
+}
+
+type BannedNodes struct {
+	Nodes []string `json:"nodes"`
 }
 
 type BagDetailed struct {
 	Bag
-	BagPiecesNum  uint32 `json:"bag_pieces_num"`
-	HasPiecesMask []byte `json:"has_pieces_mask"`
-	Files         []File `json:"files"`
-	Peers         []Peer `json:"peers"`
+	BagPiecesNum  uint32       `json:"bag_pieces_num"`
+	HasPiecesMask []byte       `json:"has_pieces_mask"`
+	Files         []File       `json:"files"`
+	Peers         []Peer       `json:"peers"`
+	BannedPeers   []BannedPeer `json:"banned_peers,omitempty"`
+	AnnounceData  []byte       `json:"announce_data,omitempty"`
+	// HotPieces - number of first pieces which are kept in memory, 0 when bag is not hot
+	HotPieces uint32 `json:"hot_pieces,omitempty"`
+	// AllowedPeers - ADNL ids of nodes which can access bag, empty when all nodes can
+	AllowedPeers []string `json:"allowed_peers,omitempty"`
+	// Unlisted - bag is not announced in DHT
+	Unlisted bool `json:"unlisted,omitempty"`
+	// Announce - state of announce of our node in DHT record of bag
+	Announce *Announce `json:"announce,omitempty"`
+}
+
+type Announce struct {
+	// AnnouncedAt - time of the last announce, 0 when bag was not announced since start
+	AnnouncedAt int64  `json:"announced_at"`
+	Error       string `json:"error,omitempty"`
+	// Interval - seconds between re-announces of bag
+	Interval uint64 `json:"interval"`
+	// CustomInterval - interval is set for this bag, otherwise default one is used
+	CustomInterval bool `json:"custom_interval"`
+}
+
+// Queue - download queue, Limit is max number of simultaneously downloading bags, 0 = unlimited
+type Queue struct {
+	Limit int         `json:"limit"`
+	Bags  []QueuedBag `json:"bags"`
+}
+
+type QueuedBag struct {
+	BagID    string `json:"bag_id"`
+	Priority int    `json:"priority"`
+	// Active - bag is downloading, Position - place of waiting bag in queue starting from 1
+	Active   bool  `json:"active"`
+	Position int   `json:"position,omitempty"`
+	Since    int64 `json:"since"`
+}
+
+type BannedPeer struct {
+	ID    string `json:"id"`
+	Until int64  `json:"until"`
 }
 
 type Bag struct {
@@ -59,6 +135,33 @@ type Bag struct {
 	InfoLoaded    bool   `json:"info_loaded"`
 	Active        bool   `json:"active"`
 	Seeding       bool   `json:"seeding"`
+	Private       bool   `json:"private"`
+	// SeedOnly - missing pieces are not downloaded, bag is only seeded
+	SeedOnly bool `json:"seed_only,omitempty"`
+	// Queued - bag is waiting for download slot, Priority - its position in download queue
+	Queued   bool `json:"queued,omitempty"`
+	Priority int  `json:"priority,omitempty"`
+	// Transferred - bytes downloaded and uploaded for bag during its whole lifetime, kept across restarts
+	Transferred TransferStats `json:"transferred"`
+	ExpiresAt   int64         `json:"expires_at,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Quarantine  string        `json:"quarantine,omitempty"`
+}
+
+// Receipt - signed acknowledgment of bytes received by peer from us, SignedData is boxed TL of receipt,
+// signature of it can be verified with downloader key, and ADNL id of peer is derived from this key
+type Receipt struct {
+	PeerID        string `json:"peer_id"`
+	DownloaderKey string `json:"downloader_key"`
+	SessionID     int64  `json:"session_id"`
+	Bytes         int64  `json:"bytes"`
+	CreatedAt     int64  `json:"created_at"`
+	SignedData    []byte `json:"signed_data"`
+	Signature     []byte `json:"signature"`
+}
+
+type Receipts struct {
+	Receipts []Receipt `json:"receipts"`
 }
 
 type List struct {
@@ -69,15 +172,112 @@ type Created struct {
 	BagID string `json:"bag_id"`
 }
 
+type BagsStats struct {
+	Total       uint64 `json:"total"`
+	Active      uint64 `json:"active"`
+	Downloading uint64 `json:"downloading"`
+	Seeding     uint64 `json:"seeding"`
+	Completed   uint64 `json:"completed"`
+	Stopped     uint64 `json:"stopped"`
+}
+
+type TransferStats struct {
+	Downloaded uint64 `json:"downloaded"`
+	Uploaded   uint64 `json:"uploaded"`
+}
+
+type Stats struct {
+	Bags          BagsStats     `json:"bags"`
+	StoredBytes   uint64        `json:"stored_bytes"`
+	DownloadSpeed uint64        `json:"download_speed"`
+	UploadSpeed   uint64        `json:"upload_speed"`
+	Session       TransferStats `json:"session"`
+	Lifetime      TransferStats `json:"lifetime"`
+	Peers         uint64        `json:"peers"`
+	BagPeers      uint64        `json:"bag_peers"`
+	// DHTNodes - size of DHT routing table
+	DHTNodes uint64 `json:"dht_nodes"`
+	Uptime   uint64 `json:"uptime"`
+}
+
+type HistoryPoint struct {
+	Time             int64  `json:"time"`
+	Downloaded       uint64 `json:"downloaded"`
+	Uploaded         uint64 `json:"uploaded"`
+	DownloadSpeed    uint64 `json:"download_speed"`
+	UploadSpeed      uint64 `json:"upload_speed"`
+	MaxDownloadSpeed uint64 `json:"max_download_speed"`
+	MaxUploadSpeed   uint64 `json:"max_upload_speed"`
+	Peers            uint64 `json:"peers"`
+	StoredBytes      uint64 `json:"stored_bytes"`
+	Bags             uint64 `json:"bags"`
+	ActiveBags       uint64 `json:"active_bags"`
+	CompletedBags    uint64 `json:"completed_bags"`
+}
+
+type History struct {
+	Points []HistoryPoint `json:"points"`
+}
+
+type Job struct {
+	ID         uint64 `json:"id"`
+	Kind       string `json:"kind"`
+	Target     string `json:"target"`
+	Status     string `json:"status"`
+	Progress   uint64 `json:"progress"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  int64  `json:"started_at"`
+	FinishedAt int64  `json:"finished_at,omitempty"`
+}
+
+type Jobs struct {
+	Jobs []Job `json:"jobs"`
+}
+
+type JobStarted struct {
+	JobID uint64 `json:"job_id"`
+}
+
+// StorageOffer - conditions of storage provider for storing the bag, amounts are in TON
+type StorageOffer struct {
+	BagID        string `json:"bag_id"`
+	Provider     string `json:"provider"`
+	Size         uint64 `json:"size"`
+	RatePerMBDay string `json:"rate_per_mb_day"`
+	MaxSpan      uint32 `json:"max_span"`
+	Days         uint32 `json:"days"`
+	Amount       string `json:"amount"`
+}
+
+type StorageContract struct {
+	BagID     string `json:"bag_id"`
+	Provider  string `json:"provider"`
+	Address   string `json:"address,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	Status    string `json:"status"`
+	Balance   string `json:"balance,omitempty"`
+	LastProof int64  `json:"last_proof,omitempty"`
+	ProofLate bool   `json:"proof_late"`
+}
+
+type StorageContracts struct {
+	Contracts []StorageContract `json:"contracts"`
+}
+
 type Credentials struct {
 	Login    string
 	Password string
 }
 
 type Server struct {
-	credentials *Credentials
-	connector   storage.NetConnector
-	store       *db.Storage
+	credentials   *Credentials
+	connector     storage.NetConnector
+	store         *db.Storage
+	downloadsPath string
+	storageClient *provider.Client
+	timeout       time.Duration
+	events        *EventBroker
 }
 
 func NewServer(connector storage.NetConnector, store *db.Storage) *Server {
@@ -91,6 +291,27 @@ func (s *Server) SetCredentials(credentials *Credentials) {
 	s.credentials = credentials
 }
 
+// SetDownloadsPath - directory for bags added without path
+func (s *Server) SetDownloadsPath(path string) {
+	s.downloadsPath = path
+}
+
+// SetTimeout - max duration of request handling, long operations like bag creation are cancelled after it,
+// 0 = unlimited
+func (s *Server) SetTimeout(timeout time.Duration) {
+	s.timeout = timeout
+}
+
+// SetEventBroker - enables events stream, broker should be set as hooks of connector
+func (s *Server) SetEventBroker(b *EventBroker) {
+	s.events = b
+}
+
+// SetStorageClient - enables renting storage for bags from storage providers
+func (s *Server) SetStorageClient(client *provider.Client) {
+	s.storageClient = client
+}
+
 func (s *Server) Start(addr string) error {
 	m := http.NewServeMux()
 	m.HandleFunc("/api/v1/details", s.withAuth(s.handleDetails))
@@ -98,39 +319,87 @@ func (s *Server) Start(addr string) error {
 	m.HandleFunc("/api/v1/create", s.withAuth(s.handleCreate))
 	m.HandleFunc("/api/v1/remove", s.withAuth(s.handleRemove))
 	m.HandleFunc("/api/v1/stop", s.withAuth(s.handleStop))
+	m.HandleFunc("/api/v1/resume", s.withAuth(s.handleResume))
+	m.HandleFunc("/api/v1/release", s.withAuth(s.handleRelease))
+	m.HandleFunc("/api/v1/peer", s.withAuth(s.handlePeer))
+	m.HandleFunc("/api/v1/peers/add", s.withAuth(s.handleAddPeer))
+	m.HandleFunc("/api/v1/peers/ban", s.withAuth(s.handleBanNode))
+	m.HandleFunc("/api/v1/peers/banned", s.withAuth(s.handleBannedNodes))
+	m.HandleFunc("/api/v1/announce-data", s.withAuth(s.handleAnnounceData))
 	m.HandleFunc("/api/v1/list", s.withAuth(s.handleList))
 	m.HandleFunc("/api/v1/piece/proof", s.withAuth(s.handlePieceProof))
+	m.HandleFunc("/api/v1/stats", s.withAuth(s.handleStats))
+	m.HandleFunc("/api/v1/stats/history", s.withAuth(s.handleHistory))
+	m.HandleFunc("/api/v1/speed-limits", s.withAuth(s.handleSpeedLimits))
+	m.HandleFunc("/api/v1/seed-policy", s.withAuth(s.handleSeedPolicy))
+	m.HandleFunc("/api/v1/hot", s.withAuth(s.handleHot))
+	m.HandleFunc("/api/v1/reannounce", s.withAuth(s.handleReannounce))
+	m.HandleFunc("/api/v1/access", s.withAuth(s.handleAccess))
+	m.HandleFunc("/api/v1/transfer-mode", s.withAuth(s.handleTransferMode))
+	m.HandleFunc("/api/v1/queue", s.withAuth(s.handleQueue))
+	m.HandleFunc("/api/v1/priority", s.withAuth(s.handlePriority))
+	m.HandleFunc("/api/v1/experimental/receipts", s.withAuth(s.handleReceipts))
+	m.HandleFunc("/api/v1/storage-contracts", s.withAuth(s.handleStorageContracts))
+	m.HandleFunc("/api/v1/storage-contracts/offer", s.withAuth(s.handleStorageOffer))
+	m.HandleFunc("/api/v1/storage-contracts/store", s.withAuth(s.handleStorageStore))
+	m.HandleFunc("/api/v1/reconcile", s.withAuth(s.handleReconcile))
+	m.HandleFunc("/api/v1/verify", s.withAuth(s.handleVerify))
+	m.HandleFunc("/api/v1/jobs", s.withAuth(s.handleJobs))
+	m.HandleFunc("/api/v1/jobs/cancel", s.withAuth(s.handleCancelJob))
+	m.HandleFunc("/api/v1/events", s.withAuthStream(s.handleEvents))
 	return http.ListenAndServe(addr, m)
 }
 
 func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 	req := struct {
-		BagID       string   `json:"bag_id"`
-		Path        string   `json:"path"`
-		DownloadAll bool     `json:"download_all"`
-		Files       []uint32 `json:"files"`
+		BagID          string   `json:"bag_id"`
+		Path           string   `json:"path"`
+		DownloadAll    bool     `json:"download_all"`
+		Files          []uint32 `json:"files"`
+		SwarmSecret    string   `json:"swarm_secret"`
+		TTL            uint64   `json:"ttl"`
+		RemoveOnExpire bool     `json:"remove_on_expire"`
+		Sequential     bool     `json:"sequential"`
+		// NoSeed - download-only mode, pieces are not served to peers
+		NoSeed bool `json:"no_seed"`
+		// Concurrency - download parallelism of bag, when not set it is not changed
+		Concurrency *Concurrency `json:"concurrency"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
 
-	bag, err := hex.DecodeString(req.BagID)
+	// bag id can be passed as tonstorage:// link too
+	uri, err := storage.ParseBagURI(req.BagID)
 	if err != nil {
 		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
 		return
 	}
-	if len(bag) != 32 {
-		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
-		return
+	bag := uri.BagID
+	if len(req.Files) == 0 && len(uri.Files) > 0 {
+		req.Files = uri.Files
+	}
+
+	if req.Path == "" {
+		req.Path = s.downloadsPath
 	}
 
 	tor := s.store.GetTorrent(bag)
 	if tor == nil {
 		tor = storage.NewTorrent(req.Path+"/"+hex.EncodeToString(bag), s.store, s.connector)
 		tor.BagID = bag
+		tor.SetSwarmSecret([]byte(req.SwarmSecret))
+		setExpiration(tor, req.TTL, req.RemoveOnExpire, true)
+		_ = tor.SetSequential(req.Sequential)
+		if req.Concurrency != nil {
+			if err = tor.SetDownloadConcurrency(req.Concurrency.toStorage()); err != nil {
+				response(w, http.StatusBadRequest, Error{err.Error()})
+				return
+			}
+		}
 
-		if err = tor.Start(true, req.DownloadAll, false); err != nil {
+		if err = tor.Start(!req.NoSeed, req.DownloadAll, false); err != nil {
 			pterm.Error.Println("Failed to start:", err.Error())
 			response(w, http.StatusInternalServerError, Error{"Failed to start download:" + err.Error()})
 			return
@@ -144,11 +413,25 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 		}
 		pterm.Success.Println("Bag added", hex.EncodeToString(bag))
 	} else {
-		if err = tor.Start(true, req.DownloadAll, false); err != nil {
+		if err = tor.Start(!req.NoSeed, req.DownloadAll, false); err != nil {
 			pterm.Error.Println("Failed to start:", err.Error())
 			response(w, http.StatusInternalServerError, Error{"Failed to start download:" + err.Error()})
 			return
 		}
+		if err = tor.SetSequential(req.Sequential); err != nil {
+			response(w, http.StatusInternalServerError, Error{"Failed to set download mode:" + err.Error()})
+			return
+		}
+		if req.Concurrency != nil {
+			if err = tor.SetDownloadConcurrency(req.Concurrency.toStorage()); err != nil {
+				response(w, http.StatusBadRequest, Error{err.Error()})
+				return
+			}
+		}
+		if err = s.store.SetTorrent(tor); err != nil {
+			response(w, http.StatusInternalServerError, Error{"Failed to save to db:" + err.Error()})
+			return
+		}
 		pterm.Success.Println("Bag state updated", hex.EncodeToString(bag))
 	}
 
@@ -166,28 +449,79 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
 	req := struct {
-		Path        string `json:"path"`
-		Description string `json:"description"`
+		Path           string   `json:"path"`
+		Description    string   `json:"description"`
+		SwarmSecret    string   `json:"swarm_secret"`
+		TTL            uint64   `json:"ttl"`
+		RemoveOnExpire bool     `json:"remove_on_expire"`
+		PieceSize      uint32   `json:"piece_size"`
+		ExcludeHidden  bool     `json:"exclude_hidden"`
+		Symlinks       string   `json:"symlinks"`
+		Include        []string `json:"include"`
+		Exclude        []string `json:"exclude"`
+		Timeout        uint64   `json:"timeout"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
 
-	rootPath, dirName, files, err := s.store.DetectFileRefs(req.Path)
+	opts := storage.CreateOptions{
+		PieceSize:     req.PieceSize,
+		ExcludeHidden: req.ExcludeHidden,
+		Symlinks:      req.Symlinks,
+		Include:       req.Include,
+		Exclude:       req.Exclude,
+	}
+	if err := opts.Validate(); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	rootPath, dirName, files, err := s.store.DetectFileRefsWithOptions(req.Path, opts)
 	if err != nil {
 		pterm.Error.Println("Failed to read file refs:", err.Error())
 		response(w, http.StatusInternalServerError, Error{err.Error()})
 		return
 	}
 
-	it, err := storage.CreateTorrent(r.Context(), rootPath, dirName, req.Description, s.store, s.connector, files)
+	// created as job, so it is visible in jobs list and can be cancelled
+	var it *storage.Torrent
+	job, err := s.store.StartJob("create", req.Path, time.Duration(req.Timeout)*time.Second, func(ctx context.Context, j *db.Job) (string, error) {
+		opts.Progress = j.SetProgress
+		it, err = storage.CreateTorrentWithOptions(ctx, rootPath, dirName, req.Description, s.store, s.connector, files, opts)
+		if err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(it.BagID), nil
+	})
 	if err != nil {
-		pterm.Error.Println("Failed to create bag:", err.Error())
 		response(w, http.StatusInternalServerError, Error{err.Error()})
 		return
 	}
 
+	select {
+	case <-job.Done():
+	case <-r.Context().Done():
+		// client is gone or api timeout is reached
+		job.Cancel()
+		<-job.Done()
+	}
+
+	if err != nil {
+		pterm.Error.Println("Failed to create bag:", err.Error())
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		response(w, status, Error{err.Error()})
+		return
+	}
+
+	it.SetSwarmSecret([]byte(req.SwarmSecret))
+	// files of created bag are not owned by storage, so we never delete them
+	setExpiration(it, req.TTL, req.RemoveOnExpire, false)
+
 	if err = it.Start(true, true, false); err != nil {
 		pterm.Error.Println("Failed to start bag:", err.Error())
 		response(w, http.StatusInternalServerError, Error{err.Error()})
@@ -204,44 +538,103 @@ func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
 	response(w, http.StatusOK, Created{BagID: hex.EncodeToString(it.BagID)})
 }
 
-func (s *Server) handlePieceProof(w http.ResponseWriter, r *http.Request) {
-	bag, err := hex.DecodeString(r.URL.Query().Get("bag_id"))
-	if err != nil {
-		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+// handleVerify - starts verification of stored pieces of bag as job, its outcome is available in jobs
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID   string `json:"bag_id"`
+		Timeout uint64 `json:"timeout"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
-	if len(bag) != 32 {
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
 		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
 		return
 	}
 
-	piece, err := strconv.ParseUint(r.URL.Query().Get("piece"), 10, 32)
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	job, err := s.store.StartJob("verify", req.BagID, time.Duration(req.Timeout)*time.Second, func(ctx context.Context, j *db.Job) (string, error) {
+		corrupted, _, err := tor.ValidateFrom(ctx, 0, func(checked, total uint32) {
+			j.SetProgress(uint64(checked), uint64(total))
+		})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprint(len(corrupted), " corrupted pieces are marked as missing"), nil
+	})
 	if err != nil {
-		response(w, http.StatusBadRequest, Error{"Invalid piece"})
+		response(w, http.StatusInternalServerError, Error{err.Error()})
 		return
 	}
+	response(w, http.StatusOK, JobStarted{JobID: job.ID()})
+}
 
-	if tor := s.store.GetTorrent(bag); tor != nil {
-		proof, err := tor.GetPieceProof(uint32(piece))
-		if err == nil {
-			response(w, http.StatusOK, ProofResponse{proof})
-			return
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	list, err := s.store.ListJobs()
+	if err != nil {
+		response(w, http.StatusInternalServerError, Error{"Failed to list jobs: " + err.Error()})
+		return
+	}
+
+	res := Jobs{Jobs: []Job{}}
+	for _, j := range list {
+		job := Job{
+			ID:        j.ID,
+			Kind:      j.Kind,
+			Target:    j.Target,
+			Status:    j.Status,
+			Progress:  j.Progress,
+			Result:    j.Result,
+			Error:     j.Error,
+			StartedAt: j.StartedAt.Unix(),
+		}
+		if !j.FinishedAt.IsZero() {
+			job.FinishedAt = j.FinishedAt.Unix()
 		}
+		res.Jobs = append(res.Jobs, job)
 	}
-	response(w, http.StatusNotFound, Ok{Ok: false})
+	response(w, http.StatusOK, res)
 }
 
-func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
 	req := struct {
-		BagID     string `json:"bag_id"`
-		WithFiles bool   `json:"with_files"`
+		ID uint64 `json:"id"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
 
-	bag, err := hex.DecodeString(req.BagID)
+	if err := s.store.CancelJob(req.ID); err != nil {
+		if errors.Is(err, db.ErrJobNotFound) {
+			response(w, http.StatusNotFound, Ok{Ok: false})
+			return
+		}
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+func setExpiration(t *storage.Torrent, ttl uint64, removeOnExpire, withFiles bool) {
+	if ttl == 0 {
+		return
+	}
+	t.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Second)
+	t.RemoveOnExpire = removeOnExpire
+	t.RemoveFilesOnExpire = removeOnExpire && withFiles
+}
+
+func (s *Server) handlePieceProof(w http.ResponseWriter, r *http.Request) {
+	bag, err := hex.DecodeString(r.URL.Query().Get("bag_id"))
 	if err != nil {
 		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
 		return
@@ -251,28 +644,23 @@ func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	piece, err := strconv.ParseUint(r.URL.Query().Get("piece"), 10, 32)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid piece"})
+		return
+	}
+
 	if tor := s.store.GetTorrent(bag); tor != nil {
-		if err = s.store.RemoveTorrent(tor, req.WithFiles); err != nil {
-			pterm.Error.Println("Failed to remove bag from db:", err.Error())
-			response(w, http.StatusInternalServerError, Error{err.Error()})
+		proof, err := tor.GetPieceProof(uint32(piece))
+		if err == nil {
+			response(w, http.StatusOK, ProofResponse{proof})
 			return
 		}
-		pterm.Success.Println("Bag removed", hex.EncodeToString(tor.BagID))
-		response(w, http.StatusOK, Ok{Ok: true})
-		return
 	}
 	response(w, http.StatusNotFound, Ok{Ok: false})
 }
 
-func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
-	var bags []Bag
-	for _, t := range s.store.GetAll() {
-		bags = append(bags, s.getBag(t, true).Bag)
-	}
-	response(w, http.StatusOK, List{Bags: bags})
-}
-
-func (s *Server) handleDetails(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleReceipts(w http.ResponseWriter, r *http.Request) {
 	bag, err := hex.DecodeString(r.URL.Query().Get("bag_id"))
 	if err != nil {
 		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
@@ -283,22 +671,64 @@ func (s *Server) handleDetails(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if tor := s.store.GetTorrent(bag); tor != nil {
-		response(w, http.StatusOK, s.getBag(tor, false))
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
 		return
 	}
-	response(w, http.StatusNotFound, Ok{Ok: false})
+
+	res := Receipts{Receipts: []Receipt{}}
+	for _, rc := range tor.GetBandwidthReceipts() {
+		data, err := tl.Serialize(rc.Receipt, true)
+		if err != nil {
+			response(w, http.StatusInternalServerError, Error{"Failed to serialize receipt:" + err.Error()})
+			return
+		}
+
+		id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: rc.Receipt.Downloader})
+		if err != nil {
+			response(w, http.StatusInternalServerError, Error{"Failed to calc peer id:" + err.Error()})
+			return
+		}
+
+		res.Receipts = append(res.Receipts, Receipt{
+			PeerID:        hex.EncodeToString(id),
+			DownloaderKey: hex.EncodeToString(rc.Receipt.Downloader),
+			SessionID:     rc.Receipt.SessionID,
+			Bytes:         rc.Receipt.Bytes,
+			CreatedAt:     int64(rc.Receipt.CreatedAt),
+			SignedData:    data,
+			Signature:     rc.Signature,
+		})
+	}
+	sort.Slice(res.Receipts, func(i, j int) bool {
+		return res.Receipts[i].CreatedAt > res.Receipts[j].CreatedAt
+	})
+	response(w, http.StatusOK, res)
 }
 
-func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSpeedLimits(w http.ResponseWriter, r *http.Request) {
 	req := struct {
-		BagID string `json:"bag_id"`
+		BagID    string `json:"bag_id"`
+		Download uint64 `json:"download"`
+		Upload   uint64 `json:"upload"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		response(w, http.StatusBadRequest, Error{err.Error()})
 		return
 	}
 
+	if req.BagID == "" {
+		s.connector.SetDownloadLimit(req.Download)
+		s.connector.SetUploadLimit(req.Upload)
+		if err := s.store.SetSpeedLimits(req.Download, req.Upload); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+
 	bag, err := hex.DecodeString(req.BagID)
 	if err != nil {
 		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
@@ -309,60 +739,877 @@ func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if tor := s.store.GetTorrent(bag); tor != nil {
-		tor.Stop()
-		response(w, http.StatusOK, Ok{Ok: true})
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
 		return
 	}
-	response(w, http.StatusNotFound, Ok{Ok: false})
 
+	tor.SetSpeedLimits(req.Download, req.Upload)
+	if err = s.store.SetTorrent(tor); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
 }
 
-func (s *Server) withAuth(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if crs := s.credentials; crs != nil {
-			login, password, ok := r.BasicAuth()
-			if !ok || login != crs.Login || password != crs.Password {
-				response(w, http.StatusUnauthorized, Error{
-					"Invalid credentials",
-				})
-				return
-			}
+func (s *Server) handleSeedPolicy(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID       string  `json:"bag_id"`
+		Ratio       float64 `json:"ratio"`
+		Hours       uint64  `json:"hours"`
+		Remove      bool    `json:"remove"`
+		RemoveFiles bool    `json:"remove_files"`
+		Clear       bool    `json:"clear"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	if req.Ratio < 0 {
+		response(w, http.StatusBadRequest, Error{"Invalid ratio"})
+		return
+	}
+
+	p := db.SeedPolicy{
+		Ratio:       req.Ratio,
+		Hours:       req.Hours,
+		Remove:      req.Remove,
+		RemoveFiles: req.Remove && req.RemoveFiles,
+	}
+
+	if req.BagID == "" {
+		if req.Clear {
+			p = db.SeedPolicy{}
 		}
-		next(w, r)
+		if err := s.store.SetSeedPolicy(p); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	var bagPolicy *db.SeedPolicy
+	if !req.Clear {
+		bagPolicy = &p
+	}
+	if err = s.store.SetBagSeedPolicy(tor, bagPolicy); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
 	}
+	response(w, http.StatusOK, Ok{Ok: true})
 }
 
-func response(w http.ResponseWriter, status int, result any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	_ = json.NewEncoder(w).Encode(result)
+func (s *Server) handleStorageContracts(w http.ResponseWriter, r *http.Request) {
+	if s.storageClient == nil {
+		response(w, http.StatusNotImplemented, Error{"Wallet is not set in config"})
+		return
+	}
+
+	res := StorageContracts{Contracts: []StorageContract{}}
+	for _, c := range s.storageClient.Contracts() {
+		sc := StorageContract{
+			BagID:     hex.EncodeToString(c.BagID),
+			Provider:  c.Provider,
+			Address:   c.Address,
+			CreatedAt: c.CreatedAt.Unix(),
+			Status:    c.Status,
+			ProofLate: c.ProofLate,
+		}
+		if c.Address != "" {
+			sc.Balance = c.Balance.String()
+		}
+		if !c.LastProof.IsZero() {
+			sc.LastProof = c.LastProof.Unix()
+		}
+		res.Contracts = append(res.Contracts, sc)
+	}
+	response(w, http.StatusOK, res)
 }
 
-func (s *Server) getBag(t *storage.Torrent, short bool) BagDetailed {
-	res := BagDetailed{
-		Files: []File{},
-		Peers: []Peer{},
+func (s *Server) handleStorageOffer(w http.ResponseWriter, r *http.Request) {
+	offer, ok := s.getStorageOffer(w, r)
+	if !ok {
+		return
 	}
 
-	var dow, upl, num uint64
-	for id, p := range t.GetPeers() {
-		dow += p.GetDownloadSpeed()
-		upl += p.GetUploadSpeed()
-		num++
+	response(w, http.StatusOK, StorageOffer{
+		BagID:        hex.EncodeToString(offer.BagID),
+		Provider:     offer.Provider.String(),
+		Size:         offer.FileSize,
+		RatePerMBDay: offer.RatePerMBDay.String(),
+		MaxSpan:      offer.MaxSpan,
+		Days:         offer.Days,
+		Amount:       offer.Amount.String(),
+	})
+}
 
-		if !short {
-			res.Peers = append(res.Peers, Peer{
-				Addr:          p.Addr,
-				ID:            id,
-				UploadSpeed:   p.GetUploadSpeed(),
-				DownloadSpeed: p.GetDownloadSpeed(),
-			})
-		}
+func (s *Server) handleStorageStore(w http.ResponseWriter, r *http.Request) {
+	offer, ok := s.getStorageOffer(w, r)
+	if !ok {
+		return
 	}
 
-	var desc, dirName string
-	var full, downloaded, filesCount uint64
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Minute)
+	defer cancel()
+	if err := s.storageClient.Store(ctx, s.store.GetTorrent(offer.BagID), offer); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	pterm.Success.Println("Storage contract offer sent for bag", hex.EncodeToString(offer.BagID), "to provider", offer.Provider.String())
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+// getStorageOffer - parses bag, provider and days from request and requests current offer of provider
+func (s *Server) getStorageOffer(w http.ResponseWriter, r *http.Request) (*provider.Offer, bool) {
+	if s.storageClient == nil {
+		response(w, http.StatusNotImplemented, Error{"Wallet is not set in config"})
+		return nil, false
+	}
+
+	req := struct {
+		BagID    string `json:"bag_id"`
+		Provider string `json:"provider"`
+		Days     uint32 `json:"days"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return nil, false
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return nil, false
+	}
+	addr, err := address.ParseAddr(req.Provider)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid provider address"})
+		return nil, false
+	}
+	if req.Days == 0 {
+		req.Days = 30
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	offer, err := s.storageClient.GetOffer(ctx, tor, addr, req.Days)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return nil, false
+	}
+	return offer, true
+}
+
+func (s *Server) handleRemove(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID     string `json:"bag_id"`
+		WithFiles bool   `json:"with_files"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		if err = s.store.RemoveTorrent(tor, req.WithFiles); err != nil {
+			pterm.Error.Println("Failed to remove bag from db:", err.Error())
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		pterm.Success.Println("Bag removed", hex.EncodeToString(tor.BagID))
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	var bags []Bag
+	for _, t := range s.store.GetAll() {
+		bags = append(bags, s.GetBag(t, true).Bag)
+	}
+	response(w, http.StatusOK, List{Bags: bags})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	var res Stats
+	peers := map[string]bool{}
+	for _, t := range s.store.GetAll() {
+		b := s.GetBag(t, true).Bag
+
+		res.Bags.Total++
+		if b.Active {
+			res.Bags.Active++
+			if !b.Completed {
+				res.Bags.Downloading++
+			}
+		} else {
+			res.Bags.Stopped++
+		}
+		if b.Seeding {
+			res.Bags.Seeding++
+		}
+		if b.Completed {
+			res.Bags.Completed++
+		}
+
+		res.StoredBytes += b.Downloaded
+		res.DownloadSpeed += b.DownloadSpeed
+		res.UploadSpeed += b.UploadSpeed
+		res.BagPeers += b.Peers
+
+		for id := range t.GetPeers() {
+			peers[id] = true
+		}
+	}
+	res.Peers = uint64(len(peers))
+	res.DHTNodes = uint64(s.connector.GetDHTNodesNum())
+
+	session, lifetime := s.store.GetTransferStats()
+	res.Session = TransferStats{Downloaded: session.Downloaded, Uploaded: session.Uploaded}
+	res.Lifetime = TransferStats{Downloaded: lifetime.Downloaded, Uploaded: lifetime.Uploaded}
+	res.Uptime = uint64(time.Since(startedAt) / time.Second)
+
+	response(w, http.StatusOK, res)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	hours := uint64(24)
+	if v := r.URL.Query().Get("hours"); v != "" {
+		var err error
+		hours, err = strconv.ParseUint(v, 10, 32)
+		if err != nil || hours == 0 {
+			response(w, http.StatusBadRequest, Error{"Invalid hours"})
+			return
+		}
+	}
+
+	list, err := s.store.GetHistory(time.Now().Add(-time.Duration(hours-1) * time.Hour))
+	if err != nil {
+		response(w, http.StatusInternalServerError, Error{"Failed to get history: " + err.Error()})
+		return
+	}
+
+	res := History{Points: []HistoryPoint{}}
+	for _, p := range list {
+		res.Points = append(res.Points, HistoryPoint{
+			Time:             p.Time.Unix(),
+			Downloaded:       p.Downloaded,
+			Uploaded:         p.Uploaded,
+			DownloadSpeed:    p.DownloadSpeed,
+			UploadSpeed:      p.UploadSpeed,
+			MaxDownloadSpeed: p.MaxDownloadSpeed,
+			MaxUploadSpeed:   p.MaxUploadSpeed,
+			Peers:            p.Peers,
+			StoredBytes:      p.StoredBytes,
+			Bags:             p.Bags,
+			ActiveBags:       p.ActiveBags,
+			CompletedBags:    p.CompletedBags,
+		})
+	}
+	response(w, http.StatusOK, res)
+}
+
+func (s *Server) handleDetails(w http.ResponseWriter, r *http.Request) {
+	bag, err := hex.DecodeString(r.URL.Query().Get("bag_id"))
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		response(w, http.StatusOK, s.GetBag(tor, false))
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID string `json:"bag_id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		if err = s.store.PauseTorrent(tor); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID string `json:"bag_id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		if err = s.store.ResumeTorrent(tor); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID string `json:"bag_id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		if err = s.store.ReleaseTorrent(tor); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+}
+
+func (s *Server) handlePeer(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID  string `json:"bag_id"`
+		PeerID string `json:"peer_id"`
+		Action string `json:"action"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	peer, err := hex.DecodeString(req.PeerID)
+	if err != nil || len(peer) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid peer id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	switch req.Action {
+	case "drop":
+		err = tor.DropPeer(peer)
+	case "reconnect":
+		err = tor.ReconnectPeer(peer)
+	case "unban":
+		if !tor.UnbanPeer(peer) {
+			response(w, http.StatusNotFound, Error{"Peer is not banned"})
+			return
+		}
+	default:
+		response(w, http.StatusBadRequest, Error{"Invalid action, should be drop, reconnect or unban"})
+		return
+	}
+
+	if err != nil {
+		response(w, http.StatusNotFound, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+func (s *Server) handleHot(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID  string `json:"bag_id"`
+		Pieces uint32 `json:"pieces"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	if _, err = s.store.SetHotPieces(tor, req.Pieces); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+// handleReannounce - announces bag in DHT right now, when interval is set, it is saved first, 0 resets it to default
+func (s *Server) handleReannounce(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID    string  `json:"bag_id"`
+		Interval *uint64 `json:"interval"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	if req.Interval != nil {
+		if err = s.store.SetAnnounceInterval(tor, time.Duration(*req.Interval)*time.Second); err != nil {
+			response(w, http.StatusInternalServerError, Error{err.Error()})
+			return
+		}
+	}
+
+	// announce result is returned in state, not as error, it is not a failure of request
+	_ = s.connector.Reannounce(r.Context(), tor)
+	response(w, http.StatusOK, s.announceState(tor))
+}
+
+// handleTransferMode - switches bag between normal, seed-only (download is false) and download-only (upload is false) modes
+func (s *Server) handleTransferMode(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID    string `json:"bag_id"`
+		Download bool   `json:"download"`
+		Upload   bool   `json:"upload"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if !req.Download && !req.Upload {
+		response(w, http.StatusBadRequest, Error{"Bag should be downloaded or seeded"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	if err = s.store.SetTransferMode(tor, req.Download, req.Upload); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+// handleQueue - returns downloading bags and bags waiting for download slot, in order they will be started
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	response(w, http.StatusOK, s.GetQueue())
+}
+
+func (s *Server) GetQueue() Queue {
+	res := Queue{Limit: db.MaxActiveDownloads, Bags: []QueuedBag{}}
+	for _, q := range s.store.GetDownloadQueue() {
+		res.Bags = append(res.Bags, QueuedBag{
+			BagID:    hex.EncodeToString(q.Torrent.BagID),
+			Priority: q.Priority,
+			Active:   q.Active,
+			Position: q.Position,
+			Since:    q.Since.Unix(),
+		})
+	}
+	return res
+}
+
+// handlePriority - sets priority of bag in download queue, bags with higher priority are started first
+func (s *Server) handlePriority(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID    string `json:"bag_id"`
+		Priority int    `json:"priority"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	if err = s.store.SetDownloadPriority(tor, req.Priority); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+// handleAccess - sets allow-list of bag and whether it is announced in DHT, empty list allows all nodes
+func (s *Server) handleAccess(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID        string   `json:"bag_id"`
+		AllowedPeers []string `json:"allowed_peers"`
+		Unlisted     bool     `json:"unlisted"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	var allowed [][]byte
+	for _, id := range req.AllowedPeers {
+		b, err := hex.DecodeString(id)
+		if err != nil || len(b) != 32 {
+			response(w, http.StatusBadRequest, Error{"Invalid adnl id " + id})
+			return
+		}
+		allowed = append(allowed, b)
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	if err = s.store.SetAccess(tor, allowed, req.Unlisted); err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+func (s *Server) announceState(t *storage.Torrent) *Announce {
+	res := &Announce{
+		Interval:       uint64(storage.AnnounceInterval / time.Second),
+		CustomInterval: t.GetAnnounceInterval() > 0,
+	}
+	if res.CustomInterval {
+		res.Interval = uint64(t.GetAnnounceInterval() / time.Second)
+	}
+	if st, ok := s.connector.GetAnnounceStatus(t.BagID); ok {
+		res.AnnouncedAt = st.At.Unix()
+		if st.Err != nil {
+			res.Error = st.Err.Error()
+		}
+	}
+	return res
+}
+
+func (s *Server) handleAddPeer(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID  string `json:"bag_id"`
+		Addr   string `json:"addr"`
+		ADNLID string `json:"adnl_id"`
+		// Key - public key of node, when it is set, adnl id is not needed and key is not resolved using DHT
+		Key []byte `json:"key"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil || len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	tor := s.store.GetTorrent(bag)
+	if tor == nil {
+		response(w, http.StatusNotFound, Ok{Ok: false})
+		return
+	}
+
+	var id []byte
+	if len(req.Key) > 0 {
+		if err = s.connector.AddStaticPeer(req.Key, req.Addr); err != nil {
+			response(w, http.StatusBadRequest, Error{err.Error()})
+			return
+		}
+		id, _ = adnl.ToKeyID(adnl.PublicKeyED25519{Key: req.Key})
+	} else if id, err = hex.DecodeString(req.ADNLID); err != nil || len(id) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid adnl id"})
+		return
+	}
+
+	if err = s.connector.AddPeer(r.Context(), tor, req.Addr, id); err != nil {
+		response(w, http.StatusBadGateway, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+func (s *Server) handleBanNode(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		ADNLID string `json:"adnl_id"`
+		Remove bool   `json:"remove"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	id, err := hex.DecodeString(req.ADNLID)
+	if err != nil || len(id) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid adnl id"})
+		return
+	}
+
+	if req.Remove {
+		err = s.connector.UnbanNode(id)
+	} else {
+		err = s.connector.BanNode(id)
+	}
+	if err != nil {
+		response(w, http.StatusInternalServerError, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, Ok{Ok: true})
+}
+
+func (s *Server) handleBannedNodes(w http.ResponseWriter, r *http.Request) {
+	res := BannedNodes{Nodes: []string{}}
+	for _, id := range s.connector.GetBannedNodes() {
+		res.Nodes = append(res.Nodes, hex.EncodeToString(id))
+	}
+	sort.Strings(res.Nodes)
+	response(w, http.StatusOK, res)
+}
+
+func (s *Server) handleAnnounceData(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		BagID string `json:"bag_id"`
+		Data  []byte `json:"data"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	bag, err := hex.DecodeString(req.BagID)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+	if len(bag) != 32 {
+		response(w, http.StatusBadRequest, Error{"Invalid bag id"})
+		return
+	}
+
+	if tor := s.store.GetTorrent(bag); tor != nil {
+		if err = tor.SetAnnounceData(req.Data); err != nil {
+			response(w, http.StatusBadRequest, Error{err.Error()})
+			return
+		}
+		if err = s.store.SetTorrent(tor); err != nil {
+			response(w, http.StatusInternalServerError, Error{"Failed to save to db:" + err.Error()})
+			return
+		}
+		response(w, http.StatusOK, Ok{Ok: true})
+		return
+	}
+	response(w, http.StatusNotFound, Ok{Ok: false})
+}
+
+func (s *Server) withAuth(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkCredentials(w, r) {
+			return
+		}
+
+		if s.timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next(w, r)
+	}
+}
+
+// withAuthStream - same as withAuth, but without request timeout, for long-living streams
+func (s *Server) withAuthStream(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.checkCredentials(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+func (s *Server) checkCredentials(w http.ResponseWriter, r *http.Request) bool {
+	if crs := s.credentials; crs != nil {
+		login, password, ok := r.BasicAuth()
+		if !ok || login != crs.Login || password != crs.Password {
+			response(w, http.StatusUnauthorized, Error{
+				"Invalid credentials",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+func response(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// GetBag - bag state as returned by API, short = without peers, files and pieces mask
+func (s *Server) GetBag(t *storage.Torrent, short bool) BagDetailed {
+	res := BagDetailed{
+		Files: []File{},
+		Peers: []Peer{},
+	}
+
+	var dow, upl, num uint64
+	for id, p := range t.GetPeers() {
+		dow += p.GetDownloadSpeed()
+		upl += p.GetUploadSpeed()
+		num++
+
+		if !short {
+			res.Peers = append(res.Peers, Peer{
+				Addr:          p.Addr,
+				ID:            id,
+				UploadSpeed:   p.GetUploadSpeed(),
+				DownloadSpeed: p.GetDownloadSpeed(),
+				Client:        p.Client,
+				AnnounceData:  p.AnnounceData,
+				Pieces:        p.GetPiecesNum(),
+			})
+		}
+	}
+
+	if !short {
+		res.AnnounceData = t.GetAnnounceData()
+		res.HotPieces = t.GetHotPieces()
+		res.Announce = s.announceState(t)
+		for _, id := range t.GetAllowedPeers() {
+			res.AllowedPeers = append(res.AllowedPeers, hex.EncodeToString(id))
+		}
+		sort.Strings(res.AllowedPeers)
+		res.Unlisted = t.IsUnlisted()
+		for id, till := range t.GetBannedPeers() {
+			res.BannedPeers = append(res.BannedPeers, BannedPeer{
+				ID:    id,
+				Until: till.Unix(),
+			})
+		}
+	}
+
+	var desc, dirName string
+	var full, downloaded, filesCount uint64
 	completed, infoLoaded, headerLoaded := false, false, false
 	if t.Info != nil {
 		infoLoaded = true
@@ -446,7 +1693,20 @@ func (s *Server) getBag(t *storage.Torrent, short bool) BagDetailed {
 		InfoLoaded:    infoLoaded,
 		Active:        active,
 		Seeding:       seeding,
+		Private:       t.IsPrivate(),
+		SeedOnly:      t.IsSeedOnly(),
+		Queued:        s.store.IsQueued(t),
+		Priority:      t.GetDownloadPriority(),
+	}
+	st := s.store.GetBagTransferStats(t)
+	res.Bag.Transferred = TransferStats{Downloaded: st.Downloaded, Uploaded: st.Uploaded}
+	if !t.ExpiresAt.IsZero() {
+		res.Bag.ExpiresAt = t.ExpiresAt.Unix()
+	}
+	if err := t.GetError(); err != nil {
+		res.Bag.Error = err.Error()
 	}
+	res.Bag.Quarantine = t.GetQuarantineReason()
 
 	return res
 }
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// EventsBuffer - number of events queued for one subscriber, when it is not reading fast enough, new events are dropped
+var EventsBuffer = 1024
+
+const (
+	EventPieceCompleted = "piece_completed"
+	EventBagCompleted   = "bag_completed"
+	EventPeerConnected  = "peer_connected"
+)
+
+type Event struct {
+	Type   string  `json:"type"`
+	BagID  string  `json:"bag_id"`
+	Piece  *uint32 `json:"piece,omitempty"`
+	PeerID string  `json:"peer_id,omitempty"`
+	Addr   string  `json:"addr,omitempty"`
+	Time   int64   `json:"time"`
+}
+
+// EventBroker - storage hooks which deliver events to subscribers, like clients of events stream.
+// Hooks are called from download routines, so events are never blocking: slow subscriber loses them.
+type EventBroker struct {
+	// next - hooks which were set before broker, they are still called
+	next storage.Hooks
+	subs map[chan Event]bool
+	mx   sync.RWMutex
+}
+
+// NewEventBroker - creates broker which wraps existing hooks, next can be nil
+func NewEventBroker(next storage.Hooks) *EventBroker {
+	if next == nil {
+		next = storage.NoopHooks{}
+	}
+	return &EventBroker{
+		next: next,
+		subs: map[chan Event]bool{},
+	}
+}
+
+// Subscribe - returns channel of events, cancel should be called when events are not needed anymore
+func (b *EventBroker) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, EventsBuffer)
+
+	b.mx.Lock()
+	b.subs[ch] = true
+	b.mx.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mx.Lock()
+			delete(b.subs, ch)
+			b.mx.Unlock()
+		})
+	}
+}
+
+func (b *EventBroker) publish(e Event) {
+	e.Time = time.Now().Unix()
+
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *EventBroker) OnPieceVerified(t *storage.Torrent, piece uint32, peerId []byte) {
+	b.publish(Event{Type: EventPieceCompleted, BagID: hex.EncodeToString(t.BagID), Piece: &piece, PeerID: hex.EncodeToString(peerId)})
+	b.next.OnPieceVerified(t, piece, peerId)
+}
+
+func (b *EventBroker) OnPeerConnected(t *storage.Torrent, peerId []byte, addr string) {
+	b.publish(Event{Type: EventPeerConnected, BagID: hex.EncodeToString(t.BagID), PeerID: hex.EncodeToString(peerId), Addr: addr})
+	b.next.OnPeerConnected(t, peerId, addr)
+}
+
+func (b *EventBroker) OnBagCompleted(t *storage.Torrent) {
+	b.publish(Event{Type: EventBagCompleted, BagID: hex.EncodeToString(t.BagID)})
+	b.next.OnBagCompleted(t)
+}
+
+func (b *EventBroker) OnBagCreated(t *storage.Torrent) {
+	b.next.OnBagCreated(t)
+}
+
+func (b *EventBroker) OnBagError(t *storage.Torrent, err error) {
+	b.next.OnBagError(t, err)
+}
+
+func (b *EventBroker) OnBeforeServePiece(t *storage.Torrent, piece uint32, peerId []byte) bool {
+	return b.next.OnBeforeServePiece(t, piece, peerId)
+}
+
+// handleEvents - streams events as json lines till client disconnects, optionally only of one bag
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		response(w, http.StatusNotImplemented, Error{"Events are not enabled"})
+		return
+	}
+
+	bagId := r.URL.Query().Get("bag_id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response(w, http.StatusInternalServerError, Error{"Streaming is not supported"})
+		return
+	}
+
+	events, cancel := s.events.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if bagId != "" && e.BagID != bagId {
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
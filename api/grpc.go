@@ -0,0 +1,580 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// GRPCMaxMessageSize - max size of request message, same as default of grpc libraries
+var GRPCMaxMessageSize = 4 << 20
+
+// grpcPath - prefix of methods of Storage service from proto/storage.proto
+const grpcPath = "/tonutils.storage.v1.Storage/"
+
+// grpc status codes which are returned by us
+const (
+	grpcOK               = 0
+	grpcCanceled         = 1
+	grpcInvalidArgument  = 3
+	grpcDeadlineExceeded = 4
+	grpcNotFound         = 5
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnauthenticated  = 16
+)
+
+// grpcMethod - unary method which is executed by handler of HTTP API, so both APIs behave identically
+type grpcMethod struct {
+	// request - converts protobuf request to http request for handler
+	request func(ctx context.Context, msg []byte) (*http.Request, error)
+	handler func(w http.ResponseWriter, r *http.Request)
+	// response - converts json response of handler to protobuf, status is http status of handler
+	response func(status int, body []byte) ([]byte, error)
+}
+
+// StartGRPC - serves Storage service of proto/storage.proto on addr, over HTTP/2 without TLS.
+// Credentials of HTTP API are passed as basic auth in authorization metadata.
+func (s *Server) StartGRPC(addr string) error {
+	return http.ListenAndServe(addr, s.GRPCHandler())
+}
+
+// GRPCHandler - returns handler of Storage service over HTTP/2 without TLS, to mount it into another http server
+func (s *Server) GRPCHandler() http.Handler {
+	m := http.NewServeMux()
+	s.handleGRPC(m, "AddBag", grpcMethod{request: grpcAddBagRequest, handler: s.handleAdd, response: grpcResult})
+	s.handleGRPC(m, "CreateBag", grpcMethod{request: grpcCreateBagRequest, handler: s.handleCreate, response: grpcCreateBagResponse})
+	s.handleGRPC(m, "RemoveBag", grpcMethod{request: grpcRemoveBagRequest, handler: s.handleRemove, response: grpcResult})
+	s.handleGRPC(m, "ListBags", grpcMethod{request: grpcListBagsRequest, handler: s.handleList, response: grpcListBagsResponse})
+	s.handleGRPC(m, "GetBag", grpcMethod{request: grpcGetBagRequest, handler: s.handleDetails, response: grpcBagDetailedResponse})
+	m.HandleFunc(grpcPath+"SubscribeEvents", s.handleGRPCEvents)
+	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		grpcStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+	})
+	return h2c.NewHandler(m, &http2.Server{})
+}
+
+func (s *Server) handleGRPC(m *http.ServeMux, name string, method grpcMethod) {
+	handler := s.withAuth(method.handler)
+	m.HandleFunc(grpcPath+name, func(w http.ResponseWriter, r *http.Request) {
+		msg, ok := readGRPCRequest(w, r)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := grpcContext(r)
+		defer cancel()
+
+		req, err := method.request(ctx, msg)
+		if err != nil {
+			grpcStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		req.Header.Set("Authorization", r.Header.Get("Authorization"))
+
+		res := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+		handler(res, req)
+
+		if res.status == http.StatusUnauthorized || res.status == http.StatusGatewayTimeout {
+			// not a result of method, so it is returned as grpc status for any method
+			grpcStatus(w, grpcCode(ctx, res.status), jsonError(res.body.Bytes()))
+			return
+		}
+
+		reply, err := method.response(res.status, res.body.Bytes())
+		if err != nil {
+			grpcStatus(w, grpcCode(ctx, res.status), err.Error())
+			return
+		}
+		writeGRPCMessage(w, reply)
+		grpcTrailer(w, grpcOK, "")
+	})
+}
+
+// handleGRPCEvents - SubscribeEvents stream, the same events as GET /api/v1/events
+func (s *Server) handleGRPCEvents(w http.ResponseWriter, r *http.Request) {
+	msg, ok := readGRPCRequest(w, r)
+	if !ok {
+		return
+	}
+
+	auth := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	if !s.checkCredentials(auth, r) {
+		grpcStatus(w, grpcUnauthenticated, jsonError(auth.body.Bytes()))
+		return
+	}
+	if s.events == nil {
+		grpcStatus(w, grpcUnimplemented, "Events are not enabled")
+		return
+	}
+
+	fields, err := pbFields(msg)
+	if err != nil {
+		grpcStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	var bagId string
+	for _, f := range fields {
+		if f.field == 1 {
+			bagId = string(f.data)
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		grpcStatus(w, grpcInternal, "Streaming is not supported")
+		return
+	}
+
+	events, cancel := s.events.Subscribe()
+	defer cancel()
+
+	ctx, stop := grpcContext(r)
+	defer stop()
+
+	grpcHeader(w)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			grpcTrailer(w, grpcCode(ctx, 0), ctx.Err().Error())
+			return
+		case e := <-events:
+			if bagId != "" && e.BagID != bagId {
+				continue
+			}
+			if !writeGRPCMessage(w, encodeEvent(e)) {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// bufferedResponse - collects response of HTTP API handler, to convert it to grpc reply
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+// readGRPCRequest - reads single message of request, on failure grpc status is already written
+func readGRPCRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "only grpc requests are supported", http.StatusUnsupportedMediaType)
+		return nil, false
+	}
+
+	var hdr [5]byte
+	if _, err := io.ReadFull(r.Body, hdr[:]); err != nil {
+		grpcStatus(w, grpcInvalidArgument, "failed to read request: "+err.Error())
+		return nil, false
+	}
+	if hdr[0] != 0 {
+		grpcStatus(w, grpcUnimplemented, "compressed messages are not supported")
+		return nil, false
+	}
+
+	sz := binary.BigEndian.Uint32(hdr[1:])
+	if sz > uint32(GRPCMaxMessageSize) {
+		grpcStatus(w, grpcInvalidArgument, "request message is too big")
+		return nil, false
+	}
+
+	msg := make([]byte, sz)
+	if _, err := io.ReadFull(r.Body, msg); err != nil {
+		grpcStatus(w, grpcInvalidArgument, "failed to read request: "+err.Error())
+		return nil, false
+	}
+	return msg, true
+}
+
+// grpcContext - context of request with grpc-timeout of client applied
+func grpcContext(r *http.Request) (context.Context, context.CancelFunc) {
+	v := r.Header.Get("Grpc-Timeout")
+	if len(v) < 2 {
+		return context.WithCancel(r.Context())
+	}
+
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if err != nil {
+		return context.WithCancel(r.Context())
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), time.Duration(n)*unit)
+}
+
+func grpcHeader(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+}
+
+func grpcTrailer(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", grpcEscape(message))
+	}
+}
+
+// grpcStatus - replies with status only, without messages
+func grpcStatus(w http.ResponseWriter, code int, message string) {
+	grpcHeader(w)
+	grpcTrailer(w, code, message)
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) bool {
+	if w.Header().Get("Content-Type") == "" {
+		grpcHeader(w)
+	}
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err == nil
+}
+
+// grpcEscape - percent encoding of grpc-message, as required by grpc protocol
+func grpcEscape(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < 0x20 || c > 0x7E || c == '%' {
+			sb.WriteString(fmt.Sprintf("%%%02X", c))
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// grpcCode - grpc status code for http status of API handler, or for reason of context cancellation
+func grpcCode(ctx context.Context, status int) int {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return grpcDeadlineExceeded
+	case context.Canceled:
+		return grpcCanceled
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusNotImplemented:
+		return grpcUnimplemented
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcInternal
+}
+
+// jsonError - message of Error response of handler
+func jsonError(body []byte) string {
+	var e Error
+	if err := json.Unmarshal(body, &e); err != nil || e.Error == "" {
+		return "request failed"
+	}
+	return e.Error
+}
+
+func jsonRequest(ctx context.Context, req any) (*http.Request, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return http.NewRequestWithContext(ctx, http.MethodPost, "/", bytes.NewReader(data))
+}
+
+func grpcAddBagRequest(ctx context.Context, msg []byte) (*http.Request, error) {
+	fields, err := pbFields(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req := struct {
+		BagID          string   `json:"bag_id"`
+		Path           string   `json:"path"`
+		DownloadAll    bool     `json:"download_all"`
+		Files          []uint32 `json:"files"`
+		SwarmSecret    string   `json:"swarm_secret"`
+		TTL            uint64   `json:"ttl"`
+		RemoveOnExpire bool     `json:"remove_on_expire"`
+		Sequential     bool     `json:"sequential"`
+	}{}
+	for _, f := range fields {
+		switch f.field {
+		case 1:
+			req.BagID = string(f.data)
+		case 2:
+			req.Path = string(f.data)
+		case 3:
+			req.DownloadAll = f.num != 0
+		case 4:
+			files, err := f.uint32s()
+			if err != nil {
+				return nil, err
+			}
+			req.Files = append(req.Files, files...)
+		case 5:
+			req.SwarmSecret = string(f.data)
+		case 6:
+			req.TTL = f.num
+		case 7:
+			req.RemoveOnExpire = f.num != 0
+		case 8:
+			req.Sequential = f.num != 0
+		}
+	}
+	return jsonRequest(ctx, req)
+}
+
+func grpcCreateBagRequest(ctx context.Context, msg []byte) (*http.Request, error) {
+	fields, err := pbFields(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req := struct {
+		Path           string   `json:"path"`
+		Description    string   `json:"description"`
+		SwarmSecret    string   `json:"swarm_secret"`
+		TTL            uint64   `json:"ttl"`
+		RemoveOnExpire bool     `json:"remove_on_expire"`
+		PieceSize      uint32   `json:"piece_size"`
+		ExcludeHidden  bool     `json:"exclude_hidden"`
+		Symlinks       string   `json:"symlinks"`
+		Include        []string `json:"include"`
+		Exclude        []string `json:"exclude"`
+		Timeout        uint64   `json:"timeout"`
+	}{}
+	for _, f := range fields {
+		switch f.field {
+		case 1:
+			req.Path = string(f.data)
+		case 2:
+			req.Description = string(f.data)
+		case 3:
+			req.SwarmSecret = string(f.data)
+		case 4:
+			req.TTL = f.num
+		case 5:
+			req.RemoveOnExpire = f.num != 0
+		case 6:
+			req.PieceSize = uint32(f.num)
+		case 7:
+			req.ExcludeHidden = f.num != 0
+		case 8:
+			req.Symlinks = string(f.data)
+		case 9:
+			req.Include = append(req.Include, string(f.data))
+		case 10:
+			req.Exclude = append(req.Exclude, string(f.data))
+		case 11:
+			req.Timeout = f.num
+		}
+	}
+	return jsonRequest(ctx, req)
+}
+
+func grpcRemoveBagRequest(ctx context.Context, msg []byte) (*http.Request, error) {
+	fields, err := pbFields(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	req := struct {
+		BagID     string `json:"bag_id"`
+		WithFiles bool   `json:"with_files"`
+	}{}
+	for _, f := range fields {
+		switch f.field {
+		case 1:
+			req.BagID = string(f.data)
+		case 2:
+			req.WithFiles = f.num != 0
+		}
+	}
+	return jsonRequest(ctx, req)
+}
+
+func grpcListBagsRequest(ctx context.Context, _ []byte) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+}
+
+func grpcGetBagRequest(ctx context.Context, msg []byte) (*http.Request, error) {
+	fields, err := pbFields(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var bagId string
+	for _, f := range fields {
+		if f.field == 1 {
+			bagId = string(f.data)
+		}
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, "/?bag_id="+url.QueryEscape(bagId), nil)
+}
+
+// grpcResult - Result message, failures of method are returned in it, like Ok and Error of HTTP API
+func grpcResult(status int, body []byte) ([]byte, error) {
+	var w pbWriter
+	if status == http.StatusOK {
+		w.bool(1, true)
+		return w.buf, nil
+	}
+
+	if status == http.StatusNotFound {
+		w.string(2, "bag not found")
+		return w.buf, nil
+	}
+	w.string(2, jsonError(body))
+	return w.buf, nil
+}
+
+func grpcCreateBagResponse(status int, body []byte) ([]byte, error) {
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s", jsonError(body))
+	}
+
+	var res Created
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	var w pbWriter
+	w.string(1, res.BagID)
+	return w.buf, nil
+}
+
+func grpcListBagsResponse(status int, body []byte) ([]byte, error) {
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s", jsonError(body))
+	}
+
+	var res List
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	var w pbWriter
+	for _, b := range res.Bags {
+		w.message(1, encodeBag(b))
+	}
+	return w.buf, nil
+}
+
+func grpcBagDetailedResponse(status int, body []byte) ([]byte, error) {
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("bag not found")
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s", jsonError(body))
+	}
+
+	var res BagDetailed
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	// peers count of bag is shadowed by the peers list in json
+	res.Bag.Peers = uint64(len(res.Peers))
+
+	var w pbWriter
+	w.message(1, encodeBag(res.Bag))
+	w.uint(2, uint64(res.BagPiecesNum))
+	w.bytes(3, res.HasPiecesMask)
+	for _, f := range res.Files {
+		var fw pbWriter
+		fw.uint(1, uint64(f.Index))
+		fw.string(2, f.Name)
+		fw.uint(3, f.Size)
+		w.message(4, fw.buf)
+	}
+	for _, p := range res.Peers {
+		var pw pbWriter
+		pw.string(1, p.Addr)
+		pw.string(2, p.ID)
+		pw.uint(3, p.UploadSpeed)
+		pw.uint(4, p.DownloadSpeed)
+		w.message(5, pw.buf)
+	}
+	w.uint(6, uint64(res.HotPieces))
+	return w.buf, nil
+}
+
+func encodeBag(b Bag) []byte {
+	var w pbWriter
+	w.string(1, b.BagID)
+	w.string(2, b.Description)
+	w.uint(3, b.Downloaded)
+	w.uint(4, b.Size)
+	w.uint(5, b.Peers)
+	w.uint(6, b.DownloadSpeed)
+	w.uint(7, b.UploadSpeed)
+	w.uint(8, b.FilesCount)
+	w.string(9, b.DirName)
+	w.bool(10, b.Completed)
+	w.bool(11, b.HeaderLoaded)
+	w.bool(12, b.InfoLoaded)
+	w.bool(13, b.Active)
+	w.bool(14, b.Seeding)
+	w.bool(15, b.Private)
+	w.int(16, b.ExpiresAt)
+	w.string(17, b.Error)
+	return w.buf
+}
+
+func encodeEvent(e Event) []byte {
+	types := map[string]uint64{
+		EventPieceCompleted: 1,
+		EventBagCompleted:   2,
+		EventPeerConnected:  3,
+	}
+
+	var w pbWriter
+	w.uint(1, types[e.Type])
+	w.string(2, e.BagID)
+	if e.Piece != nil {
+		w.uint(3, uint64(*e.Piece))
+	}
+	w.string(4, e.PeerID)
+	w.string(5, e.Addr)
+	w.int(6, e.Time)
+	return w.buf
+}
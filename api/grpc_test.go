@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"github.com/xssnick/tonutils-storage/storage/storagetest"
+	"golang.org/x/net/http2"
+)
+
+// grpcCall - unary call with grpc framing over plain HTTP/2, like stock grpc client does,
+// returns reply message and grpc-status from trailers
+func grpcCall(t *testing.T, client *http.Client, url, method, login string, msg []byte) ([]byte, string) {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+	req, err := http.NewRequest(http.MethodPost, url+grpcPath+method, bytes.NewReader(append(frame, msg...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+	req.SetBasicAuth(login, "pass")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("got %s, expected HTTP/2", resp.Proto)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("got status %d and content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// trailers are available only after body is read
+	code := resp.Trailer.Get("Grpc-Status")
+	if len(body) == 0 {
+		return nil, code
+	}
+
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		t.Fatalf("malformed reply frame %x", body)
+	}
+	return body[5:], code
+}
+
+func TestGRPC_Handler(t *testing.T) {
+	conn := storage.NewConnector(storagetest.Server{})
+	store, err := db.NewStorage(db.NewMemoryKV(), conn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	dir := filepath.Join(t.TempDir(), "bag")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rootPath, dirName, files, err := store.DetectFileRefs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := storage.CreateTorrent(context.Background(), rootPath, dirName, "desc", store, conn, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetTorrent(tor); err != nil {
+		t.Fatal(err)
+	}
+	bagId := hex.EncodeToString(tor.BagID)
+
+	s := NewServer(conn, store)
+	s.SetCredentials(&Credentials{Login: "user", Password: "pass"})
+
+	srv := httptest.NewServer(s.GRPCHandler())
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	proto := loadProto(t)
+
+	getBag := func(id string) []byte {
+		var w pbWriter
+		w.string(1, id)
+		return w.buf
+	}
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		login  string
+		msg    []byte
+		code   string
+	}{
+		{"unauthenticated", "ListBags", "other", nil, "16"},
+		{"unknown method", "Unknown", "user", nil, "12"},
+		{"invalid bag id", "GetBag", "user", getBag("zz"), "3"},
+		{"unknown bag", "GetBag", "user", getBag(hex.EncodeToString(make([]byte, 32))), "5"},
+		{"malformed request", "GetBag", "user", []byte{0x0A, 0x05}, "3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, code := grpcCall(t, client, srv.URL, tt.method, tt.login, tt.msg)
+			if code != tt.code {
+				t.Fatalf("got grpc status %s, expected %s", code, tt.code)
+			}
+			if reply != nil {
+				t.Fatalf("got reply %x for failed call", reply)
+			}
+		})
+	}
+
+	reply, code := grpcCall(t, client, srv.URL, "ListBags", "user", nil)
+	if code != "0" {
+		t.Fatalf("got grpc status %s", code)
+	}
+	bags := decodeByName(t, proto["ListBagsResponse"], reply)["bags"].([]any)
+	if len(bags) != 1 {
+		t.Fatalf("got %d bags", len(bags))
+	}
+	if bag := decodeByName(t, proto["Bag"], bags[0].([]byte)); bag["bag_id"] != bagId || bag["description"] != "desc" {
+		t.Fatalf("got bag %v", bag)
+	}
+
+	reply, code = grpcCall(t, client, srv.URL, "GetBag", "user", getBag(bagId))
+	if code != "0" {
+		t.Fatalf("got grpc status %s", code)
+	}
+	detailed := decodeByName(t, proto["BagDetailed"], reply)
+	if f := decodeByName(t, proto["File"], detailed["files"].([]any)[0].([]byte)); f["name"] != "file.txt" {
+		t.Fatalf("got file %v", f)
+	}
+}
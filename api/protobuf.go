@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Minimal protobuf wire format, enough for messages of proto/storage.proto,
+// so gRPC service doesn't need generated code and protobuf runtime.
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+var errBadProtobuf = errors.New("malformed protobuf message")
+
+type pbWriter struct {
+	buf []byte
+}
+
+func (w *pbWriter) tag(field, typ int) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|uint64(typ))
+}
+
+// uint - writes unsigned varint field, zero values are skipped as in proto3
+func (w *pbWriter) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	w.tag(field, pbVarint)
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+// int - writes int64 field, negative numbers are encoded as 10 bytes varint, as protobuf does
+func (w *pbWriter) int(field int, v int64) {
+	w.uint(field, uint64(v))
+}
+
+func (w *pbWriter) bool(field int, v bool) {
+	if v {
+		w.uint(field, 1)
+	}
+}
+
+func (w *pbWriter) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	w.tag(field, pbBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *pbWriter) string(field int, v string) {
+	w.bytes(field, []byte(v))
+}
+
+// message - writes embedded message, unlike scalars it is written even when empty
+func (w *pbWriter) message(field int, v []byte) {
+	w.tag(field, pbBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// pbField - one field of decoded message, num is varint value or length-delimited data
+type pbField struct {
+	field int
+	typ   int
+	num   uint64
+	data  []byte
+}
+
+// pbFields - parses message to list of fields, fixed size values are kept in num
+func pbFields(msg []byte) ([]pbField, error) {
+	var list []pbField
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return nil, errBadProtobuf
+		}
+		msg = msg[n:]
+
+		f := pbField{field: int(key >> 3), typ: int(key & 7)}
+		if f.field == 0 {
+			return nil, errBadProtobuf
+		}
+
+		switch f.typ {
+		case pbVarint:
+			f.num, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return nil, errBadProtobuf
+			}
+			msg = msg[n:]
+		case pbFixed64:
+			if len(msg) < 8 {
+				return nil, errBadProtobuf
+			}
+			f.num = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case pbFixed32:
+			if len(msg) < 4 {
+				return nil, errBadProtobuf
+			}
+			f.num = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		case pbBytes:
+			sz, n := binary.Uvarint(msg)
+			if n <= 0 || sz > uint64(len(msg)-n) {
+				return nil, errBadProtobuf
+			}
+			f.data = msg[n : n+int(sz)]
+			msg = msg[n+int(sz):]
+		default:
+			return nil, errBadProtobuf
+		}
+		list = append(list, f)
+	}
+	return list, nil
+}
+
+// uint32s - values of repeated uint32 field, which can be packed or not
+func (f pbField) uint32s() ([]uint32, error) {
+	if f.typ == pbVarint {
+		if f.num > math.MaxUint32 {
+			return nil, errBadProtobuf
+		}
+		return []uint32{uint32(f.num)}, nil
+	}
+	if f.typ != pbBytes {
+		return nil, errBadProtobuf
+	}
+
+	var list []uint32
+	data := f.data
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || v > math.MaxUint32 {
+			return nil, errBadProtobuf
+		}
+		list = append(list, uint32(v))
+		data = data[n:]
+	}
+	return list, nil
+}
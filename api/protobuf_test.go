@@ -0,0 +1,452 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// protoField - field of message in proto/storage.proto
+type protoField struct {
+	num      int
+	typ      string
+	repeated bool
+}
+
+var protoMessageRegexp = regexp.MustCompile(`(?ms)^message (\w+) \{(?:\}|(.*?)^\})`)
+var protoFieldRegexp = regexp.MustCompile(`(?m)^\s*(repeated )?(\w+) (\w+) = (\d+);`)
+
+// loadProto - fields of all messages of proto/storage.proto by message and field name,
+// so tests are checking codec against the schema which clients are generated from
+func loadProto(t *testing.T) map[string]map[string]protoField {
+	t.Helper()
+
+	data, err := os.ReadFile("proto/storage.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := map[string]map[string]protoField{}
+	for _, m := range protoMessageRegexp.FindAllStringSubmatch(string(data), -1) {
+		fields := map[string]protoField{}
+		for _, f := range protoFieldRegexp.FindAllStringSubmatch(m[2], -1) {
+			num, _ := strconv.Atoi(f[4])
+			fields[f[3]] = protoField{num: num, typ: f[2], repeated: f[1] != ""}
+		}
+		messages[m[1]] = fields
+	}
+	if len(messages) == 0 {
+		t.Fatal("no messages in proto")
+	}
+	return messages
+}
+
+// decodeByName - decodes message to map of field name and value, values are decoded by proto types
+func decodeByName(t *testing.T, schema map[string]protoField, msg []byte) map[string]any {
+	t.Helper()
+
+	names := map[int]string{}
+	for name, f := range schema {
+		names[f.num] = name
+	}
+
+	fields, err := pbFields(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := map[string]any{}
+	for _, f := range fields {
+		name, ok := names[f.field]
+		if !ok {
+			t.Fatalf("field %d is not in proto", f.field)
+		}
+
+		var v any
+		switch schema[name].typ {
+		case "string":
+			v = string(f.data)
+		case "bytes":
+			v = f.data
+		case "bool":
+			v = f.num != 0
+		case "int64":
+			v = int64(f.num)
+		case "uint64", "uint32", "Type":
+			v = f.num
+		default:
+			// embedded messages are decoded by caller
+			v = f.data
+		}
+
+		if schema[name].repeated {
+			list, _ := res[name].([]any)
+			res[name] = append(list, v)
+			continue
+		}
+		res[name] = v
+	}
+	return res
+}
+
+// encodeByName - encodes message from values by field name, as client generated from proto would do
+func encodeByName(t *testing.T, schema map[string]protoField, values map[string]any) []byte {
+	t.Helper()
+
+	var w pbWriter
+	for name, v := range values {
+		f, ok := schema[name]
+		if !ok {
+			t.Fatalf("field %s is not in proto", name)
+		}
+
+		switch val := v.(type) {
+		case string:
+			w.string(f.num, val)
+		case bool:
+			w.bool(f.num, val)
+		case uint64:
+			w.uint(f.num, val)
+		case []string:
+			for _, s := range val {
+				w.string(f.num, s)
+			}
+		case []uint32:
+			// packed, as proto3 encodes repeated scalars
+			var packed pbWriter
+			for _, n := range val {
+				packed.buf = append(packed.buf, encodeVarint(uint64(n))...)
+			}
+			w.bytes(f.num, packed.buf)
+		default:
+			t.Fatalf("unsupported value of %s", name)
+		}
+	}
+	return w.buf
+}
+
+func encodeVarint(v uint64) []byte {
+	var w pbWriter
+	w.uint(1, v)
+	return w.buf[1:]
+}
+
+// TestPbWriter_Wire - encoding examples of protobuf documentation
+func TestPbWriter_Wire(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		write func(w *pbWriter)
+		hex   string
+	}{
+		{"varint", func(w *pbWriter) { w.uint(1, 150) }, "089601"},
+		{"string", func(w *pbWriter) { w.string(2, "testing") }, "120774657374696e67"},
+		{"negative int64", func(w *pbWriter) { w.int(6, -2) }, "30feffffffffffffffff01"},
+		{"bool", func(w *pbWriter) { w.bool(15, true) }, "7801"},
+		{"big field number", func(w *pbWriter) { w.uint(17, 1) }, "880101"},
+		{"zero values are skipped", func(w *pbWriter) {
+			w.uint(1, 0)
+			w.int(2, 0)
+			w.bool(3, false)
+			w.string(4, "")
+			w.bytes(5, nil)
+		}, ""},
+		{"empty message is written", func(w *pbWriter) { w.message(3, nil) }, "1a00"},
+		{"embedded message", func(w *pbWriter) {
+			var inner pbWriter
+			inner.uint(1, 150)
+			w.message(3, inner.buf)
+		}, "1a03089601"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var w pbWriter
+			tt.write(&w)
+			if got := hex.EncodeToString(w.buf); got != tt.hex {
+				t.Fatalf("got %s, expected %s", got, tt.hex)
+			}
+		})
+	}
+}
+
+func TestPbFields(t *testing.T) {
+	var w pbWriter
+	w.uint(1, 150)
+	w.string(2, "testing")
+	w.int(3, -1)
+	w.message(4, nil)
+	// fixed size fields are not written by us, but clients can send them
+	w.buf = append(w.buf, 0x29, 1, 0, 0, 0, 0, 0, 0, 0) // field 5 fixed64
+	w.buf = append(w.buf, 0x35, 2, 0, 0, 0)             // field 6 fixed32
+
+	fields, err := pbFields(w.buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []pbField{
+		{field: 1, typ: pbVarint, num: 150},
+		{field: 2, typ: pbBytes, data: []byte("testing")},
+		{field: 3, typ: pbVarint, num: 1<<64 - 1},
+		{field: 4, typ: pbBytes, data: []byte{}},
+		{field: 5, typ: pbFixed64, num: 1},
+		{field: 6, typ: pbFixed32, num: 2},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("got %+v, expected %+v", fields, expected)
+	}
+
+	for _, bad := range []string{
+		"08",           // varint without value
+		"0896",         // truncated varint
+		"1205746573",   // length is bigger than data
+		"00",           // field 0
+		"0b",           // group wire type
+		"29010000",     // truncated fixed64
+		"3501",         // truncated fixed32
+		"12ffffffff0f", // huge length
+	} {
+		msg, _ := hex.DecodeString(bad)
+		if _, err = pbFields(msg); err == nil {
+			t.Errorf("malformed message %s is parsed", bad)
+		}
+	}
+}
+
+func TestPbField_Uint32s(t *testing.T) {
+	// packed example of protobuf documentation: repeated field 4 = [3, 270, 86942]
+	msg, _ := hex.DecodeString("2206038e029ea705" + "2005")
+	fields, err := pbFields(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var list []uint32
+	for _, f := range fields {
+		v, err := f.uint32s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, v...)
+	}
+	if !reflect.DeepEqual(list, []uint32{3, 270, 86942, 5}) {
+		t.Fatalf("got %v", list)
+	}
+
+	for _, f := range []pbField{
+		{field: 1, typ: pbVarint, num: 1 << 32},
+		{field: 1, typ: pbBytes, data: []byte{0x80, 0x80, 0x80, 0x80, 0x10}},
+		{field: 1, typ: pbBytes, data: []byte{0x80}},
+		{field: 1, typ: pbFixed32, num: 1},
+	} {
+		if _, err = f.uint32s(); err == nil {
+			t.Errorf("invalid %+v is parsed", f)
+		}
+	}
+}
+
+// jsonValues - fields of json object, numbers are kept as json.Number
+func jsonValues(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var res map[string]any
+	if err := dec.Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// checkByName - protobuf values of scalar fields are the same as json values of HTTP API with the same names
+func checkByName(t *testing.T, schema map[string]protoField, pb map[string]any, js map[string]any) {
+	t.Helper()
+
+	for name, f := range schema {
+		if f.repeated || f.typ == "bytes" || (f.typ != "string" && f.typ != "bool" && !strings.Contains(f.typ, "int")) {
+			continue
+		}
+
+		want, ok := js[name]
+		if !ok {
+			t.Errorf("field %s is not in json", name)
+			continue
+		}
+
+		got := pb[name]
+		if n, ok := want.(json.Number); ok {
+			got, want = fmt.Sprint(got), n.String()
+		}
+		if got != want {
+			t.Errorf("field %s: got %v, expected %v", name, got, want)
+		}
+	}
+}
+
+func jsonString(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGRPC_ResponsesMatchProto(t *testing.T) {
+	proto := loadProto(t)
+
+	bag := Bag{
+		BagID:         "aa",
+		Description:   "desc",
+		Downloaded:    3,
+		Size:          4,
+		Peers:         5,
+		DownloadSpeed: 6,
+		UploadSpeed:   7,
+		FilesCount:    8,
+		DirName:       "dir",
+		Completed:     true,
+		HeaderLoaded:  true,
+		InfoLoaded:    true,
+		Active:        true,
+		Seeding:       true,
+		Private:       true,
+		ExpiresAt:     16,
+		Error:         "err",
+	}
+	js := jsonValues(t, []byte(jsonString(t, bag)))
+	checkByName(t, proto["Bag"], decodeByName(t, proto["Bag"], encodeBag(bag)), js)
+
+	detailed := BagDetailed{
+		Bag:           bag,
+		BagPiecesNum:  2,
+		HasPiecesMask: []byte{0xFF},
+		Files:         []File{{Index: 1, Name: "file", Size: 3}},
+		Peers:         []Peer{{Addr: "1.2.3.4:5", ID: "bb", UploadSpeed: 3, DownloadSpeed: 4}},
+		HotPieces:     6,
+	}
+	body := []byte(jsonString(t, detailed))
+	msg, err := grpcBagDetailedResponse(200, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb := decodeByName(t, proto["BagDetailed"], msg)
+	checkByName(t, proto["BagDetailed"], pb, jsonValues(t, body))
+	bag.Peers = uint64(len(detailed.Peers))
+	checkByName(t, proto["Bag"], decodeByName(t, proto["Bag"], pb["bag"].([]byte)),
+		jsonValues(t, []byte(jsonString(t, bag))))
+	if !bytes.Equal(pb["has_pieces_mask"].([]byte), detailed.HasPiecesMask) {
+		t.Fatal("pieces mask is not matching")
+	}
+	checkByName(t, proto["File"], decodeByName(t, proto["File"], pb["files"].([]any)[0].([]byte)),
+		jsonValues(t, []byte(jsonString(t, detailed.Files[0]))))
+	checkByName(t, proto["Peer"], decodeByName(t, proto["Peer"], pb["peers"].([]any)[0].([]byte)),
+		jsonValues(t, []byte(jsonString(t, detailed.Peers[0]))))
+
+	msg, err = grpcListBagsResponse(200, []byte(jsonString(t, List{Bags: []Bag{bag, bag}})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bags := decodeByName(t, proto["ListBagsResponse"], msg)["bags"].([]any); len(bags) != 2 {
+		t.Fatalf("got %d bags", len(bags))
+	}
+
+	msg, err = grpcCreateBagResponse(200, []byte(`{"bag_id":"cc"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := decodeByName(t, proto["CreateBagResponse"], msg)["bag_id"]; id != "cc" {
+		t.Fatalf("got bag id %v", id)
+	}
+
+	for _, tt := range []struct {
+		status int
+		body   string
+		res    map[string]any
+	}{
+		{200, `{"ok":true}`, map[string]any{"ok": true}},
+		{400, `{"error":"bad request"}`, map[string]any{"error": "bad request"}},
+		{404, ``, map[string]any{"error": "bag not found"}},
+	} {
+		msg, err = grpcResult(tt.status, []byte(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := decodeByName(t, proto["Result"], msg); !reflect.DeepEqual(res, tt.res) {
+			t.Fatalf("status %d: got %v, expected %v", tt.status, res, tt.res)
+		}
+	}
+
+	piece := uint32(7)
+	ev := decodeByName(t, proto["Event"], encodeEvent(Event{
+		Type: EventPeerConnected, BagID: "aa", Piece: &piece, PeerID: "bb", Addr: "1.2.3.4:5", Time: 9,
+	}))
+	expected := map[string]any{"type": uint64(3), "bag_id": "aa", "piece": uint64(7), "peer_id": "bb", "addr": "1.2.3.4:5", "time": int64(9)}
+	if !reflect.DeepEqual(ev, expected) {
+		t.Fatalf("got event %v, expected %v", ev, expected)
+	}
+}
+
+func TestGRPC_RequestsMatchProto(t *testing.T) {
+	proto := loadProto(t)
+
+	for _, tt := range []struct {
+		message string
+		convert func(msg []byte) ([]byte, error)
+		values  map[string]any
+	}{
+		{"AddBagRequest", requestBody(grpcAddBagRequest), map[string]any{
+			"bag_id": "aa", "path": "/data", "download_all": true, "files": []uint32{1, 300, 70000},
+			"swarm_secret": "secret", "ttl": uint64(60), "remove_on_expire": true, "sequential": true,
+		}},
+		{"CreateBagRequest", requestBody(grpcCreateBagRequest), map[string]any{
+			"path": "/data", "description": "desc", "swarm_secret": "secret", "ttl": uint64(60),
+			"remove_on_expire": true, "piece_size": uint64(1 << 20), "exclude_hidden": true, "symlinks": "skip",
+			"include": []string{"*.txt", "*.md"}, "exclude": []string{".git/**"}, "timeout": uint64(30),
+		}},
+		{"RemoveBagRequest", requestBody(grpcRemoveBagRequest), map[string]any{
+			"bag_id": "aa", "with_files": true,
+		}},
+	} {
+		t.Run(tt.message, func(t *testing.T) {
+			body, err := tt.convert(encodeByName(t, proto[tt.message], tt.values))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := jsonValues(t, []byte(jsonString(t, tt.values)))
+			if got := jsonValues(t, body); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("got %v, expected %v", got, expected)
+			}
+		})
+	}
+
+	req, err := grpcGetBagRequest(context.Background(), encodeByName(t, proto["GetBagRequest"], map[string]any{"bag_id": "a&b"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := req.URL.Query().Get("bag_id"); id != "a&b" {
+		t.Fatalf("got bag id %q", id)
+	}
+}
+
+// requestBody - json body of http request made from protobuf request
+func requestBody(convert func(ctx context.Context, msg []byte) (*http.Request, error)) func(msg []byte) ([]byte, error) {
+	return func(msg []byte) ([]byte, error) {
+		req, err := convert(context.Background(), msg)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(req.Body)
+	}
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"net/http"
+	"sort"
+)
+
+// DesiredBag - wanted state of bag on node, empty files list means that all files are wanted
+type DesiredBag struct {
+	BagID       string   `json:"bag_id"`
+	Path        string   `json:"path"`
+	Files       []uint32 `json:"files"`
+	SwarmSecret string   `json:"swarm_secret"`
+	Paused      bool     `json:"paused"`
+	Sequential  bool     `json:"sequential"`
+	// Priority - bags with higher priority are added and started first, so they are first
+	// to take memory of downloads when it is limited, bags with same priority are kept in list order
+	Priority int `json:"priority"`
+	// DownloadLimit and UploadLimit - speed limits of bag in bytes per second, 0 is unlimited
+	DownloadLimit uint64 `json:"download_limit"`
+	UploadLimit   uint64 `json:"upload_limit"`
+}
+
+type ReconcileAction struct {
+	BagID   string   `json:"bag_id"`
+	Action  string   `json:"action"`
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+type ReconcileReport struct {
+	DryRun    bool              `json:"dry_run"`
+	Actions   []ReconcileAction `json:"actions"`
+	Added     uint64            `json:"added"`
+	Removed   uint64            `json:"removed"`
+	Updated   uint64            `json:"updated"`
+	Unchanged uint64            `json:"unchanged"`
+	Failed    uint64            `json:"failed"`
+}
+
+const (
+	ReconcileAdd    = "add"
+	ReconcileRemove = "remove"
+	ReconcileUpdate = "update"
+	ReconcileKeep   = "keep"
+)
+
+// ReconcileRequest - desired state of all bags of node, same format is used by bags file of node config
+type ReconcileRequest struct {
+	Bags           []DesiredBag `json:"bags"`
+	Prune          bool         `json:"prune"`
+	PruneWithFiles bool         `json:"prune_with_files"`
+	DryRun         bool         `json:"dry_run"`
+}
+
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	var req ReconcileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+
+	report, err := Reconcile(s.store, s.connector, s.downloadsPath, req)
+	if err != nil {
+		response(w, http.StatusBadRequest, Error{err.Error()})
+		return
+	}
+	response(w, http.StatusOK, report)
+}
+
+// Reconcile - brings bags of node to the desired state, bags without path are added to downloadsPath.
+// Error is returned only when request is invalid, then nothing is applied, failures of single bags are in report.
+func Reconcile(store *db.Storage, connector storage.NetConnector, downloadsPath string, req ReconcileRequest) (*ReconcileReport, error) {
+	// validate whole list before applying anything, to not leave node half reconciled
+	desired := map[string]bool{}
+	ids := make([][]byte, len(req.Bags))
+	for i := range req.Bags {
+		uri, err := storage.ParseBagURI(req.Bags[i].BagID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bag id %s", req.Bags[i].BagID)
+		}
+		if desired[string(uri.BagID)] {
+			return nil, fmt.Errorf("duplicate bag id %s", req.Bags[i].BagID)
+		}
+		desired[string(uri.BagID)] = true
+		ids[i] = uri.BagID
+
+		if len(req.Bags[i].Files) == 0 && len(uri.Files) > 0 {
+			req.Bags[i].Files = uri.Files
+		}
+		if req.Bags[i].Path == "" {
+			req.Bags[i].Path = downloadsPath
+		}
+	}
+
+	order := make([]int, len(req.Bags))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return req.Bags[order[i]].Priority > req.Bags[order[j]].Priority
+	})
+
+	rc := &reconciler{store: store, connector: connector}
+	report := &ReconcileReport{DryRun: req.DryRun, Actions: []ReconcileAction{}}
+	for _, i := range order {
+		act := rc.reconcileBag(ids[i], req.Bags[i], req.DryRun)
+		report.add(act)
+	}
+
+	if req.Prune {
+		var extra []ReconcileAction
+		for _, t := range store.GetAll() {
+			if desired[string(t.BagID)] {
+				continue
+			}
+
+			act := ReconcileAction{BagID: hex.EncodeToString(t.BagID), Action: ReconcileRemove}
+			if !req.DryRun {
+				if err := store.RemoveTorrent(t, req.PruneWithFiles); err != nil {
+					act.Error = "failed to remove: " + err.Error()
+				} else {
+					pterm.Success.Println("Bag removed by reconcile", act.BagID)
+				}
+			}
+			extra = append(extra, act)
+		}
+		sort.Slice(extra, func(i, j int) bool {
+			return extra[i].BagID < extra[j].BagID
+		})
+		for _, act := range extra {
+			report.add(act)
+		}
+	}
+	return report, nil
+}
+
+func (r *ReconcileReport) add(act ReconcileAction) {
+	r.Actions = append(r.Actions, act)
+	if act.Error != "" {
+		r.Failed++
+		return
+	}
+
+	switch act.Action {
+	case ReconcileAdd:
+		r.Added++
+	case ReconcileRemove:
+		r.Removed++
+	case ReconcileUpdate:
+		r.Updated++
+	case ReconcileKeep:
+		r.Unchanged++
+	}
+}
+
+type reconciler struct {
+	store     *db.Storage
+	connector storage.NetConnector
+}
+
+func (s *reconciler) reconcileBag(id []byte, bag DesiredBag, dryRun bool) ReconcileAction {
+	act := ReconcileAction{BagID: hex.EncodeToString(id)}
+
+	tor := s.store.GetTorrent(id)
+	if tor == nil {
+		act.Action = ReconcileAdd
+		if !dryRun {
+			if err := s.reconcileAdd(id, bag); err != nil {
+				act.Error = err.Error()
+			} else {
+				pterm.Success.Println("Bag added by reconcile", act.BagID)
+			}
+		}
+		return act
+	}
+
+	// compare only what is requested to be changed, and apply it after,
+	// so dry run reports exactly the same changes
+	active, _ := tor.IsActive()
+	if bag.Paused == active {
+		if bag.Paused {
+			act.Changes = append(act.Changes, "pause")
+		} else {
+			act.Changes = append(act.Changes, "resume")
+		}
+	}
+	if !sameFiles(tor, bag.Files) {
+		act.Changes = append(act.Changes, "files")
+	}
+	if tor.IsSequential() != bag.Sequential {
+		act.Changes = append(act.Changes, "sequential")
+	}
+	if dl, ul := tor.GetSpeedLimits(); dl != bag.DownloadLimit || ul != bag.UploadLimit {
+		act.Changes = append(act.Changes, "speed_limits")
+	}
+	if bag.SwarmSecret != string(tor.GetSwarmSecret()) {
+		act.Changes = append(act.Changes, "swarm_secret")
+	}
+
+	if len(act.Changes) == 0 {
+		act.Action = ReconcileKeep
+		return act
+	}
+	act.Action = ReconcileUpdate
+
+	if !dryRun {
+		if err := s.reconcileUpdate(tor, bag, act.Changes); err != nil {
+			act.Error = err.Error()
+		} else {
+			pterm.Success.Println("Bag updated by reconcile", act.BagID, act.Changes)
+		}
+	}
+	return act
+}
+
+func (s *reconciler) reconcileAdd(id []byte, bag DesiredBag) error {
+	tor := storage.NewTorrent(bag.Path+"/"+hex.EncodeToString(id), s.store, s.connector)
+	tor.BagID = id
+	tor.SetSwarmSecret([]byte(bag.SwarmSecret))
+	tor.SetSpeedLimits(bag.DownloadLimit, bag.UploadLimit)
+	_ = tor.SetSequential(bag.Sequential)
+
+	downloadAll := len(bag.Files) == 0
+	if bag.Paused {
+		tor.SetStartOptions(true, downloadAll, false)
+	} else if err := tor.Start(true, downloadAll, false); err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+
+	if err := s.store.SetTorrent(tor); err != nil {
+		return fmt.Errorf("failed to save to db: %w", err)
+	}
+
+	if !downloadAll {
+		if err := tor.SetActiveFilesIDs(bag.Files); err != nil {
+			return fmt.Errorf("failed to set active files: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *reconciler) reconcileUpdate(tor *storage.Torrent, bag DesiredBag, changes []string) error {
+	for _, ch := range changes {
+		var err error
+		switch ch {
+		case "files":
+			if len(bag.Files) == 0 {
+				err = tor.SetDownloadAll()
+			} else {
+				err = tor.SetActiveFilesIDs(bag.Files)
+			}
+		case "sequential":
+			err = tor.SetSequential(bag.Sequential)
+		case "speed_limits":
+			tor.SetSpeedLimits(bag.DownloadLimit, bag.UploadLimit)
+		case "swarm_secret":
+			tor.SetSwarmSecret([]byte(bag.SwarmSecret))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", ch, err)
+		}
+	}
+
+	// state is changed last, so new settings are used by resumed bag
+	if len(changes) > 0 {
+		switch changes[0] {
+		case "pause":
+			return s.store.PauseTorrent(tor)
+		case "resume":
+			return s.store.ResumeTorrent(tor)
+		}
+	}
+
+	if err := s.store.SetTorrent(tor); err != nil {
+		return fmt.Errorf("failed to save to db: %w", err)
+	}
+	return nil
+}
+
+func sameFiles(tor *storage.Torrent, files []uint32) bool {
+	if len(files) == 0 {
+		return tor.IsDownloadAll()
+	}
+	if tor.IsDownloadAll() {
+		return false
+	}
+
+	active := tor.GetActiveFilesIDs()
+	if len(active) != len(files) {
+		return false
+	}
+
+	has := make(map[uint32]bool, len(active))
+	for _, id := range active {
+		has[id] = true
+	}
+	for _, id := range files {
+		if !has[id] {
+			return false
+		}
+	}
+	return true
+}
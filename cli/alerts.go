@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/db"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var alertsClient = &http.Client{Timeout: 10 * time.Second}
+
+// quotaAlerter - delivers quota alerts to webhook and telegram, in background, to not block quota checks
+func quotaAlerter(cfg db.AlertsConfig) func(a db.QuotaAlert) {
+	return func(a db.QuotaAlert) {
+		if a.Level == db.QuotaLevelOk {
+			pterm.Info.Println(a.String())
+		} else {
+			pterm.Warning.Println(a.String())
+		}
+
+		go func() {
+			if cfg.WebhookURL != "" {
+				if err := sendWebhookAlert(cfg.WebhookURL, a); err != nil {
+					pterm.Error.Println("Failed to send alert to webhook:", err.Error())
+				}
+			}
+			if cfg.TelegramBotToken != "" && cfg.TelegramChatID != "" {
+				if err := sendTelegramAlert(cfg.TelegramBotToken, cfg.TelegramChatID, a); err != nil {
+					pterm.Error.Println("Failed to send alert to telegram:", err.Error())
+				}
+			}
+		}()
+	}
+}
+
+func sendWebhookAlert(webhook string, a db.QuotaAlert) error {
+	data, err := json.Marshal(struct {
+		Quota   string `json:"quota"`
+		Level   string `json:"level"`
+		Used    uint64 `json:"used"`
+		Limit   uint64 `json:"limit"`
+		Message string `json:"message"`
+	}{a.Quota, a.Level, a.Used, a.Limit, a.String()})
+	if err != nil {
+		return err
+	}
+
+	resp, err := alertsClient.Post(webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sendTelegramAlert(token, chatId string, a db.QuotaAlert) error {
+	resp, err := alertsClient.PostForm("https://api.telegram.org/bot"+token+"/sendMessage", url.Values{
+		"chat_id": {chatId},
+		"text":    {"[tonutils-storage] " + a.String()},
+	})
+	if err != nil {
+		// url contains token, so we are not showing it
+		return fmt.Errorf("request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
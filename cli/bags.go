@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/api"
+	"os"
+	"path/filepath"
+)
+
+// BagsFile - declared bags of node from config, empty when it is not set
+var BagsFile string
+
+// loadBagsFile - reads declared bags, file has the same format as /api/v1/reconcile request
+func loadBagsFile(path string) (api.ReconcileRequest, error) {
+	var req api.ReconcileRequest
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, err
+	}
+	if err = json.Unmarshal(data, &req); err != nil {
+		return req, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return req, nil
+}
+
+// applyBagsFile - reconciles bags of node with declared ones, missing bags are added and existing are adjusted
+func applyBagsFile() error {
+	if BagsFile == "" {
+		return fmt.Errorf("BagsFile is not set in config")
+	}
+
+	path := BagsFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(*DBPath, path)
+	}
+
+	req, err := loadBagsFile(path)
+	if err != nil {
+		return err
+	}
+
+	report, err := api.Reconcile(Storage, Connector, *DBPath+"/downloads", req)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	for _, act := range report.Actions {
+		if act.Error != "" {
+			pterm.Error.Println("Failed to", act.Action, "bag", act.BagID, "from bags file:", act.Error)
+		}
+	}
+	pterm.Info.Println("Bags file is applied:", report.Added, "added,", report.Updated, "updated,",
+		report.Removed, "removed,", report.Unchanged, "unchanged,", report.Failed, "failed")
+	return nil
+}
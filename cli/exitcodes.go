@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/storage"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Exit codes of one-shot invocation with -exec flag, they are stable, so scripts can branch on failure type
+const (
+	ExitOK = 0
+	// ExitFailed - any failure which has no own code
+	ExitFailed = 1
+	// ExitUsage - wrong arguments or unknown command
+	ExitUsage = 2
+	// ExitInvalidID - bag, node or job id is malformed
+	ExitInvalidID = 3
+	// ExitNotFound - bag, file or mount is not found
+	ExitNotFound = 4
+	// ExitTimeout - operation is not finished in time
+	ExitTimeout = 5
+	// ExitDiskFull - no space left on device
+	ExitDiskFull = 6
+)
+
+// exitCategories - names of exit codes, printed in machine-readable error line
+var exitCategories = map[int]string{
+	ExitOK:        "ok",
+	ExitFailed:    "failed",
+	ExitUsage:     "usage",
+	ExitInvalidID: "invalid_id",
+	ExitNotFound:  "not_found",
+	ExitTimeout:   "timeout",
+	ExitDiskFull:  "disk_full",
+}
+
+// commandFailure - first failure of executed command, it is reported by exit code of one-shot invocation
+var commandFailure struct {
+	mx   sync.Mutex
+	code int
+	msg  string
+}
+
+// fail - prints error of command and remembers it
+func fail(code int, a ...any) {
+	pterm.Error.Println(a...)
+	msg := strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+	setFailure(code, msg)
+
+	if isJSONOutput() {
+		printJSON(struct {
+			Ok       bool   `json:"ok"`
+			Code     int    `json:"code"`
+			Category string `json:"category"`
+			Error    string `json:"error"`
+		}{false, code, exitCategories[code], msg})
+	}
+}
+
+// failErr - same as fail, code is detected from error, its text is printed after other arguments
+func failErr(err error, a ...any) {
+	fail(errorCode(err), append(a, err.Error())...)
+}
+
+// setFailure - remembers failure which is already printed in other way, like by spinner
+func setFailure(code int, msg string) {
+	commandFailure.mx.Lock()
+	defer commandFailure.mx.Unlock()
+
+	if commandFailure.code == ExitOK {
+		commandFailure.code = code
+		commandFailure.msg = msg
+	}
+}
+
+// errorCode - returns exit code by type of error
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, storage.ErrNotEnoughSpace):
+		return ExitDiskFull
+	case errors.Is(err, fs.ErrNotExist):
+		return ExitNotFound
+	}
+	return ExitFailed
+}
+
+// exitWithResult - exits with code of command failure, failure is also written to stderr as json line:
+// {"code":4,"category":"not_found","error":"Bag not found"}
+func exitWithResult() {
+	commandFailure.mx.Lock()
+	code, msg := commandFailure.code, commandFailure.msg
+	commandFailure.mx.Unlock()
+
+	if code != ExitOK {
+		data, _ := json.Marshal(struct {
+			Code     int    `json:"code"`
+			Category string `json:"category"`
+			Error    string `json:"error"`
+		}{code, exitCategories[code], msg})
+		_, _ = fmt.Fprintln(os.Stderr, string(data))
+	}
+	os.Exit(code)
+}
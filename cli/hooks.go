@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// HookCommandTimeout - hook command is killed when it is running longer
+var HookCommandTimeout = 30 * time.Minute
+
+const (
+	hookEventComplete = "complete"
+	hookEventCreate   = "create"
+	hookEventError    = "error"
+)
+
+// hookPayload - bag metadata which is passed to hook commands and webhooks
+type hookPayload struct {
+	Event       string `json:"event"`
+	BagID       string `json:"bag_id"`
+	Description string `json:"description"`
+	Path        string `json:"path"`
+	DirName     string `json:"dir_name"`
+	Size        uint64 `json:"size"`
+	FilesCount  uint32 `json:"files_count"`
+	Error       string `json:"error,omitempty"`
+}
+
+// configHooks - fires commands and webhooks of config on bag events, other hooks are still called after it
+type configHooks struct {
+	storage.Hooks
+	cfg db.HooksConfig
+}
+
+func newConfigHooks(cfg db.HooksConfig, next storage.Hooks) *configHooks {
+	if next == nil {
+		next = storage.NoopHooks{}
+	}
+	return &configHooks{Hooks: next, cfg: cfg}
+}
+
+func (h *configHooks) OnBagCompleted(t *storage.Torrent) {
+	h.fire(h.cfg.OnComplete, hookEventComplete, t, nil)
+	h.Hooks.OnBagCompleted(t)
+}
+
+func (h *configHooks) OnBagCreated(t *storage.Torrent) {
+	h.fire(h.cfg.OnCreate, hookEventCreate, t, nil)
+	h.Hooks.OnBagCreated(t)
+}
+
+func (h *configHooks) OnBagError(t *storage.Torrent, err error) {
+	h.fire(h.cfg.OnError, hookEventError, t, err)
+	h.Hooks.OnBagError(t, err)
+}
+
+// fire - runs hook in background, hooks are called from download routines and should not block them
+func (h *configHooks) fire(cfg db.HookConfig, event string, t *storage.Torrent, bagErr error) {
+	if cfg.Command == "" && cfg.WebhookURL == "" {
+		return
+	}
+
+	p := hookPayload{
+		Event: event,
+		BagID: hex.EncodeToString(t.BagID),
+		Path:  t.Path,
+	}
+	if t.Header != nil {
+		p.DirName = string(t.Header.DirName)
+		p.Path = filepath.Join(t.Path, p.DirName)
+		p.FilesCount = t.Header.FilesCount
+	}
+	if t.Info != nil {
+		p.Description = t.Info.Description.Value
+		p.Size = t.Info.FileSize - t.Info.HeaderSize
+	}
+	if bagErr != nil {
+		p.Error = bagErr.Error()
+	}
+
+	go func() {
+		if cfg.Command != "" {
+			if err := runHookCommand(cfg.Command, p); err != nil {
+				pterm.Error.Println("Hook command of", event, "event failed for bag", p.BagID+":", err.Error())
+			}
+		}
+		if cfg.WebhookURL != "" {
+			if err := sendHookWebhook(cfg.WebhookURL, p); err != nil {
+				pterm.Error.Println("Hook webhook of", event, "event failed for bag", p.BagID+":", err.Error())
+			}
+		}
+	}()
+}
+
+func runHookCommand(command string, p hookPayload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HookCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"TONSTORAGE_EVENT="+p.Event,
+		"TONSTORAGE_BAG_ID="+p.BagID,
+		"TONSTORAGE_DESCRIPTION="+p.Description,
+		"TONSTORAGE_PATH="+p.Path,
+		"TONSTORAGE_DIR_NAME="+p.DirName,
+		"TONSTORAGE_SIZE="+strconv.FormatUint(p.Size, 10),
+		"TONSTORAGE_FILES_COUNT="+strconv.FormatUint(uint64(p.FilesCount), 10),
+		"TONSTORAGE_ERROR="+p.Error,
+	)
+	cmd.Stdin = bytes.NewReader(data)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%w: %s", err, bytes.TrimSpace(out))
+		}
+		return err
+	}
+	return nil
+}
+
+func sendHookWebhook(webhook string, p hookPayload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	resp, err := alertsClient.Post(webhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/db"
+	"sync"
+	"time"
+)
+
+// job - long operation started from CLI, like hashing files of new bag or verification.
+// It can be cancelled with cancel command, or with Ctrl+C when it is running in foreground,
+// without stopping the node.
+type job struct {
+	*db.Job
+	background bool
+}
+
+var (
+	// foregroundJob - job which is waited by CLI, Ctrl+C cancels it
+	foregroundJob *job
+	jobsMx        sync.Mutex
+)
+
+// runJob - runs operation as job, in foreground it is waited, in background CLI is available meanwhile.
+// Timeout 0 = unlimited. Foreground operations are printing their outcome themselves.
+func runJob(kind, target string, timeout time.Duration, background bool, fn func(ctx context.Context, j *job) (string, error)) {
+	// failure of foreground job is the result of one-shot invocation
+	var jobErr error
+	jobCode := ExitOK
+	dj, err := Storage.StartJob(kind, target, timeout, func(ctx context.Context, dj *db.Job) (string, error) {
+		res, err := fn(ctx, &job{Job: dj, background: background})
+		if err != nil {
+			jobErr, jobCode = err, errorCode(err)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				jobCode = ExitTimeout
+			}
+		}
+		return res, err
+	})
+	if err != nil {
+		failErr(err, "Failed to start job:")
+		return
+	}
+	j := &job{Job: dj, background: background}
+
+	if background {
+		pterm.Info.Println("Job", j.ID(), "is started, check it with 'jobs', stop with 'cancel", fmt.Sprint(j.ID())+"'")
+		go func() {
+			<-j.Done()
+			info := j.Info()
+			switch info.Status {
+			case db.JobDone:
+				pterm.Success.Println("Job", info.ID, info.Kind, info.Target, "is done:", info.Result)
+			case db.JobInterrupted:
+			default:
+				pterm.Warning.Println("Job", info.ID, info.Kind, info.Target, "is", info.Status+":", info.Error)
+			}
+		}()
+		return
+	}
+
+	jobsMx.Lock()
+	foregroundJob = j
+	jobsMx.Unlock()
+
+	<-j.Done()
+
+	jobsMx.Lock()
+	if foregroundJob == j {
+		foregroundJob = nil
+	}
+	jobsMx.Unlock()
+
+	if jobErr != nil {
+		setFailure(jobCode, jobErr.Error())
+	}
+}
+
+// cancelForegroundJob - called on Ctrl+C, returns false when there is no job to cancel, then node is stopped
+func cancelForegroundJob() bool {
+	jobsMx.Lock()
+	defer jobsMx.Unlock()
+
+	if foregroundJob == nil {
+		return false
+	}
+	pterm.Warning.Println("Cancelling job", foregroundJob.ID(), "press Ctrl+C again to stop the node")
+	foregroundJob.Cancel()
+	foregroundJob = nil
+	return true
+}
+
+func cancelJob(id uint64) {
+	if err := Storage.CancelJob(id); err != nil {
+		failErr(err, "Failed to cancel job", id, "-")
+		return
+	}
+	pterm.Success.Println("Job", id, "is cancelled")
+}
+
+// listJobs - shows running jobs and last finished ones
+func listJobs(all bool) {
+	list, err := Storage.ListJobs()
+	if err != nil {
+		failErr(err, "Failed to list jobs:")
+		return
+	}
+	if len(list) == 0 {
+		pterm.Info.Println("No jobs yet")
+		return
+	}
+	if !all && len(list) > 10 {
+		list = list[:10]
+	}
+
+	var table = pterm.TableData{
+		{"ID", "Operation", "Target", "Status", "Progress", "Started", "Duration", "Outcome"},
+	}
+	for _, j := range list {
+		status := j.Status
+		switch j.Status {
+		case db.JobRunning:
+			status = pterm.LightCyan(status)
+		case db.JobDone:
+			status = pterm.LightGreen(status)
+		default:
+			status = pterm.LightRed(status)
+		}
+
+		duration := "-"
+		if j.Status == db.JobRunning {
+			duration = time.Since(j.StartedAt).Truncate(time.Second).String()
+		} else if !j.FinishedAt.IsZero() {
+			duration = j.FinishedAt.Sub(j.StartedAt).Truncate(time.Second).String()
+		}
+
+		outcome := j.Result
+		if j.Error != "" {
+			outcome = j.Error
+		}
+
+		table = append(table, []string{fmt.Sprint(j.ID), j.Kind, j.Target, status,
+			fmt.Sprintf("%d.%d%%", j.Progress/10, j.Progress%10), j.StartedAt.Format("2006-01-02 15:04:05"),
+			duration, outcome})
+	}
+	pterm.DefaultTable.WithHasHeader().WithBoxed().WithData(table).Render()
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+	"os"
+)
+
+// waitKeyPress - returns channel which will be closed when any key is pressed in terminal.
+// Listening stops when stop is closed, terminal mode is restored after that.
+func waitKeyPress(stop <-chan struct{}) <-chan struct{} {
+	pressed := make(chan struct{})
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return pressed
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return pressed
+	}
+
+	go func() {
+		defer term.Restore(fd, state)
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, 100)
+			if err != nil && err != unix.EINTR {
+				return
+			}
+
+			if n > 0 && fds[0].Revents&unix.POLLIN != 0 {
+				buf := make([]byte, 16)
+				_, _ = unix.Read(fd, buf)
+				close(pressed)
+				return
+			}
+		}
+	}()
+
+	return pressed
+}
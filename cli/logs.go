@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/dht"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// setupLogs - configures structured logs by config, verbosity of -debug flag enables debug level,
+// 2 adds dht logs and 3 adds adnl logs. Standard log of db package is written to the same output.
+func setupLogs(cfg db.LogConfig, verbosity int) error {
+	level := strings.ToLower(cfg.Level)
+	if verbosity > 0 {
+		level = "debug"
+	}
+
+	var lvl slog.Level
+	switch level {
+	case "", "none":
+		return nil
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info":
+		lvl = slog.LevelInfo
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return fmt.Errorf("unknown log level %q, should be debug, info, warn, error or none", cfg.Level)
+	}
+
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = f
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(out, opts)
+	case "json":
+		h = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, should be text or json", cfg.Format)
+	}
+
+	storage.Log = slog.New(h)
+	slog.SetDefault(storage.Log)
+
+	if verbosity >= 2 {
+		dht.Logger = storage.LogPrinter("dht")
+	}
+	if verbosity >= 3 {
+		adnl.Logger = storage.LogPrinter("adnl")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// jsonOutput - results of current command are printed to stdout as json lines, for scripts.
+// Human-readable output is moved to stderr meanwhile, so stdout contains only json.
+var jsonOutput struct {
+	mx      sync.Mutex
+	enabled bool
+	// printed - command printed its result, otherwise {"ok":true} is printed after it
+	printed bool
+}
+
+// beginJSON - starts json output of command when it is requested by --json flag or -json global flag
+func beginJSON(enabled bool) {
+	jsonOutput.mx.Lock()
+	defer jsonOutput.mx.Unlock()
+
+	jsonOutput.enabled = enabled || *JSONOutput
+	jsonOutput.printed = false
+	if jsonOutput.enabled {
+		pterm.SetDefaultOutput(os.Stderr)
+	}
+}
+
+// endJSON - prints success result when command has not printed anything, and returns human-readable output back
+func endJSON() {
+	jsonOutput.mx.Lock()
+	enabled, printed := jsonOutput.enabled, jsonOutput.printed
+	jsonOutput.enabled = false
+	jsonOutput.mx.Unlock()
+
+	if !enabled {
+		return
+	}
+	if !printed {
+		writeJSON(struct {
+			Ok bool `json:"ok"`
+		}{true})
+	}
+	if !*JSONOutput {
+		pterm.SetDefaultOutput(os.Stdout)
+	}
+}
+
+func isJSONOutput() bool {
+	jsonOutput.mx.Lock()
+	defer jsonOutput.mx.Unlock()
+	return jsonOutput.enabled
+}
+
+// printJSON - prints result of command as json line
+func printJSON(v any) {
+	jsonOutput.mx.Lock()
+	jsonOutput.printed = true
+	jsonOutput.mx.Unlock()
+
+	writeJSON(v)
+}
+
+func writeJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		pterm.Error.Println("Failed to serialize result:", err.Error())
+		return
+	}
+	_, _ = os.Stdout.Write(append(data, '\n'))
+}
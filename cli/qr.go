@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Minimal QR code encoder: byte mode, error correction level L, versions 1-6,
+// it is enough for bag ids and links, and avoids extra dependency.
+
+type qrVersion struct {
+	dataCodewords int
+	ecPerBlock    int
+	blocks        int
+	alignPos      int
+}
+
+var qrVersions = []qrVersion{
+	{19, 7, 1, 0},
+	{34, 10, 1, 18},
+	{55, 15, 1, 22},
+	{80, 20, 1, 26},
+	{108, 26, 1, 30},
+	{136, 18, 2, 34},
+}
+
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+
+	ver := -1
+	for i, v := range qrVersions {
+		// 4 bits mode + 8 bits length
+		if len(data)+2 <= v.dataCodewords {
+			ver = i
+			break
+		}
+	}
+	if ver < 0 {
+		return nil, fmt.Errorf("too long text for qr code")
+	}
+	v := qrVersions[ver]
+
+	var bits []bool
+	appendBits := func(val, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (val>>i)&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), 8)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	capacity := v.dataCodewords * 8
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		appendBits(0, 1)
+	}
+	for len(bits)%8 != 0 {
+		appendBits(0, 1)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	codewords := make([]byte, v.dataCodewords)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	// split to blocks, calc error correction and interleave
+	blockLen := v.dataCodewords / v.blocks
+	var dataBlocks, ecBlocks [][]byte
+	for i := 0; i < v.blocks; i++ {
+		block := codewords[i*blockLen : (i+1)*blockLen]
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, reedSolomon(block, v.ecPerBlock))
+	}
+
+	var result []byte
+	for i := 0; i < blockLen; i++ {
+		for _, b := range dataBlocks {
+			result = append(result, b[i])
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			result = append(result, b[i])
+		}
+	}
+
+	q := newQRCode(ver+1, v.alignPos)
+	q.drawCodewords(result)
+
+	best, bestPenalty := -1, 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); best < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		// xor again to revert
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+
+	return q, nil
+}
+
+func newQRCode(version, alignPos int) *qrCode {
+	size := version*4 + 17
+	q := &qrCode{
+		size:     size,
+		modules:  make([][]bool, size),
+		function: make([][]bool, size),
+	}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	if alignPos > 0 {
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				q.set(alignPos+dx, alignPos+dy, qrMax(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+
+	// reserve format area
+	q.drawFormat(0)
+	return q
+}
+
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= q.size || yy >= q.size {
+				continue
+			}
+			dist := qrMax(abs(dx), abs(dy))
+			q.set(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *qrCode) drawFormat(mask int) {
+	// level L = 01
+	data := 1<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>i)&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = (data[i/8]>>(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.function[y][x] {
+				continue
+			}
+
+			var inv bool
+			switch mask {
+			case 0:
+				inv = (x+y)%2 == 0
+			case 1:
+				inv = y%2 == 0
+			case 2:
+				inv = x%3 == 0
+			case 3:
+				inv = (x+y)%3 == 0
+			case 4:
+				inv = (x/3+y/2)%2 == 0
+			case 5:
+				inv = x*y%2+x*y%3 == 0
+			case 6:
+				inv = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				inv = ((x+y)%2+x*y%3)%2 == 0
+			}
+			q.modules[y][x] = q.modules[y][x] != inv
+		}
+	}
+}
+
+// penalty - simplified score of the mask, counts long runs, 2x2 blocks and dark/light balance
+func (q *qrCode) penalty() int {
+	res, dark := 0, 0
+	for a := 0; a < q.size; a++ {
+		rowRun, colRun := 1, 1
+		for b := 1; b < q.size; b++ {
+			if q.modules[a][b] == q.modules[a][b-1] {
+				rowRun++
+				if rowRun == 5 {
+					res += 3
+				} else if rowRun > 5 {
+					res++
+				}
+			} else {
+				rowRun = 1
+			}
+
+			if q.modules[b][a] == q.modules[b-1][a] {
+				colRun++
+				if colRun == 5 {
+					res += 3
+				} else if colRun > 5 {
+					res++
+				}
+			} else {
+				colRun = 1
+			}
+		}
+	}
+
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if c == q.modules[y-1][x] && c == q.modules[y][x-1] && c == q.modules[y-1][x-1] {
+					res += 3
+				}
+			}
+		}
+	}
+
+	total := q.size * q.size
+	k := (abs(dark*20-total*10) + total - 1) / total
+	return res + (k-1)*10
+}
+
+// String - renders code with half blocks, light modules are drawn, so it is readable on dark terminals
+func (q *qrCode) String() string {
+	const quiet = 4
+	isLight := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= q.size || y >= q.size {
+			return true
+		}
+		return !q.modules[y][x]
+	}
+
+	var sb strings.Builder
+	for y := -quiet; y < q.size+quiet; y += 2 {
+		for x := -quiet; x < q.size+quiet; x++ {
+			top, bottom := isLight(x, y), isLight(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func reedSolomon(data []byte, degree int) []byte {
+	// generator polynomial, product of (x - a^i)
+	gen := make([]byte, degree)
+	gen[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < degree {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+
+	res := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[degree-1] = 0
+		for i := range res {
+			res[i] ^= gfMul(gen[i], factor)
+		}
+	}
+	return res
+}
+
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func qrMax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-storage/db"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchInterval - how often watched directories are scanned for new subfolders
+const watchInterval = 10 * time.Second
+
+// dirSummary - state of subfolder, it is compared between scans to find when writes are settled
+type dirSummary struct {
+	files    int
+	size     int64
+	modified time.Time
+}
+
+type watchedDir struct {
+	summary dirSummary
+	// stableSince - when summary was changed last time
+	stableSince time.Time
+	// created - summary for which bag creation was started, it is not started again till content is changed
+	created *dirSummary
+}
+
+// watchDirs - creates and seeds bag for each new subfolder of directories, when its files are not changed for settle time.
+// Subfolders which are already shared are skipped, so bag which is removed is created again while its folder exists.
+func watchDirs(dirs []string, settle time.Duration) {
+	subDirs := map[string]*watchedDir{}
+	for {
+		for _, dir := range dirs {
+			scanWatchDir(dir, settle, subDirs)
+		}
+		time.Sleep(watchInterval)
+	}
+}
+
+func scanWatchDir(dir string, settle time.Duration, subDirs map[string]*watchedDir) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		pterm.Warning.Println("Failed to scan watched directory", dir+":", err.Error())
+		return
+	}
+
+	for _, e := range entries {
+		// hidden ones are usually temporary directories of copying tools
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+
+		path, err := filepath.Abs(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if isShared(path) {
+			delete(subDirs, path)
+			continue
+		}
+
+		sum, err := summarizeDir(path)
+		if err != nil {
+			pterm.Warning.Println("Failed to scan", path+":", err.Error())
+			continue
+		}
+
+		w := subDirs[path]
+		if w == nil || w.summary != sum {
+			if w == nil {
+				w = &watchedDir{}
+				subDirs[path] = w
+			}
+			w.summary = sum
+			w.stableSince = time.Now()
+			continue
+		}
+
+		if sum.files == 0 || time.Since(w.stableSince) < settle || time.Since(sum.modified) < settle ||
+			(w.created != nil && *w.created == sum) {
+			continue
+		}
+		w.created = &sum
+
+		name := e.Name()
+		pterm.Info.Println("New folder", path, "is found in watched directory, creating bag")
+		runJob("create", path, 0, true, func(ctx context.Context, j *job) (string, error) {
+			return create(ctx, j, path, name, bagOptions{})
+		})
+	}
+}
+
+// isShared - checks if there is bag created from the directory
+func isShared(path string) bool {
+	for _, t := range Storage.GetAll() {
+		if t.Header == nil || len(t.Header.DirName) == 0 {
+			continue
+		}
+		if filepath.Join(t.Path, string(t.Header.DirName)) == path {
+			return true
+		}
+	}
+	return false
+}
+
+func summarizeDir(path string) (sum dirSummary, err error) {
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(sum.modified) {
+			sum.modified = fi.ModTime()
+		}
+		if d.Type().IsRegular() {
+			sum.files++
+			sum.size += fi.Size()
+		}
+		return nil
+	})
+	return sum, err
+}
+
+// startWatchDirs - checks config and starts watching in background
+func startWatchDirs(cfg *db.Config) {
+	if len(cfg.WatchDirs) == 0 {
+		return
+	}
+
+	for _, dir := range cfg.WatchDirs {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			pterm.Warning.Println("Watched directory", dir, "is not exists yet, it will be scanned when it appears")
+		}
+	}
+
+	settle := time.Duration(cfg.WatchSettleSec) * time.Second
+	if settle == 0 {
+		settle = time.Minute
+	}
+	go watchDirs(cfg.WatchDirs, settle)
+	pterm.Info.Println("Watching", len(cfg.WatchDirs), "directories for new folders to share")
+}
@@ -121,6 +121,14 @@ func LoadConfig(dir string) (*db.Config, error) {
 			ListenAddr:    "0.0.0.0:17555",
 			ExternalIP:    "",
 			DownloadsPath: "./downloads/",
+
+			FileNameCollisionPolicy: "auto",
+			FileNamesNormalization:  "nfc",
+			DownloadsMemoryLimitMB:  1024,
+			LevelDB: db.LevelDBConfig{
+				BlockCacheMB:    32,
+				BloomFilterBits: 10,
+			},
 		}
 
 		ip, seed := checkCanSeed()
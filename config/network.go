@@ -0,0 +1,50 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"os"
+	"strings"
+)
+
+const DefaultNetworkConfigURL = "https://ton.org/global.config.json"
+
+// LoadNetworkConfig - loads global network config from URL or local file path.
+// When source is empty, mainnet config is downloaded, and static cache is used if it is not available.
+// Custom source is used as is, without fallback, because it is probably another network.
+func LoadNetworkConfig(ctx context.Context, source string) (*liteclient.GlobalConfig, error) {
+	if source == "" {
+		cfg, err := liteclient.GetConfigFromUrl(ctx, DefaultNetworkConfigURL)
+		if err != nil {
+			pterm.Warning.Println("Failed to download ton config:", err.Error(), "; We will take it from static cache")
+			cfg = &liteclient.GlobalConfig{}
+			if err = json.NewDecoder(bytes.NewBufferString(FallbackNetworkConfig)).Decode(cfg); err != nil {
+				return nil, fmt.Errorf("failed to parse fallback ton config: %w", err)
+			}
+		}
+		return cfg, nil
+	}
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		cfg, err := liteclient.GetConfigFromUrl(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download ton config from %s: %w", source, err)
+		}
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ton config file: %w", err)
+	}
+
+	cfg := &liteclient.GlobalConfig{}
+	if err = json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse ton config file %s: %w", source, err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+func nodeAddressKey(adnlID []byte) []byte {
+	return append([]byte("addr:"), adnlID...)
+}
+
+// GetNodeAddress - returns cached address of node, expired records are deleted
+func (s *Storage) GetNodeAddress(adnlID []byte) (addr string, key ed25519.PublicKey, ok bool) {
+	data, err := s.db.Get(nodeAddressKey(adnlID))
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			storage.Log.Warn("failed to get cached address of node", storage.Component("storage"), storage.PeerAttr(adnlID), storage.ErrAttr(err))
+		}
+		return "", nil, false
+	}
+
+	// expire at (8) + public key (32) + address
+	if len(data) <= 8+ed25519.PublicKeySize ||
+		time.Unix(int64(binary.LittleEndian.Uint64(data)), 0).Before(time.Now()) {
+		_ = s.RemoveNodeAddress(adnlID)
+		return "", nil, false
+	}
+	return string(data[8+ed25519.PublicKeySize:]), data[8 : 8+ed25519.PublicKeySize], true
+}
+
+func (s *Storage) SetNodeAddress(adnlID []byte, addr string, key ed25519.PublicKey, expireAt time.Time) error {
+	data := make([]byte, 8, 8+ed25519.PublicKeySize+len(addr))
+	binary.LittleEndian.PutUint64(data, uint64(expireAt.Unix()))
+	data = append(data, key...)
+	data = append(data, addr...)
+	return s.db.Put(nodeAddressKey(adnlID), data)
+}
+
+func (s *Storage) RemoveNodeAddress(adnlID []byte) error {
+	return s.db.Delete(nodeAddressKey(adnlID))
+}
+
+// pruneNodeAddresses - deletes expired cached addresses, nodes which are not met again are not asked for them
+func (s *Storage) pruneNodeAddresses() error {
+	now := time.Now()
+	batch := new(Batch)
+	err := s.db.Iterate([]byte("addr:"), func(key, value []byte) bool {
+		if len(value) < 8 || time.Unix(int64(binary.LittleEndian.Uint64(value)), 0).Before(now) {
+			batch.Delete(append([]byte{}, key...))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(batch, false)
+}
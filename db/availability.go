@@ -0,0 +1,282 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AvailabilityBuckets - max number of columns of availability history, pieces of bigger bags are grouped,
+// so hourly point of the bag takes at most 2 bytes per bucket
+var AvailabilityBuckets uint32 = 1024
+
+// AvailabilityPoint - availability of bag pieces among connected peers during one hour
+type AvailabilityPoint struct {
+	// Time - start of the hour
+	Time time.Time
+	// PiecesPerBucket - number of pieces in each bucket, the last bucket can contain less
+	PiecesPerBucket uint32
+	Samples         uint32
+	// Min - for each bucket, the lowest number of peers which had all its pieces, during the hour
+	Min []uint8
+	// Avg - for each bucket, average number of peers which had its rarest piece
+	Avg []uint8
+}
+
+// availabilityAcc - samples of the current hour for one bag
+type availabilityAcc struct {
+	hour            time.Time
+	piecesPerBucket uint32
+	samples         uint32
+	min             []uint8
+	sum             []uint32
+}
+
+var availabilityTrackPrefix = []byte("avail_track:")
+var availabilityKeyPrefix = []byte("avail:")
+
+func availabilityKey(bagID []byte, hour time.Time) []byte {
+	key := make([]byte, len(availabilityKeyPrefix)+len(bagID)+8)
+	n := copy(key, availabilityKeyPrefix)
+	n += copy(key[n:], bagID)
+	// big endian to iterate in time order
+	binary.BigEndian.PutUint64(key[n:], uint64(hour.Unix()))
+	return key
+}
+
+// SetAvailabilityTracking - enables recording of hourly pieces availability of the bag, it is kept for HistoryRetention.
+// When disabled, already recorded history is deleted.
+func (s *Storage) SetAvailabilityTracking(bagID []byte, track bool) error {
+	if track {
+		return s.db.Put(append(append([]byte{}, availabilityTrackPrefix...), bagID...), []byte{1})
+	}
+
+	s.historyMx.Lock()
+	delete(s.availability, string(bagID))
+	s.historyMx.Unlock()
+
+	batch := new(Batch)
+	batch.Delete(append(append([]byte{}, availabilityTrackPrefix...), bagID...))
+	prefix := append(append([]byte{}, availabilityKeyPrefix...), bagID...)
+	err := s.db.Iterate(prefix, func(key, value []byte) bool {
+		batch.Delete(append([]byte{}, key...))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(batch, false)
+}
+
+func (s *Storage) IsAvailabilityTracked(bagID []byte) bool {
+	_, err := s.db.Get(append(append([]byte{}, availabilityTrackPrefix...), bagID...))
+	return err == nil
+}
+
+// GetAvailabilityHistory - returns hourly availability points of the bag since the given time, in time order,
+// including current incomplete hour
+func (s *Storage) GetAvailabilityHistory(bagID []byte, since time.Time) ([]AvailabilityPoint, error) {
+	since = since.UTC().Truncate(time.Hour)
+
+	s.historyMx.Lock()
+	var current *AvailabilityPoint
+	if a := s.availability[string(bagID)]; a != nil && a.samples > 0 {
+		p := a.result()
+		current = &p
+	}
+	s.historyMx.Unlock()
+
+	prefix := append(append([]byte{}, availabilityKeyPrefix...), bagID...)
+	var list []AvailabilityPoint
+	err := s.db.Iterate(prefix, func(key, value []byte) bool {
+		if len(key) != len(prefix)+8 {
+			return true
+		}
+
+		p, err := decodeAvailabilityPoint(value)
+		if err != nil {
+			return true
+		}
+		p.Time = time.Unix(int64(binary.BigEndian.Uint64(key[len(prefix):])), 0).UTC()
+		if !p.Time.Before(since) && (current == nil || !p.Time.Equal(current.Time)) {
+			list = append(list, p)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && !current.Time.Before(since) {
+		list = append(list, *current)
+	}
+	return list, nil
+}
+
+// sampleAvailability - adds sample of pieces availability for tracked bags, should be called under historyMx lock
+func (s *Storage) sampleAvailability(now time.Time) error {
+	hour := now.Truncate(time.Hour)
+
+	for _, t := range s.GetAll() {
+		if !s.IsAvailabilityTracked(t.BagID) {
+			continue
+		}
+		if active, _ := t.IsActive(); !active {
+			continue
+		}
+
+		avail := t.PiecesAvailability()
+		if len(avail) == 0 {
+			continue
+		}
+
+		a := s.availability[string(t.BagID)]
+		if a != nil && !a.hour.Equal(hour) {
+			if err := s.flushAvailability(t.BagID, a); err != nil {
+				return err
+			}
+			a = nil
+		}
+		if a == nil {
+			var err error
+			if a, err = s.loadAvailabilityHour(t.BagID, hour, uint32(len(avail))); err != nil {
+				return err
+			}
+			s.availability[string(t.BagID)] = a
+		}
+		a.add(avail)
+	}
+	return nil
+}
+
+// loadAvailabilityHour - continues point of the hour saved before restart, or starts new one
+func (s *Storage) loadAvailabilityHour(bagID []byte, hour time.Time, pieces uint32) (*availabilityAcc, error) {
+	perBucket := (pieces + AvailabilityBuckets - 1) / AvailabilityBuckets
+	buckets := (pieces + perBucket - 1) / perBucket
+	a := &availabilityAcc{
+		hour:            hour,
+		piecesPerBucket: perBucket,
+		min:             make([]uint8, buckets),
+		sum:             make([]uint32, buckets),
+	}
+
+	data, err := s.db.Get(availabilityKey(bagID, hour))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return a, nil
+		}
+		return nil, err
+	}
+
+	p, err := decodeAvailabilityPoint(data)
+	if err != nil || p.PiecesPerBucket != perBucket || len(p.Min) != len(a.min) {
+		return a, nil
+	}
+	a.samples = p.Samples
+	copy(a.min, p.Min)
+	for i, v := range p.Avg {
+		a.sum[i] = uint32(v) * p.Samples
+	}
+	return a, nil
+}
+
+// add - accounts sample, bucket value is availability of its rarest piece
+func (a *availabilityAcc) add(avail []uint32) {
+	for i := range a.min {
+		var rarest uint32 = 255
+		for p := uint32(i) * a.piecesPerBucket; p < uint32(i+1)*a.piecesPerBucket && p < uint32(len(avail)); p++ {
+			if avail[p] < rarest {
+				rarest = avail[p]
+			}
+		}
+
+		if a.samples == 0 || uint8(rarest) < a.min[i] {
+			a.min[i] = uint8(rarest)
+		}
+		a.sum[i] += rarest
+	}
+	a.samples++
+}
+
+func (a *availabilityAcc) result() AvailabilityPoint {
+	p := AvailabilityPoint{
+		Time:            a.hour,
+		PiecesPerBucket: a.piecesPerBucket,
+		Samples:         a.samples,
+		Min:             append([]uint8{}, a.min...),
+		Avg:             make([]uint8, len(a.sum)),
+	}
+	if a.samples > 0 {
+		for i, v := range a.sum {
+			p.Avg[i] = uint8((v + a.samples/2) / a.samples)
+		}
+	}
+	return p
+}
+
+// flushAvailability - saves point of the hour and deletes expired ones of the bag, should be called under historyMx lock
+func (s *Storage) flushAvailability(bagID []byte, a *availabilityAcc) error {
+	if a.samples == 0 {
+		return nil
+	}
+
+	batch := new(Batch)
+	batch.Put(availabilityKey(bagID, a.hour), encodeAvailabilityPoint(a.result()))
+
+	prefix := append(append([]byte{}, availabilityKeyPrefix...), bagID...)
+	expire := a.hour.Add(-HistoryRetention)
+	err := s.db.Iterate(prefix, func(key, value []byte) bool {
+		if len(key) != len(prefix)+8 {
+			return true
+		}
+		if int64(binary.BigEndian.Uint64(key[len(prefix):])) >= expire.Unix() {
+			return false
+		}
+		batch.Delete(append([]byte{}, key...))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return s.db.Write(batch, false)
+}
+
+// flushAllAvailability - saves points of all bags, should be called under historyMx lock
+func (s *Storage) flushAllAvailability() error {
+	for id, a := range s.availability {
+		if err := s.flushAvailability([]byte(id), a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeAvailabilityPoint(p AvailabilityPoint) []byte {
+	data := make([]byte, 8+2*len(p.Min))
+	binary.LittleEndian.PutUint32(data, p.PiecesPerBucket)
+	binary.LittleEndian.PutUint32(data[4:], p.Samples)
+	copy(data[8:], p.Min)
+	copy(data[8+len(p.Min):], p.Avg)
+	return data
+}
+
+func decodeAvailabilityPoint(data []byte) (AvailabilityPoint, error) {
+	if len(data) < 8 || (len(data)-8)%2 != 0 {
+		return AvailabilityPoint{}, fmt.Errorf("invalid availability point")
+	}
+
+	n := (len(data) - 8) / 2
+	return AvailabilityPoint{
+		PiecesPerBucket: binary.LittleEndian.Uint32(data),
+		Samples:         binary.LittleEndian.Uint32(data[4:]),
+		Min:             append([]uint8{}, data[8:8+n]...),
+		Avg:             append([]uint8{}, data[8+n:]...),
+	}, nil
+}
+
+// BucketPieces - returns first and last piece of the bucket, for the last bucket it can be beyond the last piece of bag
+func (p AvailabilityPoint) BucketPieces(bucket int) (first, last uint32) {
+	first = uint32(bucket) * p.PiecesPerBucket
+	return first, first + p.PiecesPerBucket - 1
+}
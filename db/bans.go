@@ -0,0 +1,34 @@
+package db
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+func nodeBanKey(adnlID []byte) []byte {
+	return append([]byte("node_ban:"), adnlID...)
+}
+
+// GetBannedNodes - returns nodes banned by operator, they are kept banned across restarts
+func (s *Storage) GetBannedNodes() ([][]byte, error) {
+	var list [][]byte
+	err := s.db.Iterate([]byte("node_ban:"), func(key, value []byte) bool {
+		list = append(list, append([]byte{}, key[len("node_ban:"):]...))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *Storage) SetNodeBanned(adnlID []byte, banned bool) error {
+	if !banned {
+		return s.db.Delete(nodeBanKey(adnlID))
+	}
+
+	// time of ban, for operator
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(time.Now().Unix()))
+	return s.db.Put(nodeBanKey(adnlID), data)
+}
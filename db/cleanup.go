@@ -0,0 +1,130 @@
+package db
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// OrphanFile - file in directory managed by storage, which is not referenced by any known bag
+type OrphanFile struct {
+	Path string
+	Size int64
+}
+
+// FindOrphanFiles - scans downloads directory and StagingDir for files which are not referenced by known bags,
+// they are leftovers of removed bags, stale .part files and files from renamed layouts.
+// Directories of bags which headers are not downloaded yet are skipped, because their files are not known.
+// Bags outside of scanned directories are not affected, so files of created bags are safe.
+func (s *Storage) FindOrphanFiles(downloadsDir string) ([]OrphanFile, error) {
+	referenced := map[string]bool{}
+	var unknownDirs []string
+
+	for _, t := range s.GetAll() {
+		if t.Header == nil {
+			unknownDirs = append(unknownDirs, absPath(t.Path))
+			if storage.StagingDir != "" {
+				unknownDirs = append(unknownDirs, absPath(filepath.Join(storage.StagingDir, hex.EncodeToString(t.BagID))))
+			}
+			continue
+		}
+
+		for i := uint32(0); i < t.Header.FilesCount; i++ {
+			name, err := t.GetLocalFileName(i)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get file name of bag %s: %w", hex.EncodeToString(t.BagID), err)
+			}
+			referenced[absPath(t.Path+"/"+string(t.Header.DirName)+"/"+name)] = true
+
+			if p, _ := t.GetIncompleteFilePath(i); p != "" {
+				referenced[absPath(p)] = true
+			}
+		}
+	}
+
+	var orphans []OrphanFile
+	for _, root := range cleanupRoots(downloadsDir) {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			path = absPath(path)
+			for _, dir := range unknownDirs {
+				if isInside(path, dir) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+			}
+
+			if !d.Type().IsRegular() || referenced[path] {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			orphans = append(orphans, OrphanFile{Path: path, Size: info.Size()})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+		}
+	}
+	return orphans, nil
+}
+
+// RemoveOrphanFiles - deletes files found by FindOrphanFiles, and directories which became empty,
+// scanned directories itself are kept. Returns number of removed bytes.
+func (s *Storage) RemoveOrphanFiles(downloadsDir string, files []OrphanFile) (uint64, error) {
+	roots := cleanupRoots(downloadsDir)
+
+	var removed uint64
+	for _, f := range files {
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %w", f.Path, err)
+		}
+		removed += uint64(f.Size)
+
+		for _, root := range roots {
+			root = absPath(root)
+			for dir := filepath.Dir(f.Path); dir != root && isInside(dir, root); dir = filepath.Dir(dir) {
+				// fails when dir is not empty
+				if os.Remove(dir) != nil {
+					break
+				}
+			}
+		}
+	}
+	return removed, nil
+}
+
+func cleanupRoots(downloadsDir string) []string {
+	roots := []string{downloadsDir}
+	if storage.StagingDir != "" {
+		roots = append(roots, storage.StagingDir)
+	}
+	return roots
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func isInside(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
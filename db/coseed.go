@@ -0,0 +1,78 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var coSeedKeyPrefix = []byte("coseed:")
+
+// CoSeedBag - bag seen in catalog of co-seeder group member
+type CoSeedBag struct {
+	// Member - ADNL id of member which catalog contained the bag first
+	Member []byte
+	// Downloaded - bag was auto-downloaded from catalog, its size is counted in co-seeding quota
+	Downloaded bool
+	Size       uint64
+}
+
+func coSeedKey(bagID []byte) []byte {
+	return append(append([]byte{}, coSeedKeyPrefix...), bagID...)
+}
+
+// GetCoSeedBag - returns record of bag seen in catalogs, records are kept after bag removal,
+// so bags removed by operator are not downloaded again
+func (s *Storage) GetCoSeedBag(bagID []byte) (*CoSeedBag, error) {
+	data, err := s.db.Get(coSeedKey(bagID))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 32+1+8 {
+		return nil, fmt.Errorf("invalid co-seed record")
+	}
+	return &CoSeedBag{
+		Member:     append([]byte{}, data[:32]...),
+		Downloaded: data[32] == 1,
+		Size:       binary.LittleEndian.Uint64(data[33:]),
+	}, nil
+}
+
+// SetCoSeedBag - saves record of bag seen in catalog of member
+func (s *Storage) SetCoSeedBag(bagID []byte, b CoSeedBag) error {
+	if len(b.Member) != 32 {
+		return fmt.Errorf("invalid member id")
+	}
+
+	data := make([]byte, 32+1+8)
+	copy(data, b.Member)
+	if b.Downloaded {
+		data[32] = 1
+	}
+	binary.LittleEndian.PutUint64(data[33:], b.Size)
+	return s.db.Put(coSeedKey(bagID), data)
+}
+
+// IsCoSeedBagSeen - checks if bag was already processed from some catalog
+func (s *Storage) IsCoSeedBagSeen(bagID []byte) bool {
+	_, err := s.GetCoSeedBag(bagID)
+	return !errors.Is(err, ErrNotFound)
+}
+
+// GetCoSeedUsage - returns total size of auto-downloaded bags which we still have
+func (s *Storage) GetCoSeedUsage() (uint64, error) {
+	var used uint64
+	err := s.db.Iterate(coSeedKeyPrefix, func(key, value []byte) bool {
+		if len(value) != 32+1+8 || value[32] != 1 {
+			return true
+		}
+		if s.GetTorrent(key[len(coSeedKeyPrefix):]) != nil {
+			used += binary.LittleEndian.Uint64(value[33:])
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return used, nil
+}
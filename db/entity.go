@@ -1,9 +1,7 @@
 package db
 
 import (
-	"bytes"
 	"encoding/binary"
-	"github.com/syndtr/goleveldb/leveldb/util"
 	"github.com/xssnick/tonutils-storage/storage"
 )
 
@@ -20,7 +18,7 @@ func (s *Storage) SetActiveFiles(bagId []byte, ids []uint32) error {
 	for i := 0; i < len(ids); i++ {
 		binary.LittleEndian.PutUint32(v[i*4:], ids[i])
 	}
-	return s.db.Put(k, v, nil)
+	return s.db.Put(k, v)
 }
 
 func (s *Storage) GetActiveFiles(bagId []byte) ([]uint32, error) {
@@ -32,7 +30,7 @@ func (s *Storage) GetActiveFiles(bagId []byte) ([]uint32, error) {
 	copy(k, "ai:")
 	copy(k[3:3+32], bagId)
 
-	res, err := s.db.Get(k, nil)
+	res, err := s.db.Get(k)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +52,7 @@ func (s *Storage) GetPiece(bagId []byte, id uint32) (*storage.PieceInfo, error)
 	copy(k[3:3+32], bagId)
 	binary.LittleEndian.PutUint32(k[3+32:], id)
 
-	res, err := s.db.Get(k, nil)
+	res, err := s.db.Get(k)
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +73,7 @@ func (s *Storage) RemovePiece(bagId []byte, id uint32) error {
 	copy(k[3:3+32], bagId)
 	binary.LittleEndian.PutUint32(k[3+32:], id)
 
-	return s.db.Delete(k, nil)
+	return s.db.Delete(k)
 }
 
 func (s *Storage) SetPiece(bagId []byte, id uint32, p *storage.PieceInfo) error {
@@ -92,7 +90,34 @@ func (s *Storage) SetPiece(bagId []byte, id uint32, p *storage.PieceInfo) error
 	binary.LittleEndian.PutUint32(v, p.StartFileIndex)
 	copy(v[4:], p.Proof)
 
-	return s.db.Put(k, v, nil)
+	return s.db.Put(k, v)
+}
+
+// removeBagData - deletes active files and pieces records of the bag in one batch
+func (s *Storage) removeBagData(bagId []byte) error {
+	if len(bagId) != 32 {
+		panic("invalid bag id len, should be 32")
+	}
+
+	batch := new(Batch)
+
+	k := make([]byte, 3+32)
+	copy(k, "ai:")
+	copy(k[3:3+32], bagId)
+	batch.Delete(k)
+
+	k = make([]byte, 3+32)
+	copy(k, "pc:")
+	copy(k[3:3+32], bagId)
+
+	if err := s.db.Iterate(k, func(key, _ []byte) bool {
+		batch.Delete(append([]byte{}, key...))
+		return true
+	}); err != nil {
+		return err
+	}
+
+	return s.db.Write(batch, false)
 }
 
 func (s *Storage) PiecesMask(bagId []byte, num uint32) []byte {
@@ -110,13 +135,10 @@ func (s *Storage) PiecesMask(bagId []byte, num uint32) []byte {
 	}
 
 	mask := make([]byte, p)
-	iter := s.db.NewIterator(&util.Range{Start: k}, nil)
-	for iter.Next() {
-		if !bytes.HasPrefix(iter.Key(), k) {
-			break
-		}
-		id := binary.LittleEndian.Uint32(iter.Key()[len(k):])
+	_ = s.db.Iterate(k, func(key, _ []byte) bool {
+		id := binary.LittleEndian.Uint32(key[len(k):])
 		mask[id/8] |= 1 << (id % 8)
-	}
+		return true
+	})
 	return mask
 }
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+func (s *Storage) expiredBagsChecker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+
+		for _, t := range s.GetAll() {
+			if t.ExpiresAt.IsZero() || time.Now().Before(t.ExpiresAt) {
+				continue
+			}
+
+			if err := s.expireTorrent(t); err != nil {
+				storage.Log.Error("failed to expire bag", storage.Component("expiry"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+			}
+		}
+	}
+}
+
+func (s *Storage) expireTorrent(t *storage.Torrent) error {
+	if t.RemoveOnExpire {
+		storage.Log.Info("bag expired, removing", storage.Component("storage"), storage.BagAttr(t.BagID))
+		return s.RemoveTorrent(t, t.RemoveFilesOnExpire)
+	}
+
+	storage.Log.Info("bag expired, stopping", storage.Component("storage"), storage.BagAttr(t.BagID))
+	t.Stop()
+	// bag can be started again manually, so expiration is not needed anymore
+	t.ExpiresAt = time.Time{}
+	return s.SetTorrent(t)
+}
@@ -58,6 +58,16 @@ func (f fileInfo) CreateReader() (io.ReadCloser, error) {
 }
 
 func (s *Storage) DetectFileRefs(path string) (rootPath string, dirName string, _ []storage.FileRef, _ error) {
+	return s.DetectFileRefsWithOptions(path, storage.CreateOptions{})
+}
+
+// DetectFileRefsWithOptions - scans file or directory, files not matching options are skipped,
+// symlinks are handled according to options
+func (s *Storage) DetectFileRefsWithOptions(path string, opts storage.CreateOptions) (rootPath string, dirName string, _ []storage.FileRef, _ error) {
+	if err := opts.Validate(); err != nil {
+		return "", "", nil, err
+	}
+
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return "", "", nil, err
@@ -69,7 +79,7 @@ func (s *Storage) DetectFileRefs(path string) (rootPath string, dirName string,
 	}
 
 	if fi.IsDir() {
-		files, err := s.GetAllFilesRefsInDir(path)
+		files, err := s.GetAllFilesRefsInDirWithOptions(path, opts)
 		if err != nil {
 			return "", "", nil, err
 		}
@@ -117,23 +127,87 @@ func (s *Storage) GetSingleFileRef(path string) (storage.FileRef, error) {
 }
 
 func (s *Storage) GetAllFilesRefsInDir(path string) ([]storage.FileRef, error) {
+	return s.GetAllFilesRefsInDirWithOptions(path, storage.CreateOptions{})
+}
+
+// GetAllFilesRefsInDirWithOptions - scans directory recursively in lexical order,
+// files not matching options are skipped, symlinks are handled according to options
+func (s *Storage) GetAllFilesRefsInDirWithOptions(path string, opts storage.CreateOptions) ([]storage.FileRef, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
 
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directory '%s': %w", path, err)
+	}
+
 	var files []storage.FileRef
-	err = filepath.Walk(path, func(filePath string, f os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if err = walkDir(path, "", opts, map[string]bool{real: true}, &files); err != nil {
+		err = fmt.Errorf("failed to scan directory '%s': %w", path, err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// walkDir - adds files of directory, parents are real paths of directories above, to detect symlink loops
+func walkDir(dirPath, prefix string, opts storage.CreateOptions, parents map[string]bool, files *[]storage.FileRef) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		filePath := filepath.Join(dirPath, e.Name())
+		name := prefix + e.Name()
+
+		if opts.Skip(name) {
+			continue
 		}
 
-		if f.IsDir() {
-			return nil
+		mode := e.Type()
+		if mode&os.ModeSymlink != 0 {
+			switch opts.Symlinks {
+			case storage.SymlinksSkip:
+				continue
+			case storage.SymlinksError:
+				return fmt.Errorf("%s is symlink", filePath)
+			}
+
+			fi, err := os.Stat(filePath)
+			if err != nil {
+				return fmt.Errorf("failed to follow symlink %s: %w", filePath, err)
+			}
+			mode = fi.Mode().Type()
 		}
 
-		name := filePath[len(path)+1:]
-		name = strings.ReplaceAll(name, "\\", "/") // to unix style
+		if mode.IsDir() {
+			real, err := filepath.EvalSymlinks(filePath)
+			if err != nil {
+				return err
+			}
+			if parents[real] {
+				return fmt.Errorf("symlink loop at %s", filePath)
+			}
+
+			parents[real] = true
+			err = walkDir(filePath, name+"/", opts, parents, files)
+			delete(parents, real)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !mode.IsRegular() {
+			// sockets, devices and pipes
+			continue
+		}
+
+		if !opts.Match(name) {
+			continue
+		}
 
 		// stat is not always gives the right file size, so we open file and find the end
 		fl, err := os.Open(filePath)
@@ -147,16 +221,11 @@ func (s *Storage) GetAllFilesRefsInDir(path string) ([]storage.FileRef, error) {
 			return fmt.Errorf("failed to seek file end %s: %w", filePath, err)
 		}
 
-		files = append(files, fileInfo{
+		*files = append(*files, fileInfo{
 			name: name,
 			size: uint64(sz),
 			path: filePath,
 		})
-		return nil
-	})
-	if err != nil {
-		err = fmt.Errorf("failed to scan directory '%s': %w", path, err)
-		return nil, err
 	}
-	return files, nil
+	return nil
 }
@@ -0,0 +1,269 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+// HistorySampleInterval - how often speeds and peers are sampled, hourly points are averages of samples
+var HistorySampleInterval = 1 * time.Minute
+
+// HistoryRetention - hourly points older than it are deleted
+var HistoryRetention = 30 * 24 * time.Hour
+
+// HistoryPoint - coarse metrics of the node for one hour
+type HistoryPoint struct {
+	// Time - start of the hour
+	Time time.Time
+	// Downloaded, Uploaded - bytes transferred during the hour
+	Downloaded uint64
+	Uploaded   uint64
+	// DownloadSpeed, UploadSpeed - average speeds in bytes per second, MaxDownloadSpeed, MaxUploadSpeed - peak of samples
+	DownloadSpeed    uint64
+	UploadSpeed      uint64
+	MaxDownloadSpeed uint64
+	MaxUploadSpeed   uint64
+	// Peers - average number of unique connected peers
+	Peers uint64
+	// StoredBytes, Bags, ActiveBags, CompletedBags - state at the end of the hour
+	StoredBytes   uint64
+	Bags          uint64
+	ActiveBags    uint64
+	CompletedBags uint64
+}
+
+const historyPointLen = 8 * 12
+
+// historyAcc - samples of the current hour
+type historyAcc struct {
+	hour     time.Time
+	base     TransferStats
+	samples  uint64
+	down, up uint64
+	peers    uint64
+	point    HistoryPoint
+}
+
+var historyKeyPrefix = []byte("history:")
+
+func historyKey(hour time.Time) []byte {
+	key := make([]byte, len(historyKeyPrefix)+8)
+	copy(key, historyKeyPrefix)
+	// big endian to iterate in time order
+	binary.BigEndian.PutUint64(key[len(historyKeyPrefix):], uint64(hour.Unix()))
+	return key
+}
+
+// GetHistory - returns hourly points since the given time, in time order, including current incomplete hour
+func (s *Storage) GetHistory(since time.Time) ([]HistoryPoint, error) {
+	since = since.UTC().Truncate(time.Hour)
+
+	s.historyMx.Lock()
+	var current *HistoryPoint
+	if s.history != nil && s.history.samples > 0 {
+		p := s.history.result()
+		current = &p
+	}
+	s.historyMx.Unlock()
+
+	var list []HistoryPoint
+	err := s.db.Iterate(historyKeyPrefix, func(key, value []byte) bool {
+		if len(key) != len(historyKeyPrefix)+8 || len(value) < historyPointLen {
+			return true
+		}
+
+		p, _ := decodeHistoryPoint(value)
+		p.Time = time.Unix(int64(binary.BigEndian.Uint64(key[len(historyKeyPrefix):])), 0).UTC()
+		// point of current hour can be saved on shutdown, it is continued after restart
+		if !p.Time.Before(since) && (current == nil || !p.Time.Equal(current.Time)) {
+			list = append(list, p)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if current != nil && !current.Time.Before(since) {
+		list = append(list, *current)
+	}
+	return list, nil
+}
+
+func (a *historyAcc) result() HistoryPoint {
+	p := a.point
+	p.Time = a.hour
+	if a.samples > 0 {
+		p.DownloadSpeed = a.down / a.samples
+		p.UploadSpeed = a.up / a.samples
+		p.Peers = a.peers / a.samples
+	}
+	return p
+}
+
+func (s *Storage) historySampler() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(HistorySampleInterval):
+		}
+
+		if err := s.sampleHistory(time.Now().UTC()); err != nil {
+			storage.Log.Error("failed to save metrics history", storage.Component("storage"), storage.ErrAttr(err))
+		}
+	}
+}
+
+func (s *Storage) sampleHistory(now time.Time) error {
+	hour := now.Truncate(time.Hour)
+	_, lifetime := s.GetTransferStats()
+
+	var down, up, stored, bags, active, completed uint64
+	peers := map[string]bool{}
+	for _, t := range s.GetAll() {
+		bags++
+		if a, _ := t.IsActive(); a {
+			active++
+		}
+		if t.Info != nil {
+			st := t.Stats()
+			stored += st.Downloaded
+			if st.Completed {
+				completed++
+			}
+		}
+
+		for id, p := range t.GetPeers() {
+			down += p.GetDownloadSpeed()
+			up += p.GetUploadSpeed()
+			peers[id] = true
+		}
+	}
+
+	s.historyMx.Lock()
+	defer s.historyMx.Unlock()
+
+	if s.history != nil && !s.history.hour.Equal(hour) {
+		if err := s.flushHistory(); err != nil {
+			return err
+		}
+		s.history = &historyAcc{hour: hour, base: s.history.lastTotal()}
+	} else if s.history == nil {
+		h, err := s.loadHistoryHour(hour, lifetime)
+		if err != nil {
+			return err
+		}
+		s.history = h
+	}
+
+	h := s.history
+	h.samples++
+	h.down += down
+	h.up += up
+	h.peers += uint64(len(peers))
+	if down > h.point.MaxDownloadSpeed {
+		h.point.MaxDownloadSpeed = down
+	}
+	if up > h.point.MaxUploadSpeed {
+		h.point.MaxUploadSpeed = up
+	}
+	if lifetime.Downloaded >= h.base.Downloaded {
+		h.point.Downloaded = lifetime.Downloaded - h.base.Downloaded
+	}
+	if lifetime.Uploaded >= h.base.Uploaded {
+		h.point.Uploaded = lifetime.Uploaded - h.base.Uploaded
+	}
+	h.point.StoredBytes = stored
+	h.point.Bags = bags
+	h.point.ActiveBags = active
+	h.point.CompletedBags = completed
+
+	return s.sampleAvailability(now)
+}
+
+// loadHistoryHour - continues point of the hour saved before restart, or starts new one
+func (s *Storage) loadHistoryHour(hour time.Time, lifetime TransferStats) (*historyAcc, error) {
+	h := &historyAcc{hour: hour, base: lifetime}
+
+	data, err := s.db.Get(historyKey(hour))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return h, nil
+		}
+		return nil, err
+	}
+	if len(data) < historyPointLen {
+		return h, nil
+	}
+
+	p, samples := decodeHistoryPoint(data)
+	h.point = p
+	h.samples = samples
+	h.down = p.DownloadSpeed * samples
+	h.up = p.UploadSpeed * samples
+	h.peers = p.Peers * samples
+	if lifetime.Downloaded >= p.Downloaded && lifetime.Uploaded >= p.Uploaded {
+		h.base = TransferStats{
+			Downloaded: lifetime.Downloaded - p.Downloaded,
+			Uploaded:   lifetime.Uploaded - p.Uploaded,
+		}
+	}
+	return h, nil
+}
+
+// lastTotal - lifetime transferred bytes at the last sample of the hour, next hour is counted from it
+func (a *historyAcc) lastTotal() TransferStats {
+	return TransferStats{
+		Downloaded: a.base.Downloaded + a.point.Downloaded,
+		Uploaded:   a.base.Uploaded + a.point.Uploaded,
+	}
+}
+
+// flushHistory - saves point of the current hour and deletes expired ones, should be called under historyMx lock
+func (s *Storage) flushHistory() error {
+	if s.history == nil || s.history.samples == 0 {
+		return nil
+	}
+
+	batch := new(Batch)
+	batch.Put(historyKey(s.history.hour), encodeHistoryPoint(s.history.result(), s.history.samples))
+
+	expire := s.history.hour.Add(-HistoryRetention)
+	err := s.db.Iterate(historyKeyPrefix, func(key, value []byte) bool {
+		if len(key) != len(historyKeyPrefix)+8 {
+			return true
+		}
+		if int64(binary.BigEndian.Uint64(key[len(historyKeyPrefix):])) >= expire.Unix() {
+			return false
+		}
+		batch.Delete(append([]byte{}, key...))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.db.Write(batch, false)
+}
+
+func encodeHistoryPoint(p HistoryPoint, samples uint64) []byte {
+	data := make([]byte, historyPointLen)
+	for i, v := range []uint64{p.Downloaded, p.Uploaded, p.DownloadSpeed, p.UploadSpeed,
+		p.MaxDownloadSpeed, p.MaxUploadSpeed, p.Peers, p.StoredBytes, p.Bags, p.ActiveBags, p.CompletedBags, samples} {
+		binary.LittleEndian.PutUint64(data[i*8:], v)
+	}
+	return data
+}
+
+func decodeHistoryPoint(data []byte) (p HistoryPoint, samples uint64) {
+	for i, v := range []*uint64{&p.Downloaded, &p.Uploaded, &p.DownloadSpeed, &p.UploadSpeed,
+		&p.MaxDownloadSpeed, &p.MaxUploadSpeed, &p.Peers, &p.StoredBytes, &p.Bags, &p.ActiveBags, &p.CompletedBags, &samples} {
+		*v = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return p, samples
+}
@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JobsHistoryLimit - number of finished jobs which records are kept in db
+var JobsHistoryLimit = 100
+
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+	// JobInterrupted - node was stopped while job was running
+	JobInterrupted = "interrupted"
+)
+
+// ErrJobNotFound - job is not running or not exists
+var ErrJobNotFound = errors.New("job is not found")
+
+// JobInfo - record of long operation, like bag creation or verification, kept in db after it is finished
+type JobInfo struct {
+	ID     uint64
+	Kind   string
+	Target string
+	Status string
+	// Progress - done part in permille
+	Progress uint64
+	// Result - short outcome of successful job, Error - reason of failure
+	Result     string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Job - running operation
+type Job struct {
+	info     JobInfo
+	progress uint64
+	cancel   func()
+	done     chan struct{}
+	mx       sync.Mutex
+}
+
+// JobFunc - operation of job, returns short human-readable result, context is cancelled on job cancel and on timeout
+type JobFunc func(ctx context.Context, j *Job) (result string, err error)
+
+func (j *Job) ID() uint64 {
+	return j.info.ID
+}
+
+// SetProgress - reports progress of job, can be called from any goroutine
+func (j *Job) SetProgress(done, total uint64) {
+	if total > 0 {
+		atomic.StoreUint64(&j.progress, done*1000/total)
+	}
+}
+
+// Info - current state of job
+func (j *Job) Info() JobInfo {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	info := j.info
+	if info.Status == JobRunning {
+		info.Progress = atomic.LoadUint64(&j.progress)
+	}
+	return info
+}
+
+// Done - closed when job is finished, its final state is available in Info
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func jobKey(id uint64) []byte {
+	key := make([]byte, 4+8)
+	copy(key, "job:")
+	// big endian to iterate in order of creation
+	binary.BigEndian.PutUint64(key[4:], id)
+	return key
+}
+
+// StartJob - runs operation in background and keeps its record, timeout 0 = unlimited.
+// Jobs are cancelled on storage close, then they are recorded as interrupted.
+func (s *Storage) StartJob(kind, target string, timeout time.Duration, fn JobFunc) (*Job, error) {
+	s.jobsMx.Lock()
+	if s.closeCtx.Err() != nil {
+		s.jobsMx.Unlock()
+		return nil, fmt.Errorf("storage is closed")
+	}
+	s.lastJobID++
+	j := &Job{
+		info: JobInfo{
+			ID:        s.lastJobID,
+			Kind:      kind,
+			Target:    target,
+			Status:    JobRunning,
+			StartedAt: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+
+	// record of running job stays in db if node is killed, it is marked as interrupted on next start
+	if err := s.saveJob(j.info); err != nil {
+		s.jobsMx.Unlock()
+		return nil, fmt.Errorf("failed to save job: %w", err)
+	}
+	s.jobs[j.info.ID] = j
+	s.jobsMx.Unlock()
+
+	ctx, cancel := context.WithCancel(s.closeCtx)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(s.closeCtx, timeout)
+	}
+	j.cancel = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		result, err := fn(ctx, j)
+		s.finishJob(j, ctx, result, err)
+	}()
+	return j, nil
+}
+
+func (s *Storage) finishJob(j *Job, ctx context.Context, result string, err error) {
+	j.mx.Lock()
+	j.info.FinishedAt = time.Now()
+	j.info.Progress = atomic.LoadUint64(&j.progress)
+	switch {
+	case err == nil:
+		j.info.Status = JobDone
+		j.info.Progress = 1000
+		j.info.Result = result
+	case s.closeCtx.Err() != nil:
+		j.info.Status = JobInterrupted
+		j.info.Error = "node was stopped"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		j.info.Status = JobFailed
+		j.info.Error = "timed out: " + err.Error()
+	case ctx.Err() != nil:
+		j.info.Status = JobCancelled
+		j.info.Error = err.Error()
+	default:
+		j.info.Status = JobFailed
+		j.info.Error = err.Error()
+	}
+	// partial result, like number of hashed pieces, can be useful for failed jobs too
+	if err != nil && result != "" {
+		j.info.Result = result
+	}
+	info := j.info
+	j.mx.Unlock()
+
+	storage.Log.Info("job is finished", storage.Component("jobs"), "job", info.ID, "kind", info.Kind, "target", info.Target, "status", info.Status)
+
+	s.jobsMx.Lock()
+	delete(s.jobs, info.ID)
+	err = s.saveJob(info)
+	s.jobsMx.Unlock()
+	if err != nil {
+		storage.Log.Error("failed to save job record", storage.Component("jobs"), "job", info.ID, storage.ErrAttr(err))
+	}
+	close(j.done)
+}
+
+// saveJob - should be called under jobsMx lock
+func (s *Storage) saveJob(info JobInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	lastID := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lastID, s.lastJobID)
+
+	batch := new(Batch)
+	batch.Put(jobKey(info.ID), data)
+	batch.Put([]byte("job_last_id:"), lastID)
+
+	if info.ID > uint64(JobsHistoryLimit) {
+		// oldest records are deleted, running jobs are not older than limit in practice
+		batch.Delete(jobKey(info.ID - uint64(JobsHistoryLimit)))
+	}
+	return s.db.Write(batch, false)
+}
+
+// GetJob - returns running job
+func (s *Storage) GetJob(id uint64) *Job {
+	s.jobsMx.Lock()
+	defer s.jobsMx.Unlock()
+
+	return s.jobs[id]
+}
+
+func (s *Storage) CancelJob(id uint64) error {
+	j := s.GetJob(id)
+	if j == nil {
+		return ErrJobNotFound
+	}
+	j.Cancel()
+	return nil
+}
+
+// ListJobs - returns running jobs and records of finished ones, newest first
+func (s *Storage) ListJobs() ([]JobInfo, error) {
+	s.jobsMx.Lock()
+	running := make(map[uint64]*Job, len(s.jobs))
+	for id, j := range s.jobs {
+		running[id] = j
+	}
+	s.jobsMx.Unlock()
+
+	var list []JobInfo
+	err := s.db.Iterate([]byte("job:"), func(key, value []byte) bool {
+		var info JobInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			storage.Log.Warn("failed to parse job record", storage.Component("jobs"), storage.ErrAttr(err))
+			return true
+		}
+
+		if j := running[info.ID]; j != nil {
+			info = j.Info()
+			delete(running, info.ID)
+		}
+		list = append(list, info)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// records of long running jobs can be already deleted by history limit
+	for _, j := range running {
+		list = append(list, j.Info())
+	}
+
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].ID > list[k].ID
+	})
+	return list, nil
+}
+
+// loadJobs - restores last job id, jobs which were running when node was stopped are marked as interrupted
+func (s *Storage) loadJobs() error {
+	data, err := s.db.Get([]byte("job_last_id:"))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if len(data) >= 8 {
+		s.lastJobID = binary.LittleEndian.Uint64(data)
+	}
+
+	var interrupted []JobInfo
+	err = s.db.Iterate([]byte("job:"), func(key, value []byte) bool {
+		var info JobInfo
+		if err := json.Unmarshal(value, &info); err != nil {
+			return true
+		}
+		if info.Status == JobRunning {
+			interrupted = append(interrupted, info)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, info := range interrupted {
+		info.Status = JobInterrupted
+		info.Error = "node was stopped"
+		if err = s.saveJob(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound - returned by KV Get when key is not exists
+var ErrNotFound = errors.New("not found")
+
+// KV - key-value store which keeps metadata of storage: bags, pieces proofs and stats.
+// It can be implemented over any ordered store, like Pebble, Bolt or SQLite, when embedding.
+type KV interface {
+	// Get - returns copy of value, or ErrNotFound
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	// Iterate - calls fn for keys with prefix in bytes order, till it returns false.
+	// Key and value are valid only during the call, they should be copied to be kept.
+	Iterate(prefix []byte, fn func(key, value []byte) bool) error
+	// Write - applies all operations of batch atomically, with sync it is flushed to disk before return
+	Write(batch *Batch, sync bool) error
+	Close() error
+}
+
+// KVSizer - optional interface of KV, approximate size on disk of keys with prefix, used for metrics
+type KVSizer interface {
+	SizeOf(prefix []byte) (int64, error)
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// Batch - list of put and delete operations, which are applied by KV Write together
+type Batch struct {
+	ops []batchOp
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Replay - calls put or del for each operation in order they were added
+func (b *Batch) Replay(put func(key, value []byte), del func(key []byte)) {
+	for _, op := range b.ops {
+		if op.delete {
+			del(op.key)
+		} else {
+			put(op.key, op.value)
+		}
+	}
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+// KVOpener - opens store of engine in path
+type KVOpener func(path string, cfg *Config) (KV, error)
+
+const DefaultKVEngine = "leveldb"
+
+var kvEngines = map[string]KVOpener{
+	DefaultKVEngine: func(path string, cfg *Config) (KV, error) {
+		return OpenLevelDB(path, cfg.LevelDB)
+	},
+	"memory": func(path string, cfg *Config) (KV, error) {
+		return NewMemoryKV(), nil
+	},
+}
+var kvEnginesMx sync.RWMutex
+
+// RegisterKVEngine - makes engine available for OpenKV, for example with -db-engine flag
+func RegisterKVEngine(name string, opener KVOpener) {
+	kvEnginesMx.Lock()
+	defer kvEnginesMx.Unlock()
+
+	kvEngines[name] = opener
+}
+
+// OpenKV - opens store with registered engine, empty engine = leveldb
+func OpenKV(engine, path string, cfg *Config) (KV, error) {
+	if engine == "" {
+		engine = DefaultKVEngine
+	}
+
+	kvEnginesMx.RLock()
+	opener := kvEngines[engine]
+	kvEnginesMx.RUnlock()
+
+	if opener == nil {
+		return nil, fmt.Errorf("db engine %q is not supported", engine)
+	}
+	return opener(path, cfg)
+}
+
+// LevelDB - default KV, keeps data in leveldb files
+type LevelDB struct {
+	db *leveldb.DB
+}
+
+func OpenLevelDB(path string, cfg LevelDBConfig) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, cfg.Options())
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{db: db}, nil
+}
+
+// NewLevelDB - wraps already opened leveldb
+func NewLevelDB(db *leveldb.DB) *LevelDB {
+	return &LevelDB{db: db}
+}
+
+func (l *LevelDB) Get(key []byte) ([]byte, error) {
+	v, err := l.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (l *LevelDB) Put(key, value []byte) error {
+	return l.db.Put(key, value, nil)
+}
+
+func (l *LevelDB) Delete(key []byte) error {
+	return l.db.Delete(key, nil)
+}
+
+func (l *LevelDB) Iterate(prefix []byte, fn func(key, value []byte) bool) error {
+	iter := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Error()
+}
+
+func (l *LevelDB) Write(batch *Batch, sync bool) error {
+	b := new(leveldb.Batch)
+	batch.Replay(b.Put, b.Delete)
+	return l.db.Write(b, &opt.WriteOptions{Sync: sync})
+}
+
+func (l *LevelDB) SizeOf(prefix []byte) (int64, error) {
+	sz, err := l.db.SizeOf([]util.Range{*util.BytesPrefix(prefix)})
+	if err != nil {
+		return 0, err
+	}
+	return sz.Sum(), nil
+}
+
+func (l *LevelDB) Close() error {
+	return l.db.Close()
+}
+
+// MemoryKV - keeps everything in memory, data is lost on close, useful for tests and short living nodes
+type MemoryKV struct {
+	data map[string][]byte
+	mx   sync.RWMutex
+}
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{
+		data: map[string][]byte{},
+	}
+}
+
+func (m *MemoryKV) Get(key []byte) ([]byte, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	v, ok := m.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (m *MemoryKV) Put(key, value []byte) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *MemoryKV) Delete(key []byte) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *MemoryKV) Iterate(prefix []byte, fn func(key, value []byte) bool) error {
+	// snapshot of matching records, so fn can modify store
+	type record struct {
+		key, value []byte
+	}
+
+	m.mx.RLock()
+	var list []record
+	for k, v := range m.data {
+		if bytes.HasPrefix([]byte(k), prefix) {
+			list = append(list, record{key: []byte(k), value: v})
+		}
+	}
+	m.mx.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].key, list[j].key) < 0
+	})
+
+	for _, r := range list {
+		if !fn(r.key, r.value) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryKV) Write(batch *Batch, sync bool) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	batch.Replay(func(key, value []byte) {
+		m.data[string(key)] = append([]byte{}, value...)
+	}, func(key []byte) {
+		delete(m.data, string(key))
+	})
+	return nil
+}
+
+func (m *MemoryKV) Close() error {
+	return nil
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// DefaultDownloadsLayout - bags are placed to directories named by their description
+const DefaultDownloadsLayout = "{description}"
+
+// LayoutMove - planned move of bag from legacy directory to directory by layout
+type LayoutMove struct {
+	BagID []byte
+	From  string
+	To    string
+}
+
+// PlanLayoutMigration - finds bags stored in legacy layout, where each bag is in own directory named by bag id
+// in downloadsDir, and returns their new directories in root by layout. Layout is relative path template,
+// it can contain {bag_id} and {description}, for example "{description}" or "archive/{bag_id}".
+// Bags with custom paths and bags which header is not downloaded yet are skipped.
+// When directory is already taken, short bag id is added to its name.
+func (s *Storage) PlanLayoutMigration(downloadsDir, root, layout string) ([]LayoutMove, error) {
+	if layout == "" {
+		layout = DefaultDownloadsLayout
+	}
+	if filepath.IsAbs(layout) {
+		return nil, fmt.Errorf("layout should be relative to root")
+	}
+	for _, p := range strings.Split(filepath.ToSlash(layout), "/") {
+		if p == ".." {
+			return nil, fmt.Errorf("layout cannot contain '..'")
+		}
+	}
+
+	root = absPath(root)
+	taken := map[string]bool{}
+	var moves []LayoutMove
+	for _, t := range s.GetAll() {
+		if t.Header == nil {
+			continue
+		}
+
+		id := hex.EncodeToString(t.BagID)
+		from := absPath(t.Path)
+		if from != absPath(filepath.Join(downloadsDir, id)) {
+			// custom path, chosen by user
+			continue
+		}
+
+		to := filepath.Join(root, layoutPath(layout, t))
+		if !isInside(to, root) || to == root {
+			return nil, fmt.Errorf("layout gives path %s outside of root for bag %s", to, id)
+		}
+		if to == from {
+			continue
+		}
+		if taken[to] || pathExists(to) {
+			to += "-" + id[:8]
+		}
+		taken[to] = true
+
+		moves = append(moves, LayoutMove{BagID: t.BagID, From: from, To: to})
+	}
+	return moves, nil
+}
+
+// MigrateLayout - moves bags to directories planned by PlanLayoutMigration. Bag is stopped during move,
+// its path in db is changed after files are moved, and files are moved back when db cannot be updated.
+// Returns number of moved bags, migration is stopped on first error.
+func (s *Storage) MigrateLayout(moves []LayoutMove) (int, error) {
+	for i, m := range moves {
+		if err := s.migrateBag(m); err != nil {
+			return i, fmt.Errorf("failed to move bag %s: %w", hex.EncodeToString(m.BagID), err)
+		}
+	}
+	return len(moves), nil
+}
+
+func (s *Storage) migrateBag(m LayoutMove) error {
+	t := s.GetTorrent(m.BagID)
+	if t == nil {
+		return fmt.Errorf("bag not found")
+	}
+	if absPath(t.Path) != m.From {
+		return fmt.Errorf("bag path was changed since plan")
+	}
+
+	active, _ := t.IsActive()
+	t.StopAndWait()
+	t.CloseFiles()
+
+	resume := func() {
+		if active {
+			if err := t.Resume(); err != nil {
+				storage.Log.Error("failed to resume bag after move", storage.Component("layout"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+			}
+		}
+	}
+
+	if pathExists(m.From) {
+		if err := moveDir(m.From, m.To); err != nil {
+			resume()
+			return err
+		}
+	}
+
+	oldPath := t.Path
+	t.Path = m.To
+	resume()
+
+	if err := s.SetTorrent(t); err != nil {
+		t.StopAndWait()
+		t.CloseFiles()
+		t.Path = oldPath
+		if pathExists(m.To) {
+			if mvErr := moveDir(m.To, m.From); mvErr != nil {
+				storage.Log.Error("failed to move bag back", storage.Component("layout"), storage.BagAttr(t.BagID), "path", m.From, storage.ErrAttr(mvErr))
+			}
+		}
+		resume()
+		return fmt.Errorf("failed to save bag: %w", err)
+	}
+	return nil
+}
+
+// layoutPath - fills placeholders of layout with values of bag
+func layoutPath(layout string, t *storage.Torrent) string {
+	id := hex.EncodeToString(t.BagID)
+	desc := ""
+	if t.Info != nil {
+		desc = dirNameOf(t.Info.Description.Value)
+	}
+	if desc == "" {
+		desc = id
+	}
+
+	return filepath.FromSlash(strings.NewReplacer(
+		"{bag_id}", id,
+		"{description}", desc,
+	).Replace(layout))
+}
+
+// dirNameOf - makes safe directory name from description, characters not allowed on common filesystems are replaced
+func dirNameOf(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Trim(s, " .")
+
+	if r := []rune(s); len(r) > 64 {
+		s = strings.TrimRight(string(r[:64]), " .")
+	}
+	return s
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// moveDir - renames directory, when it is not possible, for example because target is on another device,
+// files are copied and source is removed after
+func moveDir(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", to, err)
+	}
+
+	if err := os.Rename(from, to); err == nil {
+		return nil
+	}
+
+	if err := copyDir(from, to); err != nil {
+		_ = os.RemoveAll(to)
+		return fmt.Errorf("failed to copy %s to %s: %w", from, to, err)
+	}
+	if err := os.RemoveAll(from); err != nil {
+		return fmt.Errorf("failed to remove %s after copy: %w", from, err)
+	}
+	return nil
+}
+
+func copyDir(from, to string) error {
+	return filepath.Walk(from, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(dst, src); err != nil {
+			_ = dst.Close()
+			return err
+		}
+		if err = dst.Sync(); err != nil {
+			_ = dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// LevelDBConfig - tuning of leveldb, 0 values mean leveldb defaults.
+// Defaults are made for small databases, nodes with many big bags keep millions of pieces keys,
+// for them bigger cache and bloom filter are reducing disk reads a lot.
+type LevelDBConfig struct {
+	// BlockCacheMB - size of cache for decompressed blocks
+	BlockCacheMB int
+	// BloomFilterBits - bits per key of bloom filter, 10 is a good value, 0 = no filter
+	BloomFilterBits int
+	// WriteBufferMB - size of memtable, bigger buffer means less compactions
+	WriteBufferMB int
+	// CompactionL0Trigger - number of level-0 tables which triggers compaction
+	CompactionL0Trigger int
+	// OpenFilesCacheCapacity - max number of open table files
+	OpenFilesCacheCapacity int
+}
+
+// Options - converts config to leveldb options
+func (c LevelDBConfig) Options() *opt.Options {
+	o := &opt.Options{
+		BlockCacheCapacity:     c.BlockCacheMB << 20,
+		WriteBuffer:            c.WriteBufferMB << 20,
+		CompactionL0Trigger:    c.CompactionL0Trigger,
+		OpenFilesCacheCapacity: c.OpenFilesCacheCapacity,
+	}
+	if c.BloomFilterBits > 0 {
+		o.Filter = filter.NewBloomFilter(c.BloomFilterBits)
+	}
+	return o
+}
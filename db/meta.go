@@ -0,0 +1,29 @@
+package db
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// AddTorrentFromMeta - adds bag using metadata from .tonbag file, its info and header are not requested from peers.
+// Bag is saved stopped, prepare is called before saving to set options which should be known from the start
+// (like swarm secret), then bag can be started as usual.
+func (s *Storage) AddTorrentFromMeta(meta *storage.BagMeta, path string, prepare func(t *storage.Torrent)) (*storage.Torrent, error) {
+	if s.GetTorrent(meta.BagID()) != nil {
+		return nil, fmt.Errorf("bag is already added")
+	}
+
+	t := storage.NewTorrent(path, s, s.connector)
+	if err := t.ApplyMeta(meta); err != nil {
+		return nil, fmt.Errorf("failed to apply meta: %w", err)
+	}
+
+	if prepare != nil {
+		prepare(t)
+	}
+
+	if err := s.SetTorrent(t); err != nil {
+		return nil, fmt.Errorf("failed to save bag: %w", err)
+	}
+	return t, nil
+}
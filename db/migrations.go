@@ -0,0 +1,143 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion - version of records format which is used by this build.
+// When format is changed, version should be incremented and migration added.
+const SchemaVersion = 1
+
+type migration struct {
+	version uint32
+	name    string
+	apply   func(db KV) error
+}
+
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "versioned schema, records are same as in unversioned db",
+		apply: func(db KV) error {
+			return nil
+		},
+	},
+}
+
+var schemaVersionKey = []byte("schema_version:")
+
+// GetSchemaVersion - returns version of db records format, 0 for db created before versioning
+func GetSchemaVersion(db KV) (uint32, error) {
+	data, err := db.Get(schemaVersionKey)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, fmt.Errorf("corrupted schema version")
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+func setSchemaVersion(db KV, version uint32) error {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, version)
+
+	batch := new(Batch)
+	batch.Put(schemaVersionKey, data)
+	return db.Write(batch, true)
+}
+
+// Migrate - upgrades records format to the current SchemaVersion, should be called before NewStorage.
+// Before the first migration, all records are copied to new db at backupPath, so state can be restored
+// by replacing db folder with backup if something went wrong, backup is always leveldb.
+// Empty backupPath disables backup.
+func Migrate(db KV, backupPath string) (migrated bool, err error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return false, fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	if version > SchemaVersion {
+		return false, fmt.Errorf("db schema version %d is newer than supported %d, please update storage", version, SchemaVersion)
+	}
+
+	if version == SchemaVersion {
+		return false, nil
+	}
+
+	empty, err := isEmpty(db)
+	if err != nil {
+		return false, fmt.Errorf("failed to check db: %w", err)
+	}
+
+	if empty {
+		// new db, nothing to migrate
+		if err = setSchemaVersion(db, SchemaVersion); err != nil {
+			return false, fmt.Errorf("failed to set schema version: %w", err)
+		}
+		return false, nil
+	}
+
+	if backupPath != "" {
+		if err = backup(db, backupPath); err != nil {
+			return false, fmt.Errorf("failed to backup db before migration: %w", err)
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if err = m.apply(db); err != nil {
+			return false, fmt.Errorf("migration to version %d (%s) failed: %w", m.version, m.name, err)
+		}
+
+		// version is saved after each step, so on failure we continue from the failed one
+		if err = setSchemaVersion(db, m.version); err != nil {
+			return false, fmt.Errorf("failed to set schema version %d: %w", m.version, err)
+		}
+	}
+	return true, nil
+}
+
+func isEmpty(db KV) (bool, error) {
+	has := false
+	err := db.Iterate(nil, func(key, value []byte) bool {
+		has = true
+		return false
+	})
+	return !has, err
+}
+
+func backup(db KV, path string) error {
+	dst, err := OpenLevelDB(path, LevelDBConfig{})
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	// migrations are running before storage is started, so nothing is written during copy
+	batch := new(Batch)
+	if iterErr := db.Iterate(nil, func(key, value []byte) bool {
+		batch.Put(append([]byte{}, key...), append([]byte{}, value...))
+		if batch.Len() >= 10000 {
+			if err = dst.Write(batch, false); err != nil {
+				return false
+			}
+			batch = new(Batch)
+		}
+		return true
+	}); iterErr != nil {
+		return iterErr
+	}
+	if err != nil {
+		return err
+	}
+	return dst.Write(batch, true)
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// MaxActiveDownloads - how many bags can download pieces at the same time, others are queued
+// and started when active downloads are finished, 0 = unlimited. Queued bags are still seeding.
+var MaxActiveDownloads = 0
+
+// QueuedBag - bag which is downloading or waiting for download slot
+type QueuedBag struct {
+	Torrent  *storage.Torrent
+	Priority int
+	// Active - bag is downloading, otherwise it is waiting
+	Active bool
+	// Position - place in queue of waiting bags starting from 1, 0 for active bags
+	Position int
+	// Since - when bag started to download or was queued
+	Since time.Time
+}
+
+type queueEntry struct {
+	t     *storage.Torrent
+	since time.Time
+}
+
+// AcquireDownloadSlot - waits till number of active downloads is below MaxActiveDownloads
+// and there are no queued bags before this one, by priority and then by time of queueing
+func (s *Storage) AcquireDownloadSlot(ctx context.Context, t *storage.Torrent) (release func(), err error) {
+	key := string(t.BagID)
+	entry := &queueEntry{t: t, since: time.Now()}
+
+	s.queueMx.Lock()
+	queued := false
+	for {
+		if s.canStartLocked(entry) {
+			delete(s.queueWaiting, key)
+			entry.since = time.Now()
+			s.queueActive[key] = entry
+			s.notifyQueueLocked()
+			s.queueMx.Unlock()
+
+			if queued {
+				storage.Log.Info("queued bag is starting download", storage.Component("queue"), storage.BagAttr(t.BagID))
+			}
+			return func() {
+				s.queueMx.Lock()
+				defer s.queueMx.Unlock()
+
+				// bag can be restarted meanwhile and take new slot
+				if s.queueActive[key] == entry {
+					delete(s.queueActive, key)
+					s.notifyQueueLocked()
+				}
+			}, nil
+		}
+
+		if !queued {
+			queued = true
+			s.queueWaiting[key] = entry
+			storage.Log.Info("bag is queued for download", storage.Component("queue"), storage.BagAttr(t.BagID),
+				"active", len(s.queueActive), "limit", MaxActiveDownloads)
+		}
+		wait := s.queueChanged
+		s.queueMx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			s.queueMx.Lock()
+			if s.queueWaiting[key] == entry {
+				delete(s.queueWaiting, key)
+				s.notifyQueueLocked()
+			}
+			s.queueMx.Unlock()
+			return nil, ctx.Err()
+		case <-wait:
+		}
+		s.queueMx.Lock()
+	}
+}
+
+func (s *Storage) canStartLocked(entry *queueEntry) bool {
+	if MaxActiveDownloads <= 0 {
+		return true
+	}
+
+	free := MaxActiveDownloads - len(s.queueActive)
+	if free <= 0 {
+		return false
+	}
+
+	// bags before it in queue are taking free slots first
+	before := 0
+	for _, e := range s.queueWaiting {
+		if e != entry && queuedBefore(e, entry) {
+			before++
+		}
+	}
+	return before < free
+}
+
+func queuedBefore(a, b *queueEntry) bool {
+	pa, pb := a.t.GetDownloadPriority(), b.t.GetDownloadPriority()
+	if pa != pb {
+		return pa > pb
+	}
+	return a.since.Before(b.since)
+}
+
+func (s *Storage) notifyQueueLocked() {
+	close(s.queueChanged)
+	s.queueChanged = make(chan struct{})
+}
+
+// SetDownloadPriority - sets priority of bag in download queue and saves it, bags with higher priority are started first
+func (s *Storage) SetDownloadPriority(t *storage.Torrent, priority int) error {
+	t.SetDownloadPriority(priority)
+	if err := s.SetTorrent(t); err != nil {
+		return err
+	}
+
+	s.queueMx.Lock()
+	s.notifyQueueLocked()
+	s.queueMx.Unlock()
+	return nil
+}
+
+// IsQueued - bag is waiting for download slot
+func (s *Storage) IsQueued(t *storage.Torrent) bool {
+	s.queueMx.Lock()
+	defer s.queueMx.Unlock()
+
+	e := s.queueWaiting[string(t.BagID)]
+	return e != nil && e.t == t
+}
+
+// GetDownloadQueue - returns downloading bags and then waiting bags in order they will be started
+func (s *Storage) GetDownloadQueue() []QueuedBag {
+	s.queueMx.Lock()
+	defer s.queueMx.Unlock()
+
+	active := make([]*queueEntry, 0, len(s.queueActive))
+	for _, e := range s.queueActive {
+		active = append(active, e)
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].since.Before(active[j].since)
+	})
+
+	waiting := make([]*queueEntry, 0, len(s.queueWaiting))
+	for _, e := range s.queueWaiting {
+		waiting = append(waiting, e)
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return queuedBefore(waiting[i], waiting[j])
+	})
+
+	res := make([]QueuedBag, 0, len(active)+len(waiting))
+	for _, e := range active {
+		res = append(res, QueuedBag{Torrent: e.t, Priority: e.t.GetDownloadPriority(), Active: true, Since: e.since})
+	}
+	for i, e := range waiting {
+		res = append(res, QueuedBag{Torrent: e.t, Priority: e.t.GetDownloadPriority(), Position: i + 1, Since: e.since})
+	}
+	return res
+}
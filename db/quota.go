@@ -0,0 +1,230 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+// QuotaCheckInterval - how often usage is compared with quotas
+var QuotaCheckInterval = 30 * time.Second
+
+const (
+	QuotaDisk      = "disk"
+	QuotaBandwidth = "bandwidth"
+
+	QuotaLevelOk       = "recovered"
+	QuotaLevelWarning  = "warning"
+	QuotaLevelExceeded = "exceeded"
+)
+
+// QuotaConfig - hard limits, when they are reached transfers are paused, and warning thresholds before them
+type QuotaConfig struct {
+	// DiskGB - max size of downloaded data of all bags, when reached, incomplete bags are paused, 0 = unlimited
+	DiskGB uint64
+	// BandwidthGB - max downloaded and uploaded data per calendar month (UTC), when reached,
+	// all bags are paused till next month, 0 = unlimited
+	BandwidthGB uint64
+	// DiskWarnPercent, BandwidthWarnPercent - usage in percents of quota when warning alert is sent, 0 = 80 and 90
+	DiskWarnPercent      uint64
+	BandwidthWarnPercent uint64
+}
+
+// QuotaAlert - sent when usage of quota crosses warning threshold, quota is exceeded, or usage is back to normal
+type QuotaAlert struct {
+	Quota string
+	Level string
+	Used  uint64
+	Limit uint64
+}
+
+func (a QuotaAlert) String() string {
+	switch a.Level {
+	case QuotaLevelWarning:
+		return fmt.Sprintf("%s quota warning: %s of %s used, transfers will be paused when it is reached",
+			a.Quota, storage.ToSz(a.Used), storage.ToSz(a.Limit))
+	case QuotaLevelExceeded:
+		return fmt.Sprintf("%s quota exceeded: %s of %s used, transfers are paused",
+			a.Quota, storage.ToSz(a.Used), storage.ToSz(a.Limit))
+	default:
+		return fmt.Sprintf("%s quota usage is back to normal: %s of %s used, transfers are resumed",
+			a.Quota, storage.ToSz(a.Used), storage.ToSz(a.Limit))
+	}
+}
+
+type quotaState struct {
+	level  string
+	paused map[string]*storage.Torrent
+}
+
+var bandwidthPeriodKey = []byte("bandwidth_period:")
+
+// SetQuotas - enables quotas, alert is called on changes of usage level, can be nil
+func (s *Storage) SetQuotas(cfg QuotaConfig, alert func(QuotaAlert)) {
+	if cfg.DiskWarnPercent == 0 {
+		cfg.DiskWarnPercent = 80
+	}
+	if cfg.BandwidthWarnPercent == 0 {
+		cfg.BandwidthWarnPercent = 90
+	}
+
+	s.quotaMx.Lock()
+	s.quotas = cfg
+	s.quotaAlert = alert
+	s.quotaMx.Unlock()
+}
+
+// GetBandwidthUsage - returns bytes downloaded and uploaded in the current month
+func (s *Storage) GetBandwidthUsage() (uint64, error) {
+	_, lifetime := s.GetTransferStats()
+	total := lifetime.Downloaded + lifetime.Uploaded
+
+	now := time.Now().UTC()
+	period := uint32(now.Year()*100 + int(now.Month()))
+
+	data, err := s.db.Get(bandwidthPeriodKey)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	if len(data) == 12 && binary.LittleEndian.Uint32(data) == period {
+		base := binary.LittleEndian.Uint64(data[4:])
+		if total < base {
+			return 0, nil
+		}
+		return total - base, nil
+	}
+
+	// new month, usage is counted from the current total
+	data = make([]byte, 12)
+	binary.LittleEndian.PutUint32(data, period)
+	binary.LittleEndian.PutUint64(data[4:], total)
+	if err = s.db.Put(bandwidthPeriodKey, data); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// GetDiskUsage - returns size of downloaded data of all bags
+func (s *Storage) GetDiskUsage() uint64 {
+	var used uint64
+	for _, t := range s.GetAll() {
+		used += t.Stats().Downloaded
+	}
+	return used
+}
+
+func (s *Storage) quotaChecker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(QuotaCheckInterval):
+		}
+
+		s.quotaMx.Lock()
+		cfg := s.quotas
+		s.quotaMx.Unlock()
+
+		if cfg.DiskGB > 0 {
+			s.checkQuota(QuotaDisk, s.GetDiskUsage(), cfg.DiskGB<<30, cfg.DiskWarnPercent)
+		}
+
+		if cfg.BandwidthGB > 0 {
+			used, err := s.GetBandwidthUsage()
+			if err != nil {
+				storage.Log.Warn("failed to get bandwidth usage", storage.Component("quota"), storage.ErrAttr(err))
+				continue
+			}
+			s.checkQuota(QuotaBandwidth, used, cfg.BandwidthGB<<30, cfg.BandwidthWarnPercent)
+		}
+	}
+}
+
+func (s *Storage) checkQuota(quota string, used, limit, warnPercent uint64) {
+	level := QuotaLevelOk
+	if used >= limit {
+		level = QuotaLevelExceeded
+	} else if used >= limit/100*warnPercent {
+		level = QuotaLevelWarning
+	}
+
+	s.quotaMx.Lock()
+	st := s.quotaStates[quota]
+	if st == nil {
+		st = &quotaState{level: QuotaLevelOk, paused: map[string]*storage.Torrent{}}
+		s.quotaStates[quota] = st
+	}
+	prev := st.level
+	st.level = level
+	alert := s.quotaAlert
+	s.quotaMx.Unlock()
+
+	if level == QuotaLevelExceeded {
+		// checked each time, because bags can be started manually or added while quota is exceeded
+		s.pauseForQuota(quota, st)
+	} else if prev == QuotaLevelExceeded {
+		s.resumeAfterQuota(quota, st)
+	}
+
+	if level == prev {
+		return
+	}
+	if level == QuotaLevelOk && prev == QuotaLevelWarning {
+		// usage is decreased below warning, nothing was paused
+		return
+	}
+
+	a := QuotaAlert{Quota: quota, Level: level, Used: used, Limit: limit}
+	storage.Log.Warn(a.String(), storage.Component("quota"), "quota", a.Quota, "level", a.Level)
+	if alert != nil {
+		alert(a)
+	}
+}
+
+// pauseForQuota - stops transfers of bags without changing their saved state,
+// so they are started again after restart, or when usage is back to normal
+func (s *Storage) pauseForQuota(quota string, st *quotaState) {
+	for _, t := range s.GetAll() {
+		if active, _ := t.IsActive(); !active {
+			continue
+		}
+		// completed bags are not taking more space, only uploading
+		if quota == QuotaDisk && t.Info != nil && t.Stats().Completed {
+			continue
+		}
+
+		storage.Log.Warn("pausing bag because of quota", storage.Component("quota"), storage.BagAttr(t.BagID), "quota", quota)
+		t.Stop()
+
+		s.quotaMx.Lock()
+		st.paused[string(t.BagID)] = t
+		s.quotaMx.Unlock()
+	}
+}
+
+func (s *Storage) resumeAfterQuota(quota string, st *quotaState) {
+	s.quotaMx.Lock()
+	list := st.paused
+	st.paused = map[string]*storage.Torrent{}
+	s.quotaMx.Unlock()
+
+	for id, t := range list {
+		// bag can be removed meanwhile
+		if s.GetTorrent([]byte(id)) != t {
+			continue
+		}
+		if active, _ := t.IsActive(); active {
+			continue
+		}
+
+		storage.Log.Info("resuming bag after quota", storage.Component("quota"), storage.BagAttr(t.BagID), "quota", quota)
+		if err := t.Resume(); err != nil {
+			storage.Log.Error("failed to resume bag after quota", storage.Component("quota"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"os"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// IsCorrupted - checks if db cannot be opened because its files are corrupted
+func IsCorrupted(err error) bool {
+	return lerrors.IsCorrupted(err)
+}
+
+// RecoverLevelDB - opens corrupted leveldb. First leveldb recovery is tried, it rebuilds manifest from table files,
+// so most records survive. When it fails, corrupted db is moved to path-corrupted-[unix time] and new empty db
+// is created, then fresh is true. In both cases stored pieces state cannot be trusted,
+// so RebuildPieceState should be run after storage is loaded.
+func RecoverLevelDB(path string, cfg LevelDBConfig) (kv *LevelDB, fresh bool, err error) {
+	db, err := leveldb.RecoverFile(path, cfg.Options())
+	if err == nil {
+		return &LevelDB{db: db}, false, nil
+	}
+	storage.Log.Error("failed to recover db, creating new one", storage.Component("recover"), storage.ErrAttr(err))
+
+	moved := fmt.Sprintf("%s-corrupted-%d", path, time.Now().Unix())
+	if err = os.Rename(path, moved); err != nil {
+		return nil, false, fmt.Errorf("failed to move corrupted db aside: %w", err)
+	}
+
+	if kv, err = OpenLevelDB(path, cfg); err != nil {
+		return nil, false, err
+	}
+	return kv, true, nil
+}
+
+// FindLegacyBags - returns ids of bags which directories are in downloadsDir, named by bag id,
+// it is used to find known bags when db is lost. Bags moved by MigrateLayout or downloaded to custom paths
+// are not found, their directories are not named by bag id and layout root is known only from db.
+func FindLegacyBags(downloadsDir string) ([][]byte, error) {
+	entries, err := os.ReadDir(downloadsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids [][]byte
+	for _, e := range entries {
+		if !e.IsDir() || len(e.Name()) != 64 {
+			continue
+		}
+		id, err := hex.DecodeString(e.Name())
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// RebuildPieceState - verifies stored pieces of all bags after db recovery: pieces which are not matching data
+// on disk are marked as missing, and incomplete bags are checked for existing files, so data which is on disk
+// but lost from db is imported instead of downloaded again. Returns number of corrupted pieces.
+func (s *Storage) RebuildPieceState(ctx context.Context, progressCallback func(bag, bags int)) (int, error) {
+	all := s.GetAll()
+
+	var corrupted int
+	for i, t := range all {
+		if progressCallback != nil {
+			progressCallback(i, len(all))
+		}
+
+		if t.Info != nil && t.Header != nil {
+			c, _, err := t.ValidateFrom(ctx, 0, nil)
+			corrupted += len(c)
+			if err != nil {
+				if ctx.Err() != nil {
+					return corrupted, ctx.Err()
+				}
+				storage.Log.Warn("failed to verify bag", storage.Component("recover"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+				continue
+			}
+
+			if isComplete(t) {
+				continue
+			}
+		}
+
+		if err := t.SetCheckExisting(true); err != nil {
+			storage.Log.Warn("failed to check existing files of bag", storage.Component("recover"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+		}
+	}
+	return corrupted, nil
+}
+
+func isComplete(t *storage.Torrent) bool {
+	var have uint32
+	for _, b := range t.PiecesMask() {
+		have += uint32(bits.OnesCount8(b))
+	}
+	return have >= t.PiecesNum()
+}
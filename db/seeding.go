@@ -0,0 +1,232 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+// SeedPolicy - when completed bag should stop seeding, zero Ratio and Hours mean no limit
+type SeedPolicy struct {
+	// Ratio - uploaded bytes of bag divided by size of its data
+	Ratio float64
+	// Hours - time of seeding after bag is completed
+	Hours uint64
+	// Remove - remove bag instead of stopping it, RemoveFiles - delete its files too,
+	// files are deleted only for bags which data was downloaded, files of created bags are kept
+	Remove      bool
+	RemoveFiles bool
+}
+
+func (p SeedPolicy) IsZero() bool {
+	return p.Ratio == 0 && p.Hours == 0
+}
+
+// SeedState - seeding progress of bag against its policy
+type SeedState struct {
+	Policy SeedPolicy
+	// Custom - policy is set for bag, otherwise global policy is used
+	Custom bool
+	Ratio  float64
+	// SeedingSince - when bag was completed, zero if it is not completed yet
+	SeedingSince time.Time
+	// Reached - bag was stopped by policy, if it is resumed manually, policy is not applied to it anymore
+	Reached bool
+}
+
+// bagSeedRecord - per bag data of seed policies, kept in db
+type bagSeedRecord struct {
+	Policy       *SeedPolicy `json:",omitempty"`
+	SeedingSince time.Time
+	Reached      bool `json:",omitempty"`
+}
+
+var globalSeedPolicyKey = []byte("seed_policy:")
+
+func bagSeedKey(bagId []byte) []byte {
+	return append([]byte("bag_seed:"), bagId...)
+}
+
+// SetSeedPolicy - sets global policy, applied to bags without their own policy
+func (s *Storage) SetSeedPolicy(p SeedPolicy) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	s.seedMx.Lock()
+	defer s.seedMx.Unlock()
+
+	if err = s.db.Put(globalSeedPolicyKey, data); err != nil {
+		return err
+	}
+	s.seedPolicy = p
+	return nil
+}
+
+func (s *Storage) GetSeedPolicy() SeedPolicy {
+	s.seedMx.Lock()
+	defer s.seedMx.Unlock()
+
+	return s.seedPolicy
+}
+
+// SetBagSeedPolicy - sets policy of bag, nil to use global policy for it
+func (s *Storage) SetBagSeedPolicy(t *storage.Torrent, p *SeedPolicy) error {
+	s.seedMx.Lock()
+	defer s.seedMx.Unlock()
+
+	rec := s.bagSeed(t.BagID)
+	rec.Policy = p
+	// new policy can allow more seeding, so it is applied again even if bag was resumed manually
+	rec.Reached = false
+	return s.saveBagSeed(t.BagID, rec)
+}
+
+// GetSeedState - returns policy of bag and its progress
+func (s *Storage) GetSeedState(t *storage.Torrent) SeedState {
+	s.seedMx.Lock()
+	rec := s.bagSeed(t.BagID)
+	st := SeedState{
+		Policy:       s.seedPolicy,
+		SeedingSince: rec.SeedingSince,
+		Reached:      rec.Reached,
+	}
+	if rec.Policy != nil {
+		st.Policy = *rec.Policy
+		st.Custom = true
+	}
+	s.seedMx.Unlock()
+
+	if t.Info != nil && t.Info.FileSize > t.Info.HeaderSize {
+		st.Ratio = float64(s.GetBagTransferStats(t).Uploaded) / float64(t.Info.FileSize-t.Info.HeaderSize)
+	}
+	return st
+}
+
+// bagSeed - should be called under seedMx lock
+func (s *Storage) bagSeed(bagId []byte) bagSeedRecord {
+	if rec := s.bagSeeds[string(bagId)]; rec != nil {
+		return *rec
+	}
+	return bagSeedRecord{}
+}
+
+// saveBagSeed - should be called under seedMx lock
+func (s *Storage) saveBagSeed(bagId []byte, rec bagSeedRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err = s.db.Put(bagSeedKey(bagId), data); err != nil {
+		return err
+	}
+	s.bagSeeds[string(bagId)] = &rec
+	return nil
+}
+
+func (s *Storage) removeBagSeed(bagId []byte) error {
+	s.seedMx.Lock()
+	defer s.seedMx.Unlock()
+
+	delete(s.bagSeeds, string(bagId))
+	return s.db.Delete(bagSeedKey(bagId))
+}
+
+func (s *Storage) loadSeedPolicies() error {
+	data, err := s.db.Get(globalSeedPolicyKey)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &s.seedPolicy); err != nil {
+			return fmt.Errorf("failed to parse seed policy: %w", err)
+		}
+	}
+
+	return s.db.Iterate([]byte("bag_seed:"), func(key, value []byte) bool {
+		var rec bagSeedRecord
+		if err := json.Unmarshal(value, &rec); err != nil {
+			storage.Log.Warn("failed to parse seed record of bag", storage.Component("seed-policy"), storage.BagAttr(key[9:]), storage.ErrAttr(err))
+			return true
+		}
+		s.bagSeeds[string(key[9:])] = &rec
+		return true
+	})
+}
+
+func (s *Storage) seedPolicyChecker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+
+		for _, t := range s.GetAll() {
+			if err := s.checkSeedPolicy(t); err != nil {
+				storage.Log.Error("failed to apply seed policy to bag", storage.Component("seed-policy"), storage.BagAttr(t.BagID), storage.ErrAttr(err))
+			}
+		}
+	}
+}
+
+func (s *Storage) checkSeedPolicy(t *storage.Torrent) error {
+	if _, upload := t.IsActive(); !upload || t.Info == nil || t.Header == nil {
+		return nil
+	}
+	if !t.Stats().Completed {
+		return nil
+	}
+
+	s.seedMx.Lock()
+	rec := s.bagSeed(t.BagID)
+	if rec.SeedingSince.IsZero() {
+		// seeding time is counted from the first check after completion
+		rec.SeedingSince = time.Now()
+		if err := s.saveBagSeed(t.BagID, rec); err != nil {
+			s.seedMx.Unlock()
+			return err
+		}
+	}
+	s.seedMx.Unlock()
+
+	st := s.GetSeedState(t)
+	if st.Reached || st.Policy.IsZero() {
+		return nil
+	}
+
+	reason := ""
+	if st.Policy.Ratio > 0 && st.Ratio >= st.Policy.Ratio {
+		reason = fmt.Sprintf("ratio %.2f is reached", st.Ratio)
+	} else if st.Policy.Hours > 0 && time.Since(st.SeedingSince) >= time.Duration(st.Policy.Hours)*time.Hour {
+		reason = fmt.Sprintf("seeded for %d hours", st.Policy.Hours)
+	}
+	if reason == "" {
+		return nil
+	}
+
+	if st.Policy.Remove {
+		// created bags have nothing downloaded, their files are user's originals
+		withFiles := st.Policy.RemoveFiles && s.GetBagTransferStats(t).Downloaded > 0
+		storage.Log.Info("seed policy of bag is done, removing", storage.Component("storage"), storage.BagAttr(t.BagID), "reason", reason)
+		return s.RemoveTorrent(t, withFiles)
+	}
+
+	storage.Log.Info("seed policy of bag is done, stopping", storage.Component("storage"), storage.BagAttr(t.BagID), "reason", reason)
+	t.Stop()
+	if err := s.SetTorrent(t); err != nil {
+		return err
+	}
+
+	s.seedMx.Lock()
+	defer s.seedMx.Unlock()
+
+	rec = s.bagSeed(t.BagID)
+	rec.Reached = true
+	return s.saveBagSeed(t.BagID, rec)
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/xssnick/tonutils-storage/storage"
+	"time"
+)
+
+type TransferStats struct {
+	Downloaded uint64
+	Uploaded   uint64
+}
+
+// bagStats - lifetime stats of bag saved in db, and its session stats at the moment of saving
+type bagStats struct {
+	lifetime TransferStats
+	flushed  TransferStats
+}
+
+// GetTransferStats - returns bytes transferred since process start and for the whole node lifetime
+func (s *Storage) GetTransferStats() (session, lifetime TransferStats) {
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	session = s.currentSessionStats()
+	lifetime = TransferStats{
+		Downloaded: s.lifetimeStats.Downloaded + session.Downloaded - s.flushedSessionStats.Downloaded,
+		Uploaded:   s.lifetimeStats.Uploaded + session.Uploaded - s.flushedSessionStats.Uploaded,
+	}
+	return session, lifetime
+}
+
+// GetBagTransferStats - returns bytes transferred for bag during its whole lifetime in storage
+func (s *Storage) GetBagTransferStats(t *storage.Torrent) TransferStats {
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	return s.bagLifetimeStats(t)
+}
+
+// bagLifetimeStats - should be called under statsMx lock
+func (s *Storage) bagLifetimeStats(t *storage.Torrent) TransferStats {
+	down, up := t.GetSessionTransferred()
+	st := TransferStats{Downloaded: down, Uploaded: up}
+	if bs := s.bagStats[string(t.BagID)]; bs != nil {
+		st.Downloaded += bs.lifetime.Downloaded - bs.flushed.Downloaded
+		st.Uploaded += bs.lifetime.Uploaded - bs.flushed.Uploaded
+	}
+	return st
+}
+
+// currentSessionStats - should be called under statsMx lock
+func (s *Storage) currentSessionStats() TransferStats {
+	st := s.removedSessionStats
+	for _, t := range s.GetAll() {
+		down, up := t.GetSessionTransferred()
+		st.Downloaded += down
+		st.Uploaded += up
+	}
+	return st
+}
+
+func (s *Storage) addRemovedSessionStats(t *storage.Torrent) {
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	down, up := t.GetSessionTransferred()
+	s.removedSessionStats.Downloaded += down
+	s.removedSessionStats.Uploaded += up
+}
+
+// removeBagTransferStats - forgets stats of removed bag, so it starts from zero when added again
+func (s *Storage) removeBagTransferStats(bagId []byte) error {
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	delete(s.bagStats, string(bagId))
+	return s.db.Delete(bagStatsKey(bagId))
+}
+
+func bagStatsKey(bagId []byte) []byte {
+	return append([]byte("bag_stats:"), bagId...)
+}
+
+func (s *Storage) loadTransferStats() error {
+	data, err := s.db.Get([]byte("transfer_stats:"))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if len(data) >= 16 {
+		s.lifetimeStats = TransferStats{
+			Downloaded: binary.LittleEndian.Uint64(data),
+			Uploaded:   binary.LittleEndian.Uint64(data[8:]),
+		}
+	}
+
+	return s.db.Iterate([]byte("bag_stats:"), func(key, value []byte) bool {
+		if len(value) < 16 {
+			return true
+		}
+		s.bagStats[string(key[10:])] = &bagStats{
+			lifetime: TransferStats{
+				Downloaded: binary.LittleEndian.Uint64(value),
+				Uploaded:   binary.LittleEndian.Uint64(value[8:]),
+			},
+		}
+		return true
+	})
+}
+
+func (s *Storage) flushTransferStats() error {
+	s.statsMx.Lock()
+	defer s.statsMx.Unlock()
+
+	session := s.currentSessionStats()
+	lifetime := TransferStats{
+		Downloaded: s.lifetimeStats.Downloaded + session.Downloaded - s.flushedSessionStats.Downloaded,
+		Uploaded:   s.lifetimeStats.Uploaded + session.Uploaded - s.flushedSessionStats.Uploaded,
+	}
+
+	batch := new(Batch)
+	batch.Put([]byte("transfer_stats:"), encodeTransferStats(lifetime))
+
+	flushed := map[string]*bagStats{}
+	for _, t := range s.GetAll() {
+		st := s.bagLifetimeStats(t)
+		if bs := s.bagStats[string(t.BagID)]; bs != nil && bs.lifetime == st {
+			continue
+		}
+
+		down, up := t.GetSessionTransferred()
+		flushed[string(t.BagID)] = &bagStats{
+			lifetime: st,
+			flushed:  TransferStats{Downloaded: down, Uploaded: up},
+		}
+		batch.Put(bagStatsKey(t.BagID), encodeTransferStats(st))
+	}
+
+	if err := s.db.Write(batch, false); err != nil {
+		return err
+	}
+
+	s.lifetimeStats = lifetime
+	s.flushedSessionStats = session
+	for id, bs := range flushed {
+		s.bagStats[id] = bs
+	}
+	return nil
+}
+
+func encodeTransferStats(st TransferStats) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data, st.Downloaded)
+	binary.LittleEndian.PutUint64(data[8:], st.Uploaded)
+	return data
+}
+
+func (s *Storage) transferStatsSaver() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+
+		if err := s.flushTransferStats(); err != nil {
+			storage.Log.Error("failed to save transfer stats", storage.Component("storage"), storage.ErrAttr(err))
+		}
+	}
+}
+
+// GetDBSizes - returns approximate size on disk of db records by their kind,
+// nil when db engine is not able to tell it
+func (s *Storage) GetDBSizes() (map[string]int64, error) {
+	sizer, ok := s.db.(KVSizer)
+	if !ok {
+		return nil, nil
+	}
+
+	kinds := map[string]string{
+		"bags":         "bags:",
+		"pieces":       "pc:",
+		"active_files": "ai:",
+	}
+
+	res := make(map[string]int64, len(kinds))
+	for kind, prefix := range kinds {
+		sz, err := sizer.SizeOf([]byte(prefix))
+		if err != nil {
+			return nil, err
+		}
+		res[kind] = sz
+	}
+	return res, nil
+}
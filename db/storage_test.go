@@ -0,0 +1,35 @@
+package db
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/xssnick/tonutils-storage/storage"
+	"github.com/xssnick/tonutils-storage/storage/storagetest"
+)
+
+func TestStorage_CloseStopsRoutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	s, err := NewStorage(NewMemoryKV(), storage.NewConnector(storagetest.Server{}), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("background routines are not started")
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("leaked goroutines: %d, expected %d\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
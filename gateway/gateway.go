@@ -0,0 +1,132 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Gateway - serves files of bags over HTTP on /bag/<bag_id>/<path>, with Range requests support.
+// Files of bags which are still downloading are served too, reads are waiting for required pieces.
+// Private, unlisted and allow-listed bags are not served.
+type Gateway struct {
+	store *db.Storage
+}
+
+func NewGateway(store *db.Storage) *Gateway {
+	return &Gateway{
+		store: store,
+	}
+}
+
+func (g *Gateway) Start(addr string) error {
+	return http.ListenAndServe(addr, g.Handler())
+}
+
+// Handler - returns gateway routes, to mount them into another http server
+func (g *Gateway) Handler() http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/bag/", g.handleBag)
+	return m
+}
+
+func (g *Gateway) handleBag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/bag/"), "/", 2)
+	bagId, err := hex.DecodeString(parts[0])
+	if err != nil || len(bagId) != 32 {
+		http.Error(w, "invalid bag id", http.StatusBadRequest)
+		return
+	}
+
+	t := g.store.GetTorrent(bagId)
+	// bags with allow-list or unlisted are hidden too, http clients can't be checked against them
+	if t == nil || t.IsRestricted() {
+		http.Error(w, "bag not found", http.StatusNotFound)
+		return
+	}
+
+	if t.Info == nil || t.Header == nil {
+		http.Error(w, "bag header is not downloaded yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	if len(parts) == 1 {
+		// links of listing are relative to the bag root
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	path := parts[1]
+
+	if path == "" || strings.HasSuffix(path, "/") {
+		g.listDir(w, t, parts[0], path)
+		return
+	}
+
+	f, err := t.OpenFile(r.Context(), path)
+	if err != nil {
+		if errors.Is(err, storage.ErrFileNotExist) {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(w, r, path, t.CreatedAt, f)
+}
+
+func (g *Gateway) listDir(w http.ResponseWriter, t *storage.Torrent, bagId, dir string) {
+	files, err := t.ListFiles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range files {
+		if !strings.HasPrefix(name, dir) {
+			continue
+		}
+
+		name = strings.TrimPrefix(name, dir)
+		if i := strings.Index(name, "/"); i >= 0 {
+			// subdirectory
+			name = name[:i+1]
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		http.Error(w, "directory not found", http.StatusNotFound)
+		return
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	title := html.EscapeString("/" + bagId + "/" + dir)
+	sb.WriteString("<html><head><title>" + title + "</title></head><body><h3>" + title + "</h3><ul>")
+	for _, name := range names {
+		link := (&url.URL{Path: name}).String()
+		sb.WriteString(`<li><a href="` + html.EscapeString(link) + `">` + html.EscapeString(name) + "</a></li>")
+	}
+	sb.WriteString("</ul></body></html>")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"github.com/xssnick/tonutils-storage/storage/storagetest"
+)
+
+func TestGateway_RestrictedBags(t *testing.T) {
+	conn := storage.NewConnector(storagetest.Server{})
+	store, err := db.NewStorage(db.NewMemoryKV(), conn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	dir := filepath.Join(t.TempDir(), "bag")
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGateway(store)
+	for _, tt := range []struct {
+		name     string
+		restrict func(tor *storage.Torrent)
+		status   int
+	}{
+		{"public", func(tor *storage.Torrent) {}, http.StatusOK},
+		{"private", func(tor *storage.Torrent) { tor.SetSwarmSecret([]byte("secret")) }, http.StatusNotFound},
+		{"allow-list", func(tor *storage.Torrent) { tor.SetAllowedPeers([][]byte{make([]byte, 32)}) }, http.StatusNotFound},
+		{"unlisted", func(tor *storage.Torrent) { tor.SetUnlisted(true) }, http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rootPath, dirName, files, err := store.DetectFileRefs(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// description makes bag id unique for each case
+			tor, err := storage.CreateTorrent(context.Background(), rootPath, dirName, tt.name, store, conn, files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.restrict(tor)
+			if err = store.SetTorrent(tor); err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			g.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/bag/"+hex.EncodeToString(tor.BagID)+"/file.txt", nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, expected %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
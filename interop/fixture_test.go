@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"github.com/xssnick/tonutils-storage/storage/storagetest"
+)
+
+// TestFixtureBagID - bag created from fixture files has recorded id. Id is self-generated regression value,
+// it is compared with storage-daemon only by interop test, here it catches any change of bag format
+func TestFixtureBagID(t *testing.T) {
+	*FixtureDir = "testdata"
+
+	conn := storage.NewConnector(storagetest.Server{})
+	store, err := db.NewStorage(db.NewMemoryKV(), conn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	f, tor, err := createFixtureBag(t.TempDir(), store, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := hex.EncodeToString(tor.BagID); id != f.BagID {
+		t.Fatalf("bag id %s, expected %s", id, f.BagID)
+	}
+}
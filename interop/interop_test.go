@@ -0,0 +1,34 @@
+//go:build interop
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestInterop - runs all checks against storage-daemon, binaries are taken from
+// STORAGE_DAEMON and STORAGE_DAEMON_CLI, without them test is skipped.
+// Example: STORAGE_DAEMON=./storage-daemon STORAGE_DAEMON_CLI=./storage-daemon-cli go test -tags interop ./interop
+func TestInterop(t *testing.T) {
+	*DaemonBin = os.Getenv("STORAGE_DAEMON")
+	*DaemonCLIBin = os.Getenv("STORAGE_DAEMON_CLI")
+	if *DaemonBin == "" || *DaemonCLIBin == "" {
+		t.Skip("STORAGE_DAEMON and STORAGE_DAEMON_CLI are not set")
+	}
+	*FixtureDir = "testdata"
+
+	e, err := startEnv(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.fn(e); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
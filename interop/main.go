@@ -0,0 +1,467 @@
+package main
+
+// Interop checks of tonutils-storage against reference C++ storage-daemon.
+// It starts storage-daemon from the given binary, and exchanges bags with it in both directions:
+// handshake, header fetch, pieces transfer and DHT announce are verified.
+// Both nodes should be reachable for each other by the given ip, and DHT should be accessible.
+//
+// Example: go run ./interop --daemon ./storage-daemon --daemon-cli ./storage-daemon-cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pterm/pterm"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/dht"
+	"github.com/xssnick/tonutils-go/liteclient"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	DaemonBin    = flag.String("daemon", "", "Path to storage-daemon binary")
+	DaemonCLIBin = flag.String("daemon-cli", "", "Path to storage-daemon-cli binary")
+	NetConfig    = flag.String("config", "https://ton.org/global.config.json", "Network config url or path")
+	IP           = flag.String("ip", "127.0.0.1", "IP which nodes are announcing")
+	DaemonPort   = flag.Int("daemon-port", 17601, "ADNL port of storage-daemon")
+	ControlPort  = flag.Int("control-port", 17602, "Control port of storage-daemon")
+	OurPort      = flag.Int("port", 17603, "ADNL port of tonutils-storage")
+	FileSize     = flag.Int("size", 3<<20, "Size of test file in bytes")
+	Timeout      = flag.Duration("timeout", 5*time.Minute, "Timeout of each check")
+	Keep         = flag.Bool("keep", false, "Keep temporary dir after run")
+	FixtureDir   = flag.String("fixture", "interop/testdata", "Directory with expected id of fixture bag")
+)
+
+type node struct {
+	store *db.Storage
+	srv   *storage.Server
+	conn  *storage.Connector
+}
+
+func main() {
+	flag.Parse()
+
+	if *DaemonBin == "" || *DaemonCLIBin == "" {
+		pterm.Error.Println("--daemon and --daemon-cli should be set")
+		os.Exit(2)
+	}
+
+	dir, err := os.MkdirTemp("", "tonutils-storage-interop")
+	if err != nil {
+		pterm.Error.Println("Failed to create temp dir:", err.Error())
+		os.Exit(1)
+	}
+	if !*Keep {
+		defer os.RemoveAll(dir)
+	}
+	pterm.Info.Println("Working dir:", dir)
+
+	env, err := startEnv(dir)
+	if err != nil {
+		pterm.Error.Println(err.Error())
+		os.Exit(1)
+	}
+	defer env.Close()
+
+	failed := 0
+	for _, c := range checks {
+		spinner, _ := pterm.DefaultSpinner.Start(c.name)
+		if err = c.fn(env); err != nil {
+			failed++
+			spinner.Fail(c.name, ": ", err.Error())
+			continue
+		}
+		spinner.Success(c.name)
+	}
+
+	if failed > 0 {
+		pterm.Error.Println(failed, "of", len(checks), "checks failed")
+		os.Exit(1)
+	}
+	pterm.Success.Println("All checks passed")
+}
+
+// env - running storage-daemon and tonutils-storage node, which checks are done between
+type env struct {
+	dir    string
+	node   *node
+	daemon *exec.Cmd
+	cancel func()
+}
+
+var checks = []struct {
+	name string
+	fn   func(e *env) error
+}{
+	{"fixture bag id is same in storage-daemon", func(e *env) error { return checkFixtureBagID(e.dir) }},
+	{"download bag created by storage-daemon", func(e *env) error { return checkDownloadFromDaemon(e.node, e.dir) }},
+	{"upload bag to storage-daemon", func(e *env) error { return checkUploadToDaemon(e.node, e.dir) }},
+}
+
+// startEnv - starts storage-daemon and tonutils-storage node with working files in dir
+func startEnv(dir string) (*env, error) {
+	cfgPath, err := networkConfig(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network config: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	daemon, err := startDaemon(ctx, dir, cfgPath)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start storage-daemon: %w", err)
+	}
+
+	n, err := startNode(dir, cfgPath)
+	if err != nil {
+		cancel()
+		_ = daemon.Wait()
+		return nil, fmt.Errorf("failed to start tonutils-storage: %w", err)
+	}
+
+	return &env{dir: dir, node: n, daemon: daemon, cancel: cancel}, nil
+}
+
+func (e *env) Close() {
+	e.node.srv.Stop()
+	_ = e.daemon.Process.Kill()
+	_ = e.daemon.Wait()
+	e.cancel()
+}
+
+func networkConfig(dir string) (string, error) {
+	if _, err := os.Stat(*NetConfig); err == nil {
+		return filepath.Abs(*NetConfig)
+	}
+
+	resp, err := http.Get(*NetConfig)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+
+	path := filepath.Join(dir, "global.config.json")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func startDaemon(ctx context.Context, dir, cfgPath string) (*exec.Cmd, error) {
+	dbDir := filepath.Join(dir, "daemon-db")
+	logFile, err := os.Create(filepath.Join(dir, "daemon.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, *DaemonBin, "-v", "3", "-C", cfgPath,
+		"-I", fmt.Sprintf("%s:%d", *IP, *DaemonPort), "-p", fmt.Sprint(*ControlPort), "-D", dbDir)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// cli keys are generated by daemon on start
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if _, err = os.Stat(filepath.Join(dbDir, "cli-keys", "server.pub")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			_ = cmd.Process.Kill()
+			return nil, fmt.Errorf("daemon not created cli keys, check %s", logFile.Name())
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return cmd, nil
+}
+
+func daemonCLI(dir, command string) (string, error) {
+	keys := filepath.Join(dir, "daemon-db", "cli-keys")
+	out, err := exec.Command(*DaemonCLIBin, "-I", fmt.Sprintf("127.0.0.1:%d", *ControlPort),
+		"-k", filepath.Join(keys, "client"), "-p", filepath.Join(keys, "server.pub"), "-c", command).CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("command %q failed: %w, output: %s", command, err, out)
+	}
+	return string(out), nil
+}
+
+func startNode(dir, cfgPath string) (*node, error) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgData, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network config: %w", err)
+	}
+
+	var lsCfg *liteclient.GlobalConfig
+	if err = json.Unmarshal(cfgData, &lsCfg); err != nil {
+		return nil, fmt.Errorf("failed to parse network config: %w", err)
+	}
+
+	gate := adnl.NewGateway(key)
+	gate.SetExternalIP(net.ParseIP(*IP))
+	if err = gate.StartServer(fmt.Sprintf("0.0.0.0:%d", *OurPort)); err != nil {
+		return nil, fmt.Errorf("failed to start adnl gateway: %w", err)
+	}
+
+	dhtGate := adnl.NewGateway(key)
+	if err = dhtGate.StartClient(); err != nil {
+		return nil, fmt.Errorf("failed to start dht gateway: %w", err)
+	}
+
+	dhtClient, err := dht.NewClientFromConfig(dhtGate, lsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init dht client: %w", err)
+	}
+
+	kv, err := db.OpenLevelDB(filepath.Join(dir, "node-db"), db.LevelDBConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+
+	srv := storage.NewServer(dhtClient, gate, key, true, true)
+	conn := storage.NewConnector(srv)
+	store, err := db.NewStorage(kv, conn, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init storage: %w", err)
+	}
+	srv.SetStorage(store)
+
+	return &node{
+		store: store,
+		srv:   srv,
+		conn:  conn,
+	}, nil
+}
+
+func randomFile(path string) ([]byte, error) {
+	data := make([]byte, *FileSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return data, os.WriteFile(path, data, 0644)
+}
+
+// waitFile - waits till file with the given name under root has expected content
+func waitFile(root, name string, expected []byte) error {
+	deadline := time.Now().Add(*Timeout)
+	for time.Now().Before(deadline) {
+		var found []byte
+		_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && info.Name() == name && info.Size() == int64(len(expected)) {
+				found, _ = os.ReadFile(path)
+			}
+			return nil
+		})
+		if bytes.Equal(found, expected) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("file %s is not received in %s", name, Timeout.String())
+}
+
+var bagIDRegexp = regexp.MustCompile(`[0-9A-Fa-f]{64}`)
+
+func checkDownloadFromDaemon(n *node, dir string) error {
+	src := filepath.Join(dir, "daemon-src", "from-daemon.bin")
+	data, err := randomFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to prepare file: %w", err)
+	}
+
+	out, err := daemonCLI(dir, "create "+src+" -d interop")
+	if err != nil {
+		return err
+	}
+
+	id := bagIDRegexp.FindString(out)
+	if id == "" {
+		return fmt.Errorf("bag id not found in create output: %s", out)
+	}
+	bagId, _ := hex.DecodeString(id)
+
+	dst := filepath.Join(dir, "node-downloads")
+	t := storage.NewTorrent(dst, n.store, n.conn)
+	t.BagID = bagId
+	if err = t.Start(true, true, false); err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+	if err = n.store.SetTorrent(t); err != nil {
+		return fmt.Errorf("failed to add bag: %w", err)
+	}
+
+	// header is fetched only after peer is found in DHT and handshake is done
+	deadline := time.Now().Add(*Timeout)
+	for t.Header == nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("header is not fetched, peers: %d", len(t.GetPeers()))
+		}
+		time.Sleep(time.Second)
+	}
+
+	return waitFile(dst, filepath.Base(src), data)
+}
+
+func checkUploadToDaemon(n *node, dir string) error {
+	src := filepath.Join(dir, "node-src", "from-node.bin")
+	data, err := randomFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to prepare file: %w", err)
+	}
+
+	rootPath, dirName, files, err := n.store.DetectFileRefs(src)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	t, err := storage.CreateTorrent(context.Background(), rootPath, dirName, "interop", n.store, n.conn, files)
+	if err != nil {
+		return fmt.Errorf("failed to create bag: %w", err)
+	}
+	if err = t.Start(true, true, false); err != nil {
+		return fmt.Errorf("failed to start bag: %w", err)
+	}
+	if err = n.store.SetTorrent(t); err != nil {
+		return fmt.Errorf("failed to add bag: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *Timeout)
+	err = n.srv.WaitAnnounced(ctx, t)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("bag is not announced in dht: %w", err)
+	}
+
+	dst := filepath.Join(dir, "daemon-downloads")
+	if _, err = daemonCLI(dir, "add-by-hash "+hex.EncodeToString(t.BagID)+" -d "+dst); err != nil {
+		return err
+	}
+
+	return waitFile(dst, filepath.Base(src), data)
+}
+
+// fixture - expected id of bag made from generated files, so any change of bag format is detected.
+// Id was produced by tonutils-storage, not by storage-daemon, so it is a regression value until
+// checkFixtureBagID confirms that storage-daemon creates the same bag.
+type fixture struct {
+	Description string `json:"description"`
+	BagID       string `json:"bag_id"`
+}
+
+// loadFixture - reads fixture from testdata and writes its files to dir, returns path of bag directory
+func loadFixture(dir string) (*fixture, string, error) {
+	data, err := os.ReadFile(filepath.Join(*FixtureDir, "fixture.json"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read fixture: %w", err)
+	}
+
+	var f fixture
+	if err = json.Unmarshal(data, &f); err != nil {
+		return nil, "", fmt.Errorf("failed to parse fixture: %w", err)
+	}
+
+	path, err := filepath.Abs(filepath.Join(dir, "fixture", "bag"))
+	if err != nil {
+		return nil, "", err
+	}
+	if err = writeFixtureFiles(path); err != nil {
+		return nil, "", fmt.Errorf("failed to write fixture files: %w", err)
+	}
+	return &f, path, nil
+}
+
+// writeFixtureFiles - deterministic files of fixture bag, numbers file spans several pieces
+func writeFixtureFiles(path string) error {
+	var numbers bytes.Buffer
+	for i := 0; i < 60000; i++ {
+		numbers.WriteString(strconv.Itoa(i))
+		numbers.WriteByte('\n')
+	}
+
+	files := map[string][]byte{
+		"hello.txt":          []byte("tonutils-storage interop fixture\n"),
+		"nested/numbers.txt": numbers.Bytes(),
+	}
+	for name, data := range files {
+		name = filepath.Join(path, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFixtureBag - creates bag from fixture files, same as create command does
+func createFixtureBag(dir string, store *db.Storage, conn storage.NetConnector) (*fixture, *storage.Torrent, error) {
+	f, path, err := loadFixture(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootPath, dirName, files, err := store.DetectFileRefs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read fixture files: %w", err)
+	}
+
+	t, err := storage.CreateTorrent(context.Background(), rootPath, dirName, f.Description, store, conn, files)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create bag: %w", err)
+	}
+	return f, t, nil
+}
+
+func checkFixtureBagID(dir string) error {
+	f, path, err := loadFixture(dir)
+	if err != nil {
+		return err
+	}
+
+	out, err := daemonCLI(dir, "create "+path+" -d "+f.Description)
+	if err != nil {
+		return err
+	}
+
+	id := bagIDRegexp.FindString(out)
+	if !strings.EqualFold(id, f.BagID) {
+		return fmt.Errorf("storage-daemon created bag %s from fixture, expected %s, output: %s", id, f.BagID, out)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// Server - exposes storage metrics in prometheus text format on /metrics
+type Server struct {
+	store  *db.Storage
+	server *storage.Server
+
+	// pieces - results of piece transfers counted by Middleware, [op][failed]
+	pieces [2][2]uint64
+}
+
+func NewServer(store *db.Storage, server *storage.Server) *Server {
+	return &Server{
+		store:  store,
+		server: server,
+	}
+}
+
+// Middleware - counts piece transfers, should be added to connector to have piece metrics
+func (s *Server) Middleware() storage.Middleware {
+	return func(next storage.PieceHandler) storage.PieceHandler {
+		return func(ctx context.Context, req *storage.PieceRequest) (*storage.Piece, error) {
+			p, err := next(ctx, req)
+			failed := 0
+			if err != nil {
+				failed = 1
+			}
+			atomic.AddUint64(&s.pieces[req.Op][failed], 1)
+			return p, err
+		}
+	}
+}
+
+func (s *Server) Start(addr string) error {
+	m := http.NewServeMux()
+	m.HandleFunc("/metrics", s.handleMetrics)
+	return http.ListenAndServe(addr, m)
+}
+
+type sample struct {
+	labels string
+	value  uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+func (f *family) add(value uint64, labels ...string) {
+	var lb bytes.Buffer
+	for i := 0; i+1 < len(labels); i += 2 {
+		if lb.Len() > 0 {
+			lb.WriteByte(',')
+		}
+		fmt.Fprintf(&lb, "%s=%q", labels[i], labels[i+1])
+	}
+	f.samples = append(f.samples, sample{labels: lb.String(), value: value})
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var (
+		bagDownloaded = &family{name: "tonstorage_bag_downloaded_bytes_total", help: "Bytes downloaded for bag since start", typ: "counter"}
+		bagUploaded   = &family{name: "tonstorage_bag_uploaded_bytes_total", help: "Bytes uploaded for bag since start", typ: "counter"}
+		bagPieces     = &family{name: "tonstorage_bag_pieces", help: "Number of pieces in bag", typ: "gauge"}
+		bagHave       = &family{name: "tonstorage_bag_pieces_stored", help: "Number of pieces of bag which are stored", typ: "gauge"}
+		bagPeers      = &family{name: "tonstorage_bag_peers", help: "Number of connected peers of bag", typ: "gauge"}
+		bagActive     = &family{name: "tonstorage_bag_active", help: "Is bag active, 1 or 0", typ: "gauge"}
+		bagSeeding    = &family{name: "tonstorage_bag_seeding", help: "Is bag seeding, 1 or 0", typ: "gauge"}
+		bagDownSpeed  = &family{name: "tonstorage_bag_download_speed_bytes", help: "Current download speed of bag in bytes per second", typ: "gauge"}
+		bagUpSpeed    = &family{name: "tonstorage_bag_upload_speed_bytes", help: "Current upload speed of bag in bytes per second", typ: "gauge"}
+		transferred   = &family{name: "tonstorage_transferred_bytes_total", help: "Bytes transferred by node during its lifetime", typ: "counter"}
+		dhtQueries    = &family{name: "tonstorage_dht_queries_total", help: "DHT queries made since start", typ: "counter"}
+		dhtFailed     = &family{name: "tonstorage_dht_queries_failed_total", help: "Failed DHT queries since start", typ: "counter"}
+		dbSize        = &family{name: "tonstorage_leveldb_size_bytes", help: "Approximate size of db records on disk", typ: "gauge"}
+		pieces        = &family{name: "tonstorage_pieces_total", help: "Piece transfers with peers since start", typ: "counter"}
+		piecesFailed  = &family{name: "tonstorage_pieces_failed_total", help: "Failed or denied piece transfers with peers since start", typ: "counter"}
+	)
+
+	for _, t := range s.store.GetAll() {
+		id := hex.EncodeToString(t.BagID)
+
+		down, up := t.GetSessionTransferred()
+		bagDownloaded.add(down, "bag_id", id)
+		bagUploaded.add(up, "bag_id", id)
+
+		if t.Info != nil {
+			var have uint64
+			for _, b := range t.PiecesMask() {
+				have += uint64(bits.OnesCount8(b))
+			}
+			bagPieces.add(uint64(t.PiecesNum()), "bag_id", id)
+			bagHave.add(have, "bag_id", id)
+		}
+
+		var peers, dSpeed, uSpeed uint64
+		for _, p := range t.GetPeers() {
+			peers++
+			dSpeed += p.GetDownloadSpeed()
+			uSpeed += p.GetUploadSpeed()
+		}
+		bagPeers.add(peers, "bag_id", id)
+		bagDownSpeed.add(dSpeed, "bag_id", id)
+		bagUpSpeed.add(uSpeed, "bag_id", id)
+
+		active, seeding := t.IsActive()
+		bagActive.add(boolValue(active), "bag_id", id)
+		bagSeeding.add(boolValue(seeding), "bag_id", id)
+	}
+
+	_, lifetime := s.store.GetTransferStats()
+	transferred.add(lifetime.Downloaded, "direction", "download")
+	transferred.add(lifetime.Uploaded, "direction", "upload")
+
+	if s.server != nil {
+		stats := s.server.GetDHTStats()
+		for _, q := range sortedKeys(stats) {
+			dhtQueries.add(stats[q].Total, "query", q)
+			dhtFailed.add(stats[q].Failed, "query", q)
+		}
+	}
+
+	sizes, err := s.store.GetDBSizes()
+	if err == nil {
+		for _, kind := range sortedKeys(sizes) {
+			dbSize.add(uint64(sizes[kind]), "kind", kind)
+		}
+	}
+
+	for _, op := range []storage.PieceOp{storage.PieceFetch, storage.PieceServe} {
+		pieces.add(atomic.LoadUint64(&s.pieces[op][0])+atomic.LoadUint64(&s.pieces[op][1]), "op", op.String())
+		piecesFailed.add(atomic.LoadUint64(&s.pieces[op][1]), "op", op.String())
+	}
+
+	var buf bytes.Buffer
+	for _, f := range []*family{bagDownloaded, bagUploaded, bagPieces, bagHave, bagPeers, bagActive, bagSeeding,
+		bagDownSpeed, bagUpSpeed, transferred, dhtQueries, dhtFailed, dbSize, pieces, piecesFailed} {
+		if len(f.samples) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, smp := range f.samples {
+			fmt.Fprintf(&buf, "%s{%s} %d\n", f.name, smp.labels, smp.value)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+func boolValue(v bool) uint64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,422 @@
+//go:build linux
+
+package mount
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// FUSE kernel protocol, only requests which are needed for read-only filesystem are implemented
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opOpen        = 14
+	opRead        = 15
+	opStatfs      = 17
+	opRelease     = 18
+	opGetxattr    = 22
+	opListxattr   = 23
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opAccess      = 34
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+
+	protoMajor = 7
+	protoMinor = 28
+
+	initAsyncRead = 1 << 0
+	openKeepCache = 1 << 1
+
+	inHeaderSize  = 40
+	outHeaderSize = 16
+	attrSize      = 88
+	maxWrite      = 128 << 10
+	blockSize     = 4096
+
+	mountOptions = "ro,nosuid,nodev,default_permissions,fsname=tonstorage,subtype=tonstorage"
+)
+
+type platform struct {
+	fd   int
+	root bool
+}
+
+// Mount - mounts files of bag to the empty directory, bag header should be already downloaded
+func Mount(t *storage.Torrent, point string) (*FS, error) {
+	m, err := newFS(t, point)
+	if err != nil {
+		return nil, err
+	}
+
+	m.root = unix.Geteuid() == 0
+	if m.root {
+		m.fd, err = mountDirect(point)
+	} else {
+		m.fd, err = mountFusermount(point)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	go m.serve()
+	return m, nil
+}
+
+// Unmount - unmounts filesystem, reads which are waiting for pieces are interrupted
+func (m *FS) Unmount() error {
+	m.cancel()
+
+	var err error
+	if m.root {
+		err = unix.Unmount(m.point, unix.MNT_DETACH)
+	} else {
+		var out []byte
+		if out, err = exec.Command(fusermountPath(), "-u", "-z", m.point).CombinedOutput(); err != nil {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unmount: %w", err)
+	}
+	<-m.done
+	return nil
+}
+
+func mountDirect(point string) (int, error) {
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open /dev/fuse: %w", err)
+	}
+
+	data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions", fd, os.Getuid(), os.Getgid())
+	if err = unix.Mount("tonstorage", point, "fuse.tonstorage", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, data); err != nil {
+		_ = unix.Close(fd)
+		return -1, fmt.Errorf("failed to mount: %w", err)
+	}
+	return fd, nil
+}
+
+// mountFusermount - mounts using setuid fusermount helper, it passes opened /dev/fuse back over unix socket
+func mountFusermount(point string) (int, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to create socket pair: %w", err)
+	}
+	defer unix.Close(fds[0])
+
+	remote := os.NewFile(uintptr(fds[1]), "fusermount")
+	defer remote.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(fusermountPath(), "-o", mountOptions, "--", point)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return -1, fmt.Errorf("fusermount failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	buf := make([]byte, 4)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(fds[0], buf, oob, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to receive fuse fd: %w", err)
+	}
+
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) == 0 {
+		return -1, fmt.Errorf("failed to parse fuse fd message: %v", err)
+	}
+	rights, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(rights) == 0 {
+		return -1, fmt.Errorf("failed to parse fuse fd: %v", err)
+	}
+	return rights[0], nil
+}
+
+func fusermountPath() string {
+	for _, name := range []string{"fusermount3", "fusermount"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return "fusermount"
+}
+
+func (m *FS) serve() {
+	defer close(m.done)
+	defer unix.Close(m.fd)
+	defer m.cancel()
+
+	buf := make([]byte, maxWrite+blockSize)
+	for {
+		n, err := unix.Read(m.fd, buf)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) || errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.ENOENT) {
+				// interrupted or request was aborted by kernel
+				continue
+			}
+			if !errors.Is(err, unix.ENODEV) {
+				storage.Log.Error("fuse read failed", compMount, storage.ErrAttr(err))
+			}
+			return
+		}
+		if n < inHeaderSize {
+			continue
+		}
+
+		req := append([]byte{}, buf[:n]...)
+		if binary.LittleEndian.Uint32(req[4:]) == opDestroy {
+			m.reply(req, 0, nil)
+			return
+		}
+
+		// reads can wait for pieces for a long time, so requests are processed in parallel
+		go m.handle(req)
+	}
+}
+
+func (m *FS) handle(req []byte) {
+	opcode := binary.LittleEndian.Uint32(req[4:])
+	nodeId := binary.LittleEndian.Uint64(req[16:])
+	body := req[inHeaderSize:]
+
+	switch opcode {
+	case opForget, opBatchForget, opInterrupt:
+		// no reply expected, nodes are static
+		return
+	case opInit:
+		m.handleInit(req, body)
+		return
+	}
+
+	n := m.getNode(nodeId)
+	if n == nil {
+		m.reply(req, unix.ENOENT, nil)
+		return
+	}
+
+	switch opcode {
+	case opLookup:
+		name := string(bytes.TrimRight(body, "\x00"))
+		child := m.lookup(n, name)
+		if !n.dir || child == nil {
+			m.reply(req, unix.ENOENT, nil)
+			return
+		}
+		m.reply(req, 0, m.entryOut(child))
+	case opGetattr:
+		out := make([]byte, 16+attrSize)
+		binary.LittleEndian.PutUint64(out, 60)
+		m.putAttr(out[16:], n)
+		m.reply(req, 0, out)
+	case opOpen:
+		if n.dir {
+			m.reply(req, unix.EISDIR, nil)
+			return
+		}
+		if len(body) < 4 || binary.LittleEndian.Uint32(body)&unix.O_ACCMODE != unix.O_RDONLY {
+			m.reply(req, unix.EROFS, nil)
+			return
+		}
+
+		fh, err := m.open(n)
+		if err != nil {
+			storage.Log.Warn("failed to open file", compMount, "file", n.path, storage.ErrAttr(err))
+			m.reply(req, unix.EIO, nil)
+			return
+		}
+
+		out := make([]byte, 16)
+		binary.LittleEndian.PutUint64(out, fh)
+		binary.LittleEndian.PutUint32(out[8:], openKeepCache)
+		m.reply(req, 0, out)
+	case opRead:
+		if len(body) < 24 {
+			m.reply(req, unix.EINVAL, nil)
+			return
+		}
+		fh := binary.LittleEndian.Uint64(body)
+		offset := binary.LittleEndian.Uint64(body[8:])
+		size := binary.LittleEndian.Uint32(body[16:])
+
+		data, err := m.read(fh, int64(offset), int(size))
+		if err != nil {
+			if m.ctx.Err() != nil {
+				m.reply(req, unix.EINTR, nil)
+				return
+			}
+			storage.Log.Warn("failed to read file", compMount, "file", n.path, storage.ErrAttr(err))
+			m.reply(req, unix.EIO, nil)
+			return
+		}
+		m.reply(req, 0, data)
+	case opRelease:
+		if len(body) >= 8 {
+			m.release(binary.LittleEndian.Uint64(body))
+		}
+		m.reply(req, 0, nil)
+	case opOpendir:
+		if !n.dir {
+			m.reply(req, unix.ENOTDIR, nil)
+			return
+		}
+		m.reply(req, 0, make([]byte, 16))
+	case opReaddir:
+		if len(body) < 24 {
+			m.reply(req, unix.EINVAL, nil)
+			return
+		}
+		m.reply(req, 0, m.readDir(n, binary.LittleEndian.Uint64(body[8:]), binary.LittleEndian.Uint32(body[16:])))
+	case opReleasedir, opFlush:
+		m.reply(req, 0, nil)
+	case opStatfs:
+		out := make([]byte, 80)
+		blocks := (m.totalSize() + blockSize - 1) / blockSize
+		binary.LittleEndian.PutUint64(out, blocks)
+		binary.LittleEndian.PutUint64(out[24:], uint64(len(m.nodes)))
+		binary.LittleEndian.PutUint32(out[40:], blockSize)
+		binary.LittleEndian.PutUint32(out[44:], 255)
+		binary.LittleEndian.PutUint32(out[48:], blockSize)
+		m.reply(req, 0, out)
+	case opAccess:
+		if len(body) >= 4 && binary.LittleEndian.Uint32(body)&unix.W_OK != 0 {
+			m.reply(req, unix.EROFS, nil)
+			return
+		}
+		m.reply(req, 0, nil)
+	case opGetxattr, opListxattr:
+		m.reply(req, unix.ENOSYS, nil)
+	default:
+		m.reply(req, unix.ENOSYS, nil)
+	}
+}
+
+func (m *FS) handleInit(req, body []byte) {
+	if len(body) < 16 {
+		m.reply(req, unix.EINVAL, nil)
+		return
+	}
+	major := binary.LittleEndian.Uint32(body)
+	minor := binary.LittleEndian.Uint32(body[4:])
+	readahead := binary.LittleEndian.Uint32(body[8:])
+	flags := binary.LittleEndian.Uint32(body[12:])
+
+	if major < protoMajor {
+		m.reply(req, unix.EPROTO, nil)
+		return
+	}
+
+	out := make([]byte, 64)
+	binary.LittleEndian.PutUint32(out, protoMajor)
+	binary.LittleEndian.PutUint32(out[4:], protoMinor)
+	if major > protoMajor {
+		// kernel will send init again with our major version
+		m.reply(req, 0, out[:8])
+		return
+	}
+	binary.LittleEndian.PutUint32(out[8:], readahead)
+	binary.LittleEndian.PutUint32(out[12:], flags&initAsyncRead)
+	binary.LittleEndian.PutUint16(out[16:], 16)
+	binary.LittleEndian.PutUint16(out[18:], 12)
+	binary.LittleEndian.PutUint32(out[20:], maxWrite)
+	binary.LittleEndian.PutUint32(out[24:], 1)
+
+	if minor < 23 {
+		// older kernels are expecting shorter struct
+		out = out[:24]
+	}
+	m.reply(req, 0, out)
+}
+
+func (m *FS) entryOut(n *node) []byte {
+	out := make([]byte, 40+attrSize)
+	binary.LittleEndian.PutUint64(out, n.id)
+	binary.LittleEndian.PutUint64(out[16:], 60)
+	binary.LittleEndian.PutUint64(out[24:], 60)
+	m.putAttr(out[40:], n)
+	return out
+}
+
+func (m *FS) putAttr(b []byte, n *node) {
+	mode, nlink := uint32(syscall.S_IFREG|0444), uint32(1)
+	if n.dir {
+		mode, nlink = syscall.S_IFDIR|0555, 2
+	}
+	ts := uint64(m.t.CreatedAt.Unix())
+
+	binary.LittleEndian.PutUint64(b, n.id)
+	binary.LittleEndian.PutUint64(b[8:], n.size)
+	binary.LittleEndian.PutUint64(b[16:], (n.size+511)/512)
+	binary.LittleEndian.PutUint64(b[24:], ts)
+	binary.LittleEndian.PutUint64(b[32:], ts)
+	binary.LittleEndian.PutUint64(b[40:], ts)
+	binary.LittleEndian.PutUint32(b[60:], mode)
+	binary.LittleEndian.PutUint32(b[64:], nlink)
+	binary.LittleEndian.PutUint32(b[68:], uint32(os.Getuid()))
+	binary.LittleEndian.PutUint32(b[72:], uint32(os.Getgid()))
+	binary.LittleEndian.PutUint32(b[80:], blockSize)
+}
+
+// readDir - serializes entries starting from offset, offset of entry is index of the next one
+func (m *FS) readDir(n *node, offset uint64, size uint32) []byte {
+	type entry struct {
+		id   uint64
+		name string
+		dir  bool
+	}
+	entries := []entry{{n.id, ".", true}, {n.parent, "..", true}}
+	for _, c := range n.children {
+		entries = append(entries, entry{c.id, c.name, c.dir})
+	}
+
+	var out []byte
+	for i := offset; i < uint64(len(entries)); i++ {
+		e := entries[i]
+		recLen := (24 + len(e.name) + 7) &^ 7
+		if len(out)+recLen > int(size) {
+			break
+		}
+
+		typ := uint32(syscall.DT_REG)
+		if e.dir {
+			typ = syscall.DT_DIR
+		}
+
+		rec := make([]byte, recLen)
+		binary.LittleEndian.PutUint64(rec, e.id)
+		binary.LittleEndian.PutUint64(rec[8:], i+1)
+		binary.LittleEndian.PutUint32(rec[16:], uint32(len(e.name)))
+		binary.LittleEndian.PutUint32(rec[20:], typ)
+		copy(rec[24:], e.name)
+		out = append(out, rec...)
+	}
+	return out
+}
+
+func (m *FS) reply(req []byte, errno syscall.Errno, data []byte) {
+	out := make([]byte, outHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(out, uint32(len(out)))
+	binary.LittleEndian.PutUint32(out[4:], uint32(-int32(errno)))
+	copy(out[8:16], req[8:16])
+	copy(out[outHeaderSize:], data)
+
+	if _, err := unix.Write(m.fd, out); err != nil && !errors.Is(err, unix.ENOENT) {
+		storage.Log.Error("fuse write failed", compMount, storage.ErrAttr(err))
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package mount
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+type platform struct{}
+
+// Mount - mounting is supported only on linux, with FUSE
+func Mount(t *storage.Torrent, point string) (*FS, error) {
+	return nil, fmt.Errorf("mount is supported only on linux")
+}
+
+func (m *FS) Unmount() error {
+	return fmt.Errorf("mount is supported only on linux")
+}
@@ -0,0 +1,242 @@
+package mount
+
+import (
+	"context"
+	"fmt"
+	"github.com/xssnick/tonutils-storage/storage"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const rootID = 1
+
+var compMount = storage.Component("mount")
+
+// FS - files of bag exposed as read-only filesystem. Files are downloaded lazily:
+// file is selected for download when it is opened first time, and reads are waiting only for pieces they need.
+type FS struct {
+	t     *storage.Torrent
+	point string
+
+	nodes []*node
+
+	handles  map[uint64]*handle
+	lastFH   uint64
+	handleMx sync.Mutex
+	activeMx sync.Mutex
+
+	ctx    context.Context
+	cancel func()
+	done   chan struct{}
+
+	platform
+}
+
+type node struct {
+	id       uint64
+	parent   uint64
+	name     string
+	path     string
+	dir      bool
+	size     uint64
+	children []*node
+}
+
+type handle struct {
+	reader *storage.FileReader
+	mx     sync.Mutex
+}
+
+func newFS(t *storage.Torrent, point string) (*FS, error) {
+	if t.Info == nil || t.Header == nil {
+		return nil, fmt.Errorf("bag header is not downloaded yet")
+	}
+
+	files, err := t.ListFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	m := &FS{
+		t:       t,
+		point:   point,
+		handles: map[uint64]*handle{},
+		done:    make(chan struct{}),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+
+	root := &node{id: rootID, parent: rootID, dir: true}
+	m.nodes = []*node{root}
+	dirs := map[string]*node{"": root}
+
+	var dirOf func(path string) *node
+	dirOf = func(path string) *node {
+		if d := dirs[path]; d != nil {
+			return d
+		}
+
+		parent, name := "", path
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			parent, name = path[:i], path[i+1:]
+		}
+
+		d := m.addNode(dirOf(parent), &node{name: name, path: path, dir: true})
+		dirs[path] = d
+		return d
+	}
+
+	for _, name := range files {
+		info, err := t.GetFileOffsets(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file %s: %w", name, err)
+		}
+
+		parent, base := "", name
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			parent, base = name[:i], name[i+1:]
+		}
+		if base == "" {
+			continue
+		}
+		m.addNode(dirOf(parent), &node{name: base, path: name, size: info.Size})
+	}
+
+	for _, n := range m.nodes {
+		sort.Slice(n.children, func(i, j int) bool {
+			return n.children[i].name < n.children[j].name
+		})
+	}
+	return m, nil
+}
+
+func (m *FS) addNode(parent, n *node) *node {
+	n.id = uint64(len(m.nodes) + 1)
+	n.parent = parent.id
+	m.nodes = append(m.nodes, n)
+	parent.children = append(parent.children, n)
+	return n
+}
+
+func (m *FS) getNode(id uint64) *node {
+	if id < rootID || id > uint64(len(m.nodes)) {
+		return nil
+	}
+	return m.nodes[id-1]
+}
+
+func (m *FS) lookup(parent *node, name string) *node {
+	i := sort.Search(len(parent.children), func(i int) bool {
+		return parent.children[i].name >= name
+	})
+	if i < len(parent.children) && parent.children[i].name == name {
+		return parent.children[i]
+	}
+	return nil
+}
+
+// open - selects file for download, when it is not yet, and returns handle to read it
+func (m *FS) open(n *node) (uint64, error) {
+	if err := m.activateFile(n.path); err != nil {
+		return 0, err
+	}
+
+	r, err := m.t.OpenFile(m.ctx, n.path)
+	if err != nil {
+		return 0, err
+	}
+
+	m.handleMx.Lock()
+	defer m.handleMx.Unlock()
+
+	m.lastFH++
+	m.handles[m.lastFH] = &handle{reader: r}
+	return m.lastFH, nil
+}
+
+func (m *FS) release(fh uint64) {
+	m.handleMx.Lock()
+	defer m.handleMx.Unlock()
+
+	delete(m.handles, fh)
+}
+
+// read - reads file part, blocks till pieces which are containing it are downloaded
+func (m *FS) read(fh uint64, offset int64, size int) ([]byte, error) {
+	m.handleMx.Lock()
+	h := m.handles[fh]
+	m.handleMx.Unlock()
+
+	if h == nil {
+		return nil, fmt.Errorf("file is not opened")
+	}
+
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	if offset >= h.reader.Size() {
+		return nil, nil
+	}
+	if left := h.reader.Size() - offset; int64(size) > left {
+		size = int(left)
+	}
+
+	if _, err := h.reader.Seek(offset, 0); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	for read := 0; read < size; {
+		n, err := h.reader.Read(buf[read:])
+		if err != nil {
+			return nil, err
+		}
+		read += n
+	}
+	return buf, nil
+}
+
+func (m *FS) activateFile(name string) error {
+	if m.t.IsDownloadAll() {
+		return nil
+	}
+
+	info, err := m.t.GetFileOffsets(name)
+	if err != nil {
+		return err
+	}
+
+	m.activeMx.Lock()
+	defer m.activeMx.Unlock()
+
+	active := m.t.GetActiveFilesIDs()
+	for _, id := range active {
+		if id == info.Index {
+			return nil
+		}
+	}
+
+	storage.Log.Info("downloading opened file", compMount, storage.BagAttr(m.t.BagID), "file", name)
+	if err = m.t.SetActiveFilesIDs(append(append([]uint32{}, active...), info.Index)); err != nil {
+		return fmt.Errorf("failed to select file for download: %w", err)
+	}
+	return nil
+}
+
+func (m *FS) totalSize() uint64 {
+	var sz uint64
+	for _, n := range m.nodes {
+		sz += n.size
+	}
+	return sz
+}
+
+// Point - directory where bag is mounted
+func (m *FS) Point() string {
+	return m.point
+}
+
+// Done - closed when filesystem is unmounted, by Unmount or externally
+func (m *FS) Done() <-chan struct{} {
+	return m.done
+}
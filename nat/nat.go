@@ -0,0 +1,124 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// MappingLifetime - lease time requested from router, mapping is renewed before it ends
+var MappingLifetime = 1 * time.Hour
+
+const description = "tonutils-storage"
+
+var ErrNoGateway = errors.New("no gateway with port mapping support found")
+
+type mapper interface {
+	name() string
+	externalIP(ctx context.Context) (net.IP, error)
+	addMapping(ctx context.Context, port int, lifetime time.Duration) error
+	deleteMapping(ctx context.Context, port int) error
+}
+
+// Mapping - UDP port forwarded on router, it is renewed in background till Close
+type Mapping struct {
+	ExternalIP net.IP
+	Port       int
+	Method     string
+
+	m      mapper
+	stop   func()
+	closed sync.Once
+	wg     sync.WaitGroup
+}
+
+// MapPort - discovers router using UPnP IGD and NAT-PMP, forwards the same external UDP port to us
+// and returns our external ip. Only mappings with equal external and internal port are accepted,
+// because ADNL address list contains listen port.
+func MapPort(ctx context.Context, port int) (*Mapping, error) {
+	var errs []error
+	for _, discover := range []func(ctx context.Context) (mapper, error){discoverUPnP, discoverPMP} {
+		m, err := discover(ctx)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err = m.addMapping(ctx, port, MappingLifetime); err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to map port: %w", m.name(), err))
+			continue
+		}
+
+		ip, err := m.externalIP(ctx)
+		if err == nil && (ip.IsUnspecified() || ip.IsPrivate() || ip.IsLoopback()) {
+			// router is behind another nat, so mapping is useless
+			err = fmt.Errorf("external ip %s is not public", ip.String())
+		}
+		if err != nil {
+			_ = m.deleteMapping(ctx, port)
+			errs = append(errs, fmt.Errorf("%s: failed to get external ip: %w", m.name(), err))
+			continue
+		}
+
+		mp := &Mapping{
+			ExternalIP: ip,
+			Port:       port,
+			Method:     m.name(),
+			m:          m,
+		}
+
+		var renewCtx context.Context
+		renewCtx, mp.stop = context.WithCancel(context.Background())
+		mp.wg.Add(1)
+		go mp.renew(renewCtx)
+
+		return mp, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, ErrNoGateway
+	}
+	return nil, fmt.Errorf("%w: %v", ErrNoGateway, errs)
+}
+
+func (mp *Mapping) renew(ctx context.Context) {
+	defer mp.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(MappingLifetime / 2):
+		}
+
+		rCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_ = mp.m.addMapping(rCtx, mp.Port, MappingLifetime)
+		cancel()
+	}
+}
+
+// Close - stops renewal and removes mapping from router
+func (mp *Mapping) Close() {
+	mp.closed.Do(func() {
+		mp.stop()
+		mp.wg.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = mp.m.deleteMapping(ctx, mp.Port)
+	})
+}
+
+// localIP - returns ip of interface which is used for internet traffic, no packets are sent
+func localIP() (net.IP, error) {
+	conn, err := net.Dial("udp4", "8.8.8.8:53")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,146 @@
+package nat
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const pmpPort = 5351
+
+type pmpMapper struct {
+	gateway net.IP
+}
+
+func discoverPMP(ctx context.Context) (mapper, error) {
+	gw, err := defaultGateway()
+	if err != nil {
+		return nil, fmt.Errorf("nat-pmp: failed to find gateway: %w", err)
+	}
+
+	m := &pmpMapper{gateway: gw}
+	if _, err = m.externalIP(ctx); err != nil {
+		return nil, fmt.Errorf("nat-pmp: gateway %s is not responding: %w", gw.String(), err)
+	}
+	return m, nil
+}
+
+func (p *pmpMapper) name() string {
+	return "NAT-PMP"
+}
+
+func (p *pmpMapper) externalIP(ctx context.Context) (net.IP, error) {
+	resp, err := p.request(ctx, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+func (p *pmpMapper) addMapping(ctx context.Context, port int, lifetime time.Duration) error {
+	resp, err := p.request(ctx, pmpMappingRequest(port, lifetime), 16)
+	if err != nil {
+		return err
+	}
+
+	if ext := int(binary.BigEndian.Uint16(resp[10:])); ext != port {
+		_, _ = p.request(ctx, pmpMappingRequest(port, 0), 16)
+		return fmt.Errorf("gateway mapped different external port %d", ext)
+	}
+	return nil
+}
+
+func (p *pmpMapper) deleteMapping(ctx context.Context, port int) error {
+	_, err := p.request(ctx, pmpMappingRequest(port, 0), 16)
+	return err
+}
+
+func pmpMappingRequest(port int, lifetime time.Duration) []byte {
+	req := make([]byte, 12)
+	req[1] = 1 // map udp
+	binary.BigEndian.PutUint16(req[4:], uint16(port))
+	binary.BigEndian.PutUint16(req[6:], uint16(port))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	return req
+}
+
+// request - sends request to gateway with retries as described in RFC 6886
+func (p *pmpMapper) request(ctx context.Context, req []byte, respLen int) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: p.gateway, Port: pmpPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for {
+		if _, err = conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+			deadline = dl
+		}
+		_ = conn.SetReadDeadline(deadline)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && timeout < 4*time.Second {
+				timeout *= 2
+				continue
+			}
+			return nil, err
+		}
+
+		if n < respLen || buf[0] != 0 || buf[1] != req[1]|0x80 {
+			return nil, fmt.Errorf("invalid response")
+		}
+		if code := binary.BigEndian.Uint16(buf[2:]); code != 0 {
+			return nil, fmt.Errorf("gateway returned error code %d", code)
+		}
+		return buf[:n], nil
+	}
+}
+
+// defaultGateway - reads default route on linux, on other systems assumes that gateway is .1 in our subnet
+func defaultGateway() (net.IP, error) {
+	if f, err := os.Open("/proc/net/route"); err == nil {
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+
+			b, err := hex.DecodeString(fields[2])
+			if err != nil || len(b) != 4 {
+				continue
+			}
+			// stored in host byte order, which is little endian on supported platforms
+			return net.IPv4(b[3], b[2], b[1], b[0]), nil
+		}
+	}
+
+	ip, err := localIP()
+	if err != nil {
+		return nil, err
+	}
+	ip = ip.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("no ipv4 address")
+	}
+	return net.IPv4(ip[0], ip[1], ip[2], 1), nil
+}
@@ -0,0 +1,233 @@
+package nat
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var upnpServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+type upnpMapper struct {
+	controlURL string
+	service    string
+	localIP    net.IP
+	client     *http.Client
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func discoverUPnP(ctx context.Context) (mapper, error) {
+	local, err := localIP()
+	if err != nil {
+		return nil, fmt.Errorf("upnp: failed to get local ip: %w", err)
+	}
+
+	locations, err := ssdpSearch(ctx, local)
+	if err != nil {
+		return nil, fmt.Errorf("upnp: discovery failed: %w", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, loc := range locations {
+		m, err := upnpFromLocation(ctx, client, loc)
+		if err != nil {
+			continue
+		}
+		m.localIP = local
+		return m, nil
+	}
+	return nil, fmt.Errorf("upnp: no internet gateway device found")
+}
+
+// ssdpSearch - multicasts M-SEARCH and collects device description locations until ctx or timeout
+func ssdpSearch(ctx context.Context, local net.IP) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: local})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err = conn.WriteToUDP([]byte(req), &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	_ = conn.SetReadDeadline(deadline)
+
+	var locations []string
+	seen := map[string]bool{}
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(line[:i], "location") {
+				loc := strings.TrimSpace(line[i+1:])
+				if !seen[loc] {
+					seen[loc] = true
+					locations = append(locations, loc)
+				}
+			}
+		}
+	}
+
+	if len(locations) == 0 {
+		return nil, fmt.Errorf("no responses")
+	}
+	return locations, nil
+}
+
+func upnpFromLocation(ctx context.Context, client *http.Client, location string) (*upnpMapper, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&root); err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if b, err := url.Parse(root.URLBase); err == nil {
+			base = b
+		}
+	}
+
+	for _, svc := range upnpServices {
+		if ctrl := findControlURL(&root.Device, svc); ctrl != "" {
+			u, err := base.Parse(ctrl)
+			if err != nil {
+				return nil, err
+			}
+			return &upnpMapper{controlURL: u.String(), service: svc, client: client}, nil
+		}
+	}
+	return nil, fmt.Errorf("no wan connection service")
+}
+
+func findControlURL(d *upnpDevice, service string) string {
+	for _, s := range d.Services {
+		if s.ServiceType == service {
+			return s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		if u := findControlURL(&d.Devices[i], service); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+func (u *upnpMapper) name() string {
+	return "UPnP"
+}
+
+func (u *upnpMapper) externalIP(ctx context.Context) (net.IP, error) {
+	var res struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := u.soap(ctx, "GetExternalIPAddress", "", &res); err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(res.IP))
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external ip %q", res.IP)
+	}
+	return ip, nil
+}
+
+func (u *upnpMapper) addMapping(ctx context.Context, port int, lifetime time.Duration) error {
+	p := strconv.Itoa(port)
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + p + "</NewExternalPort>" +
+		"<NewProtocol>UDP</NewProtocol>" +
+		"<NewInternalPort>" + p + "</NewInternalPort>" +
+		"<NewInternalClient>" + u.localIP.String() + "</NewInternalClient>" +
+		"<NewEnabled>1</NewEnabled>" +
+		"<NewPortMappingDescription>" + description + "</NewPortMappingDescription>" +
+		"<NewLeaseDuration>" + strconv.Itoa(int(lifetime/time.Second)) + "</NewLeaseDuration>"
+	return u.soap(ctx, "AddPortMapping", args, nil)
+}
+
+func (u *upnpMapper) deleteMapping(ctx context.Context, port int) error {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(port) + "</NewExternalPort>" +
+		"<NewProtocol>UDP</NewProtocol>"
+	return u.soap(ctx, "DeletePortMapping", args, nil)
+}
+
+func (u *upnpMapper) soap(ctx context.Context, action, args string, result any) error {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + u.service + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.controlURL, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.service+"#"+action+`"`)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed with status %d", action, resp.StatusCode)
+	}
+
+	if result != nil {
+		if err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result); err != nil {
+			return fmt.Errorf("failed to parse %s response: %w", action, err)
+		}
+	}
+	return nil
+}
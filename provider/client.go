@@ -0,0 +1,434 @@
+package provider
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/ton/wallet"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OfferFee - added to price of storage when contract is deployed, to pay deploy and messages fees
+var OfferFee = tlb.MustFromTON("0.1")
+
+const clientContractKeyPrefix = "storage_contract:"
+
+// Offer - conditions of provider for storing the bag, calculated from parameters of provider contract
+type Offer struct {
+	Provider     *address.Address
+	BagID        []byte
+	FileSize     uint64
+	RatePerMBDay tlb.Coins
+	// MaxSpan - max time between proofs, provider is paid only for proven periods
+	MaxSpan uint32
+	Days    uint32
+	// Amount - sent to deploy storage contract, price of storage for days and OfferFee
+	Amount tlb.Coins
+}
+
+// Client - rents storage for bags from storage providers: deploys storage contracts
+// by offers to provider contracts, paid from wallet, and monitors that providers are submitting proofs
+type Client struct {
+	api    *ton.APIClient
+	db     db.KV
+	wallet *wallet.Wallet
+
+	contracts map[string]*clientContract
+
+	closeCtx context.Context
+	close    func()
+	wg       sync.WaitGroup
+	mx       sync.RWMutex
+}
+
+type clientContract struct {
+	BagID     []byte
+	Provider  string
+	CreatedAt time.Time
+	// Address - storage contract, empty till it is found in transactions of provider contract
+	Address string
+	Closed  bool
+
+	data       *ContractData
+	lateLogged time.Time
+}
+
+// StorageContractInfo - state of storage contract deployed by client
+type StorageContractInfo struct {
+	BagID     []byte
+	Provider  string
+	Address   string
+	CreatedAt time.Time
+	Status    string
+	Balance   tlb.Coins
+	LastProof time.Time
+	// ProofLate - provider has not submitted proof during max span
+	ProofLate bool
+}
+
+// NewClient - loads deployed contracts from db, storage is paid from w
+func NewClient(api *ton.APIClient, kv db.KV, w *wallet.Wallet) (*Client, error) {
+	c := &Client{
+		api:       api,
+		db:        kv,
+		wallet:    w,
+		contracts: map[string]*clientContract{},
+	}
+	c.closeCtx, c.close = context.WithCancel(context.Background())
+
+	var err error
+	if iterErr := kv.Iterate([]byte(clientContractKeyPrefix), func(key, value []byte) bool {
+		var sc clientContract
+		if err = json.Unmarshal(value, &sc); err != nil {
+			err = fmt.Errorf("failed to load storage contract %s: %w", string(key), err)
+			return false
+		}
+		c.contracts[sc.key()] = &sc
+		return true
+	}); iterErr != nil {
+		return nil, fmt.Errorf("failed to load storage contracts: %w", iterErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) Start() {
+	c.wg.Add(1)
+	go c.worker()
+}
+
+func (c *Client) Stop() {
+	c.close()
+	c.wg.Wait()
+}
+
+// WalletAddress - address of wallet which pays for storage
+func (c *Client) WalletAddress() *address.Address {
+	return c.wallet.Address()
+}
+
+// GetOffer - reads current rate and proof period of provider and calculates price of storing bag for days
+func (c *Client) GetOffer(ctx context.Context, t *storage.Torrent, providerAddr *address.Address, days uint32) (*Offer, error) {
+	if t.Info == nil {
+		return nil, fmt.Errorf("bag info is not loaded")
+	}
+	if days == 0 {
+		return nil, fmt.Errorf("days should be positive")
+	}
+
+	params, err := GetProviderParams(ctx, c.api, providerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if !params.AcceptNewContracts {
+		return nil, fmt.Errorf("provider is not accepting new contracts")
+	}
+	if t.Info.FileSize < params.MinFileSize || t.Info.FileSize > params.MaxFileSize {
+		return nil, fmt.Errorf("bag size %d is out of provider limits [%d, %d]", t.Info.FileSize, params.MinFileSize, params.MaxFileSize)
+	}
+
+	// rate is per megabyte per day, rounded up
+	price := new(big.Int).Mul(params.RatePerMBDay.NanoTON(), new(big.Int).SetUint64(t.Info.FileSize))
+	price.Mul(price, big.NewInt(int64(days)))
+	price.Add(price, big.NewInt(1<<20-1))
+	price.Rsh(price, 20)
+	price.Add(price, OfferFee.NanoTON())
+
+	return &Offer{
+		Provider:     providerAddr,
+		BagID:        t.BagID,
+		FileSize:     t.Info.FileSize,
+		RatePerMBDay: params.RatePerMBDay,
+		MaxSpan:      params.MaxSpan,
+		Days:         days,
+		Amount:       tlb.FromNanoTON(price),
+	}, nil
+}
+
+// Store - deploys storage contract of bag by offer, bag should be fully downloaded to calculate its merkle hash.
+// Waits till message is processed by wallet, contract address is found later, when provider contract deploys it.
+func (c *Client) Store(ctx context.Context, t *storage.Torrent, offer *Offer) error {
+	if !t.Stats().Completed || !t.IsDownloadAll() {
+		return fmt.Errorf("bag should be fully downloaded")
+	}
+
+	infoCell, err := tlb.ToCell(t.Info)
+	if err != nil {
+		return fmt.Errorf("failed to serialize bag info: %w", err)
+	}
+	if string(infoCell.Hash()) != string(t.BagID) {
+		return fmt.Errorf("bag info is not matching bag id")
+	}
+
+	tree, err := BuildMicrochunkTree(t.Info.FileSize, bagReader(t))
+	if err != nil {
+		return fmt.Errorf("failed to build merkle tree of bag: %w", err)
+	}
+
+	msg := internalMessage(offer.Provider, offer.Amount, OfferMessage(infoCell, tree.RootHash(), offer.RatePerMBDay, offer.MaxSpan))
+	if err = c.wallet.Send(ctx, &wallet.Message{Mode: 1, InternalMessage: msg}, true); err != nil {
+		return fmt.Errorf("failed to send offer: %w", err)
+	}
+
+	sc := &clientContract{
+		BagID:     t.BagID,
+		Provider:  offer.Provider.String(),
+		CreatedAt: time.Now(),
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.contracts[sc.key()] = sc
+	return c.saveContract(sc)
+}
+
+// Contracts - storage contracts deployed by us, sorted by creation time
+func (c *Client) Contracts() []StorageContractInfo {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	list := make([]*clientContract, 0, len(c.contracts))
+	for _, sc := range c.contracts {
+		list = append(list, sc)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	res := make([]StorageContractInfo, 0, len(list))
+	for _, sc := range list {
+		info := StorageContractInfo{
+			BagID:     sc.BagID,
+			Provider:  sc.Provider,
+			Address:   sc.Address,
+			CreatedAt: sc.CreatedAt,
+			Status:    "deploying",
+		}
+		switch {
+		case sc.Closed:
+			info.Status = "closed"
+		case sc.data != nil && !sc.data.Active:
+			info.Status = "waiting for provider"
+		case sc.data != nil:
+			info.Status = "active"
+			info.LastProof = sc.data.LastProofTime
+			info.ProofLate = sc.data.isProofLate()
+			if info.ProofLate {
+				info.Status = "proof is late"
+			}
+		}
+		if sc.data != nil {
+			info.Balance = sc.data.Balance
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+func (c *Client) worker() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.closeCtx.Done():
+			return
+		case <-time.After(CheckInterval):
+		}
+
+		c.mx.RLock()
+		list := make([]*clientContract, 0, len(c.contracts))
+		for _, sc := range c.contracts {
+			if !sc.Closed {
+				list = append(list, sc)
+			}
+		}
+		c.mx.RUnlock()
+
+		for _, sc := range list {
+			ctx, cancel := context.WithTimeout(c.closeCtx, CheckInterval)
+			if err := c.checkContract(ctx, sc); err != nil {
+				storage.Log.Warn("failed to check storage contract", compContract, storage.BagAttr(sc.BagID), storage.ErrAttr(err))
+			}
+			cancel()
+		}
+	}
+}
+
+func (c *Client) checkContract(ctx context.Context, sc *clientContract) error {
+	if sc.Address == "" {
+		addr, err := c.findContract(ctx, sc)
+		if err != nil {
+			return err
+		}
+		if addr == nil {
+			if time.Since(sc.CreatedAt) > DeployTimeout {
+				storage.Log.Warn("storage contract was not deployed by provider", compContract, storage.BagAttr(sc.BagID), "provider", sc.Provider)
+				return c.setClosed(sc)
+			}
+			return nil
+		}
+
+		c.mx.Lock()
+		sc.Address = addr.String()
+		err = c.saveContract(sc)
+		c.mx.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	addr, err := address.ParseAddr(sc.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	block, err := c.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block: %w", err)
+	}
+	acc, err := c.api.GetAccount(ctx, block, addr)
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+	if !acc.IsActive {
+		if sc.data != nil || time.Since(sc.CreatedAt) > DeployTimeout {
+			storage.Log.Info("storage contract is closed", compContract, storage.BagAttr(sc.BagID), "contract", sc.Address)
+			return c.setClosed(sc)
+		}
+		return nil
+	}
+
+	data, err := GetContractData(ctx, c.api, addr)
+	if err != nil {
+		return err
+	}
+
+	c.mx.Lock()
+	sc.data = data
+	logLate := data.isProofLate() && time.Since(sc.lateLogged) > time.Duration(data.MaxSpan)*time.Second
+	if logLate {
+		sc.lateLogged = time.Now()
+	}
+	c.mx.Unlock()
+
+	if logLate {
+		storage.Log.Warn("provider has not sent proof in time", compContract, storage.BagAttr(sc.BagID),
+			"provider", sc.Provider, "last_proof", data.LastProofTime)
+	}
+	return nil
+}
+
+// findContract - looks for storage contract deployed by provider contract in response to our offer
+func (c *Client) findContract(ctx context.Context, sc *clientContract) (*address.Address, error) {
+	providerAddr, err := address.ParseAddr(sc.Provider)
+	if err != nil {
+		return nil, fmt.Errorf("invalid provider address: %w", err)
+	}
+
+	block, err := c.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block: %w", err)
+	}
+	acc, err := c.api.GetAccount(ctx, block, providerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get provider account: %w", err)
+	}
+	if !acc.IsActive {
+		return nil, fmt.Errorf("provider contract is not active")
+	}
+
+	lt, hash := acc.LastTxLT, acc.LastTxHash
+	for lt > 0 {
+		txs, err := c.api.ListTransactions(ctx, providerAddr, 16, lt, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list transactions: %w", err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+
+		oldest := txs[0]
+		for _, tx := range txs {
+			if tx.LT < oldest.LT {
+				oldest = tx
+			}
+			if addr := c.deployedBy(tx, sc.BagID); addr != nil {
+				return addr, nil
+			}
+		}
+
+		if int64(oldest.Now) < sc.CreatedAt.Add(-5*time.Minute).Unix() {
+			// older than our offer
+			break
+		}
+		lt, hash = oldest.PrevTxLT, oldest.PrevTxHash
+	}
+	return nil, nil
+}
+
+// deployedBy - returns address of storage contract if transaction is processing of our offer for bag
+func (c *Client) deployedBy(tx *tlb.Transaction, bagId []byte) *address.Address {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal || tx.IO.Out == nil {
+		return nil
+	}
+
+	in := tx.IO.In.AsInternal()
+	if in.Body == nil || in.SrcAddr.String() != c.wallet.Address().String() {
+		return nil
+	}
+
+	op, err := in.Body.BeginParse().LoadUInt(32)
+	if err != nil || op != OpOfferStorageContract {
+		return nil
+	}
+	// torrent info is the only ref of offer, its hash is bag id
+	info, err := in.Body.PeekRef(0)
+	if err != nil || string(info.Hash()) != string(bagId) {
+		return nil
+	}
+
+	out, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		return nil
+	}
+	for _, m := range out {
+		if m.MsgType == tlb.MsgTypeInternal && m.AsInternal().StateInit != nil {
+			return m.AsInternal().DstAddr
+		}
+	}
+	return nil
+}
+
+func (c *Client) setClosed(sc *clientContract) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	sc.Closed = true
+	return c.saveContract(sc)
+}
+
+func (c *Client) saveContract(sc *clientContract) error {
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	return c.db.Put([]byte(clientContractKeyPrefix+sc.key()), data)
+}
+
+func (sc *clientContract) key() string {
+	return hex.EncodeToString(sc.BagID) + ":" + sc.Provider
+}
+
+func (d *ContractData) isProofLate() bool {
+	return d.Active && time.Since(d.LastProofTime) > time.Duration(d.MaxSpan)*time.Second
+}
@@ -0,0 +1,259 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// Op codes of storage provider and storage contracts (ton/storage/storage-daemon/smartcont)
+const (
+	OpOfferStorageContract  = 0x107c49ef
+	OpCloseContract         = 0x79f937ea
+	OpAcceptStorageContract = 0x7a361688
+	OpWithdraw              = 0x46ed2e94
+	OpProofStorage          = 0x419d5d4d
+)
+
+// ProviderParams - parameters of storage provider contract
+type ProviderParams struct {
+	AcceptNewContracts bool
+	RatePerMBDay       tlb.Coins
+	MaxSpan            uint32
+	MinFileSize        uint64
+	MaxFileSize        uint64
+}
+
+// ContractData - state of storage contract of a bag
+type ContractData struct {
+	Active        bool
+	Balance       tlb.Coins
+	Provider      *address.Address
+	MerkleHash    []byte
+	FileSize      uint64
+	NextProof     uint64
+	RatePerMBDay  tlb.Coins
+	MaxSpan       uint32
+	LastProofTime time.Time
+	Client        *address.Address
+	BagID         []byte
+}
+
+// GetProviderParams - reads storage parameters of provider contract
+func GetProviderParams(ctx context.Context, api *ton.APIClient, addr *address.Address) (*ProviderParams, error) {
+	block, err := api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block: %w", err)
+	}
+
+	res, err := api.RunGetMethod(ctx, block, addr, "get_storage_params")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run get_storage_params: %w", err)
+	}
+
+	var ints [5]*big.Int
+	for i := range ints {
+		if ints[i], err = res.Int(uint(i)); err != nil {
+			return nil, fmt.Errorf("failed to parse storage params: %w", err)
+		}
+	}
+
+	return &ProviderParams{
+		AcceptNewContracts: ints[0].Sign() != 0,
+		RatePerMBDay:       tlb.FromNanoTON(ints[1]),
+		MaxSpan:            uint32(ints[2].Uint64()),
+		MinFileSize:        ints[3].Uint64(),
+		MaxFileSize:        ints[4].Uint64(),
+	}, nil
+}
+
+// GetContractData - reads state of storage contract
+func GetContractData(ctx context.Context, api *ton.APIClient, addr *address.Address) (*ContractData, error) {
+	block, err := api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get block: %w", err)
+	}
+
+	res, err := api.RunGetMethod(ctx, block, addr, "get_storage_contract_data")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run get_storage_contract_data: %w", err)
+	}
+
+	var d ContractData
+	if err = func() error {
+		active, err := res.Int(0)
+		if err != nil {
+			return err
+		}
+		balance, err := res.Int(1)
+		if err != nil {
+			return err
+		}
+		provider, err := res.Slice(2)
+		if err != nil {
+			return err
+		}
+		merkle, err := res.Int(3)
+		if err != nil {
+			return err
+		}
+		fileSize, err := res.Int(4)
+		if err != nil {
+			return err
+		}
+		nextProof, err := res.Int(5)
+		if err != nil {
+			return err
+		}
+		rate, err := res.Int(6)
+		if err != nil {
+			return err
+		}
+		maxSpan, err := res.Int(7)
+		if err != nil {
+			return err
+		}
+		lastProof, err := res.Int(8)
+		if err != nil {
+			return err
+		}
+		client, err := res.Slice(9)
+		if err != nil {
+			return err
+		}
+		bagId, err := res.Int(10)
+		if err != nil {
+			return err
+		}
+
+		if d.Provider, err = provider.LoadAddr(); err != nil {
+			return err
+		}
+		if d.Client, err = client.LoadAddr(); err != nil {
+			return err
+		}
+		d.Active = active.Sign() != 0
+		d.Balance = tlb.FromNanoTON(balance)
+		d.MerkleHash = uint256(merkle)
+		d.FileSize = fileSize.Uint64()
+		d.NextProof = nextProof.Uint64()
+		d.RatePerMBDay = tlb.FromNanoTON(rate)
+		d.MaxSpan = uint32(maxSpan.Uint64())
+		d.LastProofTime = time.Unix(lastProof.Int64(), 0)
+		d.BagID = uint256(bagId)
+		return nil
+	}(); err != nil {
+		return nil, fmt.Errorf("failed to parse storage contract data: %w", err)
+	}
+	return &d, nil
+}
+
+// OfferMessage - body of message from client to provider contract, which deploys storage contract of bag,
+// expected rate and max span should be equal to current parameters of provider
+func OfferMessage(torrentInfo *cell.Cell, merkleHash []byte, rate tlb.Coins, maxSpan uint32) *cell.Cell {
+	return cell.BeginCell().MustStoreUInt(OpOfferStorageContract, 32).MustStoreUInt(rand.Uint64(), 64).
+		MustStoreRef(torrentInfo).MustStoreSlice(merkleHash, 256).
+		MustStoreBigCoins(rate.NanoTON()).MustStoreUInt(uint64(maxSpan), 32).EndCell()
+}
+
+// AcceptMessage - body of message from provider contract which activates storage contract
+func AcceptMessage() *cell.Cell {
+	return cell.BeginCell().MustStoreUInt(OpAcceptStorageContract, 32).MustStoreUInt(rand.Uint64(), 64).EndCell()
+}
+
+// ProofMessage - body of message with proof of data which storage contract is asking
+func ProofMessage(proof *cell.Cell) *cell.Cell {
+	return cell.BeginCell().MustStoreUInt(OpProofStorage, 32).MustStoreUInt(rand.Uint64(), 64).
+		MustStoreRef(proof).EndCell()
+}
+
+// WithdrawMessage - body of message which asks storage contract to send earned reward to provider
+func WithdrawMessage() *cell.Cell {
+	return cell.BeginCell().MustStoreUInt(OpWithdraw, 32).MustStoreUInt(rand.Uint64(), 64).EndCell()
+}
+
+// CloseMessage - body of message which closes storage contract, remaining balance is returned to client
+func CloseMessage() *cell.Cell {
+	return cell.BeginCell().MustStoreUInt(OpCloseContract, 32).MustStoreUInt(rand.Uint64(), 64).EndCell()
+}
+
+// sendFromProvider - sends internal messages from provider contract, it accepts wallet v3 like external messages,
+// signed by provider key: subwallet_id, valid_until, seqno and up to 4 (mode, message) pairs
+func sendFromProvider(ctx context.Context, api *ton.APIClient, addr *address.Address, key ed25519.PrivateKey, messages []*tlb.InternalMessage) error {
+	if len(messages) > 4 {
+		return fmt.Errorf("max 4 messages can be sent at once")
+	}
+
+	block, err := api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block: %w", err)
+	}
+
+	res, err := api.RunGetMethod(ctx, block, addr, "get_wallet_params")
+	if err != nil {
+		return fmt.Errorf("failed to run get_wallet_params: %w", err)
+	}
+	seqno, err := res.Int(0)
+	if err != nil {
+		return fmt.Errorf("failed to parse seqno: %w", err)
+	}
+	subwallet, err := res.Int(1)
+	if err != nil {
+		return fmt.Errorf("failed to parse subwallet: %w", err)
+	}
+	pub, err := res.Int(2)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if string(uint256(pub)) != string(key.Public().(ed25519.PublicKey)) {
+		return fmt.Errorf("provider key is not matching public key of contract")
+	}
+
+	payload := cell.BeginCell().MustStoreUInt(subwallet.Uint64(), 32).
+		MustStoreUInt(uint64(time.Now().Add(3*time.Minute).Unix()), 32).
+		MustStoreUInt(seqno.Uint64(), 32)
+	for i, m := range messages {
+		c, err := tlb.ToCell(m)
+		if err != nil {
+			return fmt.Errorf("failed to serialize message %d: %w", i, err)
+		}
+		// pay fees separately, ignore errors
+		payload.MustStoreUInt(1+2, 8).MustStoreRef(c)
+	}
+
+	sign := payload.EndCell().Sign(key)
+	body := cell.BeginCell().MustStoreSlice(sign, 512).MustStoreBuilder(payload).EndCell()
+
+	if err = api.SendExternalMessage(ctx, &tlb.ExternalMessage{
+		DstAddr: addr,
+		Body:    body,
+	}); err != nil {
+		return fmt.Errorf("failed to send external message: %w", err)
+	}
+	return nil
+}
+
+func internalMessage(to *address.Address, amount tlb.Coins, body *cell.Cell) *tlb.InternalMessage {
+	return &tlb.InternalMessage{
+		IHRDisabled: true,
+		Bounce:      true,
+		DstAddr:     to,
+		Amount:      amount,
+		Body:        body,
+	}
+}
+
+func uint256(v *big.Int) []byte {
+	b := v.Bytes()
+	if len(b) >= 32 {
+		return b[len(b)-32:]
+	}
+	return append(make([]byte, 32-len(b)), b...)
+}
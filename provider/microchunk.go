@@ -0,0 +1,246 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// MicrochunkSize - size of leaf of merkle tree which storage contract is checking proofs against
+const MicrochunkSize = 64
+
+// cachedHeight - hashes of subtrees of this height (1 MB of data) are kept in memory,
+// so for proof we need to read only one such subtree from disk
+const cachedHeight = 14
+
+// DataReader - reads bag data (header and files, as it is split to pieces) in range [from, to)
+type DataReader func(from, to uint64) ([]byte, error)
+
+// MicrochunkTree - merkle tree of bag data, which root is stored in storage contract.
+// Leaves are 64 bytes chunks of data, tree is completed with zero chunks to power of 2 leaves.
+// Cells of tree are hashmap with key of tree height bits, so proof is checked by contract with dictionary lookup.
+// Hashes of cells are calculated directly, to not keep whole tree in memory.
+type MicrochunkTree struct {
+	fileSize uint64
+	height   int
+	cacheH   int
+	// subtrees - hashes of subtrees of cacheH height, which are not fully out of data
+	subtrees [][]byte
+	root     []byte
+}
+
+// zeroHashes - hashes of subtrees of zero chunks, by height
+var zeroHashes [][]byte
+
+func init() {
+	zeroHashes = append(zeroHashes, leafHash(make([]byte, MicrochunkSize)))
+	for h := 1; h <= 64; h++ {
+		zeroHashes = append(zeroHashes, nodeHash(zeroHashes[h-1], zeroHashes[h-1], uint16(h-1)))
+	}
+}
+
+// TreeHeight - height of microchunk tree for data of this size, it is also a key length of proof dictionary
+func TreeHeight(fileSize uint64) int {
+	h := 0
+	for (uint64(MicrochunkSize) << h) < fileSize {
+		h++
+	}
+	return h
+}
+
+// BuildMicrochunkTree - calculates tree of data of fileSize, it is read sequentially by 1 MB
+func BuildMicrochunkTree(fileSize uint64, read DataReader) (*MicrochunkTree, error) {
+	t := &MicrochunkTree{
+		fileSize: fileSize,
+		height:   TreeHeight(fileSize),
+	}
+	t.cacheH = cachedHeight
+	if t.cacheH > t.height {
+		t.cacheH = t.height
+	}
+
+	sz := t.subtreeSize()
+	for from := uint64(0); from < fileSize; from += sz {
+		levels, err := t.readSubtree(from, read)
+		if err != nil {
+			return nil, err
+		}
+		t.subtrees = append(t.subtrees, levels[t.cacheH][0])
+	}
+
+	levels := t.upperLevels()
+	t.root = levels[len(levels)-1][0]
+	return t, nil
+}
+
+// RootHash - hash of tree root cell, should be equal to merkle hash in storage contract
+func (t *MicrochunkTree) RootHash() []byte {
+	return t.root
+}
+
+// Proof - builds merkle proof of chunk with data at offset, which contract is asking (next_proof),
+// branches which are not on the path to chunk are pruned.
+func (t *MicrochunkTree) Proof(offset uint64, read DataReader) (*cell.Cell, error) {
+	if offset >= t.fileSize {
+		return nil, fmt.Errorf("offset %d is out of data of size %d", offset, t.fileSize)
+	}
+	chunk := offset / MicrochunkSize
+	sub := chunk >> t.cacheH
+
+	levels, err := t.readSubtree(sub*t.subtreeSize(), read)
+	if err != nil {
+		return nil, err
+	}
+	if string(levels[t.cacheH][0]) != string(t.subtrees[sub]) {
+		return nil, fmt.Errorf("data was changed after tree is built")
+	}
+
+	upper := t.upperLevels()
+	local := chunk & (1<<t.cacheH - 1)
+	siblings := make([][]byte, 0, t.height)
+	for h := 0; h < t.cacheH; h++ {
+		siblings = append(siblings, levels[h][(local>>h)^1])
+	}
+	for h := t.cacheH; h < t.height; h++ {
+		siblings = append(siblings, upper[h-t.cacheH][(chunk>>h)^1])
+	}
+
+	leafData := make([]byte, MicrochunkSize)
+	if from := chunk * MicrochunkSize; from < t.fileSize {
+		to := from + MicrochunkSize
+		if to > t.fileSize {
+			to = t.fileSize
+		}
+		data, err := read(from, to)
+		if err != nil {
+			return nil, err
+		}
+		copy(leafData, data)
+	}
+
+	root := cell.BeginCell().MustStoreUInt(0, 2).MustStoreSlice(leafData, MicrochunkSize*8).EndCell()
+	for h := 0; h < t.height; h++ {
+		pruned := prunedCell(siblings[h], uint16(h))
+
+		node := cell.BeginCell().MustStoreUInt(0, 2)
+		if (chunk>>h)&1 == 0 {
+			node.MustStoreRef(root).MustStoreRef(pruned)
+		} else {
+			node.MustStoreRef(pruned).MustStoreRef(root)
+		}
+		root = node.EndCell()
+		root.UnsafeModify(cell.LevelMask{Mask: 1}, false)
+	}
+
+	data := make([]byte, 1+32+2)
+	data[0] = 0x03 // merkle proof
+	copy(data[1:], t.root)
+	binary.BigEndian.PutUint16(data[1+32:], uint16(t.height))
+
+	proof := cell.BeginCell().MustStoreSlice(data, uint(len(data)*8)).MustStoreRef(root).EndCell()
+	proof.UnsafeModify(cell.LevelMask{Mask: 0}, true)
+	return proof, nil
+}
+
+func (t *MicrochunkTree) subtreeSize() uint64 {
+	return uint64(MicrochunkSize) << t.cacheH
+}
+
+// readSubtree - reads data of cached subtree and returns hashes of all its levels, from leaves to its root
+func (t *MicrochunkTree) readSubtree(from uint64, read DataReader) ([][][]byte, error) {
+	to := from + t.subtreeSize()
+	if to > t.fileSize {
+		to = t.fileSize
+	}
+
+	data, err := read(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data at %d: %w", from, err)
+	}
+	if uint64(len(data)) != to-from {
+		return nil, fmt.Errorf("data at %d is incomplete", from)
+	}
+
+	leaves := make([][]byte, 1<<t.cacheH)
+	chunk := make([]byte, MicrochunkSize)
+	for i := range leaves {
+		off := i * MicrochunkSize
+		if off >= len(data) {
+			leaves[i] = zeroHashes[0]
+			continue
+		}
+		for j := range chunk {
+			chunk[j] = 0
+		}
+		copy(chunk, data[off:])
+		leaves[i] = leafHash(chunk)
+	}
+	return buildLevels(leaves, 0, t.cacheH), nil
+}
+
+// upperLevels - hashes of tree levels above cached subtrees, from cached level to root
+func (t *MicrochunkTree) upperLevels() [][][]byte {
+	nodes := make([][]byte, 1<<(t.height-t.cacheH))
+	for i := range nodes {
+		if i < len(t.subtrees) {
+			nodes[i] = t.subtrees[i]
+		} else {
+			nodes[i] = zeroHashes[t.cacheH]
+		}
+	}
+	return buildLevels(nodes, t.cacheH, t.height)
+}
+
+func buildLevels(nodes [][]byte, fromHeight, toHeight int) [][][]byte {
+	levels := [][][]byte{nodes}
+	for h := fromHeight; h < toHeight; h++ {
+		next := make([][]byte, len(nodes)/2)
+		for i := range next {
+			next[i] = nodeHash(nodes[2*i], nodes[2*i+1], uint16(h))
+		}
+		levels = append(levels, next)
+		nodes = next
+	}
+	return levels
+}
+
+// leafHash - hash of cell with empty hashmap label (2 zero bits) and 64 bytes of chunk
+func leafHash(chunk []byte) []byte {
+	// 514 bits of data, not full byte is completed with 1 bit
+	data := make([]byte, 2+MicrochunkSize+1)
+	data[0], data[1] = 0, MicrochunkSize*2+1
+	for i := 0; i < MicrochunkSize; i++ {
+		data[2+i] |= chunk[i] >> 2
+		data[2+i+1] = chunk[i] << 6
+	}
+	data[len(data)-1] |= 0x20
+
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// nodeHash - hash of fork cell with empty label and 2 refs to subtrees of the same depth
+func nodeHash(left, right []byte, childDepth uint16) []byte {
+	data := make([]byte, 3+2+2+32+32)
+	data[0], data[1], data[2] = 2, 1, 0x20
+	binary.BigEndian.PutUint16(data[3:], childDepth)
+	binary.BigEndian.PutUint16(data[5:], childDepth)
+	copy(data[7:], left)
+	copy(data[39:], right)
+
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+func prunedCell(hash []byte, depth uint16) *cell.Cell {
+	data := make([]byte, 2+32+2)
+	data[0] = 0x01 // pruned type
+	data[1] = 1    // level
+	copy(data[2:], hash)
+	binary.BigEndian.PutUint16(data[2+32:], depth)
+
+	c := cell.BeginCell().MustStoreSlice(data, uint(len(data)*8)).EndCell()
+	c.UnsafeModify(cell.LevelMask{Mask: 1}, true)
+	return c
+}
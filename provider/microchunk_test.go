@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+// referenceTree - microchunk tree built of real cells, the same way as storage-daemon does it:
+// leaf is 2 zero bits of empty label and 64 bytes of chunk, fork is 2 zero bits and refs to both subtrees
+func referenceTree(data []byte) (root *cell.Cell, leaves []*cell.Cell) {
+	height := TreeHeight(uint64(len(data)))
+
+	nodes := make([]*cell.Cell, 1<<height)
+	for i := range nodes {
+		chunk := make([]byte, MicrochunkSize)
+		if off := i * MicrochunkSize; off < len(data) {
+			copy(chunk, data[off:])
+		}
+		nodes[i] = cell.BeginCell().MustStoreUInt(0, 2).MustStoreSlice(chunk, MicrochunkSize*8).EndCell()
+	}
+	leaves = nodes
+
+	for len(nodes) > 1 {
+		next := make([]*cell.Cell, len(nodes)/2)
+		for i := range next {
+			next[i] = cell.BeginCell().MustStoreUInt(0, 2).MustStoreRef(nodes[2*i]).MustStoreRef(nodes[2*i+1]).EndCell()
+		}
+		nodes = next
+	}
+	return nodes[0], leaves
+}
+
+func TestMicrochunkTree_MatchesCells(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, tt := range []struct {
+		name string
+		size int
+	}{
+		{"one byte", 1},
+		{"one chunk", MicrochunkSize},
+		{"partial chunk", 1000},
+		{"power of 2 chunks", 16 * MicrochunkSize},
+		// more than cached subtree, so upper levels and zero subtrees are used
+		{"few subtrees", MicrochunkSize<<cachedHeight + 1000},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			rnd.Read(data)
+			read := func(from, to uint64) ([]byte, error) {
+				return data[from:to], nil
+			}
+
+			tree, err := BuildMicrochunkTree(uint64(len(data)), read)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root, leaves := referenceTree(data)
+			if !bytes.Equal(tree.RootHash(), root.Hash()) {
+				t.Fatalf("root hash %x is not matching cells %x", tree.RootHash(), root.Hash())
+			}
+
+			for _, offset := range []uint64{0, uint64(len(data)) / 2, uint64(len(data)) - 1} {
+				proof, err := tree.Proof(offset, read)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// proof must survive serialization, as it is sent to contract in boc
+				proof, err = cell.FromBOC(proof.ToBOC())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = cell.CheckProof(proof, tree.RootHash()); err != nil {
+					t.Fatalf("proof of %d is not valid: %v", offset, err)
+				}
+
+				// path to chunk must be kept in proof, with data of chunk in leaf
+				chunk := offset / MicrochunkSize
+				c := proof.MustPeekRef(0)
+				for h := TreeHeight(uint64(len(data))) - 1; h >= 0; h-- {
+					c = c.MustPeekRef(int(chunk>>h) & 1)
+				}
+				if !bytes.Equal(c.Hash(), leaves[chunk].Hash()) {
+					t.Fatalf("leaf of %d is not matching chunk", offset)
+				}
+			}
+
+			if _, err = tree.Proof(uint64(len(data)), read); err == nil {
+				t.Fatal("proof out of data should fail")
+			}
+		})
+	}
+}
+
+func TestMicrochunkTree_ChangedData(t *testing.T) {
+	data := make([]byte, 1000)
+	read := func(from, to uint64) ([]byte, error) {
+		return data[from:to], nil
+	}
+
+	tree, err := BuildMicrochunkTree(uint64(len(data)), read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[10] = 1
+	if _, err = tree.Proof(0, read); err == nil {
+		t.Fatal("proof of changed data should fail")
+	}
+}
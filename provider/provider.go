@@ -0,0 +1,531 @@
+package provider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/address"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/ton"
+	"github.com/xssnick/tonutils-storage/db"
+	"github.com/xssnick/tonutils-storage/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// CheckInterval - how often new transactions of provider contract and states of storage contracts are checked
+	CheckInterval = 30 * time.Second
+	// WithdrawInterval - how often earned reward is withdrawn from storage contract, with next proof
+	WithdrawInterval = 24 * time.Hour
+	// MessageAmount - value attached to messages sent to storage contracts to pay their fees
+	MessageAmount = tlb.MustFromTON("0.05")
+	// DeployTimeout - discovered contract is forgotten when it was not deployed during this time
+	DeployTimeout = 1 * time.Hour
+)
+
+var (
+	compProvider = storage.Component("provider")
+	compContract = storage.Component("contract")
+)
+
+// resendTimeout - message to contract is not sent again while previous one can still be processed
+const resendTimeout = 2 * time.Minute
+
+// Config - storage provider settings
+type Config struct {
+	// Address - storage provider contract, deployed by owner of Key
+	Address *address.Address
+	// Key - private key which is controlling provider contract, it signs accept, proof and withdraw messages
+	Key ed25519.PrivateKey
+	// MaxBagSize - storage contracts of bigger bags are closed without accepting, 0 = limits of provider contract
+	MaxBagSize uint64
+}
+
+// Bags - storage of bags which are kept for clients
+type Bags interface {
+	GetTorrent(hash []byte) *storage.Torrent
+}
+
+// Provider - serves storage contracts deployed by provider contract: downloads bags of new contracts,
+// accepts contracts when bag data matches merkle hash, submits proofs of storage and withdraws reward
+type Provider struct {
+	api    *ton.APIClient
+	db     db.KV
+	bags   Bags
+	addBag func(bagId []byte) error
+	cfg    Config
+
+	contracts map[string]*contract
+	lastLT    uint64
+
+	closeCtx context.Context
+	close    func()
+	wg       sync.WaitGroup
+	mx       sync.RWMutex
+}
+
+type contract struct {
+	Address      string
+	BagID        []byte
+	DiscoveredAt time.Time
+	Accepted     bool
+	LastWithdraw time.Time
+	// Rejected - reason why contract is not served, empty when it is ok
+	Rejected string
+
+	data   *ContractData
+	tree   *MicrochunkTree
+	sentAt time.Time
+	status string
+}
+
+// ContractInfo - state of storage contract served by provider
+type ContractInfo struct {
+	Address   string
+	BagID     []byte
+	Status    string
+	Client    string
+	FileSize  uint64
+	Balance   tlb.Coins
+	Rate      tlb.Coins
+	LastProof time.Time
+	// ProofDeadline - time after which proof will not be accepted and reward for period is lost, zero when unknown
+	ProofDeadline time.Time
+}
+
+const (
+	lastLTKey         = "provider_last_lt"
+	contractKeyPrefix = "provider_contract:"
+)
+
+// NewProvider - loads served contracts from db, addBag should start download of all files of bag
+func NewProvider(api *ton.APIClient, kv db.KV, bags Bags, addBag func(bagId []byte) error, cfg Config) (*Provider, error) {
+	if cfg.Address == nil || len(cfg.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("provider contract address and key should be set")
+	}
+
+	p := &Provider{
+		api:       api,
+		db:        kv,
+		bags:      bags,
+		addBag:    addBag,
+		cfg:       cfg,
+		contracts: map[string]*contract{},
+	}
+	p.closeCtx, p.close = context.WithCancel(context.Background())
+
+	v, err := kv.Get([]byte(lastLTKey))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return nil, fmt.Errorf("failed to load last lt: %w", err)
+	}
+	if len(v) == 8 {
+		p.lastLT = binary.LittleEndian.Uint64(v)
+	}
+
+	if iterErr := kv.Iterate([]byte(contractKeyPrefix), func(key, value []byte) bool {
+		var c contract
+		if err = json.Unmarshal(value, &c); err != nil {
+			err = fmt.Errorf("failed to load contract %s: %w", string(key), err)
+			return false
+		}
+		p.contracts[c.Address] = &c
+		return true
+	}); iterErr != nil {
+		return nil, fmt.Errorf("failed to load contracts: %w", iterErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Provider) Start() {
+	p.wg.Add(1)
+	go p.worker()
+}
+
+func (p *Provider) Stop() {
+	p.close()
+	p.wg.Wait()
+}
+
+// Contracts - served storage contracts, sorted by discovery time
+func (p *Provider) Contracts() []ContractInfo {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	list := make([]*contract, 0, len(p.contracts))
+	for _, c := range p.contracts {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].DiscoveredAt.Before(list[j].DiscoveredAt)
+	})
+
+	res := make([]ContractInfo, 0, len(list))
+	for _, c := range list {
+		info := ContractInfo{
+			Address: c.Address,
+			BagID:   c.BagID,
+			Status:  c.status,
+		}
+		if c.Rejected != "" {
+			info.Status = "rejected: " + c.Rejected
+		}
+		if c.data != nil {
+			info.Client = c.data.Client.String()
+			info.FileSize = c.data.FileSize
+			info.Balance = c.data.Balance
+			info.Rate = c.data.RatePerMBDay
+			if c.data.Active {
+				info.LastProof = c.data.LastProofTime
+				info.ProofDeadline = c.data.LastProofTime.Add(time.Duration(c.data.MaxSpan) * time.Second)
+			}
+		}
+		res = append(res, info)
+	}
+	return res
+}
+
+func (p *Provider) worker() {
+	defer p.wg.Done()
+
+	wait := 1 * time.Second
+	for {
+		select {
+		case <-p.closeCtx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = CheckInterval
+
+		ctx, cancel := context.WithTimeout(p.closeCtx, CheckInterval)
+		if err := p.scanTransactions(ctx); err != nil {
+			storage.Log.Warn("failed to check provider contract transactions", compProvider, storage.ErrAttr(err))
+		}
+		cancel()
+
+		p.mx.RLock()
+		list := make([]*contract, 0, len(p.contracts))
+		for _, c := range p.contracts {
+			list = append(list, c)
+		}
+		p.mx.RUnlock()
+
+		for _, c := range list {
+			ctx, cancel = context.WithTimeout(p.closeCtx, CheckInterval)
+			if err := p.serveContract(ctx, c); err != nil {
+				storage.Log.Warn("failed to serve storage contract", compProvider, "contract", c.Address, storage.ErrAttr(err))
+			}
+			cancel()
+		}
+	}
+}
+
+// scanTransactions - finds storage contracts deployed by provider contract since last check,
+// they are deployed when clients are sending offers to provider contract
+func (p *Provider) scanTransactions(ctx context.Context) error {
+	block, err := p.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block: %w", err)
+	}
+
+	acc, err := p.api.GetAccount(ctx, block, p.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("failed to get provider account: %w", err)
+	}
+	if !acc.IsActive {
+		return fmt.Errorf("provider contract is not deployed")
+	}
+
+	lt, hash := acc.LastTxLT, acc.LastTxHash
+	for lt > p.lastLT {
+		txs, err := p.api.ListTransactions(ctx, p.cfg.Address, 16, lt, hash)
+		if err != nil {
+			return fmt.Errorf("failed to list transactions: %w", err)
+		}
+		if len(txs) == 0 {
+			break
+		}
+
+		oldest := txs[0]
+		for _, tx := range txs {
+			if tx.LT < oldest.LT {
+				oldest = tx
+			}
+			if tx.LT > p.lastLT {
+				p.processTransaction(tx)
+			}
+		}
+		lt, hash = oldest.PrevTxLT, oldest.PrevTxHash
+	}
+
+	if acc.LastTxLT > p.lastLT {
+		v := make([]byte, 8)
+		binary.LittleEndian.PutUint64(v, acc.LastTxLT)
+		if err = p.db.Put([]byte(lastLTKey), v); err != nil {
+			return fmt.Errorf("failed to save last lt: %w", err)
+		}
+		p.lastLT = acc.LastTxLT
+	}
+	return nil
+}
+
+func (p *Provider) processTransaction(tx *tlb.Transaction) {
+	if tx.IO.In == nil || tx.IO.In.MsgType != tlb.MsgTypeInternal || tx.IO.Out == nil {
+		return
+	}
+
+	body := tx.IO.In.AsInternal().Body
+	if body == nil {
+		return
+	}
+	op, err := body.BeginParse().LoadUInt(32)
+	if err != nil || op != OpOfferStorageContract {
+		return
+	}
+
+	out, err := tx.IO.Out.ToSlice()
+	if err != nil {
+		return
+	}
+	for _, m := range out {
+		if m.MsgType != tlb.MsgTypeInternal || m.AsInternal().StateInit == nil {
+			continue
+		}
+
+		addr := m.AsInternal().DstAddr.String()
+		p.mx.Lock()
+		if p.contracts[addr] == nil {
+			c := &contract{
+				Address:      addr,
+				DiscoveredAt: time.Now(),
+				status:       "deploying",
+			}
+			p.contracts[addr] = c
+			storage.Log.Info("new storage contract", compProvider, "contract", addr)
+			if err = p.saveContract(c); err != nil {
+				storage.Log.Error("failed to save storage contract", compProvider, "contract", addr, storage.ErrAttr(err))
+			}
+		}
+		p.mx.Unlock()
+	}
+}
+
+func (p *Provider) serveContract(ctx context.Context, c *contract) error {
+	addr, err := address.ParseAddr(c.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address: %w", err)
+	}
+
+	block, err := p.api.CurrentMasterchainInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get block: %w", err)
+	}
+	acc, err := p.api.GetAccount(ctx, block, addr)
+	if err != nil {
+		return fmt.Errorf("failed to get account: %w", err)
+	}
+
+	if !acc.IsActive {
+		if c.Accepted || c.Rejected != "" || time.Since(c.DiscoveredAt) > DeployTimeout {
+			// contract is closed and destroyed, bag is kept, it can be removed by user
+			storage.Log.Info("storage contract is closed", compProvider, "contract", c.Address)
+			return p.removeContract(c)
+		}
+		return nil
+	}
+
+	if c.Rejected != "" {
+		// we asked to close it, waiting
+		return nil
+	}
+
+	data, err := GetContractData(ctx, p.api, addr)
+	if err != nil {
+		return err
+	}
+	p.mx.Lock()
+	c.data = data
+	c.BagID = data.BagID
+	p.mx.Unlock()
+
+	if data.Provider.String() != p.cfg.Address.String() {
+		return p.reject(ctx, c, addr, "provider is not matching")
+	}
+	if p.cfg.MaxBagSize > 0 && data.FileSize > p.cfg.MaxBagSize {
+		return p.reject(ctx, c, addr, "bag is too big")
+	}
+
+	tor := p.bags.GetTorrent(data.BagID)
+	if tor == nil {
+		if err = p.addBag(data.BagID); err != nil {
+			return fmt.Errorf("failed to add bag: %w", err)
+		}
+		p.setStatus(c, "downloading")
+		return p.persistContract(c)
+	}
+
+	if tor.Info == nil || !tor.Stats().Completed {
+		p.setStatus(c, "downloading")
+		return nil
+	}
+
+	if c.tree == nil {
+		if tor.Info.FileSize != data.FileSize {
+			return p.reject(ctx, c, addr, "bag size is not matching")
+		}
+
+		p.setStatus(c, "verifying")
+		tree, err := BuildMicrochunkTree(tor.Info.FileSize, bagReader(tor))
+		if err != nil {
+			return fmt.Errorf("failed to build merkle tree of bag: %w", err)
+		}
+		if string(tree.RootHash()) != string(data.MerkleHash) {
+			return p.reject(ctx, c, addr, "merkle hash is not matching bag data")
+		}
+		c.tree = tree
+	}
+
+	if time.Since(c.sentAt) < resendTimeout {
+		return nil
+	}
+
+	if !data.Active {
+		p.setStatus(c, "accepting")
+		if err = p.send(ctx, c, internalMessage(addr, MessageAmount, AcceptMessage())); err != nil {
+			return fmt.Errorf("failed to accept: %w", err)
+		}
+		storage.Log.Info("accepting storage contract", compProvider, storage.BagAttr(data.BagID), "contract", c.Address)
+		return nil
+	}
+
+	if !c.Accepted {
+		p.mx.Lock()
+		c.Accepted = true
+		c.LastWithdraw = time.Now()
+		p.mx.Unlock()
+		if err = p.persistContract(c); err != nil {
+			return err
+		}
+	}
+	p.setStatus(c, "active")
+
+	// proof is sent in the middle of span, so there is time to retry
+	if time.Since(data.LastProofTime) < time.Duration(data.MaxSpan)*time.Second/2 {
+		return nil
+	}
+
+	proof, err := c.tree.Proof(data.NextProof, bagReader(tor))
+	if err != nil {
+		return fmt.Errorf("failed to build proof: %w", err)
+	}
+
+	msgs := []*tlb.InternalMessage{internalMessage(addr, MessageAmount, ProofMessage(proof))}
+	withdraw := time.Since(c.LastWithdraw) > WithdrawInterval
+	if withdraw {
+		msgs = append(msgs, internalMessage(addr, MessageAmount, WithdrawMessage()))
+	}
+
+	if err = p.send(ctx, c, msgs...); err != nil {
+		return fmt.Errorf("failed to send proof: %w", err)
+	}
+	storage.Log.Info("proof is sent", compProvider, "contract", c.Address, "withdraw", withdraw)
+
+	if withdraw {
+		p.mx.Lock()
+		c.LastWithdraw = time.Now()
+		p.mx.Unlock()
+		return p.persistContract(c)
+	}
+	return nil
+}
+
+// reject - closes contract which we cannot serve, so client can get its money back
+func (p *Provider) reject(ctx context.Context, c *contract, addr *address.Address, reason string) error {
+	storage.Log.Warn("rejecting storage contract", compProvider, "contract", c.Address, "reason", reason)
+
+	p.mx.Lock()
+	c.Rejected = reason
+	p.mx.Unlock()
+	if err := p.persistContract(c); err != nil {
+		return err
+	}
+
+	if c.data != nil && c.data.Active {
+		// we cannot close accepted contract without losing reward, client will close it
+		return nil
+	}
+	return p.send(ctx, c, internalMessage(addr, MessageAmount, CloseMessage()))
+}
+
+func (p *Provider) send(ctx context.Context, c *contract, msgs ...*tlb.InternalMessage) error {
+	if err := sendFromProvider(ctx, p.api, p.cfg.Address, p.cfg.Key, msgs); err != nil {
+		return err
+	}
+	p.mx.Lock()
+	c.sentAt = time.Now()
+	p.mx.Unlock()
+	return nil
+}
+
+func (p *Provider) setStatus(c *contract, status string) {
+	p.mx.Lock()
+	c.status = status
+	p.mx.Unlock()
+}
+
+func (p *Provider) persistContract(c *contract) error {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+	return p.saveContract(c)
+}
+
+func (p *Provider) saveContract(c *contract) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return p.db.Put([]byte(contractKeyPrefix+c.Address), data)
+}
+
+func (p *Provider) removeContract(c *contract) error {
+	p.mx.Lock()
+	delete(p.contracts, c.Address)
+	p.mx.Unlock()
+	return p.db.Delete([]byte(contractKeyPrefix + c.Address))
+}
+
+// bagReader - reads bag data from its stored pieces
+func bagReader(t *storage.Torrent) DataReader {
+	return func(from, to uint64) ([]byte, error) {
+		pieceSize := uint64(t.Info.PieceSize)
+
+		res := make([]byte, 0, to-from)
+		for id := from / pieceSize; id*pieceSize < to; id++ {
+			piece, err := t.GetPiece(uint32(id))
+			if err != nil {
+				return nil, err
+			}
+
+			start := id * pieceSize
+			lo, hi := uint64(0), uint64(len(piece.Data))
+			if from > start {
+				lo = from - start
+			}
+			if to-start < hi {
+				hi = to - start
+			}
+			if lo >= hi {
+				return nil, fmt.Errorf("piece %d is too short", id)
+			}
+			res = append(res, piece.Data[lo:hi]...)
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// SetAllowedPeers - restricts bag to nodes with these ADNL ids, queries and piece requests of others are rejected.
+// Empty list removes restriction. Can be combined with swarm secret, then peer should pass both checks.
+func (t *Torrent) SetAllowedPeers(ids [][]byte) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if len(ids) == 0 {
+		t.allowedPeers = nil
+		return
+	}
+
+	t.allowedPeers = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		t.allowedPeers[string(id)] = true
+	}
+}
+
+// GetAllowedPeers - returns ADNL ids of nodes which are allowed to access bag, nil when bag is not restricted
+func (t *Torrent) GetAllowedPeers() [][]byte {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if len(t.allowedPeers) == 0 {
+		return nil
+	}
+
+	list := make([][]byte, 0, len(t.allowedPeers))
+	for id := range t.allowedPeers {
+		list = append(list, []byte(id))
+	}
+	return list
+}
+
+// SetUnlisted - bag is not announced in DHT, so it can be found only by nodes which are connected to us directly,
+// like static peers or peers added manually
+func (t *Torrent) SetUnlisted(unlisted bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.unlisted = unlisted
+}
+
+func (t *Torrent) IsUnlisted() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.unlisted
+}
+
+// IsRestricted - bag is private, unlisted or has allow-list, such bags are never shared with nodes out of its swarm,
+// for example in co-seeder catalogs or through relays, which would announce them in DHT
+func (t *Torrent) IsRestricted() bool {
+	if t.IsPrivate() {
+		return true
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return len(t.allowedPeers) > 0 || t.unlisted
+}
+
+func (t *Torrent) isPeerAllowed(id []byte) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return len(t.allowedPeers) == 0 || t.allowedPeers[string(id)]
+}
+
+// checkAccess - rejects queries of nodes which are not in allow-list of bag
+func (t *Torrent) checkAccess(id []byte) error {
+	if !t.isPeerAllowed(id) {
+		Log.Debug("rejected query of node which is not allowed", compStorage, BagAttr(t.BagID), PeerAttr(id))
+		return fmt.Errorf("not allowed")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"time"
+)
+
+// AddressCacheTTL - how long address of node resolved using DHT is reused before DHT is asked again,
+// it is shorter when DHT record expires earlier. 0 = addresses are not cached
+var AddressCacheTTL = 30 * time.Minute
+
+// AddressCache - persistent cache of resolved addresses of nodes, can be implemented by Storage.
+// Addresses are kept across restarts, so when many bags start at once the same nodes are not resolved again.
+type AddressCache interface {
+	// GetNodeAddress - returns not expired address of node
+	GetNodeAddress(adnlID []byte) (addr string, key ed25519.PublicKey, ok bool)
+	SetNodeAddress(adnlID []byte, addr string, key ed25519.PublicKey, expireAt time.Time) error
+	RemoveNodeAddress(adnlID []byte) error
+}
+
+func (s *Server) addressCache() AddressCache {
+	if AddressCacheTTL <= 0 {
+		return nil
+	}
+	cache, _ := s.store.(AddressCache)
+	return cache
+}
+
+// forgetNodeAddress - removes cached address, when node is not reachable by it
+func (s *Server) forgetNodeAddress(adnlID []byte) {
+	if cache := s.addressCache(); cache != nil {
+		if err := cache.RemoveNodeAddress(adnlID); err != nil {
+			Log.Warn("failed to remove cached address of node", compStorage, PeerAttr(adnlID), ErrAttr(err))
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package storage
+
+import "os"
+
+// allocate - extends file to its final size, blocks are reserved by filesystem only on linux
+func allocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// AnnounceInterval - how often our node is re-announced in DHT records of active bags, when bag has no own interval
+var AnnounceInterval = 3 * time.Minute
+
+// AnnounceStatus - result of the last announce of bag in DHT
+type AnnounceStatus struct {
+	At  time.Time
+	Err error
+}
+
+// SetAnnounceInterval - sets how often bag is re-announced in DHT, 0 = AnnounceInterval
+func (t *Torrent) SetAnnounceInterval(interval time.Duration) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.announceInterval = interval
+}
+
+// GetAnnounceInterval - returns own announce interval of bag, 0 when default is used
+func (t *Torrent) GetAnnounceInterval() time.Duration {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.announceInterval
+}
+
+func (t *Torrent) effectiveAnnounceInterval() time.Duration {
+	if interval := t.GetAnnounceInterval(); interval > 0 {
+		return interval
+	}
+	return AnnounceInterval
+}
+
+// Reannounce - announces our node in DHT record of bag right now, without waiting for its interval
+func (s *Server) Reannounce(ctx context.Context, t *Torrent) error {
+	if t.IsUnlisted() {
+		return fmt.Errorf("bag is unlisted, it is not announced in DHT")
+	}
+	return s.announce(ctx, t)
+}
+
+// GetAnnounceStatus - returns result of the last announce of bag, false when it was not announced since start
+func (s *Server) GetAnnounceStatus(bagId []byte) (AnnounceStatus, bool) {
+	s.announcesMx.Lock()
+	defer s.announcesMx.Unlock()
+
+	st, ok := s.announces[hex.EncodeToString(bagId)]
+	return st, ok
+}
+
+func (s *Server) announce(ctx context.Context, t *Torrent) error {
+	err := s.updateOverlay(ctx, t.OverlayKey(), t.BagID, s.serverMode)
+
+	s.announcesMx.Lock()
+	s.announces[hex.EncodeToString(t.BagID)] = AnnounceStatus{At: time.Now(), Err: err}
+	s.announcesMx.Unlock()
+
+	if err != nil {
+		Log.Warn("failed to announce bag", compDHT, BagAttr(t.BagID), ErrAttr(err))
+	}
+	return err
+}
+
+// announceDue - bag was not announced yet or its interval is passed since the last announce
+func (s *Server) announceDue(t *Torrent) bool {
+	st, ok := s.GetAnnounceStatus(t.BagID)
+	return !ok || time.Since(st.At) >= t.effectiveAnnounceInterval()
+}
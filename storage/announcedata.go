@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// MaxAnnounceDataSize - limit of application-defined data which node reports for the bag
+const MaxAnnounceDataSize = 1024
+
+// SetAnnounceData - sets small application-defined data of the bag, like version hints or pricing,
+// it is reported to peers which are asking for it. Overlay node record in DHT is signed and has fixed format,
+// so data cannot be stored there, peers are requesting it after connection.
+func (t *Torrent) SetAnnounceData(data []byte) error {
+	if len(data) > MaxAnnounceDataSize {
+		return fmt.Errorf("announce data is too big, max size is %d bytes", MaxAnnounceDataSize)
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.announceData = append([]byte{}, data...)
+	return nil
+}
+
+func (t *Torrent) GetAnnounceData() []byte {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.announceData
+}
+
+// requestAnnounceData - asks peer for its application-defined data of the bag,
+// peers of other implementations are not supporting it, and their data stays empty
+func (s *storagePeer) requestAnnounceData() {
+	ctx, cancel := context.WithTimeout(s.globalCtx, 5*time.Second)
+	defer cancel()
+
+	var res AnnounceData
+	err := s.conn.adnl.Query(ctx, overlay.WrapQuery(s.overlay, &GetAnnounceData{}), &res)
+	if err != nil {
+		Log.Debug("peer not reported announce data", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), ErrAttr(err))
+		return
+	}
+
+	if len(res.Data) == 0 || len(res.Data) > MaxAnnounceDataSize {
+		return
+	}
+	s.announceData.Store(res.Data)
+}
+
+func (s *storagePeer) getAnnounceData() []byte {
+	data, _ := s.announceData.Load().([]byte)
+	return data
+}
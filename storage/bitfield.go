@@ -0,0 +1,79 @@
+package storage
+
+// maxHaveUpdateSize - max size of pieces bitfield part or ids list in one update, in bytes
+const maxHaveUpdateSize = 8 << 10
+
+// diffPiecesUpdates - builds updates about pieces which are in mask, but were not sent yet.
+// When many pieces are new, it is cheaper to send changed part of the bitfield (1 bit per piece)
+// than ids list (32 bits per piece), so the smaller encoding is chosen, and big updates are split to chunks.
+func diffPiecesUpdates(mask, sent []byte, state State) []any {
+	first, last, num := -1, -1, 0
+	for i := 0; i < len(mask); i++ {
+		var was byte
+		if i < len(sent) {
+			was = sent[i]
+		}
+
+		diff := mask[i] &^ was
+		if diff == 0 {
+			continue
+		}
+
+		if first < 0 {
+			first = i
+		}
+		last = i
+		for ; diff > 0; diff &= diff - 1 {
+			num++
+		}
+	}
+
+	if num == 0 {
+		return nil
+	}
+
+	var updates []any
+	if last-first+1 < num*4 {
+		for from := first; from <= last; from += maxHaveUpdateSize {
+			to := from + maxHaveUpdateSize
+			if to > last+1 {
+				to = last + 1
+			}
+
+			updates = append(updates, UpdateInit{
+				HavePieces:       append([]byte{}, mask[from:to]...),
+				HavePiecesOffset: int32(from * 8),
+				State:            state,
+			})
+		}
+		return updates
+	}
+
+	ids := make([]int32, 0, num)
+	for i := first; i <= last; i++ {
+		var was byte
+		if i < len(sent) {
+			was = sent[i]
+		}
+
+		diff := mask[i] &^ was
+		for j := 0; j < 8; j++ {
+			if diff&(1<<j) != 0 {
+				ids = append(ids, int32(i*8+j))
+			}
+		}
+	}
+
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxHaveUpdateSize/4 {
+			n = maxHaveUpdateSize / 4
+		}
+
+		updates = append(updates, UpdateHavePieces{
+			PieceIDs: ids[:n],
+		})
+		ids = ids[n:]
+	}
+	return updates
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiffPiecesUpdates(t *testing.T) {
+	state := State{WillUpload: true, WantDownload: true}
+
+	// sparse - one new piece in every 5th byte, ids are cheaper than bitfield
+	sparse := make([]byte, 5*5000)
+	var sparseIds []int32
+	for i := 0; i < 5000; i++ {
+		sparse[i*5] = 1 << 3
+		sparseIds = append(sparseIds, int32(i*5*8+3))
+	}
+
+	full := bytes.Repeat([]byte{0xFF}, maxHaveUpdateSize*2+10)
+
+	for _, tt := range []struct {
+		name     string
+		mask     []byte
+		sent     []byte
+		expected []any
+	}{
+		{"nothing new", []byte{0x0F, 0x01}, []byte{0x0F, 0x01}, nil},
+		{"empty", nil, nil, nil},
+		{"pieces are never unsent", []byte{0x00}, []byte{0xFF}, nil},
+		{
+			"single piece as bitfield byte", []byte{0x00, 0x05}, []byte{0x00, 0x01}, []any{
+				UpdateInit{HavePieces: []byte{0x05}, HavePiecesOffset: 8, State: state},
+			},
+		},
+		{
+			"distant pieces as ids", []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0x80}, nil, []any{
+				UpdateHavePieces{PieceIDs: []int32{0, 79}},
+			},
+		},
+		{
+			"dense range as bitfield", []byte{0x00, 0xFF, 0x0F, 0x01}, []byte{0x00, 0x00, 0x00, 0x01}, []any{
+				UpdateInit{HavePieces: []byte{0xFF, 0x0F}, HavePiecesOffset: 8, State: state},
+			},
+		},
+		{
+			"sent is shorter than mask", []byte{0x01, 0x03}, []byte{0x01}, []any{
+				UpdateInit{HavePieces: []byte{0x03}, HavePiecesOffset: 8, State: state},
+			},
+		},
+		{
+			"bitfield is split to chunks", full, nil, []any{
+				UpdateInit{HavePieces: full[:maxHaveUpdateSize], HavePiecesOffset: 0, State: state},
+				UpdateInit{HavePieces: full[maxHaveUpdateSize : maxHaveUpdateSize*2], HavePiecesOffset: maxHaveUpdateSize * 8, State: state},
+				UpdateInit{HavePieces: full[maxHaveUpdateSize*2:], HavePiecesOffset: maxHaveUpdateSize * 2 * 8, State: state},
+			},
+		},
+		{
+			"ids are split to chunks", sparse, nil, []any{
+				UpdateHavePieces{PieceIDs: sparseIds[:maxHaveUpdateSize/4]},
+				UpdateHavePieces{PieceIDs: sparseIds[maxHaveUpdateSize/4 : maxHaveUpdateSize/2]},
+				UpdateHavePieces{PieceIDs: sparseIds[maxHaveUpdateSize/2:]},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := diffPiecesUpdates(tt.mask, tt.sent, state)
+			if !reflect.DeepEqual(updates, tt.expected) {
+				t.Fatalf("unexpected updates:\n%v\nexpected:\n%v", updates, tt.expected)
+			}
+		})
+	}
+}
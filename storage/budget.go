@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// memoryBudget limits summary memory which can be taken by active downloads,
+// new downloads are waiting for the budget instead of allocating buffers immediately.
+type memoryBudget struct {
+	limit uint64
+	used  uint64
+
+	released chan struct{}
+	mx       sync.Mutex
+}
+
+func newMemoryBudget() *memoryBudget {
+	return &memoryBudget{
+		released: make(chan struct{}),
+	}
+}
+
+func (m *memoryBudget) SetLimit(bytes uint64) {
+	m.mx.Lock()
+	m.limit = bytes
+	m.notify()
+	m.mx.Unlock()
+}
+
+func (m *memoryBudget) GetUsage() (used, limit uint64) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.used, m.limit
+}
+
+// Acquire - reserves memory, blocks till it is available.
+// When requested size is bigger than the whole limit, it will wait till all other downloads release memory,
+// so it can still be processed alone.
+func (m *memoryBudget) Acquire(ctx context.Context, sz uint64) error {
+	for {
+		m.mx.Lock()
+		if m.limit == 0 || m.used == 0 || m.used+sz <= m.limit {
+			m.used += sz
+			m.mx.Unlock()
+			return nil
+		}
+		wait := m.released
+		m.mx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func (m *memoryBudget) Release(sz uint64) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if sz > m.used {
+		sz = m.used
+	}
+	m.used -= sz
+	m.notify()
+}
+
+func (m *memoryBudget) notify() {
+	close(m.released)
+	m.released = make(chan struct{})
+}
+
+// estimateDownloadMemory - approximate amount of memory which download of the given pieces will take:
+// prefetched pieces with proofs, pieces lists and maps, and bitmaps
+func (t *Torrent) estimateDownloadMemory(prefetch int, pieces int) uint64 {
+	if prefetch > pieces {
+		prefetch = pieces
+	}
+
+	depth := uint64(math.Ceil(math.Log2(float64(t.PiecesNum()) + 1)))
+	proofSize := 64 + depth*2*(32+8)
+
+	return uint64(prefetch)*(uint64(t.Info.PieceSize)+proofSize) +
+		uint64(pieces)*(4+48) + // list + map entry
+		uint64(len(t.pieceMask))
+}
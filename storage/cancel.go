@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"sync/atomic"
+	"time"
+)
+
+// cancelTTL - how long cancellation is remembered, older requests are finished by the requester's deadline anyway
+const cancelTTL = 30 * time.Second
+
+// cancelRetryAfter - how long we are not sending cancellations to peer after failed one.
+// Peers which are not supporting it are not answering at all, so it cannot be told apart
+// from slow peer, and we try again later instead of disabling it forever.
+const cancelRetryAfter = 5 * time.Minute
+
+// cancelPieces - tells peer that we are not waiting for these pieces anymore,
+// so it can skip requests which are still queued on its side (for example waiting for upload limit).
+// Not all implementations are supporting it, so after failure we stop sending it to this peer for cancelRetryAfter.
+func (s *storagePeer) cancelPieces(ids []int32) {
+	if time.Now().UnixNano() < atomic.LoadInt64(&s.cancelPausedUntil) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.globalCtx, 3*time.Second)
+	defer cancel()
+
+	var res Ok
+	err := s.conn.adnl.Query(ctx, overlay.WrapQuery(s.overlay, &CancelPieces{PieceIDs: ids}), &res)
+	if err != nil {
+		if s.globalCtx.Err() != nil {
+			// peer is closing, it is not a failure of peer
+			return
+		}
+		atomic.StoreInt64(&s.cancelPausedUntil, time.Now().Add(cancelRetryAfter).UnixNano())
+		Log.Debug("peer not accepted pieces cancellation, pausing it", compStorage, PeerAttr(s.nodeId), ErrAttr(err))
+		return
+	}
+	Log.Debug("cancelled pieces requests", compStorage, PeerAttr(s.nodeId), "pieces", ids)
+}
+
+// markCancelled - remembers pieces which requester does not need anymore
+func (s *storagePeer) markCancelled(ids []int32) {
+	s.cancelMx.Lock()
+	defer s.cancelMx.Unlock()
+
+	now := time.Now()
+	if s.cancelled == nil {
+		s.cancelled = map[int32]time.Time{}
+	}
+	for id, at := range s.cancelled {
+		if now.Sub(at) > cancelTTL {
+			delete(s.cancelled, id)
+		}
+	}
+	for _, id := range ids {
+		s.cancelled[id] = now
+	}
+}
+
+// isCancelledSince - checks that piece request which was received at the given time was cancelled after it
+func (s *storagePeer) isCancelledSince(id int32, receivedAt time.Time) bool {
+	s.cancelMx.Lock()
+	defer s.cancelMx.Unlock()
+
+	at, ok := s.cancelled[id]
+	return ok && !at.Before(receivedAt)
+}
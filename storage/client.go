@@ -21,7 +21,16 @@ import (
 	"time"
 )
 
-var Logger = func(...any) {}
+// Keepalive of connected peers: peer is pinged every PeerPingInterval, and when it misses
+// PeerMaxMissedPings pings in a row, it is considered dead, connection is closed
+// and its pieces requests are given to other peers.
+var (
+	PeerPingInterval   = 7 * time.Second
+	PeerPingTimeout    = 7 * time.Second
+	PeerMaxMissedPings = 3
+	// PeerPieceTimeout - how long we wait for piece from peer before asking another one
+	PeerPieceTimeout = 7 * time.Second
+)
 
 type DHT interface {
 	StoreAddress(ctx context.Context, addresses address.List, ttl time.Duration, ownerKey ed25519.PrivateKey, copies int) (int, []byte, error)
@@ -56,6 +65,8 @@ type torrentDownloader struct {
 	attempts           int
 
 	torrent *Torrent
+	// prefetched - pieces of small bag, downloaded together with header
+	prefetched map[uint32]*fetchedPiece
 
 	mx sync.RWMutex
 
@@ -91,9 +102,28 @@ type storagePeer struct {
 
 	fails int32
 	loops int32
+	// inflight - number of piece requests which peer is processing now
+	inflight int32
 
 	pieceQueue chan *pieceRequest
 
+	authOnce sync.Once
+	authErr  error
+
+	// cancelled - pieces which peer asked us to not send anymore
+	cancelled map[int32]time.Time
+	cancelMx  sync.Mutex
+	// cancelPausedUntil - unix nano time until which we are not sending cancellations to peer
+	cancelPausedUntil int64
+
+	clientName   atomic.Value
+	announceData atomic.Value
+
+	// received, receiptSent - bytes received from peer in this session and acknowledged by the last receipt
+	received            uint64
+	receiptSent         uint64
+	receiptsUnsupported int32
+
 	activateOnce sync.Once
 	closeOnce    sync.Once
 	globalCtx    context.Context
@@ -111,66 +141,97 @@ type TorrentInfo struct {
 
 type speedLimit struct {
 	bytesPerSec uint64
-	limitUsed   uint64
-	lastUsedAt  time.Time
-	mx          sync.Mutex
+	// reset - set when limit is changed, allowance is refilled on the next throttle
+	reset     uint32
+	allowance float64
+	updatedAt time.Time
+	mx        sync.Mutex
 }
 
 type TorrentServer interface {
 	StartPeerSearcher(t *Torrent)
+	AddPeer(ctx context.Context, t *Torrent, addr string, adnlID []byte) error
+	AddStaticPeer(key ed25519.PublicKey, addr string) error
+	BanNode(adnlID []byte) error
+	UnbanNode(adnlID []byte) error
+	GetBannedNodes() [][]byte
+	Reannounce(ctx context.Context, t *Torrent) error
+	GetAnnounceStatus(bagId []byte) (AnnounceStatus, bool)
+	GetDHTNodesNum() int
 }
 
 type Connector struct {
-	downloadLimit *speedLimit
-	uploadLimit   *speedLimit
+	downloadLimit  *speedLimit
+	uploadLimit    *speedLimit
+	downloadMemory *memoryBudget
+	hooks          Hooks
+	// middlewares - []Middleware, replaced as a whole on change, so transfers can read it without locks
+	middlewares atomic.Value
 	TorrentServer
 }
 
-func NewConnector(srv TorrentServer) *Connector {
-	return &Connector{
-		TorrentServer: srv,
-		downloadLimit: &speedLimit{},
-		uploadLimit:   &speedLimit{},
+// NewConnector - creates connector, middlewares are wrapping piece transfers after DefaultMiddlewares,
+// more can be added later with Use
+func NewConnector(srv TorrentServer, middlewares ...Middleware) *Connector {
+	c := &Connector{
+		TorrentServer:  srv,
+		downloadLimit:  &speedLimit{},
+		uploadLimit:    &speedLimit{},
+		downloadMemory: newMemoryBudget(),
 	}
+	c.middlewares.Store(append(DefaultMiddlewares(), middlewares...))
+	return c
 }
 
 func (s *speedLimit) SetLimit(bytesPerSec uint64) {
-	atomic.StoreUint64(&s.limitUsed, 0)
 	atomic.StoreUint64(&s.bytesPerSec, bytesPerSec)
+	atomic.StoreUint32(&s.reset, 1)
 }
 
 func (s *speedLimit) GetLimit() uint64 {
 	return atomic.LoadUint64(&s.bytesPerSec)
 }
 
+// Throttle - token bucket, allowance is refilled with limit speed up to SpeedLimitWindow of limit plus SpeedLimitBurst,
+// so after idle time transfers are not delayed till allowance is used, and sustained speed is kept at limit
 func (s *speedLimit) Throttle(ctx context.Context, sz uint64) error {
-	if atomic.LoadUint64(&s.bytesPerSec) > 0 {
-		s.mx.Lock()
-		defer s.mx.Unlock()
+	limit := atomic.LoadUint64(&s.bytesPerSec)
+	if limit == 0 {
+		return nil
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
 
+	select {
+	case <-ctx.Done():
+		// skip if not needed anymore
+		return ctx.Err()
+	default:
+	}
+
+	now := time.Now()
+	capacity := float64(limit)*SpeedLimitWindow.Seconds() + float64(SpeedLimitBurst)
+	if atomic.CompareAndSwapUint32(&s.reset, 1, 0) || s.updatedAt.IsZero() {
+		s.allowance = capacity
+	} else {
+		s.allowance += now.Sub(s.updatedAt).Seconds() * float64(limit)
+		if s.allowance > capacity {
+			s.allowance = capacity
+		}
+	}
+	s.updatedAt = now
+	s.allowance -= float64(sz)
+
+	if s.allowance < 0 {
+		wait := time.Duration(-s.allowance / float64(limit) * float64(time.Second))
 		select {
 		case <-ctx.Done():
-			// skip if not needed anymore
+			// not transferred, give allowance back
+			s.allowance += float64(sz)
 			return ctx.Err()
-		default:
-		}
-
-		used := atomic.LoadUint64(&s.limitUsed)
-		limit := atomic.LoadUint64(&s.bytesPerSec)
-		if limit > 0 && used > limit {
-			wait := time.Duration(float64(used)/float64(limit)*float64(time.Second)) - time.Since(s.lastUsedAt)
-			if wait > 0 {
-				select {
-				case <-ctx.Done():
-					// skip if not needed anymore
-					return ctx.Err()
-				case <-time.After(wait):
-				}
-			}
-			atomic.StoreUint64(&s.limitUsed, 0)
+		case <-time.After(wait):
 		}
-		s.lastUsedAt = time.Now()
-		atomic.AddUint64(&s.limitUsed, sz)
 	}
 	return nil
 }
@@ -199,6 +260,44 @@ func (c *Connector) ThrottleUpload(ctx context.Context, sz uint64) error {
 	return c.uploadLimit.Throttle(ctx, sz)
 }
 
+// SetDownloadsMemoryLimit - sets memory budget for all active downloads, 0 = unlimited
+func (c *Connector) SetDownloadsMemoryLimit(bytes uint64) {
+	c.downloadMemory.SetLimit(bytes)
+}
+
+func (c *Connector) GetDownloadsMemoryUsage() (used, limit uint64) {
+	return c.downloadMemory.GetUsage()
+}
+
+func (c *Connector) AcquireDownloadMemory(ctx context.Context, sz uint64) error {
+	return c.downloadMemory.Acquire(ctx, sz)
+}
+
+func (c *Connector) ReleaseDownloadMemory(sz uint64) {
+	c.downloadMemory.Release(sz)
+}
+
+// SetHooks - sets callbacks for embedding application, nil to remove them
+func (c *Connector) SetHooks(h Hooks) {
+	c.hooks = h
+}
+
+func (c *Connector) GetHooks() Hooks {
+	return c.hooks
+}
+
+// Use - appends middlewares to the end of chain, they are applied to transfers started after the call
+func (c *Connector) Use(middlewares ...Middleware) {
+	cur := c.middlewares.Load().([]Middleware)
+	list := make([]Middleware, 0, len(cur)+len(middlewares))
+	c.middlewares.Store(append(append(list, cur...), middlewares...))
+}
+
+// WrapPieceHandler - wraps piece transfer with all middlewares of connector
+func (c *Connector) WrapPieceHandler(h PieceHandler) PieceHandler {
+	return Chain(c.middlewares.Load().([]Middleware)...)(h)
+}
+
 func (c *Connector) CreateDownloader(ctx context.Context, t *Torrent, desiredMinPeersNum, threadsPerPeer int) (_ TorrentDownloader, err error) {
 	if len(t.BagID) != 32 {
 		return nil, fmt.Errorf("invalid torrent bag id")
@@ -243,9 +342,22 @@ func (c *Connector) CreateDownloader(ctx context.Context, t *Torrent, desiredMin
 			hdrPieces++
 		}
 
+		var small map[uint32]*fetchedPiece
+		if dow.piecesNum <= SmallBagPieces {
+			if small, err = dow.downloadSmallBag(globalCtx); err != nil {
+				return nil, err
+			}
+		}
+
 		data := make([]byte, 0, hdrPieces*uint64(dow.torrent.Info.PieceSize))
 		proofs := make([][]byte, 0, hdrPieces)
 		for i := uint32(0); i < uint32(hdrPieces); i++ {
+			if p := small[i]; p != nil {
+				data = append(data, p.data...)
+				proofs = append(proofs, p.proof)
+				continue
+			}
+
 			piece, proof, _, _, pieceErr := dow.DownloadPieceDetailed(globalCtx, i)
 			if pieceErr != nil {
 				err = fmt.Errorf("failed to get header piece %d, err: %w", i, pieceErr)
@@ -266,16 +378,12 @@ func (c *Connector) CreateDownloader(ctx context.Context, t *Torrent, desiredMin
 			return nil, fmt.Errorf("too big dir name > 256")
 		}
 
-		if err := validateFileName(string(header.DirName), false); err != nil {
-			return nil, fmt.Errorf("malicious bag: %w", err)
+		if err = checkHeaderLimits(&header, dow.torrent.Info); err != nil {
+			return nil, fmt.Errorf("bag header rejected: %w", err)
 		}
 
-		if header.FilesCount > 1_000_000 {
-			return nil, fmt.Errorf("bag has > 1_000_000 files, looks dangerous")
-		}
-		if uint32(len(header.NameIndex)) != header.FilesCount ||
-			uint32(len(header.DataIndex)) != header.FilesCount {
-			err = fmt.Errorf("corrupted header, lack of files info")
+		if err = validateHeaderNames(&header); err != nil {
+			dow.torrent.rejectMalicious(err)
 			return nil, err
 		}
 
@@ -291,6 +399,8 @@ func (c *Connector) CreateDownloader(ctx context.Context, t *Torrent, desiredMin
 				return nil, err
 			}
 		}
+		// data of header pieces is needed too, when it is written to files
+		dow.prefetched = small
 	}
 
 	return dow, nil
@@ -299,7 +409,8 @@ func (c *Connector) CreateDownloader(ctx context.Context, t *Torrent, desiredMin
 func (s *storagePeer) Close() {
 	s.torrent.RemovePeer(s.nodeId)
 	s.closeOnce.Do(func() {
-		Logger("[STORAGE] CLOSING CONNECTION OF", hex.EncodeToString(s.nodeId), s.nodeAddr)
+		Log.Debug("closing connection of peer", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), "addr", s.nodeAddr)
+		s.torrent.logEvent("PEER_DISCONNECTED", anonymize(eventsKey, hex.EncodeToString(s.nodeId)))
 		s.stop()
 		s.conn.CloseFor(s)
 	})
@@ -308,8 +419,11 @@ func (s *storagePeer) Close() {
 func (s *storagePeer) touch() {
 	s.torrent.TouchPeer(s)
 	s.activateOnce.Do(func() {
-		for i := 0; i < 8; i++ {
-			go s.loop()
+		s.torrent.hooks().OnPeerConnected(s.torrent, s.nodeId, s.nodeAddr)
+		s.torrent.logEvent("PEER_CONNECTED", anonymize(eventsKey, hex.EncodeToString(s.nodeId)))
+
+		for i := 0; i < s.torrent.downloadConcurrency().PeerRequests; i++ {
+			s.torrent.spawn(s.globalCtx, s.loop)
 		}
 	})
 }
@@ -319,6 +433,12 @@ func (s *storagePeer) pinger(srv *Server) {
 		s.Close()
 	}()
 
+	if err := s.authorize(srv); err != nil {
+		return
+	}
+	s.torrent.spawn(s.globalCtx, s.requestClientInfo)
+	s.torrent.spawn(s.globalCtx, s.requestAnnounceData)
+
 	var lastPeersReq time.Time
 
 	startedAt := time.Now()
@@ -326,33 +446,36 @@ func (s *storagePeer) pinger(srv *Server) {
 	for {
 		wait := 250 * time.Millisecond
 		if s.sessionId != 0 {
-			wait = 7 * time.Second
+			wait = PeerPingInterval
 			// session should be initialised
 			var pong Pong
-			ctx, cancel := context.WithTimeout(s.globalCtx, 7*time.Second)
+			ctx, cancel := context.WithTimeout(s.globalCtx, PeerPingTimeout)
 			err := s.conn.rldp.DoQuery(ctx, 1<<25, overlay.WrapQuery(s.overlay, &Ping{SessionID: s.sessionId}), &pong)
 			cancel()
 			if err != nil {
 				fails++
-				if fails >= 3 {
-					Logger("[STORAGE] NODE NOT RESPOND 3 PINGS IN A ROW, CLOSING CONNECTION WITH ", hex.EncodeToString(s.nodeId), s.nodeAddr, err.Error())
+				if fails >= PeerMaxMissedPings {
+					Log.Debug("peer is not answering pings, closing connection", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), "addr", s.nodeAddr, "missed", fails, ErrAttr(err))
 					return
 				}
+				// recheck soon, to not keep dead peer with our requests for long
+				wait = time.Second
 			} else {
 				fails = 0
 				s.touch()
+				s.sendBandwidthReceipt(srv)
 			}
 		} else {
 			if time.Since(startedAt) > 30*time.Second {
 				sesId := rand.Int63()
 				atomic.StoreInt64(&s.sessionId, sesId)
 				atomic.StoreInt64(&s.sessionSeqno, 0)
-				Logger("[STORAGE] FORCE NEW SESSION WITH", hex.EncodeToString(s.nodeId), sesId)
+				Log.Debug("force new session", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), "session", sesId)
 			}
 		}
 
 		if fails == 0 && time.Since(lastPeersReq) > 30*time.Second {
-			Logger("[STORAGE] REQUESTING NODES LIST OF PEER", hex.EncodeToString(s.nodeId), "FOR", hex.EncodeToString(s.torrent.BagID))
+			Log.Debug("requesting nodes list of peer", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId))
 			var al overlay.NodesList
 			ctx, cancel := context.WithTimeout(s.globalCtx, 7*time.Second)
 			err := s.conn.adnl.Query(ctx, overlay.WrapQuery(s.overlay, &overlay.GetRandomPeers{}), &al)
@@ -363,8 +486,7 @@ func (s *storagePeer) pinger(srv *Server) {
 					srv.addTorrentNode(&n, s.torrent)
 				}
 			} else {
-				Logger("[STORAGE] FAILED REQUEST NODES LIST OF PEER", hex.EncodeToString(s.nodeId),
-					"FOR", hex.EncodeToString(s.torrent.BagID), "ERR:", err.Error())
+				Log.Debug("failed to request nodes list of peer", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), ErrAttr(err))
 			}
 			lastPeersReq = time.Now()
 		}
@@ -383,6 +505,7 @@ func (s *storagePeer) loop() {
 		atomic.AddInt32(&s.loops, -1)
 		s.Close()
 	}()
+	defer s.torrent.recoverPanic("peer loop", nil)
 
 	for {
 		var req *pieceRequest
@@ -392,14 +515,15 @@ func (s *storagePeer) loop() {
 		case req = <-s.pieceQueue:
 			select {
 			case <-req.ctx.Done():
-				Logger("[STORAGE] ABANDONED PIECE TASK", req.index, "BY ", hex.EncodeToString(s.nodeId), s.nodeAddr)
+				Log.Debug("abandoned piece task", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), "piece", req.index)
 				continue
 			default:
 			}
 
-			Logger("[STORAGE] PICKED UP PIECE TASK", req.index, "BY ", hex.EncodeToString(s.nodeId), s.nodeAddr)
+			Log.Debug("picked up piece task", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), "piece", req.index)
 		}
 
+		atomic.AddInt32(&s.inflight, 1)
 		resp := pieceResponse{
 			index: req.index,
 			node:  s,
@@ -408,46 +532,76 @@ func (s *storagePeer) loop() {
 		untrusted := false
 		var piece Piece
 		resp.err = func() error {
-			reqCtx, cancel := context.WithTimeout(req.ctx, 7*time.Second)
-			err := s.conn.rldp.DoQuery(reqCtx, 4096+int64(s.torrent.Info.PieceSize)*3, overlay.WrapQuery(s.overlay, &GetPiece{req.index}), &piece)
-			cancel()
-			if err != nil {
-				return fmt.Errorf("failed to query piece %d. err: %w", req.index, err)
-			}
-
-			proof, err := cell.FromBOC(piece.Proof)
-			if err != nil {
-				untrusted = true
-				return fmt.Errorf("failed to parse BoC of piece %d, err: %w", req.index, err)
-			}
+			fetch := s.torrent.pieceHandler(func(ctx context.Context, pr *PieceRequest) (*Piece, error) {
+				reqCtx, cancel := context.WithTimeout(ctx, PeerPieceTimeout)
+				go func() {
+					// abort request immediately when peer is closed, so piece is rescheduled to another peer
+					select {
+					case <-s.globalCtx.Done():
+						cancel()
+					case <-reqCtx.Done():
+					}
+				}()
+				var p Piece
+				err := s.conn.rldp.DoQuery(reqCtx, 4096+int64(s.torrent.Info.PieceSize)*3, overlay.WrapQuery(s.overlay, &GetPiece{int32(pr.Piece)}), &p)
+				expired := reqCtx.Err() != nil
+				cancel()
+				if err != nil {
+					if expired {
+						// piece is not needed anymore or deadline passed, but peer may still be processing it
+						s.torrent.spawn(s.globalCtx, func() {
+							s.cancelPieces([]int32{int32(pr.Piece)})
+						})
+					}
+					return nil, fmt.Errorf("failed to query piece %d. err: %w", pr.Piece, err)
+				}
 
-			err = cell.CheckProof(proof, s.torrent.Info.RootHash)
-			if err != nil {
-				untrusted = true
-				return fmt.Errorf("proof check of piece %d failed: %w", req.index, err)
-			}
+				if err = s.torrent.verifyPiece(pr.Piece, p.Data, p.Proof); err != nil {
+					untrusted = true
+					return nil, err
+				}
+				return &p, nil
+			})
 
-			err = s.torrent.checkProofBranch(proof, piece.Data, uint32(req.index))
+			p, err := fetch(req.ctx, &PieceRequest{
+				Op:       PieceFetch,
+				Torrent:  s.torrent,
+				Piece:    uint32(req.index),
+				PeerID:   s.nodeId,
+				PeerAddr: s.nodeAddr,
+			})
 			if err != nil {
-				untrusted = true
-				return fmt.Errorf("proof branch check of piece %d failed: %w", req.index, err)
+				return err
 			}
+			piece = *p
+			s.torrent.hooks().OnPieceVerified(s.torrent, uint32(req.index), s.nodeId)
 
 			s.torrent.UpdateDownloadedPeer(s, uint64(len(piece.Data)))
+			atomic.AddUint64(&s.received, uint64(len(piece.Data)))
 			return nil
 		}()
+		cancelled := resp.err != nil && !untrusted && req.ctx.Err() != nil
 		if resp.err == nil {
 			atomic.StoreInt32(&s.fails, 0)
 			resp.piece = piece
+		} else if cancelled {
+			// piece is not needed anymore, for example it was downloaded from another peer in endgame,
+			// it is not a fault of peer
+			Log.Debug("load piece is cancelled", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId))
 		} else {
-			Logger("[STORAGE] LOAD PIECE FROM", s.nodeAddr, "ERR:", resp.err.Error())
+			Log.Debug("failed to load piece", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), ErrAttr(resp.err))
 			atomic.AddInt32(&s.fails, 1)
 		}
+		atomic.AddInt32(&s.inflight, -1)
 		req.result <- resp
 
-		if resp.err != nil {
+		if resp.err != nil && !cancelled {
+			if untrusted {
+				s.torrent.rejectPeerPiece(s.nodeId, resp.err)
+			}
+
 			if atomic.LoadInt32(&s.fails) >= 3*atomic.LoadInt32(&s.loops) || untrusted {
-				Logger("[STORAGE] TOO MANY FAILS FROM", s.nodeAddr, "CLOSING CONNECTION, ERR:", resp.err.Error())
+				Log.Debug("too many fails of peer, closing connection", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), ErrAttr(resp.err))
 				// something wrong, close connection, we should reconnect after it
 				return
 			}
@@ -465,6 +619,16 @@ func (s *storagePeer) loop() {
 
 // DownloadPieceDetailed - same as DownloadPiece, but also returns proof data
 func (t *torrentDownloader) DownloadPieceDetailed(ctx context.Context, pieceIndex uint32) (piece []byte, proof []byte, peer []byte, peerAddr string, err error) {
+	return t.downloadPieceFrom(ctx, pieceIndex, nil, nil)
+}
+
+// downloadPieceFrom - same as DownloadPieceDetailed, but peers for which exclude returns true are not used,
+// and assigned is called with id of peer which took the request, both can be nil
+func (t *torrentDownloader) downloadPieceFrom(ctx context.Context, pieceIndex uint32, exclude func(peerId []byte) bool, assigned func(peerId []byte)) (piece []byte, proof []byte, peer []byte, peerAddr string, err error) {
+	if p := t.takePrefetched(pieceIndex); p != nil {
+		return p.data, p.proof, p.peer, p.addr, nil
+	}
+
 	resp := make(chan pieceResponse, 1)
 	req := pieceRequest{
 		index:  int32(pieceIndex),
@@ -478,7 +642,10 @@ func (t *torrentDownloader) DownloadPieceDetailed(ctx context.Context, pieceInde
 
 		var nodes = make([]*storagePeer, 0, len(peers))
 		for _, node := range peers {
-			if skip[string(node.peer.nodeId)] != nil {
+			if skip[string(node.peer.nodeId)] != nil || !t.torrent.peerBannedTill(node.peer.nodeId).IsZero() {
+				continue
+			}
+			if exclude != nil && exclude(node.peer.nodeId) {
 				continue
 			}
 
@@ -491,6 +658,19 @@ func (t *torrentDownloader) DownloadPieceDetailed(ctx context.Context, pieceInde
 			}
 		}
 
+		if len(nodes) > 1 && t.downloadingPeersLimited() {
+			// keep downloading from the same peers, others are used only when busy ones don't have the piece
+			busy := make([]*storagePeer, 0, len(nodes))
+			for _, n := range nodes {
+				if atomic.LoadInt32(&n.inflight) > 0 {
+					busy = append(busy, n)
+				}
+			}
+			if len(busy) > 0 {
+				nodes = busy
+			}
+		}
+
 		if len(nodes) == 0 {
 			select {
 			case <-ctx.Done():
@@ -509,10 +689,14 @@ func (t *torrentDownloader) DownloadPieceDetailed(ctx context.Context, pieceInde
 		}
 		cases[len(nodes)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
 
-		chId, _, recvOk := reflect.Select(cases)
-		if recvOk && ctx.Err() != nil {
+		chId, _, _ := reflect.Select(cases)
+		if chId == len(nodes) {
+			// context is done
 			return nil, nil, nil, "", ctx.Err()
 		}
+		if assigned != nil {
+			assigned(nodes[chId].nodeId)
+		}
 
 		select {
 		case <-ctx.Done():
@@ -536,14 +720,29 @@ func (t *torrentDownloader) DownloadPiece(ctx context.Context, pieceIndex uint32
 }
 
 func (t *Torrent) checkProofBranch(proof *cell.Cell, data []byte, piece uint32) error {
+	branchHash, err := t.proofBranchHash(proof, piece)
+	if err != nil {
+		return err
+	}
+
+	dataHash := sha256.New()
+	dataHash.Write(data)
+	if !bytes.Equal(branchHash, dataHash.Sum(nil)) {
+		return fmt.Errorf("incorrect branch hash")
+	}
+	return nil
+}
+
+// proofBranchHash - returns hash of piece data from its merkle proof
+func (t *Torrent) proofBranchHash(proof *cell.Cell, piece uint32) ([]byte, error) {
 	piecesNum := t.PiecesNum()
 	if piece >= piecesNum {
-		return fmt.Errorf("piece is out of range %d/%d", piece, piecesNum)
+		return nil, fmt.Errorf("piece is out of range %d/%d", piece, piecesNum)
 	}
 
 	tree, err := proof.BeginParse().LoadRef()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// calc tree depth
@@ -559,7 +758,7 @@ func (t *Torrent) checkProofBranch(proof *cell.Cell, data []byte, piece uint32)
 
 		b, err := tree.LoadRef()
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if isLeft {
@@ -570,21 +769,11 @@ func (t *Torrent) checkProofBranch(proof *cell.Cell, data []byte, piece uint32)
 		// we need right branch
 		tree, err = tree.LoadRef()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	branchHash, err := tree.LoadSlice(256)
-	if err != nil {
-		return err
-	}
-
-	dataHash := sha256.New()
-	dataHash.Write(data)
-	if !bytes.Equal(branchHash, dataHash.Sum(nil)) {
-		return fmt.Errorf("incorrect branch hash")
-	}
-	return nil
+	return tree.LoadSlice(256)
 }
 
 func (t *torrentDownloader) SetDesiredMinNodesNum(num int) {
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// ClientName - our name and version, reported to peers which are asking for it
+var ClientName = "tonutils-storage"
+
+// requestClientInfo - asks peer for its name and version, it is informational only.
+// Reference implementation does not support this query, such peers are shown without client name.
+func (s *storagePeer) requestClientInfo() {
+	ctx, cancel := context.WithTimeout(s.globalCtx, 5*time.Second)
+	defer cancel()
+
+	var res ClientInfo
+	err := s.conn.adnl.Query(ctx, overlay.WrapQuery(s.overlay, &GetClientInfo{}), &res)
+	if err != nil {
+		Log.Debug("peer not reported client info", compStorage, PeerAttr(s.nodeId), ErrAttr(err))
+		return
+	}
+
+	name := []rune(res.Name)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	s.clientName.Store(string(name))
+}
+
+func (s *storagePeer) getClientName() string {
+	name, _ := s.clientName.Load().(string)
+	return name
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Defaults of download parallelism, used for bags without own values
+var (
+	// DownloadThreads - pieces of bag downloaded in parallel
+	DownloadThreads = 24
+	// DownloadPrefetch - pieces of bag downloaded ahead of writing, they are kept in memory
+	DownloadPrefetch = 200
+	// PeerRequests - piece requests sent to one peer in parallel, higher values help on high latency links
+	PeerRequests = 8
+	// DownloadPeers - peers one bag is downloaded from in the same time, 0 = all connected peers
+	DownloadPeers = 0
+)
+
+// DownloadConcurrency - parallelism of bag download, zero fields are taken from package defaults
+type DownloadConcurrency struct {
+	Threads      int `json:",omitempty"`
+	Prefetch     int `json:",omitempty"`
+	PeerRequests int `json:",omitempty"`
+	Peers        int `json:",omitempty"`
+}
+
+// SetDownloadConcurrency - sets parallelism of bag download, when bag is downloading, download is restarted.
+// Number of requests per peer is applied to peers connected after change.
+func (t *Torrent) SetDownloadConcurrency(c DownloadConcurrency) error {
+	if c.Threads < 0 || c.Prefetch < 0 || c.PeerRequests < 0 || c.Peers < 0 {
+		return fmt.Errorf("download concurrency values cannot be negative")
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.concurrency == c {
+		return nil
+	}
+	t.concurrency = c
+
+	if active, _ := t.IsActive(); !active || t.stopDownload == nil {
+		return nil
+	}
+	return t.startDownload(t.stopOnError())
+}
+
+// GetDownloadConcurrency - returns own values of bag, zero fields are using defaults
+func (t *Torrent) GetDownloadConcurrency() DownloadConcurrency {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.concurrency
+}
+
+// downloadConcurrency - returns values of bag with defaults applied
+func (t *Torrent) downloadConcurrency() DownloadConcurrency {
+	c := t.GetDownloadConcurrency()
+	if c.Threads == 0 {
+		c.Threads = DownloadThreads
+	}
+	if c.Prefetch == 0 {
+		c.Prefetch = DownloadPrefetch
+	}
+	if c.PeerRequests == 0 {
+		c.PeerRequests = PeerRequests
+	}
+	if c.Peers == 0 {
+		c.Peers = DownloadPeers
+	}
+
+	if c.Threads <= 0 {
+		c.Threads = 1
+	}
+	if c.Prefetch <= 0 {
+		c.Prefetch = 1
+	}
+	if c.Threads > c.Prefetch {
+		// threads are waiting for prefetch slots, more of them are not useful
+		c.Threads = c.Prefetch
+	}
+	if c.PeerRequests <= 0 {
+		c.PeerRequests = 1
+	}
+	return c
+}
+
+// downloadingPeersLimited - checks if bag is downloaded from max number of peers at the same time
+func (t *torrentDownloader) downloadingPeersLimited() bool {
+	limit := t.torrent.downloadConcurrency().Peers
+	if limit <= 0 {
+		return false
+	}
+
+	busy := 0
+	for _, p := range t.torrent.GetPeers() {
+		if p.peer != nil && atomic.LoadInt32(&p.peer.inflight) > 0 {
+			busy++
+		}
+	}
+	return busy >= limit
+}
@@ -1,7 +1,6 @@
 package storage
 
 import (
-	"encoding/hex"
 	"github.com/xssnick/tonutils-go/adnl"
 	"github.com/xssnick/tonutils-go/adnl/overlay"
 	"sync"
@@ -11,8 +10,9 @@ type PeerConnection struct {
 	rldp overlay.RLDP
 	adnl adnl.Peer
 
-	mx         sync.RWMutex
-	usedByBags map[string]*storagePeer
+	mx             sync.RWMutex
+	usedByBags     map[string]*storagePeer
+	authorizedBags map[string]bool
 }
 
 func (c *PeerConnection) CloseFor(peer *storagePeer) {
@@ -20,10 +20,10 @@ func (c *PeerConnection) CloseFor(peer *storagePeer) {
 	defer c.mx.Unlock()
 
 	delete(c.usedByBags, string(peer.torrent.BagID))
-	Logger("[STORAGE_PEER] CLOSING", hex.EncodeToString(c.adnl.GetID()), "FOR", hex.EncodeToString(peer.torrent.BagID), "LEFT USAGES", len(c.usedByBags))
+	Log.Debug("closing peer for bag", compPeer, BagAttr(peer.torrent.BagID), PeerAttr(c.adnl.GetID()), "left_usages", len(c.usedByBags))
 
 	if len(c.usedByBags) == 0 {
-		Logger("[STORAGE_PEER] DISCONNECTING, NO MORE USAGES FOR", hex.EncodeToString(c.adnl.GetID()))
+		Log.Debug("disconnecting peer, it is not used by bags anymore", compPeer, PeerAttr(c.adnl.GetID()))
 		c.adnl.Close()
 	}
 }
@@ -32,7 +32,7 @@ func (c *PeerConnection) UseFor(peer *storagePeer) {
 	c.mx.Lock()
 	defer c.mx.Unlock()
 
-	Logger("[STORAGE_PEER] USING", hex.EncodeToString(c.adnl.GetID()), "FOR", hex.EncodeToString(peer.torrent.BagID))
+	Log.Debug("using peer for bag", compPeer, BagAttr(peer.torrent.BagID), PeerAttr(c.adnl.GetID()))
 
 	c.usedByBags[string(peer.torrent.BagID)] = peer
 }
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// MaxPeersPerBag - max number of connected peers of one bag, other known nodes are connected
+// when some of the current peers disconnects. 0 = unlimited
+var MaxPeersPerBag = 64
+
+// MaxConnections - max number of open ADNL connections of the node, for all bags,
+// incoming connections above it are rejected. 0 = unlimited
+var MaxConnections = 512
+
+// limitsRetryInterval - how often known node is checked again, when it was not connected because of limits
+const limitsRetryInterval = 30 * time.Second
+
+func (t *Torrent) peersLimitReached() bool {
+	if MaxPeersPerBag <= 0 {
+		return false
+	}
+
+	t.peersMx.RLock()
+	defer t.peersMx.RUnlock()
+
+	return len(t.peers) >= MaxPeersPerBag
+}
+
+func (s *Server) connectionsLimitReached() bool {
+	if MaxConnections <= 0 {
+		return false
+	}
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return len(s.bootstrapped) >= MaxConnections
+}
+
+// MaxConcurrentDials - max number of outbound connection attempts at the same time, for all bags,
+// with address resolution and handshake, other attempts are waiting. 0 = unlimited
+var MaxConcurrentDials = 32
+
+// acquireDial - waits for free dial slot, release should be called after attempt
+func (s *Server) acquireDial(ctx context.Context) (release func(), err error) {
+	if s.dials == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case s.dials <- struct{}{}:
+	}
+	return func() { <-s.dials }, nil
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// CoSeedSyncInterval - how often catalogs of co-seeder group members are requested
+var CoSeedSyncInterval = 5 * time.Minute
+
+// CoSeedGetCatalog - member of co-seeder group asks for our catalog, sent wrapped to overlay of our id
+type CoSeedGetCatalog struct{}
+
+// CoSeedCatalog - public bags which are seeded by node, private and restricted bags are never shared with group
+type CoSeedCatalog struct {
+	Bags []CoSeedBag `tl:"vector struct"`
+}
+
+type CoSeedBag struct {
+	BagID       []byte `tl:"int256"`
+	Size        int64  `tl:"long"`
+	Description string `tl:"string"`
+}
+
+// CoSeedMember - trusted node of co-seeder group, we should be in its group too
+type CoSeedMember struct {
+	Key  ed25519.PublicKey
+	Addr string
+}
+
+// CoSeedHandler - called after each successful catalog sync with ADNL id of member and its bags
+type CoSeedHandler func(member []byte, bags []CoSeedBag)
+
+// StartCoSeeding - joins co-seeder group: members can request our catalog, and their catalogs
+// are requested every CoSeedSyncInterval and passed to handler. Connections are authenticated by ADNL keys,
+// catalog is answered only to members.
+func (s *Server) StartCoSeeding(members []CoSeedMember, handler CoSeedHandler) error {
+	list := make(map[string]bool, len(members))
+	for _, m := range members {
+		id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: m.Key})
+		if err != nil {
+			return err
+		}
+		if err = s.AddStaticPeer(m.Key, m.Addr); err != nil {
+			return err
+		}
+		list[string(id)] = true
+	}
+
+	s.mx.Lock()
+	s.coSeeders = list
+	s.mx.Unlock()
+
+	s.wg.Add(1)
+	go s.coSeedWorker(members, handler)
+	return nil
+}
+
+func (s *Server) coSeedWorker(members []CoSeedMember, handler CoSeedHandler) {
+	defer s.wg.Done()
+
+	wait := 5 * time.Second
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = CoSeedSyncInterval
+
+		for _, m := range members {
+			id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: m.Key})
+			if err != nil {
+				continue
+			}
+
+			var res CoSeedCatalog
+			ctx, cancel := context.WithTimeout(s.closeCtx, 15*time.Second)
+			err = s.queryNode(ctx, m.Key, m.Addr, &CoSeedGetCatalog{}, &res)
+			cancel()
+			if err != nil {
+				Log.Warn("failed to get catalog of co-seeder", compCoSeed, PeerAttr(id), ErrAttr(err))
+				continue
+			}
+			handler(id, res.Bags)
+		}
+	}
+}
+
+func (s *Server) handleCoSeedGetCatalog(peer *overlay.ADNLWrapper, query *adnl.MessageQuery) error {
+	s.mx.RLock()
+	allowed := s.coSeeders[string(peer.GetID())]
+	s.mx.RUnlock()
+
+	if !allowed {
+		Log.Warn("rejected catalog request of node which is not in group", compCoSeed, PeerAttr(peer.GetID()))
+		return fmt.Errorf("not a member of co-seeder group")
+	}
+
+	res := CoSeedCatalog{Bags: []CoSeedBag{}}
+	for _, t := range s.store.GetAll() {
+		if t.IsRestricted() || t.Info == nil {
+			continue
+		}
+		if _, upl := t.IsActive(); !upl {
+			continue
+		}
+		res.Bags = append(res.Bags, CoSeedBag{
+			BagID:       t.BagID,
+			Size:        int64(t.Info.FileSize - t.Info.HeaderSize),
+			Description: t.Info.Description.Value,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
+	defer cancel()
+	return peer.Answer(ctx, query.ID, res)
+}
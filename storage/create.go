@@ -13,9 +13,12 @@ import (
 	"github.com/xssnick/tonutils-go/tvm/cell"
 	"io"
 	"math"
+	"path"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type fileInfoData struct {
@@ -29,14 +32,246 @@ type FileRef interface {
 	CreateReader() (io.ReadCloser, error)
 }
 
+// HashProgressCallback - reports bytes of bag data which are hashed, from total bag size (with header)
+type HashProgressCallback func(hashed, total uint64)
+
+const (
+	// maxCreateBuffersSize - memory for pieces which are hashed in parallel, number of workers is reduced for big pieces
+	maxCreateBuffersSize = 512 << 20
+
+	DefaultPieceSize = 128 << 10
+	MinPieceSize     = 4 << 10
+	MaxPieceSize     = maxPieceSize
+)
+
+const (
+	// SymlinksFollow - symlinks are replaced with files and directories they are pointing to
+	SymlinksFollow = "follow"
+	// SymlinksSkip - symlinks are not added to bag
+	SymlinksSkip = "skip"
+	// SymlinksError - bag creation fails when there is a symlink
+	SymlinksError = "error"
+)
+
+// CreateOptions - how bag is created, zero value gives the default behaviour
+type CreateOptions struct {
+	// PieceSize - power of 2 from 4 KB to 64 MB, bigger pieces are better for big media files,
+	// smaller for many small files, 0 = 128 KB
+	PieceSize uint32
+	// ExcludeHidden - skip files and directories which names are starting with dot
+	ExcludeHidden bool
+	// Symlinks - follow, skip or error, used when files are scanned, empty = follow
+	Symlinks string
+	// Include - if not empty, only files which paths in bag match any of these patterns are added
+	Include []string
+	// Exclude - files which paths in bag match any of these patterns are not added.
+	// Patterns are in path.Match syntax, pattern without "/" is matched against each part of path,
+	// so "*.tmp" excludes tmp files in any directory, and "cache" excludes whole cache directory.
+	// "**" matches any number of directories, like "build/**/*.o", pattern with "re:" prefix
+	// is a regular expression which is matched against whole path, like "re:\.(log|tmp)$".
+	Exclude []string
+	// Progress - reports hashing progress, can be nil
+	Progress HashProgressCallback
+	// Resume - if set, it is filled when creation is interrupted by context, and when it is passed again
+	// with same files and piece size, already hashed part of files is skipped
+	Resume *CreateProgress
+}
+
+// CreateProgress - hashes of pieces calculated before bag creation was interrupted
+type CreateProgress struct {
+	fingerprint  []byte
+	hashes       [][]byte
+	startIndexes []uint32
+}
+
+// Hashed - number of pieces which are already hashed
+func (p *CreateProgress) Hashed() int {
+	return len(p.hashes)
+}
+
+// Validate - checks options before long scanning and hashing
+func (o *CreateOptions) Validate() error {
+	if o.PieceSize != 0 && (o.PieceSize < MinPieceSize || o.PieceSize > MaxPieceSize || o.PieceSize&(o.PieceSize-1) != 0) {
+		return fmt.Errorf("piece size should be power of 2 from %s to %s", ToSz(MinPieceSize), ToSz(MaxPieceSize))
+	}
+
+	switch o.Symlinks {
+	case "", SymlinksFollow, SymlinksSkip, SymlinksError:
+	default:
+		return fmt.Errorf("unknown symlinks policy %q, should be follow, skip or error", o.Symlinks)
+	}
+
+	for _, p := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := compilePattern(p); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Match - checks file path in bag against hidden files option and patterns
+func (o *CreateOptions) Match(name string) bool {
+	if o.Skip(name) {
+		return false
+	}
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	for _, p := range o.Include {
+		if matchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Skip - checks if path is hidden or excluded, for directories it means that nothing inside is matching
+func (o *CreateOptions) Skip(name string) bool {
+	parts := strings.Split(name, "/")
+	if o.ExcludeHidden {
+		for _, p := range parts {
+			if strings.HasPrefix(p, ".") {
+				return true
+			}
+		}
+	}
+
+	for _, p := range o.Exclude {
+		if matchPattern(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compiledPatterns - patterns are converted to regular expressions once, files are checked many times
+var compiledPatterns sync.Map
+
+func matchPattern(pattern, name string) bool {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(name)
+}
+
+// compilePattern - converts glob pattern to regular expression, pattern without "/" is matched
+// against each part of path, pattern of directory matches everything inside it
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := compiledPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	var expr string
+	if strings.HasPrefix(pattern, "re:") {
+		expr = strings.TrimPrefix(pattern, "re:")
+	} else {
+		// check syntax of brackets and escapes, path.Match reports it only when reaches them
+		if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+			return nil, err
+		}
+
+		glob := globToRegexp(strings.TrimPrefix(pattern, "/"))
+		if strings.Contains(pattern, "/") {
+			expr = "^" + glob + "(/.*)?$"
+		} else {
+			expr = "(^|/)" + glob + "(/|$)"
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(pattern, re)
+	return re, nil
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				i++
+				if strings.HasPrefix(glob[i+1:], "/") {
+					// "a/**/b" matches "a/b" too
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+				continue
+			}
+			sb.WriteString("[^/]*")
+		case '?':
+			sb.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(glob[i:]))
+				return sb.String()
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "!" + class[1:]
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^/" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
 func CreateTorrent(ctx context.Context, filesRootPath, dirName, description string, db Storage, connector NetConnector, files []FileRef) (*Torrent, error) {
+	return CreateTorrentWithOptions(ctx, filesRootPath, dirName, description, db, connector, files, CreateOptions{})
+}
+
+// CreateTorrentWithProgress - same as CreateTorrent, but reports hashing progress to callback, it can be nil.
+func CreateTorrentWithProgress(ctx context.Context, filesRootPath, dirName, description string, db Storage, connector NetConnector, files []FileRef, progressCallback HashProgressCallback) (*Torrent, error) {
+	return CreateTorrentWithOptions(ctx, filesRootPath, dirName, description, db, connector, files, CreateOptions{Progress: progressCallback})
+}
+
+// CreateTorrentWithOptions - creates bag from files which are matching options.
+// Files are read sequentially, and pieces are hashed in parallel by GOMAXPROCS workers,
+// memory is bounded by 2 pieces per worker.
+func CreateTorrentWithOptions(ctx context.Context, filesRootPath, dirName, description string, db Storage, connector NetConnector, files []FileRef, opts CreateOptions) (*Torrent, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	pieceSize := opts.PieceSize
+	if pieceSize == 0 {
+		pieceSize = DefaultPieceSize
+	}
+	progressCallback := opts.Progress
+
+	matched := make([]FileRef, 0, len(files))
+	for _, f := range files {
+		if opts.Match(normalizeFileName(f.GetName())) {
+			matched = append(matched, f)
+		}
+	}
+	files = matched
+
 	if len(files) == 0 {
 		return nil, fmt.Errorf("0 files in torrent")
 	}
-	const pieceSize = 128 * 1024
 
-	cb := make([]byte, pieceSize)
-	cbOffset := 0
+	// cancel proofs workers on any exit
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	if dirName == "/" {
 		dirName = ""
@@ -47,6 +282,7 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 	}
 
 	torrent := NewTorrent(filesRootPath, db, connector)
+	torrent.pieceStore = ""
 	torrent.Header = &TorrentHeader{
 		DirNameSize: uint32(len(dirName)),
 		DirName:     []byte(dirName),
@@ -55,8 +291,13 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 	waiter, _ := pterm.DefaultSpinner.Start("Scanning files...")
 
 	var dataSize uint64
+	sourceNames := make([]string, 0, len(files))
+	renamed := false
 	for _, file := range files {
-		name := file.GetName()
+		name := normalizeFileName(file.GetName())
+		// header keeps normalized name, but file is read from disk by its original name
+		sourceNames = append(sourceNames, file.GetName())
+		renamed = renamed || name != file.GetName()
 
 		if err := validateFileName(name, true); err != nil {
 			return nil, fmt.Errorf("malicious file name %q: %w", name, err)
@@ -71,38 +312,130 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 
 		torrent.Header.DataIndex = append(torrent.Header.DataIndex, dataSize)
 	}
+	waiter.Success()
+	if renamed {
+		torrent.sourceNames = sourceNames
+	}
 
-	filesProcessed := uint32(0)
+	headerData, err := tl.Serialize(torrent.Header, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
 
-	hashes := make([][]byte, 0, 256)
-	piecesStartIndexes := make([]uint32, 0, 256)
+	totalSize := uint64(len(headerData)) + dataSize
+	piecesCount := totalSize / uint64(pieceSize)
+	if totalSize%uint64(pieceSize) != 0 {
+		piecesCount++
+	}
 
+	hashes := make([][]byte, piecesCount)
+	piecesStartIndexes := make([]uint32, 0, piecesCount)
+
+	fingerprint := sha256.Sum256(append(binary.LittleEndian.AppendUint32(nil, pieceSize), headerData...))
+	var hashed uint64
+	if r := opts.Resume; r != nil && bytes.Equal(r.fingerprint, fingerprint[:]) && len(r.hashes) <= len(hashes) {
+		copy(hashes, r.hashes)
+		piecesStartIndexes = append(piecesStartIndexes, r.startIndexes...)
+		hashed = uint64(len(r.hashes)) * uint64(pieceSize)
+	}
+	skip := hashed
+
+	// saveProgress - called when creation is interrupted, hashes of all submitted pieces are ready at this moment
+	saveProgress := func() {
+		if opts.Resume == nil {
+			return
+		}
+		opts.Resume.fingerprint = fingerprint[:]
+		opts.Resume.hashes = append([][]byte{}, hashes[:len(piecesStartIndexes)]...)
+		opts.Resume.startIndexes = append([]uint32{}, piecesStartIndexes...)
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if limit := int(maxCreateBuffersSize / (2 * uint64(pieceSize))); workers > limit {
+		// big pieces, keep memory bounded
+		workers = limit
+		if workers == 0 {
+			workers = 1
+		}
+	}
+	// free piece buffers, reader waits for them when workers are behind
+	buffers := make(chan []byte, workers*2)
+	for i := 0; i < workers*2; i++ {
+		buffers <- make([]byte, pieceSize)
+	}
+
+	type hashReq struct {
+		id   int
+		data []byte
+	}
+	toHash := make(chan hashReq, workers)
+
+	var hashWg sync.WaitGroup
+	hashWg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer hashWg.Done()
+
+			for req := range toHash {
+				hashes[req.id] = calcHash(req.data)
+				atomic.AddUint64(&hashed, uint64(len(req.data)))
+				buffers <- req.data[:cap(req.data)]
+			}
+		}()
+	}
+
+	var cb []byte
+	cbOffset := 0
+	filesProcessed := uint32(0)
 	pieceStartFileIndex := uint32(0)
 
-	process := func(name string, isHeader bool, rd io.Reader) error {
+	report := func() {
+		if progressCallback != nil {
+			progressCallback(atomic.LoadUint64(&hashed), totalSize)
+		}
+	}
+
+	submit := func() error {
+		if len(piecesStartIndexes) >= len(hashes) {
+			return fmt.Errorf("files are bigger than expected, were they modified?")
+		}
+
+		toHash <- hashReq{id: len(piecesStartIndexes), data: cb[:cbOffset]}
+		// save index of file where block starts
+		piecesStartIndexes = append(piecesStartIndexes, pieceStartFileIndex)
+		cb, cbOffset = nil, 0
+
+		report()
+		return nil
+	}
+
+	process := func(isHeader bool, rd io.Reader) error {
 		for {
-			if cbOffset == 0 {
+			if cb == nil {
 				pieceStartFileIndex = filesProcessed
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case cb = <-buffers:
+				}
 			}
 
 			n, err := rd.Read(cb[cbOffset:])
+			cbOffset += n
+
+			if cbOffset == len(cb) {
+				if err := submit(); err != nil {
+					return err
+				}
+			}
+
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					break
 				}
 				return err
 			}
-			cbOffset += n
-
-			if cbOffset == len(cb) {
-				hash := calcHash(cb)
-				hashes = append(hashes, hash)
-
-				// save index of file where block starts
-				piecesStartIndexes = append(piecesStartIndexes, pieceStartFileIndex)
-
-				cbOffset = 0
-			}
 		}
 
 		if !isHeader { // if not header
@@ -110,51 +443,71 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 		}
 		return nil
 	}
-	waiter.Success()
-
-	waiter, _ = pterm.DefaultSpinner.Start("Generating bag header...")
-	headerData, err := tl.Serialize(torrent.Header, true)
-	if err != nil {
-		waiter.Fail(err.Error())
-		return nil, fmt.Errorf("failed to serialize header: %w", err)
-	}
-	err = process("", true, bytes.NewBuffer(headerData))
-	if err != nil {
-		waiter.Fail(err.Error())
-		return nil, fmt.Errorf("failed to process header piece: %w", err)
-	}
-	waiter.Success()
 
-	progress, _ := pterm.DefaultProgressbar.WithTotal(len(files)).WithTitle("Validating files...").Start()
+	err = func() error {
+		defer func() {
+			close(toHash)
+			hashWg.Wait()
+		}()
 
-	// add files
-	for _, f := range files {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+		if skip < uint64(len(headerData)) {
+			if err := process(true, bytes.NewBuffer(headerData[skip:])); err != nil {
+				return fmt.Errorf("failed to process header piece: %w", err)
+			}
+			skip = 0
+		} else {
+			skip -= uint64(len(headerData))
 		}
 
-		rd, err := f.CreateReader()
-		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", f.GetName(), err)
+		for _, f := range files {
+			if skip > 0 && skip >= f.GetSize() {
+				// already hashed before interruption
+				skip -= f.GetSize()
+				filesProcessed++
+				continue
+			}
+
+			rd, err := f.CreateReader()
+			if err != nil {
+				return fmt.Errorf("failed to read file %s: %w", f.GetName(), err)
+			}
+
+			if skip > 0 {
+				if sk, ok := rd.(io.Seeker); ok {
+					_, err = sk.Seek(int64(skip), io.SeekStart)
+				} else {
+					_, err = io.CopyN(io.Discard, rd, int64(skip))
+				}
+				skip = 0
+				if err != nil {
+					_ = rd.Close()
+					return fmt.Errorf("failed to skip hashed part of file %s: %w", f.GetName(), err)
+				}
+			}
+
+			err = process(false, rd)
+			_ = rd.Close()
+			if err != nil {
+				return fmt.Errorf("failed to process file %s: %w", f.GetName(), err)
+			}
 		}
 
-		err = process(f.GetName(), false, rd)
-		_ = rd.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed to process file %s: %w", f.GetName(), err)
+		if cbOffset != 0 {
+			// last data hash
+			return submit()
+		}
+		return nil
+	}()
+	if err != nil {
+		if ctx.Err() != nil {
+			saveProgress()
 		}
-		progress.Increment()
+		return nil, err
 	}
+	report()
 
-	if cbOffset != 0 {
-		// last data hash
-		hash := calcHash(cb[:cbOffset])
-		hashes = append(hashes, hash)
-
-		// save index of file where block starts
-		piecesStartIndexes = append(piecesStartIndexes, pieceStartFileIndex)
+	if len(piecesStartIndexes) != len(hashes) {
+		return nil, fmt.Errorf("files are smaller than expected, were they modified?")
 	}
 
 	waiter, _ = pterm.DefaultSpinner.Start("Building merkle tree...")
@@ -162,7 +515,7 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 	waiter.Success("Merkle tree successfully built")
 
 	hashTree.Hash()
-	progress, _ = pterm.DefaultProgressbar.WithTotal(len(piecesStartIndexes)).WithTitle("Calculating proofs...").Start()
+	progress, _ := pterm.DefaultProgressbar.WithTotal(len(piecesStartIndexes)).WithTitle("Calculating proofs...").Start()
 
 	piecesNum := uint32(len(piecesStartIndexes))
 	pcNumBytes := len(piecesStartIndexes) / 8
@@ -233,8 +586,10 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 		select {
 		case <-ctx.Done():
 			_, _ = progress.Stop()
+			saveProgress()
 			return nil, ctx.Err()
 		case err = <-toCalcErr:
+			_, _ = progress.Stop()
 			return nil, fmt.Errorf("failed to calc proof for piece: %w", err)
 		case toCalc <- &calcReq{id: uint32(i), startIndex: idx}:
 			progress.Increment()
@@ -244,6 +599,14 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 
 	wg.Wait()
 
+	select {
+	case <-ctx.Done():
+		// workers could exit before all proofs are calculated
+		saveProgress()
+		return nil, ctx.Err()
+	default:
+	}
+
 	torrent.activeFiles = make([]uint32, 0, len(files))
 	for i := range files {
 		torrent.activeFiles = append(torrent.activeFiles, uint32(i))
@@ -252,6 +615,7 @@ func CreateTorrent(ctx context.Context, filesRootPath, dirName, description stri
 		return nil, fmt.Errorf("failed to store active files in db: %w", err)
 	}
 
+	torrent.hooks().OnBagCreated(torrent)
 	return torrent, nil
 }
 
@@ -415,21 +779,3 @@ func fastPrune(toPrune *cell.Cell, depth uint16) *cell.Builder {
 	binary.BigEndian.PutUint16(prunedData[2+32:], depth) //depth
 	return cell.BeginCell().MustStoreSlice(prunedData, uint(len(prunedData)*8))
 }
-
-func validateFileName(name string, isFile bool) error {
-	if strings.HasPrefix(name, "/") {
-		return fmt.Errorf("name cannot strat with '/'")
-	}
-	if strings.Contains(name, "./") {
-		return fmt.Errorf("name cannot contain traversal './'")
-	}
-	if isFile {
-		if name == "" {
-			return fmt.Errorf("file name cannot be empty")
-		}
-		if strings.HasSuffix(name, "/") {
-			return fmt.Errorf("file name cannot end with /")
-		}
-	}
-	return nil
-}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+type debugBag struct {
+	BagID        string
+	Private      bool
+	CreatedAt    time.Time
+	ExpiresAt    time.Time `json:",omitempty"`
+	PieceStore   string
+	InfoLoaded   bool
+	HeaderLoaded bool
+	PieceSize    uint32 `json:",omitempty"`
+	FileSize     uint64 `json:",omitempty"`
+	HeaderSize   uint64 `json:",omitempty"`
+	PiecesNum    uint32 `json:",omitempty"`
+	FilesCount   uint32 `json:",omitempty"`
+	Files        []debugFile
+	ActiveFiles  []uint32
+	DownloadAll  bool
+	Ordered      bool
+	Sequential   bool
+	Active       bool
+	Seeding      bool
+	Error        string `json:",omitempty"`
+	Restarts     uint32
+	Downloaded   uint64
+	Uploaded     uint64
+	DownLimit    uint64
+	UpLimit      uint64
+}
+
+type debugFile struct {
+	Index           uint32
+	NameHash        string
+	Size            uint64
+	FromPiece       uint32
+	ToPiece         uint32
+	FromPieceOffset uint32
+	ToPieceOffset   uint32
+}
+
+type debugPeer struct {
+	IDHash        string
+	AddrHash      string
+	LastSeenAt    time.Time
+	Downloaded    uint64
+	Uploaded      uint64
+	DownloadSpeed uint64
+	UploadSpeed   uint64
+}
+
+// WriteDebugDump - writes zip archive with bag state for bug reports: metadata, pieces mask, recent events and peers stats.
+// Files data is not included, local paths, swarm secret and file names are not included too,
+// and peers addresses are replaced with hashes salted by random key of this dump, so it is safe to share it.
+func (t *Torrent) WriteDebugDump(w io.Writer) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate dump key: %w", err)
+	}
+	active, seeding := t.IsActive()
+	down, up := t.GetSessionTransferred()
+	downLimit, upLimit := t.GetSpeedLimits()
+
+	bag := debugBag{
+		BagID:        hex.EncodeToString(t.BagID),
+		Private:      t.IsPrivate(),
+		CreatedAt:    t.CreatedAt,
+		ExpiresAt:    t.ExpiresAt,
+		PieceStore:   t.GetPieceStoreName(),
+		InfoLoaded:   t.Info != nil,
+		HeaderLoaded: t.Header != nil,
+		ActiveFiles:  t.GetActiveFilesIDs(),
+		DownloadAll:  t.IsDownloadAll(),
+		Ordered:      t.IsDownloadOrdered(),
+		Sequential:   t.IsSequential(),
+		Active:       active,
+		Seeding:      seeding,
+		Restarts:     t.GetRestartsNum(),
+		Downloaded:   down,
+		Uploaded:     up,
+		DownLimit:    downLimit,
+		UpLimit:      upLimit,
+	}
+	if err := t.GetError(); err != nil {
+		bag.Error = t.redactError(err)
+	}
+
+	if t.Info != nil {
+		bag.PieceSize = t.Info.PieceSize
+		bag.FileSize = t.Info.FileSize
+		bag.HeaderSize = t.Info.HeaderSize
+		bag.PiecesNum = t.PiecesNum()
+	}
+
+	if t.Header != nil {
+		bag.FilesCount = t.Header.FilesCount
+		for i := uint32(0); i < t.Header.FilesCount; i++ {
+			f, err := t.GetFileOffsetsByID(i)
+			if err != nil {
+				continue
+			}
+			bag.Files = append(bag.Files, debugFile{
+				Index:           f.Index,
+				NameHash:        anonymize(key, f.Name),
+				Size:            f.Size,
+				FromPiece:       f.FromPiece,
+				ToPiece:         f.ToPiece,
+				FromPieceOffset: f.FromPieceOffset,
+				ToPieceOffset:   f.ToPieceOffset,
+			})
+		}
+	}
+
+	var peers []debugPeer
+	for id, p := range t.GetPeers() {
+		peers = append(peers, debugPeer{
+			IDHash:        anonymize(key, id),
+			AddrHash:      anonymize(key, p.Addr),
+			LastSeenAt:    p.LastSeenAt,
+			Downloaded:    p.Downloaded,
+			Uploaded:      p.Uploaded,
+			DownloadSpeed: p.GetDownloadSpeed(),
+			UploadSpeed:   p.GetUploadSpeed(),
+		})
+	}
+
+	z := zip.NewWriter(w)
+
+	writeJSON := func(name string, v any) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	if err := writeJSON("bag.json", bag); err != nil {
+		return fmt.Errorf("failed to write bag info: %w", err)
+	}
+	if err := writeJSON("events.json", t.GetRecentEvents()); err != nil {
+		return fmt.Errorf("failed to write events: %w", err)
+	}
+	if err := writeJSON("peers.json", peers); err != nil {
+		return fmt.Errorf("failed to write peers: %w", err)
+	}
+
+	if t.Info != nil {
+		f, err := z.Create("pieces_mask.bin")
+		if err != nil {
+			return fmt.Errorf("failed to write pieces mask: %w", err)
+		}
+		if _, err = f.Write(t.PiecesMask()); err != nil {
+			return fmt.Errorf("failed to write pieces mask: %w", err)
+		}
+	}
+
+	return z.Close()
+}
+
+// eventsKey - salts peer ids in events log, it is random per process, so hashes cannot be reversed
+// by trying all addresses or known ids, but the same peer can be followed in events
+var eventsKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("failed to generate events key: " + err.Error())
+	}
+	return key
+}()
+
+// anonymize - keyed hash of s, without key short values such as ip:port are easily brute-forced
+func anonymize(key []byte, s string) string {
+	if s == "" {
+		return ""
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
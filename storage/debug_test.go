@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTorrent_DebugDumpWithoutPaths(t *testing.T) {
+	dir := t.TempDir()
+	tor := NewTorrent(dir, nil, nil)
+	tor.BagID = make([]byte, 32)
+
+	file := filepath.Join(dir, "bag", "secret-name.txt")
+	_, openErr := os.Open(file)
+	tor.logEvent(EventErr, fmt.Errorf("failed to store piece 1: %w", openErr))
+	tor.logEvent("LOCAL_DATA_MISMATCH", fmt.Errorf("file %s is not matching bag", file))
+	tor.lastErr = fmt.Errorf("failed to read piece: %w", &os.PathError{Op: "read", Path: "/mnt/other/secret-name.txt", Err: os.ErrClosed})
+
+	var buf bytes.Buffer
+	if err := tor.WriteDebugDump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all strings.Builder
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		all.Write(data)
+	}
+
+	dump := all.String()
+	for _, s := range []string{dir, "secret-name", "/mnt/other"} {
+		if strings.Contains(dump, s) {
+			t.Fatalf("dump contains %q:\n%s", s, dump)
+		}
+	}
+	if !strings.Contains(dump, "failed to store piece 1") || !strings.Contains(dump, "failed to read piece") {
+		t.Fatalf("errors are not in dump:\n%s", dump)
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"encoding/hex"
+	"sort"
+)
+
+// TorrentDescription - detailed snapshot of bag, with files and peers, for bag details views
+type TorrentDescription struct {
+	BagID       string
+	Description string
+	// HeaderLoaded - false when bag info and header are not yet fetched from peers,
+	// only peers are known in this case
+	HeaderLoaded bool
+	DirName      string
+	// Size - bytes of all files in bag, without header
+	Size       uint64
+	PieceSize  uint32
+	PiecesNum  uint32
+	HeaderSize uint64
+	Files      []FileDescription
+	// Peers - sorted by download speed, fastest first
+	Peers []PeerDescription
+}
+
+type FileDescription struct {
+	Index uint32
+	Name  string
+	Size  uint64
+	// Downloaded - bytes of file which are in downloaded pieces
+	Downloaded uint64
+	// Active - file is selected for download
+	Active bool
+}
+
+type PeerDescription struct {
+	ID            string
+	Addr          string
+	Client        string
+	DownloadSpeed uint64
+	UploadSpeed   uint64
+	Downloaded    uint64
+	Uploaded      uint64
+}
+
+// Describe - returns bag metadata, files with their completion and connected peers
+func (t *Torrent) Describe() TorrentDescription {
+	d := TorrentDescription{
+		BagID: hex.EncodeToString(t.BagID),
+	}
+
+	for id, p := range t.GetPeers() {
+		d.Peers = append(d.Peers, PeerDescription{
+			ID:            id,
+			Addr:          p.Addr,
+			Client:        p.Client,
+			DownloadSpeed: p.GetDownloadSpeed(),
+			UploadSpeed:   p.GetUploadSpeed(),
+			Downloaded:    p.Downloaded,
+			Uploaded:      p.Uploaded,
+		})
+	}
+	sort.Slice(d.Peers, func(i, j int) bool {
+		if d.Peers[i].DownloadSpeed != d.Peers[j].DownloadSpeed {
+			return d.Peers[i].DownloadSpeed > d.Peers[j].DownloadSpeed
+		}
+		return d.Peers[i].ID < d.Peers[j].ID
+	})
+
+	if t.Info == nil {
+		return d
+	}
+	d.Description = t.Info.Description.Value
+	d.PieceSize = t.Info.PieceSize
+	d.PiecesNum = t.PiecesNum()
+	d.HeaderSize = t.Info.HeaderSize
+	d.Size = t.Info.FileSize - t.Info.HeaderSize
+
+	if t.Header == nil {
+		return d
+	}
+	d.HeaderLoaded = true
+	d.DirName = string(t.Header.DirName)
+
+	active := map[uint32]bool{}
+	downloadAll := t.IsDownloadAll()
+	if !downloadAll {
+		for _, id := range t.GetActiveFilesIDs() {
+			active[id] = true
+		}
+	}
+
+	mask := t.PiecesMask()
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		f, err := t.GetFileOffsetsByID(i)
+		if err != nil {
+			continue
+		}
+
+		from := uint64(f.FromPiece)*uint64(t.Info.PieceSize) + uint64(f.FromPieceOffset)
+		_, have := t.rangeProgress(mask, from, from+f.Size)
+		d.Files = append(d.Files, FileDescription{
+			Index:      f.Index,
+			Name:       f.Name,
+			Size:       f.Size,
+			Downloaded: have,
+			Active:     downloadAll || active[i],
+		})
+	}
+	return d
+}
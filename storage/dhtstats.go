@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"github.com/xssnick/tonutils-go/adnl/dht"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	DHTQueryFindOverlayNodes  = "find_overlay_nodes"
+	DHTQueryStoreOverlayNodes = "store_overlay_nodes"
+	DHTQueryStoreAddress      = "store_address"
+	DHTQueryFindAddresses     = "find_addresses"
+)
+
+type DHTQueryStats struct {
+	Total  uint64
+	Failed uint64
+}
+
+type dhtCounter struct {
+	total  uint64
+	failed uint64
+}
+
+func newDHTCounters() map[string]*dhtCounter {
+	return map[string]*dhtCounter{
+		DHTQueryFindOverlayNodes:  {},
+		DHTQueryStoreOverlayNodes: {},
+		DHTQueryStoreAddress:      {},
+		DHTQueryFindAddresses:     {},
+	}
+}
+
+// countDHT - accounts DHT query result, not found value is not a failure
+func (s *Server) countDHT(query string, err error) {
+	c := s.dhtStats[query]
+	atomic.AddUint64(&c.total, 1)
+	if err != nil && !errors.Is(err, dht.ErrDHTValueIsNotFound) {
+		atomic.AddUint64(&c.failed, 1)
+	}
+}
+
+// GetDHTStats - returns number of DHT queries made by server since start, by query type
+func (s *Server) GetDHTStats() map[string]DHTQueryStats {
+	res := make(map[string]DHTQueryStats, len(s.dhtStats))
+	for k, c := range s.dhtStats {
+		res[k] = DHTQueryStats{
+			Total:  atomic.LoadUint64(&c.total),
+			Failed: atomic.LoadUint64(&c.failed),
+		}
+	}
+	return res
+}
+
+// GetDHTNodesNum - returns size of routing table of DHT client, 0 when it is unknown
+func (s *Server) GetDHTNodesNum() int {
+	if s.dht == nil {
+		return 0
+	}
+	return dhtTableSize(s.dht)
+}
+
+// dhtTableSize - dht client doesn't export its known nodes, so they are counted by its fields,
+// under its lock. When fields are changed in new version of client, 0 is returned.
+func dhtTableSize(c *dht.Client) int {
+	v := reflect.ValueOf(c).Elem()
+	nodes, mx := v.FieldByName("knownNodes"), v.FieldByName("mx")
+	if nodes.Kind() != reflect.Map || !mx.IsValid() || mx.Type() != reflect.TypeOf(sync.RWMutex{}) {
+		return 0
+	}
+
+	lock := (*sync.RWMutex)(unsafe.Pointer(mx.UnsafeAddr()))
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return nodes.Len()
+}
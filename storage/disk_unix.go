@@ -0,0 +1,37 @@
+//go:build !windows
+
+package storage
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// diskID - returns identifier of device which contains the path
+func diskID(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return path
+	}
+	return fmt.Sprintf("dev:%d", uint64(st.Dev))
+}
+
+// freeSpace - returns number of bytes available for unprivileged user on device which contains the path
+func freeSpace(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// allocatedSize - returns number of bytes which file really takes on disk, sparse files take less than their size
+func allocatedSize(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Blocks) * 512, nil
+}
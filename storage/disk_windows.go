@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// diskID - returns volume name of the path, like C:
+func diskID(path string) string {
+	if v := filepath.VolumeName(path); v != "" {
+		return strings.ToUpper(v)
+	}
+	return path
+}
+
+// freeSpace - returns number of bytes available for current user on volume which contains the path
+func freeSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var free uint64
+	if err = windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
+
+const invalidFileSize = 0xFFFFFFFF
+
+var procGetCompressedFileSize = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetCompressedFileSizeW")
+
+// allocatedSize - returns number of bytes which file really takes on disk, sparse files take less than their size
+func allocatedSize(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var high uint32
+	low, _, err := procGetCompressedFileSize.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&high)))
+	if uint32(low) == invalidFileSize && err != windows.ERROR_SUCCESS {
+		return 0, err
+	}
+	return uint64(high)<<32 | uint64(uint32(low)), nil
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotEnoughSpace - disk has not enough free space for data of bag which is going to be downloaded
+var ErrNotEnoughSpace = errors.New("not enough disk space")
+
+// DiskSpaceReserve - free space which should be left on disk after download, so other writes, like db, are not failing
+var DiskSpaceReserve uint64 = 64 << 20
+
+// PreallocateFiles - files of bag are allocated with final size before download, to avoid fragmentation
+// and failures on full disk in the middle of download
+var PreallocateFiles = false
+
+// checkDiskSpace - fails when disk of bag has less free space than required for missing pieces,
+// it is checked only for files store, other stores are not on local disk
+func (t *Torrent) checkDiskSpace(list []fileInfo, missingPieces int) error {
+	if !t.isFilesStore() || len(list) == 0 {
+		return nil
+	}
+
+	// pieces can be partially downloaded, so need is limited by size of files too,
+	// and space which files already take on disk, like preallocated on previous start, is not needed again.
+	// Allocated size is used, files can be sparse, when they are truncated or written out of order.
+	var filesSize uint64
+	for _, f := range list {
+		size := f.info.Size
+		path := t.writeFilePath(f.path)
+		if st, err := os.Stat(path); err == nil && st.Mode().IsRegular() {
+			if allocated, err := allocatedSize(path); err == nil {
+				if allocated >= size {
+					continue
+				}
+				size -= allocated
+			}
+		}
+		filesSize += size
+	}
+	need := uint64(missingPieces) * uint64(t.Info.PieceSize)
+	if filesSize < need {
+		need = filesSize
+	}
+	if need == 0 {
+		// files have their final size already
+		return nil
+	}
+
+	dirs := []string{t.Path}
+	if StagingDir != "" {
+		// incomplete files are downloaded to staging dir first
+		dirs = append(dirs, StagingDir)
+	}
+
+	for _, dir := range dirs {
+		dir = existingParent(dir)
+		free, err := freeSpace(dir)
+		if err != nil {
+			Log.Debug("failed to get free disk space", compStorage, BagAttr(t.BagID), "dir", dir, ErrAttr(err))
+			continue
+		}
+		if free < need+DiskSpaceReserve {
+			return fmt.Errorf("%w: %s is required in %s, but only %s is free", ErrNotEnoughSpace, ToSz(need+DiskSpaceReserve), dir, ToSz(free))
+		}
+	}
+	return nil
+}
+
+// preallocate - allocates files which are going to be downloaded with their final size, files which are already
+// bigger or equal are not touched. Failures are not critical, files are still written as usual.
+func (t *Torrent) preallocate(list []fileInfo) {
+	for _, f := range list {
+		if f.info.Size == 0 {
+			continue
+		}
+
+		path := t.writeFilePath(f.path)
+		if err := t.checkSandboxed(path); err != nil {
+			continue
+		}
+
+		if err := allocateFile(path, int64(f.info.Size)); err != nil {
+			Log.Warn("failed to preallocate file", compStorage, BagAttr(t.BagID), "file", f.path, ErrAttr(err))
+		}
+	}
+}
+
+func allocateFile(path string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	fl, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	st, err := fl.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() >= size {
+		return nil
+	}
+	return allocate(fl, size)
+}
+
+// existingParent - returns path or its closest parent which exists
+func existingParent(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	for {
+		if _, err = os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllocatedSize_Sparse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sparse")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the same as allocation fallback, file gets size without data
+	if err = f.Truncate(64 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	allocated, err := allocatedSize(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated >= 64<<20 {
+		t.Skip("file system has no sparse files support")
+	}
+
+	if _, err = f.WriteAt(make([]byte, 1<<20), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if allocated, err = allocatedSize(path); err != nil {
+		t.Fatal(err)
+	}
+	if allocated < 1<<20 || allocated >= 64<<20 {
+		t.Fatalf("allocated %d bytes, expected about 1MB", allocated)
+	}
+}
@@ -3,9 +3,10 @@ package storage
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"sort"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -54,8 +55,12 @@ func (t *Torrent) prepareDownloader(ctx context.Context) error {
 
 		if t.downloader == nil || !t.downloader.IsActive() {
 			t.downloader, err = t.connector.CreateDownloader(ctx, t, 5, 12)
+			if errors.Is(err, ErrMaliciousBag) {
+				// retry will not help, header is verified by bag id
+				return err
+			}
 			if err != nil {
-				Logger("bag information not resolved: %s", err.Error())
+				Log.Debug("bag information is not resolved", compStorage, BagAttr(t.BagID), ErrAttr(err))
 				time.Sleep(1 * time.Second)
 				continue
 			}
@@ -82,29 +87,72 @@ func (t *Torrent) startDownload(report func(Event)) error {
 	ctx, stop = context.WithCancel(t.globalCtx)
 	t.stopDownload = stop
 
+	events := report
+	report = func(event Event) {
+		switch event.Name {
+		case EventPieceDownloaded, EventProgress:
+			// too many of them
+		case EventDone, EventFileDownloaded:
+			// without local paths
+			t.logEvent(event.Name)
+		default:
+			t.logEvent(event.Name, event.Value)
+		}
+		events(event)
+	}
+
+	// watchdog tracks progress of the latest download, others were replaced
+	markProgress := func(event Event) {
+		if event.Name == EventPieceDownloaded && t.currentDownloadFlag == &flag {
+			t.markProgress()
+		}
+		report(event)
+	}
+
+	t.wg.Add(1)
 	go func() {
+		defer t.wg.Done()
 		defer func() {
 			if stop != nil {
 				stop()
 			}
 		}()
+		defer t.recoverPanic("download", report)
 
 		piecesMap := map[uint32]bool{}
 		var list []fileInfo
 
 		if t.Header == nil || t.Info == nil {
 			if err := t.prepareDownloader(ctx); err != nil {
-				Logger("failed to prepare downloader for", hex.EncodeToString(t.BagID), "err: ", err.Error())
+				Log.Warn("failed to prepare downloader", compStorage, BagAttr(t.BagID), ErrAttr(err))
 				return
 			}
 
 			// update torrent in db
 			if err := t.db.SetTorrent(t); err != nil {
-				Logger("failed to set torrent in db", hex.EncodeToString(t.BagID), "err: ", err.Error())
+				Log.Error("failed to save bag", compStorage, BagAttr(t.BagID), ErrAttr(err))
 				return
 			}
 		}
 
+		if t.checkSanitizedNames() {
+			return
+		}
+
+		t.mx.Lock()
+		checkExisting := t.checkExisting
+		t.checkExisting = false
+		t.mx.Unlock()
+
+		if checkExisting {
+			if err := t.importLocalData(ctx); err != nil {
+				Log.Info("local data is not imported, downloading it", compStorage, BagAttr(t.BagID), ErrAttr(err))
+				t.logEvent("LOCAL_DATA_MISMATCH", err)
+			} else {
+				t.logEvent("LOCAL_DATA_IMPORTED")
+			}
+		}
+
 		var downloaded uint64
 		rootPath := t.Path + "/" + string(t.Header.DirName)
 
@@ -129,9 +177,24 @@ func (t *Torrent) startDownload(report func(Event)) error {
 				continue
 			}
 
+			localName, err := t.GetLocalFileName(f)
+			if err != nil {
+				continue
+			}
+
 			needFile := false
 
-			if !t.db.GetFS().Exists(rootPath + "/" + info.Name) {
+			if !t.isFilesStore() {
+				// data is not in files, so check only pieces metadata
+				for i := info.FromPiece; i <= info.ToPiece; i++ {
+					if _, err = t.getPiece(i); err != nil {
+						needFile = true
+						piecesMap[i] = true
+						continue
+					}
+					downloaded++
+				}
+			} else if !t.fileExists(localName) {
 				needFile = true
 				for i := info.FromPiece; i <= info.ToPiece; i++ {
 					piecesMap[i] = true
@@ -148,10 +211,17 @@ func (t *Torrent) startDownload(report func(Event)) error {
 					}
 					downloaded++
 				}
+
+				if !needFile {
+					// all pieces are here, but file could stay in staging if we were stopped before commit
+					if err = t.commitFile(localName); err != nil {
+						Log.Error("failed to commit file", compStorage, BagAttr(t.BagID), "file", localName, ErrAttr(err))
+					}
+				}
 			}
 
 			if needFile {
-				list = append(list, fileInfo{info: info, path: info.Name})
+				list = append(list, fileInfo{info: info, path: localName})
 			}
 		}
 
@@ -169,44 +239,89 @@ func (t *Torrent) startDownload(report func(Event)) error {
 		})
 
 		report(Event{Name: EventBagResolved, Value: PiecesInfo{OverallPieces: int(t.PiecesNum()), PiecesToDownload: len(pieces)}})
+		if len(pieces) > 0 && t.IsSeedOnly() {
+			Log.Debug("bag is seed-only, missing pieces are not downloaded", compStorage, BagAttr(t.BagID), "pieces", len(pieces))
+			return
+		}
+
 		if len(pieces) > 0 {
+			if err := t.checkDiskSpace(list, len(pieces)); err != nil {
+				report(Event{Name: EventErr, Value: err})
+				t.stopWithError(err)
+				return
+			}
+			if PreallocateFiles && t.isFilesStore() {
+				t.preallocate(list)
+			}
+		}
+		if len(pieces) > 0 {
+			// bag can be queued when too many bags are downloading at once
+			release, err := t.db.AcquireDownloadSlot(ctx, t)
+			if err != nil {
+				Log.Debug("download slot is not acquired", compStorage, BagAttr(t.BagID), ErrAttr(err))
+				return
+			}
+			defer release()
+
 			if err := t.prepareDownloader(ctx); err != nil {
-				Logger("failed to prepare downloader for", hex.EncodeToString(t.BagID), "err: ", err.Error())
+				Log.Warn("failed to prepare downloader", compStorage, BagAttr(t.BagID), ErrAttr(err))
+				return
+			}
+
+			// wait for memory budget, to not run out of memory when many bags are added at once
+			threads, prefetch := t.downloadWindow()
+			mem := t.estimateDownloadMemory(prefetch, len(pieces))
+			Log.Debug("reserving memory for download", compStorage, BagAttr(t.BagID), "memory", ToSz(mem))
+			if err := t.connector.AcquireDownloadMemory(ctx, mem); err != nil {
+				Log.Warn("failed to reserve memory for download", compStorage, BagAttr(t.BagID), ErrAttr(err))
 				return
 			}
+			defer t.connector.ReleaseDownloadMemory(mem)
+
+			t.markProgress()
+			defer func() {
+				if t.currentDownloadFlag == &flag {
+					atomic.StoreInt64(&t.progressAt, 0)
+				}
+			}()
 
-			if t.downloadOrdered {
-				fetch := NewPreFetcher(ctx, t, t.downloader, report, downloaded, 24, 200, pieces)
+			// ordered mode is writing files one by one, so it is possible only for files store
+			if t.downloadOrdered && t.isFilesStore() {
+				fetch := NewPreFetcher(ctx, t, t.downloader, markProgress, downloaded, threads, prefetch, pieces)
 				defer fetch.Stop()
 
-				if err := writeOrdered(ctx, t, list, piecesMap, rootPath, report, fetch); err != nil {
+				if err := writeOrdered(ctx, t, list, piecesMap, report, fetch); err != nil {
 					report(Event{Name: EventErr, Value: err})
 					return
 				}
 			} else {
-				filesMap := map[uint32]bool{}
-				for _, file := range files {
-					filesMap[file] = true
+				store := t.GetPieceStore()
+				if store == nil {
+					report(Event{Name: EventErr, Value: fmt.Errorf("piece store %q is not registered", t.pieceStore)})
+					return
+				}
+
+				committer := newFilesCommitter(t, list, piecesMap)
+
+				if !t.sequential {
+					// order is not important when pieces are not streamed, so rarest are taken first,
+					// to not stall at the end waiting for pieces which only one peer has
+					sortRarestFirst(pieces, t.piecesAvailability())
 				}
 
 				left := len(pieces)
-				ready := make(chan uint32, 200)
+				ready := make(chan uint32, prefetch)
 				fetch := NewPreFetcher(ctx, t, t.downloader, func(event Event) {
 					if event.Name == EventPieceDownloaded {
 						ready <- event.Value.(uint32)
 					}
-					report(event)
-				}, downloaded, 24, 200, pieces)
+					markProgress(event)
+				}, downloaded, threads, prefetch, pieces)
 				defer fetch.Stop()
 
-				var currentFile FSFile
-				var currentFileId uint32
-
-				defer func() {
-					if currentFile != nil {
-						currentFile.Close()
-					}
-				}()
+				if !t.sequential {
+					go fetch.rarestFirst()
+				}
 
 				for i := 0; i < left; i++ {
 					select {
@@ -223,65 +338,8 @@ func (t *Torrent) startDownload(report func(Event)) error {
 								return fmt.Errorf("failed to get files of piece %d: %w", piece, err)
 							}
 
-							for _, file := range pieceFiles {
-								if !filesMap[file.Index] {
-									continue
-								}
-
-								if err := validateFileName(file.Name, true); err != nil {
-									Logger(fmt.Sprintf("Malicious file '%s' was skipped: %v", file.Name, err))
-									continue
-								}
-
-								err = func() error {
-									if currentFile == nil || currentFileId != file.Index {
-										if currentFile != nil {
-											currentFile.Close()
-										}
-
-										for x := 1; x <= 5; x++ {
-											// we retry because on Windows close file behaves
-											// like async, and it may throw that file still opened
-											currentFile, err = t.db.GetFS().Open(rootPath+"/"+file.Name, OpenModeWrite)
-											if err != nil {
-												Logger(fmt.Errorf("failed to create or open file %s: %w", file.Name, err).Error())
-												time.Sleep(time.Duration(x*50) * time.Millisecond)
-												continue
-											}
-											currentFileId = file.Index
-											break
-										}
-										if err != nil {
-											return fmt.Errorf("failed to create or open file %s: %w", file.Name, err)
-										}
-									}
-
-									notEmptyFile := file.FromPiece != file.ToPiece || file.FromPieceOffset != file.ToPieceOffset
-									if notEmptyFile {
-										fileOff := uint32(0)
-										if file.FromPiece != piece {
-											fileOff = (piece-file.FromPiece)*t.Info.PieceSize - file.FromPieceOffset
-										}
-
-										data := currentPiece
-										if file.ToPiece == piece {
-											data = data[:file.ToPieceOffset]
-										}
-										if file.FromPiece == piece {
-											data = data[file.FromPieceOffset:]
-										}
-
-										_, err = currentFile.WriteAt(data, int64(fileOff))
-										if err != nil {
-											return fmt.Errorf("failed to write file %s: %w", file.Name, err)
-										}
-									}
-
-									return currentFile.Sync()
-								}()
-								if err != nil {
-									return err
-								}
+							if err = store.WritePiece(t, piece, currentPiece); err != nil {
+								return fmt.Errorf("failed to store piece %d: %w", piece, err)
 							}
 
 							err = t.setPiece(piece, &PieceInfo{
@@ -292,7 +350,7 @@ func (t *Torrent) startDownload(report func(Event)) error {
 								return fmt.Errorf("failed to save piece %d to db: %w", piece, err)
 							}
 
-							return nil
+							return committer.pieceStored(pieceFiles)
 						}(e)
 						if err != nil {
 							report(Event{Name: EventErr, Value: err})
@@ -312,6 +370,17 @@ func (t *Torrent) startDownload(report func(Event)) error {
 			Description: t.Info.Description.Value,
 		}})
 
+		if len(pieces) > 0 && len(files) > 0 {
+			t.hooks().OnBagCompleted(t)
+		}
+
+		if len(pieces) > 0 && t.GetHotPieces() > 0 {
+			// pieces which were missing on start are cached now
+			if _, err := t.Preload(); err != nil {
+				Log.Warn("failed to preload hot bag", compStorage, BagAttr(t.BagID), ErrAttr(err))
+			}
+		}
+
 		for id := range t.GetPeers() {
 			peerId, _ := hex.DecodeString(id)
 			t.ResetDownloadPeer(peerId)
@@ -326,23 +395,42 @@ func (t *Torrent) startDownload(report func(Event)) error {
 	return nil
 }
 
-func writeOrdered(ctx context.Context, t *Torrent, list []fileInfo, piecesMap map[uint32]bool, rootPath string, report func(Event), fetch *PreFetcher) error {
+func writeOrdered(ctx context.Context, t *Torrent, list []fileInfo, piecesMap map[uint32]bool, report func(Event), fetch *PreFetcher) error {
+	committer := newFilesCommitter(t, list, piecesMap)
+	savePiece := func(id, startFileIndex uint32, proof []byte) error {
+		err := t.setPiece(id, &PieceInfo{
+			StartFileIndex: startFileIndex,
+			Proof:          proof,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save piece %d to db: %w", id, err)
+		}
+
+		pieceFiles, err := t.GetFilesInPiece(id)
+		if err != nil {
+			return fmt.Errorf("failed to get files of piece %d: %w", id, err)
+		}
+		return committer.pieceStored(pieceFiles)
+	}
+
 	var currentPieceId uint32
 	var pieceStartFileIndex uint32
 	var currentPiece, currentProof []byte
 	for _, off := range list {
 		err := func() error {
-			if strings.Contains(off.path, "..") {
-				Logger("Malicious file with path traversal was skipped: " + off.path)
-				return fmt.Errorf("malicious file")
-			}
 			if err := validateFileName(off.path, true); err != nil {
-				Logger(fmt.Sprintf("Malicious file '%s' was skipped: %v", off.path, err))
+				Log.Warn("malicious file was skipped", compStorage, BagAttr(t.BagID), "file", off.path, ErrAttr(err))
 				return fmt.Errorf("malicious file %q", off.path)
 			}
 
-			f, err := t.db.GetFS().Open(rootPath+"/"+off.path, OpenModeWrite)
-			if err != nil {
+			path := t.writeFilePath(off.path)
+			if err := t.checkSandboxed(path); err != nil {
+				t.rejectMalicious(err)
+				return err
+			}
+
+			f, err := t.db.GetFS().Open(path, OpenModeWrite)
+			if err = t.checkIO(err); err != nil {
 				return fmt.Errorf("failed to create file %s: %w", off.path, err)
 			}
 			defer f.Close()
@@ -358,12 +446,8 @@ func writeOrdered(ctx context.Context, t *Torrent, list []fileInfo, piecesMap ma
 						if currentPiece != nil {
 							fetch.Free(currentPieceId)
 
-							err = t.setPiece(currentPieceId, &PieceInfo{
-								StartFileIndex: pieceStartFileIndex,
-								Proof:          currentProof,
-							})
-							if err != nil {
-								return fmt.Errorf("failed to save piece %d to db: %w", currentPieceId, err)
+							if err = savePiece(currentPieceId, pieceStartFileIndex, currentProof); err != nil {
+								return err
 							}
 						}
 
@@ -393,7 +477,7 @@ func writeOrdered(ctx context.Context, t *Torrent, list []fileInfo, piecesMap ma
 					}
 
 					_, err = f.WriteAt(part, offset)
-					if err != nil {
+					if err = t.checkIO(err); err != nil {
 						return fmt.Errorf("failed to write piece %d for file %s: %w", piece, off.path, err)
 					}
 				}
@@ -410,12 +494,8 @@ func writeOrdered(ctx context.Context, t *Torrent, list []fileInfo, piecesMap ma
 	if currentPiece != nil {
 		fetch.Free(currentPieceId)
 
-		err := t.setPiece(currentPieceId, &PieceInfo{
-			StartFileIndex: pieceStartFileIndex,
-			Proof:          currentProof,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to save piece %d to db: %w", currentPieceId, err)
+		if err := savePiece(currentPieceId, pieceStartFileIndex, currentProof); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const EncryptedPieceStoreName = "encrypted"
+
+// EncryptedPieceStore - keeps each piece as separate file in directory, encrypted with AES-256-GCM,
+// so stored data is opaque for anyone who has access to disk. Key of each bag is derived from master key and bag id,
+// pieces are decrypted on read, so upload and gateway are working as usual.
+type EncryptedPieceStore struct {
+	dir string
+	key []byte
+}
+
+// EncryptionKeyFromPassphrase - derives master key of encrypted store from passphrase using scrypt,
+// salt should be taken from EncryptionSalt of store dir
+func EncryptionKeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("salt should be set")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+// EncryptionSalt - returns salt of encrypted store in dir for passphrase key derivation,
+// it is random for each store, generated on first open and kept next to key check.
+// Store which has key check but no salt is an error, its key can't be derived again
+func EncryptionSalt(dir string) ([]byte, error) {
+	saltPath := filepath.Join(dir, "salt")
+	salt, err := os.ReadFile(saltPath)
+	if err == nil {
+		if len(salt) == 0 {
+			return nil, fmt.Errorf("salt file is empty")
+		}
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, "key-check")); err == nil {
+		// store is created already, new salt would give another key, and its data could not be read
+		return nil, fmt.Errorf("salt file is missing in existing store, restore it from backup")
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dir: %w", err)
+	}
+	salt = make([]byte, 32)
+	if _, err = rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(saltPath, salt, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write salt: %w", err)
+	}
+	return salt, nil
+}
+
+// EncryptionKeyFromNodeKey - derives master key of encrypted store from private key of node,
+// data can be decrypted only while node key is the same
+func EncryptionKeyFromNodeKey(key ed25519.PrivateKey) []byte {
+	mac := hmac.New(sha256.New, key.Seed())
+	mac.Write([]byte("tonutils-storage-encryption"))
+	return mac.Sum(nil)
+}
+
+// NewEncryptedPieceStore - opens store in dir, check value of the key is kept there,
+// so store fails to open with another key instead of returning garbage
+func NewEncryptedPieceStore(dir string, masterKey []byte) (*EncryptedPieceStore, error) {
+	if len(masterKey) != 32 {
+		return nil, fmt.Errorf("master key should be 32 bytes")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dir: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte("check"))
+	check := mac.Sum(nil)
+
+	checkPath := filepath.Join(dir, "key-check")
+	stored, err := os.ReadFile(checkPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read key check: %w", err)
+		}
+		if err = os.WriteFile(checkPath, check, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write key check: %w", err)
+		}
+	} else if !hmac.Equal(stored, check) {
+		return nil, fmt.Errorf("key is not the same as the one data was encrypted with")
+	}
+
+	return &EncryptedPieceStore{
+		dir: dir,
+		key: masterKey,
+	}, nil
+}
+
+func (e *EncryptedPieceStore) bagDir(t *Torrent) string {
+	return filepath.Join(e.dir, hex.EncodeToString(t.BagID))
+}
+
+func (e *EncryptedPieceStore) piecePath(t *Torrent, id uint32) string {
+	return filepath.Join(e.bagDir(t), strconv.FormatUint(uint64(id), 10))
+}
+
+// aead - returns cipher of bag, key is unique for each bag
+func (e *EncryptedPieceStore) aead(t *Torrent) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write(t.BagID)
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pieceAD - piece id is authenticated together with data, so pieces can't be swapped on disk
+func pieceAD(id uint32) []byte {
+	ad := make([]byte, 4)
+	binary.LittleEndian.PutUint32(ad, id)
+	return ad
+}
+
+func (e *EncryptedPieceStore) ReadPiece(t *Torrent, id uint32) ([]byte, error) {
+	data, err := os.ReadFile(e.piecePath(t, id))
+	if err = t.checkIO(err); err != nil {
+		return nil, fmt.Errorf("failed to read piece %d: %w", id, err)
+	}
+
+	gcm, err := e.aead(t)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("piece %d is corrupted", id)
+	}
+
+	res, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], pieceAD(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt piece %d: %w", id, err)
+	}
+	return res, nil
+}
+
+func (e *EncryptedPieceStore) WritePiece(t *Torrent, id uint32, data []byte) error {
+	gcm, err := e.aead(t)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, data, pieceAD(id))
+
+	return t.checkIO(func() error {
+		if err := os.MkdirAll(e.bagDir(t), 0700); err != nil {
+			return err
+		}
+
+		// written to temp file first, so piece is never left partially written
+		path := e.piecePath(t, id)
+		if err := os.WriteFile(path+".tmp", sealed, 0600); err != nil {
+			return err
+		}
+		return os.Rename(path+".tmp", path)
+	}())
+}
+
+func (e *EncryptedPieceStore) VerifyPiece(t *Torrent, id uint32) error {
+	data, err := e.ReadPiece(t, id)
+	if err != nil {
+		return err
+	}
+	return t.VerifyPieceData(id, data)
+}
+
+func (e *EncryptedPieceStore) ListPieces(t *Torrent) ([]uint32, error) {
+	entries, err := os.ReadDir(e.bagDir(t))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	list := make([]uint32, 0, len(entries))
+	for _, en := range entries {
+		id, err := strconv.ParseUint(en.Name(), 10, 32)
+		if err != nil {
+			// temp files of interrupted writes
+			continue
+		}
+		list = append(list, uint32(id))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i] < list[j]
+	})
+	return list, nil
+}
+
+func (e *EncryptedPieceStore) DeletePiece(t *Torrent, id uint32) error {
+	if err := os.Remove(e.piecePath(t, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// dir of bag is removed with its last piece, it fails while there are other pieces
+	_ = os.Remove(e.bagDir(t))
+	return nil
+}
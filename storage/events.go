@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const recentEventsLimit = 100
+
+// BagEvent - notable event in bag life, kept for diagnostics
+type BagEvent struct {
+	At      time.Time
+	Name    string
+	Details string
+}
+
+// logEvent - remembers event in ring of recent bag events, local paths of errors in details are redacted
+func (t *Torrent) logEvent(name string, details ...any) {
+	for i, d := range details {
+		if err, ok := d.(error); ok {
+			details[i] = t.redactError(err)
+		}
+	}
+	e := BagEvent{
+		At:      time.Now(),
+		Name:    name,
+		Details: fmt.Sprint(details...),
+	}
+
+	t.eventsMx.Lock()
+	defer t.eventsMx.Unlock()
+
+	if len(t.events) < recentEventsLimit {
+		t.events = append(t.events, e)
+		return
+	}
+	t.events[t.eventsOff] = e
+	t.eventsOff = (t.eventsOff + 1) % recentEventsLimit
+}
+
+// GetRecentEvents - returns last events of the bag, from the oldest to the newest
+func (t *Torrent) GetRecentEvents() []BagEvent {
+	t.eventsMx.Lock()
+	defer t.eventsMx.Unlock()
+
+	res := make([]BagEvent, 0, len(t.events))
+	res = append(res, t.events[t.eventsOff:]...)
+	res = append(res, t.events[:t.eventsOff]...)
+	return res
+}
+
+// redactError - text of error where local paths are replaced with placeholders,
+// errors are kept in events and debug dumps, which are shared in bug reports
+func (t *Torrent) redactError(err error) string {
+	text := err.Error()
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && pathErr.Path != "" {
+		text = strings.ReplaceAll(text, pathErr.Path, "<path>")
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		text = strings.ReplaceAll(text, linkErr.Old, "<path>")
+		text = strings.ReplaceAll(text, linkErr.New, "<path>")
+	}
+
+	type root struct {
+		path, name string
+	}
+	var roots []root
+	add := func(path, name string) {
+		// root of fs is not replaced, it is part of any path
+		if len(path) > 1 {
+			roots = append(roots, root{path, name})
+			if abs, err := filepath.Abs(path); err == nil && abs != path {
+				roots = append(roots, root{abs, name})
+			}
+		}
+	}
+	add(t.Path, "<bag path>")
+	add(StagingDir, "<staging>")
+	if home, err := os.UserHomeDir(); err == nil {
+		add(home, "~")
+	}
+
+	// nested roots first
+	sort.Slice(roots, func(i, j int) bool {
+		return len(roots[i].path) > len(roots[j].path)
+	})
+	for _, r := range roots {
+		// whole path is replaced, names of bag files are not shared too
+		re := regexp.MustCompile(regexp.QuoteMeta(r.path) + `[^\s"':]*`)
+		text = re.ReplaceAllLiteralString(text, r.name)
+	}
+	return text
+}
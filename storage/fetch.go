@@ -3,7 +3,6 @@ package storage
 import (
 	"context"
 	"fmt"
-	"github.com/pterm/pterm"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,7 +20,9 @@ type PreFetcher struct {
 	pieces     map[uint32]*piecePack
 	tasks      chan uint32
 	piecesList []uint32
-	speed      uint64
+	// requests - pieces which are downloading now
+	requests map[uint32]*inflightPiece
+	speed    uint64
 
 	downloaded uint64
 	report     func(Event)
@@ -49,6 +50,7 @@ func NewPreFetcher(ctx context.Context, torrent *Torrent, downloader TorrentDown
 		downloaded: downloaded,
 		offset:     prefetch - 1,
 		pieces:     map[uint32]*piecePack{},
+		requests:   map[uint32]*inflightPiece{},
 		tasks:      make(chan uint32, prefetch),
 	}
 	ff.ctx, ff.close = context.WithCancel(ctx)
@@ -58,6 +60,7 @@ func NewPreFetcher(ctx context.Context, torrent *Torrent, downloader TorrentDown
 	}
 
 	// go ff.speedometer()
+	go ff.endgame()
 
 	for _, piece := range pieces {
 		// mark pieces as existing
@@ -122,39 +125,80 @@ func (f *PreFetcher) worker() {
 		case <-f.ctx.Done():
 			return
 		case task = <-f.tasks:
-			err := f.torrent.connector.ThrottleDownload(f.ctx, uint64(f.torrent.Info.PieceSize))
-			if err != nil {
-				return
-			}
+		}
+
+		ctx, slot, ok := f.startRequest(task)
+		if !ok {
+			// downloaded by another request in endgame
+			continue
 		}
 
 		for {
-			data, proof, _, _, err := f.downloader.DownloadPieceDetailed(f.ctx, task)
+			data, proof, err := f.downloadRequest(ctx, task, slot)
 			if err == nil {
-				f.mx.Lock()
-				f.pieces[task] = &piecePack{
+				if f.finishRequest(task, &piecePack{
 					data:  data,
 					proof: proof,
+				}) {
+					atomic.AddUint64(&f.downloaded, 1)
+					f.report(Event{Name: EventPieceDownloaded, Value: task})
 				}
-				f.mx.Unlock()
-
-				atomic.AddUint64(&f.downloaded, 1)
-				f.report(Event{Name: EventPieceDownloaded, Value: task})
-
 				break
 			}
 
-			// when error we retry
+			// when error we retry, till piece is downloaded by another request or fetcher is stopped
 			select {
-			case <-f.ctx.Done():
-				return
+			case <-ctx.Done():
 			case <-time.After(300 * time.Millisecond):
-				pterm.Warning.Println("Piece", task, "download error (", err.Error(), "), will retry in 300ms")
+				Log.Warn("piece download failed, will retry in 300ms", compStorage, BagAttr(f.torrent.BagID), "piece", task, ErrAttr(err))
+				continue
 			}
+			break
 		}
 	}
 }
 
+// startRequest - registers request of piece, returns its slot in requests of piece,
+// false when piece is already downloaded
+func (f *PreFetcher) startRequest(piece uint32) (context.Context, int, bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if p, ok := f.pieces[piece]; !ok || p != nil {
+		return nil, 0, false
+	}
+
+	req := f.requests[piece]
+	if req == nil {
+		req = &inflightPiece{startedAt: time.Now(), requests: 1}
+		f.requests[piece] = req
+	}
+
+	ctx, cancel := context.WithCancel(f.ctx)
+	req.cancels = append(req.cancels, cancel)
+	req.peers = append(req.peers, "")
+	return ctx, len(req.peers) - 1, true
+}
+
+// finishRequest - saves downloaded piece and cancels other requests of it, returns false when piece was already saved
+func (f *PreFetcher) finishRequest(piece uint32, pack *piecePack) bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if req := f.requests[piece]; req != nil {
+		for _, cancel := range req.cancels {
+			cancel()
+		}
+		delete(f.requests, piece)
+	}
+
+	if p, ok := f.pieces[piece]; !ok || p != nil {
+		return false
+	}
+	f.pieces[piece] = pack
+	return true
+}
+
 func ToSz(sz uint64) string {
 	switch {
 	case sz < 1024:
@@ -87,6 +87,20 @@ func (f *FSController) acquire(path string) *FDesc {
 	return nil
 }
 
+// Forget - closes cached descriptor of the file, if it exists
+func (f *FSController) Forget(path string) {
+	f.mx.Lock()
+	desc := f.dsc[path]
+	delete(f.dsc, path)
+	f.mx.Unlock()
+
+	if desc != nil {
+		desc.mx.Lock()
+		_ = desc.file.Close()
+		desc.mx.Unlock()
+	}
+}
+
 // clean the oldest and currently not used file
 func (f *FSController) clean() bool {
 	list := make([]*FDesc, 0, len(f.dsc))
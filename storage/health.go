@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DiskErrorsThreshold - after this number of I/O errors in a row on the same disk,
+// disk is marked unhealthy and all bags stored on it are stopped
+var DiskErrorsThreshold = 5
+
+type DiskHealth struct {
+	Disk      string
+	Errors    int
+	Unhealthy bool
+	LastErr   error
+	FailedAt  time.Time
+}
+
+var disks = map[string]*DiskHealth{}
+var disksMx sync.Mutex
+
+// GetDisksHealth - returns state of disks where I/O errors happened
+func GetDisksHealth() []DiskHealth {
+	disksMx.Lock()
+	defer disksMx.Unlock()
+
+	list := make([]DiskHealth, 0, len(disks))
+	for _, d := range disks {
+		list = append(list, *d)
+	}
+	return list
+}
+
+// GetError - returns reason why bag was stopped by storage, for example disk failure, nil when it is ok
+func (t *Torrent) GetError() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.lastErr
+}
+
+func (t *Torrent) disk() string {
+	t.diskOnce.Do(func() {
+		path, err := filepath.Abs(t.Path)
+		if err != nil {
+			path = t.Path
+		}
+
+		// bag dir may be not yet created, so we look for the closest existing parent
+		for {
+			if _, err = os.Stat(path); err == nil {
+				break
+			}
+			parent := filepath.Dir(path)
+			if parent == path {
+				break
+			}
+			path = parent
+		}
+		t.diskId = diskID(path)
+	})
+	return t.diskId
+}
+
+// checkIO - tracks result of I/O operation with bag files, should be called for disk reads and writes.
+// Missing files are not counted, they are handled by download logic.
+func (t *Torrent) checkIO(err error) error {
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	disk := t.disk()
+
+	disksMx.Lock()
+	d := disks[disk]
+	if err == nil {
+		if d != nil && !d.Unhealthy {
+			// errors are counted only in a row
+			delete(disks, disk)
+		}
+		disksMx.Unlock()
+		return nil
+	}
+
+	if d == nil {
+		d = &DiskHealth{Disk: disk}
+		disks[disk] = d
+	}
+	d.Errors++
+	d.LastErr = err
+	d.FailedAt = time.Now()
+
+	becameUnhealthy := !d.Unhealthy && d.Errors >= DiskErrorsThreshold
+	if becameUnhealthy {
+		d.Unhealthy = true
+	}
+	disksMx.Unlock()
+
+	if becameUnhealthy {
+		Log.Error("disk is unhealthy, stopping its bags", compStorage, "disk", disk, "errors_in_row", d.Errors, ErrAttr(err))
+
+		reason := fmt.Errorf("disk %s is unhealthy: %w", disk, err)
+		for _, bag := range t.db.GetAll() {
+			if bag.disk() == disk {
+				Log.Warn("stopping bag because of disk failure", compStorage, BagAttr(bag.BagID))
+				bag.stopWithError(reason)
+			}
+		}
+	}
+	return err
+}
+
+func (t *Torrent) stopWithError(err error) {
+	t.logEvent("STOPPED_BY_ERROR", err)
+
+	t.mx.Lock()
+	t.lastErr = err
+	t.mx.Unlock()
+
+	t.Stop()
+	t.hooks().OnBagError(t, err)
+}
+
+// resetDiskHealth - called when bag is started again by user, who is probably fixed the disk
+func (t *Torrent) resetDiskHealth() {
+	t.lastErr = nil
+
+	disksMx.Lock()
+	delete(disks, t.disk())
+	disksMx.Unlock()
+}
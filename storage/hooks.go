@@ -0,0 +1,44 @@
+package storage
+
+// Hooks - callbacks for applications which are embedding storage, to implement custom policies.
+// Hooks are called synchronously from network and download routines, so they should be fast.
+// Embed NoopHooks to implement only needed callbacks.
+type Hooks interface {
+	// OnPieceVerified - piece was downloaded from peer and its proof is correct
+	OnPieceVerified(t *Torrent, piece uint32, peerId []byte)
+	// OnPeerConnected - peer answered us and is ready to exchange pieces of the bag
+	OnPeerConnected(t *Torrent, peerId []byte, addr string)
+	// OnBagCompleted - all requested files of the bag are downloaded
+	OnBagCompleted(t *Torrent)
+	// OnBagCreated - bag is created from local files, it is not yet saved and started
+	OnBagCreated(t *Torrent)
+	// OnBagError - bag is stopped by storage because of error, like disk failure
+	OnBagError(t *Torrent, err error)
+	// OnBeforeServePiece - called before piece is sent to peer, return false to deny it
+	OnBeforeServePiece(t *Torrent, piece uint32, peerId []byte) bool
+}
+
+type NoopHooks struct{}
+
+func (NoopHooks) OnPieceVerified(t *Torrent, piece uint32, peerId []byte) {}
+
+func (NoopHooks) OnPeerConnected(t *Torrent, peerId []byte, addr string) {}
+
+func (NoopHooks) OnBagCompleted(t *Torrent) {}
+
+func (NoopHooks) OnBagCreated(t *Torrent) {}
+
+func (NoopHooks) OnBagError(t *Torrent, err error) {}
+
+func (NoopHooks) OnBeforeServePiece(t *Torrent, piece uint32, peerId []byte) bool {
+	return true
+}
+
+func (t *Torrent) hooks() Hooks {
+	if t.connector != nil {
+		if h := t.connector.GetHooks(); h != nil {
+			return h
+		}
+	}
+	return NoopHooks{}
+}
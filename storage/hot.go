@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// SetHotPieces - marks bag as hot, its header and first pieces are kept in memory cache after Preload,
+// so the first responses, like pages of sites served by gateway, are not waiting for disk after restart.
+// 0 = bag is not hot.
+func (t *Torrent) SetHotPieces(num uint32) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.hotPieces = num
+}
+
+func (t *Torrent) GetHotPieces() uint32 {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.hotPieces
+}
+
+// Preload - loads pieces of header and first hot pieces of bag into memory cache, returns number of cached pieces.
+// Pieces which are not downloaded yet are skipped. When bag is not hot, cache is dropped.
+func (t *Torrent) Preload() (int, error) {
+	num := t.GetHotPieces()
+	if num == 0 {
+		t.cacheMx.Lock()
+		t.memCache = map[uint32]*Piece{}
+		t.cacheMx.Unlock()
+		return 0, nil
+	}
+
+	if t.Info == nil || t.Header == nil {
+		return 0, fmt.Errorf("bag header is not downloaded yet")
+	}
+
+	hdrPieces := uint32((t.Info.HeaderSize + uint64(t.Info.PieceSize) - 1) / uint64(t.Info.PieceSize))
+	if num < hdrPieces {
+		num = hdrPieces
+	}
+	if num > t.PiecesNum() {
+		num = t.PiecesNum()
+	}
+
+	cache := make(map[uint32]*Piece, num)
+	for i := uint32(0); i < num; i++ {
+		if _, err := t.getPiece(i); err != nil {
+			continue
+		}
+
+		p, err := t.readPiece(i)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read piece %d: %w", i, err)
+		}
+		cache[i] = p
+	}
+
+	t.cacheMx.Lock()
+	t.memCache = cache
+	t.cacheMx.Unlock()
+	return len(cache), nil
+}
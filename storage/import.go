@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+	"io"
+	"os"
+)
+
+// ErrLocalDataMismatch - files in bag directory are not the same as in bag
+var ErrLocalDataMismatch = errors.New("local data is not matching bag")
+
+// SetCheckExisting - when enabled, files which are already in bag directory are hashed after header is downloaded,
+// and when they are matching the bag, all pieces are marked as downloaded, so bag is seeded without downloading data.
+// When files are not matching, bag is downloaded as usual. Active download is restarted to check files.
+func (t *Torrent) SetCheckExisting(check bool) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.checkExisting = check
+
+	if active, _ := t.IsActive(); !check || !active || t.stopDownload == nil {
+		return nil
+	}
+	return t.startDownload(t.stopOnError())
+}
+
+// importLocalData - hashes bag data from local files, pieces are stored only when merkle root of data
+// is equal to bag root hash, because without the tree we cannot verify single pieces
+func (t *Torrent) importLocalData(ctx context.Context) error {
+	if !t.isFilesStore() {
+		return fmt.Errorf("local data can be imported only to files store")
+	}
+
+	headerData, err := tl.Serialize(t.Header, true)
+	if err != nil {
+		return fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	piecesNum := t.PiecesNum()
+	hashes := make([][]byte, 0, piecesNum)
+	startIndexes := make([]uint32, 0, piecesNum)
+
+	block := make([]byte, t.Info.PieceSize)
+	offset := 0
+	var startIndex, filesProcessed uint32
+
+	// same as in CreateTorrent, piece remembers index of file where it starts
+	process := func(rd io.Reader) error {
+		for {
+			if offset == 0 {
+				startIndex = filesProcessed
+
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+				}
+			}
+
+			n, err := rd.Read(block[offset:])
+			offset += n
+			if offset == len(block) {
+				hashes = append(hashes, calcHash(block))
+				startIndexes = append(startIndexes, startIndex)
+				offset = 0
+			}
+
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	if err = process(bytes.NewReader(headerData)); err != nil {
+		return fmt.Errorf("failed to process header: %w", err)
+	}
+
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		info, err := t.GetFileOffsetsByID(i)
+		if err != nil {
+			return err
+		}
+
+		localName, err := t.GetLocalFileName(i)
+		if err != nil {
+			return err
+		}
+
+		if err = func() error {
+			path := t.finalPath(localName)
+			st, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("%w: file %s: %v", ErrLocalDataMismatch, localName, err)
+			}
+			if uint64(st.Size()) != info.Size {
+				return fmt.Errorf("%w: file %s size is %d, expected %d", ErrLocalDataMismatch, localName, st.Size(), info.Size)
+			}
+
+			f, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("failed to open file %s: %w", localName, err)
+			}
+			defer f.Close()
+
+			if err = process(io.LimitReader(f, int64(info.Size))); err != nil {
+				return fmt.Errorf("failed to read file %s: %w", localName, err)
+			}
+			return nil
+		}(); err != nil {
+			return err
+		}
+		filesProcessed++
+	}
+
+	if offset != 0 {
+		hashes = append(hashes, calcHash(block[:offset]))
+		startIndexes = append(startIndexes, startIndex)
+	}
+
+	if uint32(len(hashes)) != piecesNum {
+		return fmt.Errorf("%w: pieces number is %d, expected %d", ErrLocalDataMismatch, len(hashes), piecesNum)
+	}
+
+	tree := buildHashTree(hashes)
+	if !bytes.Equal(tree.Hash(), t.Info.RootHash) {
+		return fmt.Errorf("%w: root hash is different", ErrLocalDataMismatch)
+	}
+
+	for i, idx := range startIndexes {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		err = t.setPiece(uint32(i), &PieceInfo{
+			StartFileIndex: idx,
+			Proof:          t.fastProof(tree, uint32(i), piecesNum).ToBOCWithFlags(false),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save piece %d to db: %w", i, err)
+		}
+	}
+
+	Log.Info("pieces are imported from local files", compStorage, BagAttr(t.BagID), "pieces", piecesNum)
+	return nil
+}
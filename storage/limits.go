@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// SpeedLimitWindow - period over which speed limits are averaged, transfers are not delayed
+// till this period of limit is used, longer window allows bigger short spikes
+var SpeedLimitWindow = 1 * time.Second
+
+// SpeedLimitBurst - bytes allowed above limit at once after idle time, on top of the window,
+// so small reads (like gateway requests) are answered without delay
+var SpeedLimitBurst uint64 = 0
+
+// SetSpeedLimits - sets download and upload speed limits of the bag in bytes per second, 0 = unlimited.
+// Bag limits are applied together with global limits of connector.
+func (t *Torrent) SetSpeedLimits(download, upload uint64) {
+	t.downloadLimit.SetLimit(download)
+	t.uploadLimit.SetLimit(upload)
+}
+
+func (t *Torrent) GetSpeedLimits() (download, upload uint64) {
+	return t.downloadLimit.GetLimit(), t.uploadLimit.GetLimit()
+}
+
+func (t *Torrent) throttleDownload(ctx context.Context, sz uint64) error {
+	if err := t.downloadLimit.Throttle(ctx, sz); err != nil {
+		return err
+	}
+	return t.connector.ThrottleDownload(ctx, sz)
+}
+
+func (t *Torrent) throttleUpload(ctx context.Context, sz uint64) error {
+	if err := t.uploadLimit.Throttle(ctx, sz); err != nil {
+		return err
+	}
+	return t.connector.ThrottleUpload(ctx, sz)
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Log - structured logger of storage, it is used by db, provider and mount packages too.
+// Records have component attribute, and bag and peer ids when they are known, so they can be filtered
+// by log aggregators. Nothing is logged by default.
+var Log = slog.New(discardHandler{})
+
+// Component - attribute with name of subsystem which writes record, like storage, dht or relay
+func Component(name string) slog.Attr {
+	return slog.String("component", name)
+}
+
+// BagAttr - attribute with hex id of bag
+func BagAttr(id []byte) slog.Attr {
+	return slog.String("bag", hex.EncodeToString(id))
+}
+
+// PeerAttr - attribute with hex ADNL id of peer
+func PeerAttr(id []byte) slog.Attr {
+	return slog.String("peer", hex.EncodeToString(id))
+}
+
+// ErrAttr - attribute with error text
+func ErrAttr(err error) slog.Attr {
+	return slog.String("err", err.Error())
+}
+
+// LogPrinter - adapter for libraries with Println-like loggers, like adnl and dht of tonutils-go,
+// their lines are written as debug records of the component
+func LogPrinter(component string) func(v ...any) {
+	return func(v ...any) {
+		if !Log.Enabled(context.Background(), slog.LevelDebug) {
+			return
+		}
+		Log.Debug(strings.TrimSuffix(fmt.Sprintln(v...), "\n"), Component(component))
+	}
+}
+
+var (
+	compStorage = Component("storage")
+	compPeer    = Component("peer")
+	compDHT     = Component("dht")
+	compRelay   = Component("relay")
+	compStandby = Component("standby")
+	compCoSeed  = Component("coseed")
+)
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const MemoryPieceStoreName = "memory"
+
+// MemoryPieceStore - keeps pieces data in memory, useful for tests, caches and short living bags,
+// data is lost on restart, such pieces are downloaded again after Validate.
+type MemoryPieceStore struct {
+	bags map[string]map[uint32][]byte
+	mx   sync.RWMutex
+}
+
+func NewMemoryPieceStore() *MemoryPieceStore {
+	return &MemoryPieceStore{
+		bags: map[string]map[uint32][]byte{},
+	}
+}
+
+func (m *MemoryPieceStore) ReadPiece(t *Torrent, id uint32) ([]byte, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	data, ok := m.bags[string(t.BagID)][id]
+	if !ok {
+		return nil, fmt.Errorf("piece %d is not in memory", id)
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *MemoryPieceStore) WritePiece(t *Torrent, id uint32, data []byte) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	pieces := m.bags[string(t.BagID)]
+	if pieces == nil {
+		pieces = map[uint32][]byte{}
+		m.bags[string(t.BagID)] = pieces
+	}
+	pieces[id] = append([]byte{}, data...)
+	return nil
+}
+
+func (m *MemoryPieceStore) VerifyPiece(t *Torrent, id uint32) error {
+	data, err := m.ReadPiece(t, id)
+	if err != nil {
+		return err
+	}
+	return t.VerifyPieceData(id, data)
+}
+
+func (m *MemoryPieceStore) ListPieces(t *Torrent) ([]uint32, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	list := make([]uint32, 0, len(m.bags[string(t.BagID)]))
+	for id := range m.bags[string(t.BagID)] {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i] < list[j]
+	})
+	return list, nil
+}
+
+func (m *MemoryPieceStore) DeletePiece(t *Torrent, id uint32) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	pieces := m.bags[string(t.BagID)]
+	delete(pieces, id)
+	if len(pieces) == 0 {
+		delete(m.bags, string(t.BagID))
+	}
+	return nil
+}
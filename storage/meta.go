@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+func init() {
+	tl.Register(BagMeta{}, "storage.bagMeta info:bytes header:bytes hashes:bytes = storage.BagMeta")
+}
+
+// BagMetaExt - extension of exported bag metadata files
+const BagMetaExt = ".tonbag"
+
+var ErrNotFullBag = errors.New("not all pieces of bag are downloaded")
+
+// BagMeta - bag info, header and hashes of all pieces, distributed out-of-band (.tonbag files),
+// so bag can be verified offline and downloaded without fetching info and header from peers
+type BagMeta struct {
+	// Info - boc of torrent info cell, its hash is bag id
+	Info   []byte `tl:"bytes"`
+	Header []byte `tl:"bytes"`
+	// Hashes - sha256 of pieces data, 32 bytes each, in order of pieces
+	Hashes []byte `tl:"bytes"`
+}
+
+// ExportMeta - serializes metadata of bag, hashes are taken from proofs of pieces, so all pieces should be stored
+func (t *Torrent) ExportMeta() ([]byte, error) {
+	if t.Info == nil || t.Header == nil {
+		return nil, fmt.Errorf("bag header is not downloaded yet")
+	}
+
+	info, err := tlb.ToCell(t.Info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize info: %w", err)
+	}
+
+	header, err := tl.Serialize(t.Header, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header: %w", err)
+	}
+
+	piecesNum := t.PiecesNum()
+	hashes := make([]byte, 0, 32*int(piecesNum))
+	for i := uint32(0); i < piecesNum; i++ {
+		p, err := t.getPiece(i)
+		if err != nil {
+			return nil, fmt.Errorf("%w: piece %d", ErrNotFullBag, i)
+		}
+
+		proof, err := cell.FromBOC(p.Proof)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proof of piece %d: %w", i, err)
+		}
+
+		hash, err := t.proofBranchHash(proof, i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hash of piece %d from proof: %w", i, err)
+		}
+		hashes = append(hashes, hash...)
+	}
+
+	return tl.Serialize(&BagMeta{
+		Info:   info.ToBOCWithFlags(false),
+		Header: header,
+		Hashes: hashes,
+	}, true)
+}
+
+// ParseBagMeta - parses and verifies metadata offline: info hash is bag id,
+// header and hashes of pieces are matching hashes from info, limits for bags added by id are applied
+func ParseBagMeta(data []byte) (*BagMeta, error) {
+	var meta BagMeta
+	if _, err := tl.Parse(&meta, data, true); err != nil {
+		return nil, fmt.Errorf("failed to parse bag meta: %w", err)
+	}
+
+	if _, _, _, err := meta.verify(); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// BagID - hash of info cell, meta should be verified by ParseBagMeta
+func (m *BagMeta) BagID() []byte {
+	cl, err := cell.FromBOC(m.Info)
+	if err != nil {
+		return nil
+	}
+	return cl.Hash()
+}
+
+func (m *BagMeta) verify() (bagId []byte, _ *TorrentInfo, _ *TorrentHeader, err error) {
+	cl, err := cell.FromBOC(m.Info)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse info boc: %w", err)
+	}
+
+	var info TorrentInfo
+	if err = tlb.LoadFromCell(&info, cl.BeginParse()); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid info cell: %w", err)
+	}
+	if err = checkInfoLimits(&info); err != nil {
+		return nil, nil, nil, fmt.Errorf("bag info rejected: %w", err)
+	}
+
+	if uint64(len(m.Header)) != info.HeaderSize || !bytes.Equal(calcHash(m.Header), info.HeaderHash) {
+		return nil, nil, nil, fmt.Errorf("header is not matching bag info")
+	}
+
+	var header TorrentHeader
+	if _, err = tl.Parse(&header, m.Header, true); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse header: %w", err)
+	}
+	if err = checkHeaderLimits(&header, &info); err != nil {
+		return nil, nil, nil, fmt.Errorf("bag header rejected: %w", err)
+	}
+	if err = validateHeaderNames(&header); err != nil {
+		return nil, nil, nil, err
+	}
+
+	piecesNum := info.FileSize / uint64(info.PieceSize)
+	if info.FileSize%uint64(info.PieceSize) != 0 {
+		piecesNum++
+	}
+	if uint64(len(m.Hashes)) != 32*piecesNum {
+		return nil, nil, nil, fmt.Errorf("hashes of %d pieces are expected, got %d bytes", piecesNum, len(m.Hashes))
+	}
+	if !bytes.Equal(buildHashTree(m.hashes()).Hash(), info.RootHash) {
+		return nil, nil, nil, fmt.Errorf("hashes of pieces are not matching bag info")
+	}
+	return cl.Hash(), &info, &header, nil
+}
+
+func (m *BagMeta) hashes() [][]byte {
+	list := make([][]byte, 0, len(m.Hashes)/32)
+	for i := 0; i+32 <= len(m.Hashes); i += 32 {
+		list = append(list, m.Hashes[i:i+32])
+	}
+	return list
+}
+
+// ApplyMeta - sets info and header of new bag from verified metadata, pieces of header are marked as stored,
+// same as when header is downloaded from peers, so download starts without asking peers for them
+func (t *Torrent) ApplyMeta(m *BagMeta) error {
+	bagId, info, header, err := m.verify()
+	if err != nil {
+		return err
+	}
+	if t.BagID != nil && !bytes.Equal(t.BagID, bagId) {
+		return fmt.Errorf("meta is of another bag")
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.BagID = bagId
+	t.Info = info
+	t.Header = header
+	t.InitMask()
+
+	piecesNum := t.PiecesNum()
+	tree := buildHashTree(m.hashes())
+	hdrPieces := uint32((info.HeaderSize + uint64(info.PieceSize) - 1) / uint64(info.PieceSize))
+	for i := uint32(0); i < hdrPieces; i++ {
+		err = t.setPiece(i, &PieceInfo{
+			StartFileIndex: 0,
+			Proof:          t.fastProof(tree, i, piecesNum).ToBOCWithFlags(false),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store header piece %d: %w", i, err)
+		}
+	}
+	return nil
+}
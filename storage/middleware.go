@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type PieceOp int
+
+const (
+	// PieceFetch - piece is downloaded from peer and verified
+	PieceFetch PieceOp = iota
+	// PieceServe - piece is read from storage to be sent to peer
+	PieceServe
+)
+
+func (o PieceOp) String() string {
+	if o == PieceServe {
+		return "serve"
+	}
+	return "fetch"
+}
+
+// PieceRequest - piece transfer with peer, passed through middlewares of connector
+type PieceRequest struct {
+	Op       PieceOp
+	Torrent  *Torrent
+	Piece    uint32
+	PeerID   []byte
+	PeerAddr string
+}
+
+// PieceHandler - performs piece transfer, returns verified piece for fetch, or piece to send for serve
+type PieceHandler func(ctx context.Context, req *PieceRequest) (*Piece, error)
+
+// Middleware - wraps piece transfers to add policies like limits, allowlists, metrics or logging,
+// without changes in transfer code. To deny transfer return error without calling next.
+type Middleware func(next PieceHandler) PieceHandler
+
+// Chain - combines middlewares, the first one is the outermost
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next PieceHandler) PieceHandler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+// DefaultMiddlewares - policies which are always applied first: serve hook of Hooks and speed limits
+func DefaultMiddlewares() []Middleware {
+	return []Middleware{HooksMiddleware(), RateLimitMiddleware()}
+}
+
+// HooksMiddleware - denies serving of pieces rejected by OnBeforeServePiece hook
+func HooksMiddleware() Middleware {
+	return func(next PieceHandler) PieceHandler {
+		return func(ctx context.Context, req *PieceRequest) (*Piece, error) {
+			if req.Op == PieceServe && !req.Torrent.hooks().OnBeforeServePiece(req.Torrent, req.Piece, req.PeerID) {
+				return nil, fmt.Errorf("piece is not allowed to be served")
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// RateLimitMiddleware - applies download and upload speed limits of bag and connector
+func RateLimitMiddleware() Middleware {
+	return func(next PieceHandler) PieceHandler {
+		return func(ctx context.Context, req *PieceRequest) (*Piece, error) {
+			var err error
+			if req.Op == PieceServe {
+				err = req.Torrent.throttleUpload(ctx, uint64(req.Torrent.Info.PieceSize))
+			} else {
+				err = req.Torrent.throttleDownload(ctx, uint64(req.Torrent.Info.PieceSize))
+			}
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// LogMiddleware - logs every piece transfer with its duration and result using Log at debug level
+func LogMiddleware() Middleware {
+	return func(next PieceHandler) PieceHandler {
+		return func(ctx context.Context, req *PieceRequest) (*Piece, error) {
+			tm := time.Now()
+			p, err := next(ctx, req)
+			if err != nil {
+				Log.Debug("piece operation failed", compStorage, BagAttr(req.Torrent.BagID), PeerAttr(req.PeerID),
+					"op", req.Op.String(), "piece", req.Piece, "took", time.Since(tm), ErrAttr(err))
+				return nil, err
+			}
+			Log.Debug("piece operation done", compStorage, BagAttr(req.Torrent.BagID), PeerAttr(req.PeerID),
+				"op", req.Op.String(), "piece", req.Piece, "took", time.Since(tm))
+			return p, nil
+		}
+	}
+}
+
+// AllowlistMiddleware - transfers pieces only with peers for which allow returns true
+func AllowlistMiddleware(allow func(t *Torrent, peerId []byte) bool) Middleware {
+	return func(next PieceHandler) PieceHandler {
+		return func(ctx context.Context, req *PieceRequest) (*Piece, error) {
+			if !allow(req.Torrent, req.PeerID) {
+				return nil, fmt.Errorf("peer is not allowed")
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// pieceHandler - wraps transfer with middlewares of connector
+func (t *Torrent) pieceHandler(h PieceHandler) PieceHandler {
+	if t.connector == nil {
+		return Chain(DefaultMiddlewares()...)(h)
+	}
+	return t.connector.WrapPieceHandler(h)
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CloseFiles - closes cached descriptors of bag files, should be called on stopped bag before its files are moved,
+// windows is not allowing to rename opened files, and on other systems descriptors would point to old files
+func (t *Torrent) CloseFiles() {
+	if t.Header == nil {
+		return
+	}
+
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		name, err := t.GetLocalFileName(i)
+		if err != nil {
+			continue
+		}
+		fs.Forget(t.finalPath(name))
+		if stagingEnabled() {
+			fs.Forget(t.incompletePath(name))
+		}
+	}
+}
+
+type movedFile struct {
+	from, to string
+	// copied - file was copied to another device, source is removed after the whole bag is moved
+	copied bool
+}
+
+// MoveTo - moves files of bag to another directory, for example to another disk, files are placed to
+// newPath/[bag dir name] as before. Bag is stopped during move and started again after. Files are renamed
+// when possible, otherwise copied, and already moved files are returned back when move fails.
+// New path is persisted in db, then pieces are verified at the new place, corrupted are downloaded again.
+// Progress is reported in bytes of moved files, callback can be nil. Returns ids of corrupted pieces.
+func (t *Torrent) MoveTo(ctx context.Context, newPath string, progressCallback func(moved, total uint64)) ([]uint32, error) {
+	if t.Info == nil || t.Header == nil {
+		return nil, fmt.Errorf("bag header is not downloaded yet")
+	}
+	if name := t.GetPieceStoreName(); name != DefaultPieceStore {
+		return nil, fmt.Errorf("data of bag is kept in piece store %q, not in files", name)
+	}
+
+	newPath, err := filepath.Abs(newPath)
+	if err != nil {
+		return nil, err
+	}
+	oldPath := t.Path
+	if abs, err := filepath.Abs(oldPath); err == nil && abs == newPath {
+		return nil, nil
+	}
+
+	active, _ := t.IsActive()
+	t.StopAndWait()
+	t.CloseFiles()
+
+	resume := func() {
+		if active {
+			if err := t.Resume(); err != nil {
+				Log.Error("failed to resume bag after move", compStorage, BagAttr(t.BagID), ErrAttr(err))
+			}
+		}
+	}
+
+	moved, err := t.moveFiles(ctx, oldPath, newPath, progressCallback)
+	if err != nil {
+		rollbackMove(moved)
+		resume()
+		return nil, err
+	}
+
+	t.Path = newPath
+	resume()
+
+	if err = t.db.SetTorrent(t); err != nil {
+		t.StopAndWait()
+		t.CloseFiles()
+		t.Path = oldPath
+		rollbackMove(moved)
+		resume()
+		return nil, fmt.Errorf("failed to save bag path: %w", err)
+	}
+
+	for _, f := range moved {
+		if f.copied {
+			_ = os.Remove(f.from)
+		}
+		removeEmptyDirs(filepath.Dir(f.from), oldPath)
+	}
+	Log.Info("bag is moved", compStorage, BagAttr(t.BagID), "path", newPath)
+
+	corrupted, _, err := t.ValidateFrom(ctx, 0, nil)
+	if err != nil {
+		return corrupted, fmt.Errorf("bag is moved, but verification failed: %w", err)
+	}
+	return corrupted, nil
+}
+
+// moveFiles - moves downloaded and incomplete files of bag from one root to another
+func (t *Torrent) moveFiles(ctx context.Context, oldPath, newPath string, progressCallback func(moved, total uint64)) ([]movedFile, error) {
+	dir := string(t.Header.DirName)
+
+	var list []movedFile
+	var total uint64
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		name, err := t.GetLocalFileName(i)
+		if err != nil {
+			return nil, err
+		}
+
+		paths := []string{name}
+		if StagingDir == "" && UsePartFiles {
+			// part files are kept near the final ones, staging dir is not related to bag path
+			paths = append(paths, name+PartFileSuffix)
+		}
+		for _, p := range paths {
+			from := oldPath + "/" + dir + "/" + p
+			fi, err := os.Stat(from)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// not downloaded yet
+					continue
+				}
+				return nil, err
+			}
+			total += uint64(fi.Size())
+			list = append(list, movedFile{from: from, to: newPath + "/" + dir + "/" + p})
+		}
+	}
+
+	var moved []movedFile
+	var done uint64
+	for _, f := range list {
+		if err := ctx.Err(); err != nil {
+			return moved, err
+		}
+
+		if _, err := os.Stat(f.to); err == nil {
+			return moved, fmt.Errorf("file %s already exists", f.to)
+		}
+		if err := os.MkdirAll(filepath.Dir(f.to), os.ModePerm); err != nil {
+			return moved, fmt.Errorf("failed to create dir for %s: %w", f.to, err)
+		}
+
+		if err := os.Rename(f.from, f.to); err != nil {
+			// another device, we need to copy
+			if err = copyFile(f.from, f.to); err != nil {
+				return moved, fmt.Errorf("failed to move %s to %s: %w", f.from, f.to, err)
+			}
+			f.copied = true
+		}
+		moved = append(moved, f)
+
+		if fi, err := os.Stat(f.to); err == nil {
+			done += uint64(fi.Size())
+		}
+		if progressCallback != nil {
+			progressCallback(done, total)
+		}
+	}
+	return moved, nil
+}
+
+// rollbackMove - returns moved files back, copies are removed because originals are still in place
+func rollbackMove(moved []movedFile) {
+	for _, f := range moved {
+		if f.copied {
+			_ = os.Remove(f.to)
+		} else if err := os.Rename(f.to, f.from); err != nil {
+			Log.Error("failed to move file back", compStorage, "from", f.to, "to", f.from, ErrAttr(err))
+		}
+	}
+}
+
+// removeEmptyDirs - removes dir and its parents till root while they are empty
+func removeEmptyDirs(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		// fails when dir is not empty
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
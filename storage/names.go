@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type NameCollisionPolicy string
+
+const (
+	// CollisionPolicyAuto - rename colliding files only when bag is stored on case-insensitive filesystem
+	CollisionPolicyAuto NameCollisionPolicy = "auto"
+	// CollisionPolicyRename - always rename files which names differ only by case
+	CollisionPolicyRename NameCollisionPolicy = "rename"
+	// CollisionPolicyOverwrite - keep names as is, colliding files will overwrite each other
+	CollisionPolicyOverwrite NameCollisionPolicy = "overwrite"
+)
+
+type NormalizationPolicy string
+
+const (
+	NormalizationNone NormalizationPolicy = "none"
+	NormalizationNFC  NormalizationPolicy = "nfc"
+	NormalizationNFD  NormalizationPolicy = "nfd"
+)
+
+// FileNameNormalization - unicode form of file names, used for bag creation and for names of downloaded files on disk.
+// macOS tends to give names in NFD, linux and windows in NFC, so to make bags round-trip we should keep one form.
+var FileNameNormalization = NormalizationNone
+
+// FileNameCollisionPolicy defines how we store files of bag which names are equal case-insensitively,
+// for example 'Readme.md' and 'README.md' from bag created on linux, when we are downloading on macOS or Windows.
+var FileNameCollisionPolicy = CollisionPolicyAuto
+
+func ParseNameCollisionPolicy(s string) (NameCollisionPolicy, error) {
+	switch p := NameCollisionPolicy(strings.ToLower(s)); p {
+	case "":
+		return CollisionPolicyAuto, nil
+	case CollisionPolicyAuto, CollisionPolicyRename, CollisionPolicyOverwrite:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown file name collision policy %q", s)
+}
+
+func ParseNormalizationPolicy(s string) (NormalizationPolicy, error) {
+	switch p := NormalizationPolicy(strings.ToLower(s)); p {
+	case "":
+		return NormalizationNone, nil
+	case NormalizationNone, NormalizationNFC, NormalizationNFD:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown file name normalization policy %q", s)
+}
+
+func normalizeFileName(name string) string {
+	switch FileNameNormalization {
+	case NormalizationNFC:
+		return norm.NFC.String(name)
+	case NormalizationNFD:
+		return norm.NFD.String(name)
+	}
+	return name
+}
+
+// validateNameCodePoints - rejects names which cannot be safely represented on disk or can mislead user
+func validateNameCodePoints(name string) error {
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("name is not valid utf-8")
+	}
+
+	for _, r := range name {
+		switch {
+		case unicode.IsControl(r):
+			return fmt.Errorf("name cannot contain control character %U", r)
+		case r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+			// bidi overrides can hide real extension of the file
+			return fmt.Errorf("name cannot contain bidirectional control character %U", r)
+		case r == utf8.RuneError:
+			return fmt.Errorf("name cannot contain replacement character")
+		}
+	}
+	return nil
+}
+
+// GetLocalFileName - returns name relative to bag dir, under which file is stored on disk.
+// It can differ from the name in header when file is renamed because of collision.
+func (t *Torrent) GetLocalFileName(index uint32) (string, error) {
+	if err := t.calcLocalNames(); err != nil {
+		return "", err
+	}
+
+	if int(index) >= len(t.localNames) {
+		return "", ErrFileNotExist
+	}
+	return t.localNames[index], nil
+}
+
+func (t *Torrent) calcLocalNames() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.localNames != nil {
+		return nil
+	}
+
+	if t.Header == nil {
+		return fmt.Errorf("header is not loaded")
+	}
+
+	if t.sourceNames != nil {
+		// created bag, files are already on disk
+		if uint32(len(t.sourceNames)) != t.Header.FilesCount {
+			return fmt.Errorf("number of source names is not equal to number of files")
+		}
+		t.localNames = t.sourceNames
+		return nil
+	}
+
+	rename := false
+	switch FileNameCollisionPolicy {
+	case CollisionPolicyRename:
+		rename = true
+	case CollisionPolicyAuto:
+		rename = isCaseInsensitiveDir(filepath.Join(t.Path, string(t.Header.DirName)))
+	}
+
+	names := make([]string, t.Header.FilesCount)
+	used := map[string]bool{}
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		nameFrom := uint64(0)
+		if i > 0 {
+			nameFrom = t.Header.NameIndex[i-1]
+		}
+		if nameFrom > t.Header.NameIndex[i] || uint64(len(t.Header.Names)) < t.Header.NameIndex[i] {
+			return fmt.Errorf("corrupted header, too short names data")
+		}
+		origName := normalizeFileName(string(t.Header.Names[nameFrom:t.Header.NameIndex[i]]))
+
+		name := origName
+		if rename {
+			for n := 1; used[collisionKey(name)]; n++ {
+				name = collisionName(origName, n)
+			}
+			used[collisionKey(name)] = true
+		}
+		names[i] = name
+	}
+
+	t.localNames = names
+	return nil
+}
+
+// SetSourceNames - sets names of files of created bag on disk, which differ from names in header,
+// nil when they are equal. Should be set before files are accessed.
+func (t *Torrent) SetSourceNames(names []string) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.sourceNames = names
+	t.localNames = nil
+}
+
+func (t *Torrent) GetSourceNames() []string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.sourceNames
+}
+
+// collisionKey - case and normalization insensitive form of name, as filesystems of macOS and windows compare it
+func collisionKey(name string) string {
+	return strings.ToLower(norm.NFC.String(name))
+}
+
+// collisionName - adds number to the file name, 'dir/file.txt' -> 'dir/file (1).txt'
+func collisionName(name string, n int) string {
+	dir, file := path.Split(name)
+	ext := path.Ext(file)
+	if ext == file {
+		// hidden file without extension, like '.env'
+		ext = ""
+	}
+	return fmt.Sprintf("%s%s (%d)%s", dir, strings.TrimSuffix(file, ext), n, ext)
+}
+
+// isCaseInsensitiveDir - checks filesystem behaviour by creating probe file in the closest existing parent dir
+func isCaseInsensitiveDir(dir string) bool {
+	for {
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	f, err := os.CreateTemp(dir, ".tonutils-case-probe-")
+	if err != nil {
+		// cannot check, assume by os
+		return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	}
+	name := f.Name()
+	_ = f.Close()
+	defer os.Remove(name)
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(name), strings.ToUpper(filepath.Base(name))))
+	return err == nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/hex"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,6 +19,10 @@ type PeerInfo struct {
 	LastSeenAt time.Time
 	Uploaded   uint64
 	Downloaded uint64
+	// Client - name and version of peer's implementation, empty when peer not reported it
+	Client string
+	// AnnounceData - application-defined data of the bag reported by peer
+	AnnounceData []byte
 
 	peer          *storagePeer
 	uploadSpeed   *speedInfo
@@ -48,6 +53,7 @@ func (t *Torrent) UpdateDownloadedPeer(peer *storagePeer, bytes uint64) {
 
 	p := t.touchPeer(peer)
 	p.Downloaded += bytes
+	atomic.AddUint64(&t.sessionDownloaded, bytes)
 }
 
 func (t *Torrent) RemovePeer(id []byte) {
@@ -85,6 +91,12 @@ func (t *Torrent) UpdateUploadedPeer(peer *storagePeer, bytes uint64) {
 
 	p := t.touchPeer(peer)
 	p.Uploaded += bytes
+	atomic.AddUint64(&t.sessionUploaded, bytes)
+}
+
+// GetSessionTransferred - returns bytes downloaded and uploaded for this bag since process start
+func (t *Torrent) GetSessionTransferred() (downloaded, uploaded uint64) {
+	return atomic.LoadUint64(&t.sessionDownloaded), atomic.LoadUint64(&t.sessionUploaded)
 }
 
 func (t *Torrent) touchPeer(peer *storagePeer) *PeerInfo {
@@ -103,6 +115,8 @@ func (t *Torrent) touchPeer(peer *storagePeer) *PeerInfo {
 	}
 	p.peer = peer
 	p.Addr = peer.nodeAddr
+	p.Client = peer.getClientName()
+	p.AnnounceData = peer.getAnnounceData()
 	p.LastSeenAt = time.Now()
 	return p
 }
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// ErrPeerNotConnected - peer is not connected for the bag
+var ErrPeerNotConnected = errors.New("peer is not connected")
+
+// ErrNodeBanned - node is banned by operator for all bags
+var ErrNodeBanned = errors.New("node is banned")
+
+// BanList - persistent list of nodes banned by operator, can be implemented by Storage
+type BanList interface {
+	GetBannedNodes() ([][]byte, error)
+	SetNodeBanned(adnlID []byte, banned bool) error
+}
+
+// DropPeer - disconnects peer from the bag and bans it for PeerBanDuration, so it will not be connected again
+func (t *Torrent) DropPeer(id []byte) error {
+	p := t.getStoragePeer(id)
+	if p == nil {
+		return ErrPeerNotConnected
+	}
+
+	t.banPeer(id, fmt.Errorf("dropped by operator"))
+	p.Close()
+	return nil
+}
+
+// ReconnectPeer - closes connection with peer and clears its ban,
+// peers found using DHT or other peers are connected again in a few seconds,
+// peers which were connected to us can only reconnect themselves.
+func (t *Torrent) ReconnectPeer(id []byte) error {
+	t.UnbanPeer(id)
+
+	p := t.getStoragePeer(id)
+	if p == nil {
+		return ErrPeerNotConnected
+	}
+	p.Close()
+	return nil
+}
+
+// UnbanPeer - clears ban of peer for the bag, peer will be connected again when it is known
+func (t *Torrent) UnbanPeer(id []byte) bool {
+	t.peersMx.Lock()
+	defer t.peersMx.Unlock()
+
+	strId := hex.EncodeToString(id)
+	if _, ok := t.bannedPeers[strId]; !ok {
+		return false
+	}
+	delete(t.bannedPeers, strId)
+	return true
+}
+
+func (t *Torrent) getStoragePeer(id []byte) *storagePeer {
+	t.peersMx.RLock()
+	defer t.peersMx.RUnlock()
+
+	if p := t.peers[hex.EncodeToString(id)]; p != nil {
+		return p.peer
+	}
+	return nil
+}
+
+// GetBannedPeers - returns ids of banned peers of the bag with time till they are banned
+func (t *Torrent) GetBannedPeers() map[string]time.Time {
+	t.peersMx.RLock()
+	defer t.peersMx.RUnlock()
+
+	now := time.Now()
+	res := make(map[string]time.Time, len(t.bannedPeers))
+	for id, till := range t.bannedPeers {
+		if till.After(now) {
+			res[id] = till
+		}
+	}
+	return res
+}
+
+// GetPiecesNum - number of pieces of the bag which peer reported it has
+func (p *PeerInfo) GetPiecesNum() uint32 {
+	if p.peer == nil {
+		return 0
+	}
+
+	p.peer.piecesMx.RLock()
+	defer p.peer.piecesMx.RUnlock()
+
+	return uint32(len(p.peer.hasPieces))
+}
+
+// AddPeer - connects node for the bag by known address, when it cannot be discovered using DHT.
+// Address is pinned as static, so it is used for reconnects too. Key of node is taken from static peers,
+// known nodes of bag, cached addresses or DHT, so it can be pinned using AddStaticPeer before, when it is known.
+func (s *Server) AddPeer(ctx context.Context, t *Torrent, addr string, adnlID []byte) error {
+	if bytes.Equal(adnlID, s.gate.GetID()) {
+		return fmt.Errorf("it is our node")
+	}
+	if s.IsNodeBanned(adnlID) {
+		return ErrNodeBanned
+	}
+
+	key, err := s.findNodeKey(ctx, t, adnlID)
+	if err != nil {
+		return fmt.Errorf("failed to find key of node: %w", err)
+	}
+	if err = s.AddStaticPeer(key, addr); err != nil {
+		return err
+	}
+	if t.GetPeer(adnlID) != nil {
+		return nil
+	}
+
+	node := &overlay.Node{
+		ID:      adnl.PublicKeyED25519{Key: key},
+		Overlay: t.OverlayKey(),
+	}
+	stNode, err := s.connectToNode(ctx, t, adnlID, node)
+	if err != nil {
+		return err
+	}
+
+	t.peersMx.Lock()
+	known := t.knownNodes[hex.EncodeToString(adnlID)] != nil
+	if !known {
+		t.knownNodes[hex.EncodeToString(adnlID)] = node
+	}
+	t.peersMx.Unlock()
+
+	if !known {
+		// node is connected again after disconnect, same as nodes found using DHT
+		go func() {
+			<-stNode.globalCtx.Done()
+			s.nodeConnector(adnlID, t, node, 1)
+		}()
+	}
+
+	Log.Info("peer added by operator", compStorage, BagAttr(t.BagID), PeerAttr(adnlID), "addr", addr)
+	return nil
+}
+
+func (s *Server) findNodeKey(ctx context.Context, t *Torrent, adnlID []byte) (ed25519.PublicKey, error) {
+	if sp := s.getStaticPeer(adnlID); sp != nil {
+		return sp.key, nil
+	}
+
+	t.peersMx.RLock()
+	node := t.knownNodes[hex.EncodeToString(adnlID)]
+	t.peersMx.RUnlock()
+	if node != nil {
+		if id, ok := node.ID.(adnl.PublicKeyED25519); ok {
+			return id.Key, nil
+		}
+	}
+
+	_, key, _, err := s.resolveNodeAddress(ctx, adnlID)
+	return key, err
+}
+
+// BanNode - disconnects node from all bags and rejects its connections till it is unbanned
+func (s *Server) BanNode(adnlID []byte) error {
+	if list, ok := s.store.(BanList); ok {
+		if err := list.SetNodeBanned(adnlID, true); err != nil {
+			return err
+		}
+	}
+
+	s.mx.Lock()
+	s.bannedNodes[hex.EncodeToString(adnlID)] = true
+	s.mx.Unlock()
+
+	if conn := s.GetPeerIfActive(adnlID); conn != nil {
+		conn.mx.RLock()
+		peers := make([]*storagePeer, 0, len(conn.usedByBags))
+		for _, p := range conn.usedByBags {
+			peers = append(peers, p)
+		}
+		conn.mx.RUnlock()
+
+		for _, p := range peers {
+			p.Close()
+		}
+		conn.adnl.Close()
+	}
+
+	Log.Info("node is banned by operator", compStorage, PeerAttr(adnlID))
+	return nil
+}
+
+// UnbanNode - clears ban of node, it will be connected again when it is known for bags
+func (s *Server) UnbanNode(adnlID []byte) error {
+	if list, ok := s.store.(BanList); ok {
+		if err := list.SetNodeBanned(adnlID, false); err != nil {
+			return err
+		}
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.bannedNodes, hex.EncodeToString(adnlID))
+	return nil
+}
+
+func (s *Server) IsNodeBanned(adnlID []byte) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.bannedNodes[hex.EncodeToString(adnlID)]
+}
+
+func (s *Server) GetBannedNodes() [][]byte {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	list := make([][]byte, 0, len(s.bannedNodes))
+	for id := range s.bannedNodes {
+		adnlID, _ := hex.DecodeString(id)
+		list = append(list, adnlID)
+	}
+	return list
+}
+
+func (s *Server) loadBannedNodes() {
+	list, ok := s.store.(BanList)
+	if !ok {
+		return
+	}
+
+	ids, err := list.GetBannedNodes()
+	if err != nil {
+		Log.Error("failed to load banned nodes", compStorage, ErrAttr(err))
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, id := range ids {
+		s.bannedNodes[hex.EncodeToString(id)] = true
+	}
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/xssnick/tonutils-go/tl"
+	"io"
+	"sync"
+	"time"
+)
+
+// PieceStore - backend which keeps pieces data of bags. By default, data is stored in bag files on disk,
+// but custom implementations can be registered when embedding, to keep it in databases, CAS or dedup engines.
+// Pieces metadata (proofs) is always kept in Storage, store is responsible only for the data.
+type PieceStore interface {
+	// ReadPiece - returns data of the piece, pieces metadata is already checked to exist
+	ReadPiece(t *Torrent, id uint32) ([]byte, error)
+	// WritePiece - stores downloaded and already verified piece
+	WritePiece(t *Torrent, id uint32, data []byte) error
+	// VerifyPiece - checks that stored piece data is still correct
+	VerifyPiece(t *Torrent, id uint32) error
+	// ListPieces - enumerates pieces of bag which are stored
+	ListPieces(t *Torrent) ([]uint32, error)
+	// DeletePiece - removes piece data, called when bag is removed with its data
+	DeletePiece(t *Torrent, id uint32) error
+}
+
+const DefaultPieceStore = "files"
+
+var pieceStores = map[string]PieceStore{
+	DefaultPieceStore:    &FilesPieceStore{},
+	MemoryPieceStoreName: NewMemoryPieceStore(),
+}
+
+// DownloadPieceStore - name of the store which is used by new bags, created from local files bags are always
+// using files store, because their data is already on disk.
+var DownloadPieceStore = DefaultPieceStore
+var pieceStoresMx sync.RWMutex
+
+// RegisterPieceStore - makes store available for bags by name, should be called before storage is loaded,
+// because bags which are using it will fail to load otherwise.
+func RegisterPieceStore(name string, store PieceStore) {
+	pieceStoresMx.Lock()
+	defer pieceStoresMx.Unlock()
+
+	pieceStores[name] = store
+}
+
+func GetPieceStore(name string) PieceStore {
+	pieceStoresMx.RLock()
+	defer pieceStoresMx.RUnlock()
+
+	if name == "" {
+		name = DefaultPieceStore
+	}
+	return pieceStores[name]
+}
+
+// SetPieceStore - sets name of the registered store for bag data, should be called before Start
+func (t *Torrent) SetPieceStore(name string) error {
+	if GetPieceStore(name) == nil {
+		return fmt.Errorf("piece store %q is not registered", name)
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if name == DefaultPieceStore {
+		name = ""
+	}
+	t.pieceStore = name
+	return nil
+}
+
+func (t *Torrent) GetPieceStoreName() string {
+	if t.pieceStore == "" {
+		return DefaultPieceStore
+	}
+	return t.pieceStore
+}
+
+func (t *Torrent) GetPieceStore() PieceStore {
+	return GetPieceStore(t.pieceStore)
+}
+
+func (t *Torrent) isFilesStore() bool {
+	_, ok := t.GetPieceStore().(*FilesPieceStore)
+	return ok
+}
+
+// VerifyPieceData - checks data of the piece against stored proof and bag root hash
+func (t *Torrent) VerifyPieceData(id uint32, data []byte) error {
+	piece, err := t.getPiece(id)
+	if err != nil {
+		return fmt.Errorf("piece %d is not downlaoded (%w)", id, err)
+	}
+
+	return t.verifyPiece(id, data, piece.Proof)
+}
+
+// FilesPieceStore - default store, keeps pieces data directly in bag files
+type FilesPieceStore struct{}
+
+func (f *FilesPieceStore) ReadPiece(t *Torrent, id uint32) ([]byte, error) {
+	piece, err := t.getPiece(id)
+	if err != nil {
+		return nil, fmt.Errorf("piece %d is not downlaoded (%w)", id, err)
+	}
+
+	offset := 0
+	block := make([]byte, t.Info.PieceSize)
+
+	fileFrom := piece.StartFileIndex
+	for {
+		isHdr := t.Info.HeaderSize > uint64(id)*uint64(t.Info.PieceSize)+uint64(offset)
+
+		// header
+		if isHdr {
+			headerData, err := tl.Serialize(t.Header, true)
+			if err != nil {
+				return nil, fmt.Errorf("failed to serialize header: %w", err)
+			}
+			offset += copy(block[offset:], headerData[id*t.Info.PieceSize:])
+		} else {
+			f, err := t.GetFileOffsetsByID(fileFrom)
+			if err != nil {
+				return nil, fmt.Errorf("offsets for %d %d are not exists (%w)", id, fileFrom, err)
+			}
+
+			name, err := t.GetLocalFileName(fileFrom)
+			if err != nil {
+				return nil, fmt.Errorf("local name for %d is not exists (%w)", fileFrom, err)
+			}
+
+			path := t.readFilePath(name)
+			read := func(path string, from int64) error {
+				fd, err := fs.Acquire(path)
+				if err != nil {
+					return err
+				}
+				defer fd.Free()
+
+				n, err := fd.Get().ReadAt(block[offset:], from)
+				if err != nil && err != io.EOF {
+					return err
+				}
+
+				offset += n
+				return nil
+			}
+
+			fileOff := uint32(0)
+			if f.FromPiece != id {
+				fileOff = (id-f.FromPiece)*t.Info.PieceSize - f.FromPieceOffset
+			}
+			err = t.checkIO(read(path, int64(fileOff)))
+			if err != nil {
+				return nil, err
+			}
+			fileFrom++
+
+			if fileFrom >= uint32(len(t.Header.DataIndex)) {
+				// end reached
+				break
+			}
+		}
+
+		if offset == int(t.Info.PieceSize) {
+			break
+		}
+	}
+
+	if offset > 0 {
+		block = block[:offset]
+	}
+	return block, nil
+}
+
+// WritePiece - writes parts of piece to the files which are selected for download
+func (f *FilesPieceStore) WritePiece(t *Torrent, piece uint32, data []byte) error {
+	pieceFiles, err := t.GetFilesInPiece(piece)
+	if err != nil {
+		return fmt.Errorf("failed to get files of piece %d: %w", piece, err)
+	}
+
+	for _, file := range pieceFiles {
+		if !t.isFileActive(file.Index) {
+			continue
+		}
+
+		if err := validateFileName(file.Name, true); err != nil {
+			Log.Warn("malicious file was skipped", compStorage, "file", file.Name, ErrAttr(err))
+			continue
+		}
+
+		localName, err := t.GetLocalFileName(file.Index)
+		if err != nil {
+			return fmt.Errorf("failed to get local name of file %s: %w", file.Name, err)
+		}
+
+		path := t.writeFilePath(localName)
+		if err = t.checkSandboxed(path); err != nil {
+			t.rejectMalicious(err)
+			return err
+		}
+
+		err = t.checkIO(func() error {
+			var fl FSFile
+			for x := 1; x <= 5; x++ {
+				// we retry because on Windows close file behaves
+				// like async, and it may throw that file still opened
+				fl, err = t.db.GetFS().Open(path, OpenModeWrite)
+				if err != nil {
+					Log.Error("failed to create or open file", compStorage, "file", file.Name, ErrAttr(err))
+					time.Sleep(time.Duration(x*50) * time.Millisecond)
+					continue
+				}
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create or open file %s: %w", file.Name, err)
+			}
+			defer fl.Close()
+
+			notEmptyFile := file.FromPiece != file.ToPiece || file.FromPieceOffset != file.ToPieceOffset
+			if notEmptyFile {
+				fileOff := uint32(0)
+				if file.FromPiece != piece {
+					fileOff = (piece-file.FromPiece)*t.Info.PieceSize - file.FromPieceOffset
+				}
+
+				part := data
+				if file.ToPiece == piece {
+					part = part[:file.ToPieceOffset]
+				}
+				if file.FromPiece == piece {
+					part = part[file.FromPieceOffset:]
+				}
+
+				_, err = fl.WriteAt(part, int64(fileOff))
+				if err != nil {
+					return fmt.Errorf("failed to write file %s: %w", file.Name, err)
+				}
+			}
+
+			return fl.Sync()
+		}())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FilesPieceStore) VerifyPiece(t *Torrent, id uint32) error {
+	data, err := f.ReadPiece(t, id)
+	if err != nil {
+		return err
+	}
+	return t.VerifyPieceData(id, data)
+}
+
+// ListPieces - files are not telling which pieces they contain, so we take it from pieces metadata
+func (f *FilesPieceStore) ListPieces(t *Torrent) ([]uint32, error) {
+	if t.Info == nil {
+		return nil, nil
+	}
+
+	var list []uint32
+	mask := t.PiecesMask()
+	for i := uint32(0); i < t.PiecesNum(); i++ {
+		if int(i/8) < len(mask) && mask[i/8]&(1<<(i%8)) != 0 {
+			list = append(list, i)
+		}
+	}
+	return list, nil
+}
+
+// DeletePiece - does nothing, bag files are removed as a whole by storage
+func (f *FilesPieceStore) DeletePiece(t *Torrent, id uint32) error {
+	return nil
+}
+
+func (t *Torrent) isFileActive(index uint32) bool {
+	if t.downloadAll {
+		return true
+	}
+
+	for _, id := range t.activeFiles {
+		if id == index {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// FetchHeaderOnly - downloads only info and header of bag, so its description, size and files can be shown
+// before committing disk space. Stopped bag is started for it and stopped after, with its settings kept.
+func (t *Torrent) FetchHeaderOnly(ctx context.Context) error {
+	if t.Info != nil && t.Header != nil {
+		return nil
+	}
+
+	if active, _ := t.IsActive(); !active {
+		t.mx.Lock()
+		withUpload, downloadAll, downloadOrdered := t.activeUpload, t.downloadAll, t.downloadOrdered
+		t.mx.Unlock()
+
+		if err := t.StartWithContext(ctx, false, false, false); err != nil {
+			return err
+		}
+		defer func() {
+			t.StopAndWait()
+			t.SetStartOptions(withUpload, downloadAll, downloadOrdered)
+		}()
+	}
+
+	for t.Info == nil || t.Header == nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package storage
+
+// SetDownloadPriority - sets position of bag in download queue, when number of simultaneous downloads is limited,
+// queued bags with higher priority are started first, bags with the same priority are started in order of queueing
+func (t *Torrent) SetDownloadPriority(priority int) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.priority = priority
+}
+
+func (t *Torrent) GetDownloadPriority() int {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.priority
+}
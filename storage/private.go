@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// SetSwarmSecret - makes bag private, it will be announced and searched only in overlay derived from secret,
+// and peers should prove knowledge of the secret before we answer them. Should be set before Start.
+func (t *Torrent) SetSwarmSecret(secret []byte) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if len(secret) == 0 {
+		t.swarmSecret = nil
+		return
+	}
+	t.swarmSecret = append([]byte{}, secret...)
+}
+
+func (t *Torrent) GetSwarmSecret() []byte {
+	return t.swarmSecret
+}
+
+func (t *Torrent) IsPrivate() bool {
+	return len(t.swarmSecret) > 0
+}
+
+// OverlayKey - key of bag's overlay, used for DHT and peers communication
+func (t *Torrent) OverlayKey() []byte {
+	if !t.IsPrivate() {
+		return t.BagID
+	}
+
+	h := sha256.New()
+	h.Write([]byte("tonutils-storage-private-swarm"))
+	h.Write(t.swarmSecret)
+	h.Write(t.BagID)
+	return h.Sum(nil)
+}
+
+func (t *Torrent) privateAuthProof(fromID, toID []byte) []byte {
+	mac := hmac.New(sha256.New, t.swarmSecret)
+	mac.Write(t.OverlayKey())
+	mac.Write(fromID)
+	mac.Write(toID)
+	return mac.Sum(nil)
+}
+
+func (t *Torrent) checkPrivateAuth(auth PrivateAuth, fromID, toID []byte) bool {
+	return hmac.Equal(auth.Proof, t.privateAuthProof(fromID, toID))
+}
+
+// authorize - proves to peer that we know the swarm secret, does nothing for public bags
+func (s *storagePeer) authorize(srv *Server) error {
+	if !s.torrent.IsPrivate() {
+		return nil
+	}
+
+	s.authOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(s.globalCtx, 10*time.Second)
+		defer cancel()
+
+		var res Ok
+		err := s.conn.rldp.DoQuery(ctx, 1<<10, overlay.WrapQuery(s.overlay, &PrivateAuth{
+			Proof: s.torrent.privateAuthProof(srv.gate.GetID(), s.nodeId),
+		}), &res)
+		if err != nil {
+			Log.Debug("private auth failed", compStorage, BagAttr(s.torrent.BagID), PeerAttr(s.nodeId), ErrAttr(err))
+			s.authErr = fmt.Errorf("private swarm auth failed: %w", err)
+		}
+	})
+	return s.authErr
+}
+
+func (c *PeerConnection) setAuthorized(bagId []byte) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.authorizedBags[string(bagId)] = true
+}
+
+func (c *PeerConnection) isAuthorized(bagId []byte) bool {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+
+	return c.authorizedBags[string(bagId)]
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"time"
+)
+
+// PushBag - asks node to download bag, sent in bag's overlay, accepted only from trusted nodes
+type PushBag struct {
+	BagID []byte `tl:"int256"`
+}
+
+// PushHandler - called when trusted node asks us to download bag, from is its ADNL id
+type PushHandler func(bagId, from []byte) error
+
+// SetPushHandler - enables bags pushing to us from nodes with the given ADNL ids
+func (s *Server) SetPushHandler(handler PushHandler, trusted [][]byte) {
+	list := make(map[string]bool, len(trusted))
+	for _, id := range trusted {
+		list[string(id)] = true
+	}
+
+	s.mx.Lock()
+	s.pushHandler = handler
+	s.pushTrusted = list
+	s.mx.Unlock()
+}
+
+// PushBag - asks node to download public bag from swarm right now, node should trust our key.
+// Address of node is resolved like for peers: static records first, then DHT.
+func (s *Server) PushBag(ctx context.Context, adnlId []byte, t *Torrent) error {
+	if t.IsPrivate() {
+		return fmt.Errorf("private bags can't be pushed, their secret is not shared")
+	}
+	if t.IsRestricted() {
+		return fmt.Errorf("unlisted and allow-listed bags can't be pushed, node would seed them to anyone")
+	}
+
+	peer := s.GetPeerIfActive(adnlId)
+	if peer == nil {
+		addr, key, _, err := s.resolveNodeAddress(ctx, adnlId)
+		if err != nil {
+			return fmt.Errorf("failed to find node address: %w", err)
+		}
+
+		ax, err := s.gate.RegisterClient(addr, key)
+		if err != nil {
+			return fmt.Errorf("failed to connect to node: %w", err)
+		}
+		peer = s.bootstrapPeer(ax)
+	}
+
+	over, err := adnl.ToKeyID(adnl.PublicKeyOverlay{Key: t.OverlayKey()})
+	if err != nil {
+		return err
+	}
+
+	var res Ok
+	if err = peer.adnl.Query(ctx, overlay.WrapQuery(over, &PushBag{BagID: t.BagID}), &res); err != nil {
+		return fmt.Errorf("node rejected push: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handlePushBag(peer *overlay.ADNLWrapper, query *adnl.MessageQuery, q PushBag, over []byte) error {
+	id, err := adnl.ToKeyID(adnl.PublicKeyOverlay{Key: q.BagID})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(id, over) {
+		return fmt.Errorf("bag is not matching overlay")
+	}
+
+	s.mx.RLock()
+	handler, trusted := s.pushHandler, s.pushTrusted[string(peer.GetID())]
+	s.mx.RUnlock()
+
+	if handler == nil || !trusted {
+		Log.Warn("rejected push of untrusted node", compStorage, BagAttr(q.BagID), PeerAttr(peer.GetID()))
+		return fmt.Errorf("push is not allowed")
+	}
+
+	if err = handler(q.BagID, peer.GetID()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
+	defer cancel()
+	return peer.Answer(ctx, query.ID, Ok{})
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// QuarantineVerifyFailures - after this number of pieces with failed verification, bag is quarantined.
+// Single bad peer is banned, but many failures mean that something is wrong with the bag itself. 0 = disabled.
+var QuarantineVerifyFailures uint32 = 20
+
+// GetQuarantineReason - returns why bag was quarantined, empty when it is not.
+// Quarantined bag cannot be started till operator calls ReleaseQuarantine.
+func (t *Torrent) GetQuarantineReason() string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.quarantine
+}
+
+// ReleaseQuarantine - confirms that bag is trusted by operator, it can be started again
+// and it will not be quarantined for the same reasons anymore
+func (t *Torrent) ReleaseQuarantine() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.quarantine = ""
+	t.quarantineReleased = true
+	atomic.StoreUint32(&t.verifyFailures, 0)
+}
+
+// IsQuarantineReleased - returns true when operator confirmed that bag is trusted
+func (t *Torrent) IsQuarantineReleased() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.quarantineReleased
+}
+
+// SetQuarantineState - restores quarantine state, for bags loaded from db
+func (t *Torrent) SetQuarantineState(reason string, released bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.quarantine = reason
+	t.quarantineReleased = released
+}
+
+// putInQuarantine - stops bag till operator confirmation, state is saved, so it survives restart
+func (t *Torrent) putInQuarantine(reason string) {
+	t.mx.Lock()
+	if t.quarantineReleased || t.quarantine != "" {
+		t.mx.Unlock()
+		return
+	}
+	t.quarantine = reason
+	t.mx.Unlock()
+
+	Log.Warn("bag is quarantined", compStorage, BagAttr(t.BagID), "reason", reason)
+	t.logEvent("QUARANTINED", reason)
+
+	t.Stop()
+	if err := t.db.SetTorrent(t); err != nil {
+		Log.Error("failed to save quarantine state", compStorage, BagAttr(t.BagID), ErrAttr(err))
+	}
+}
+
+// countVerifyFailure - called when piece from peer is rejected
+func (t *Torrent) countVerifyFailure() {
+	if QuarantineVerifyFailures == 0 {
+		return
+	}
+
+	if n := atomic.AddUint32(&t.verifyFailures, 1); n == QuarantineVerifyFailures {
+		t.putInQuarantine(fmt.Sprintf("%d pieces failed verification", n))
+	}
+}
+
+// checkSanitizedNames - quarantines bag when some file names from header were changed to be stored on disk,
+// such bag will not look on disk as its creator expected, so operator should decide
+func (t *Torrent) checkSanitizedNames() bool {
+	if t.IsQuarantineReleased() {
+		return false
+	}
+
+	var changed int
+	var example string
+	var from uint64
+	for i := uint32(0); i < t.Header.FilesCount; i++ {
+		name := string(t.Header.Names[from:t.Header.NameIndex[i]])
+		from = t.Header.NameIndex[i]
+
+		local, err := t.GetLocalFileName(i)
+		if err != nil {
+			return false
+		}
+
+		// normalization of unicode form is not a change, file looks the same
+		if local != normalizeFileName(name) {
+			if changed == 0 {
+				example = fmt.Sprintf("%q stored as %q", name, local)
+			}
+			changed++
+		}
+	}
+
+	if changed == 0 {
+		return false
+	}
+
+	t.putInQuarantine(fmt.Sprintf("%d file names were changed to be stored on disk, for example %s", changed, example))
+	return true
+}
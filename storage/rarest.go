@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// EndgamePieces - when all pieces are requested and this number of them or fewer is still downloading,
+// slow pieces are requested from other peers too, so download is not stalled at the end by one slow peer. 0 = disabled
+var EndgamePieces = 16
+
+const (
+	// endgameDelay - how long piece is downloading before duplicate request is sent in endgame
+	endgameDelay = 2 * time.Second
+	// endgameMaxRequests - limit of concurrent requests of one piece in endgame
+	endgameMaxRequests = 3
+	// rarestRefreshInterval - how often order of not requested pieces is updated by availability
+	rarestRefreshInterval = 10 * time.Second
+)
+
+// inflightPiece - requests of piece which is downloading, there are many of them in endgame
+type inflightPiece struct {
+	startedAt time.Time
+	// requests - number of started and queued requests
+	requests int
+	cancels  []context.CancelFunc
+	// peers - id of peer which is serving each started request, empty while it is not taken by peer
+	peers []string
+}
+
+// peerAwareDownloader - downloader which can skip some peers and report which peer took the request,
+// in endgame it is used to not send duplicate request of piece to the peer which is already slow with it
+type peerAwareDownloader interface {
+	downloadPieceFrom(ctx context.Context, pieceIndex uint32, exclude func(peerId []byte) bool, assigned func(peerId []byte)) (data []byte, proof []byte, peer []byte, peerAddr string, err error)
+}
+
+// piecesAvailability - returns number of connected peers which have each piece
+func (t *Torrent) piecesAvailability() map[uint32]int {
+	avail := map[uint32]int{}
+	for _, p := range t.GetPeers() {
+		if p.peer == nil {
+			continue
+		}
+
+		p.peer.piecesMx.RLock()
+		for piece := range p.peer.hasPieces {
+			avail[piece]++
+		}
+		p.peer.piecesMx.RUnlock()
+	}
+	return avail
+}
+
+// PiecesAvailability - returns number of connected peers which have each piece, indexed by piece,
+// nil when bag info is not downloaded yet
+func (t *Torrent) PiecesAvailability() []uint32 {
+	if t.Info == nil {
+		return nil
+	}
+
+	list := make([]uint32, t.PiecesNum())
+	for piece, num := range t.piecesAvailability() {
+		if piece < uint32(len(list)) {
+			list[piece] = uint32(num)
+		}
+	}
+	return list
+}
+
+// sortRarestFirst - orders pieces by number of peers which have them, the rarest first,
+// pieces which nobody has yet are left to the end, they would only occupy download threads
+func sortRarestFirst(pieces []uint32, avail map[uint32]int) {
+	sort.SliceStable(pieces, func(i, j int) bool {
+		ai, aj := avail[pieces[i]], avail[pieces[j]]
+		if ai == 0 || aj == 0 {
+			return ai > aj
+		}
+		return ai < aj
+	})
+}
+
+// rarestFirst - periodically reorders pieces which are not requested yet, by their current availability
+func (f *PreFetcher) rarestFirst() {
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(rarestRefreshInterval):
+		}
+
+		avail := f.torrent.piecesAvailability()
+
+		f.mx.Lock()
+		if f.offset+1 >= len(f.piecesList) {
+			// everything is requested
+			f.mx.Unlock()
+			return
+		}
+		sortRarestFirst(f.piecesList[f.offset+1:], avail)
+		f.mx.Unlock()
+	}
+}
+
+// endgame - requests slow pieces again when only a few of them are left,
+// the first response is used and other requests of the piece are cancelled
+func (f *PreFetcher) endgame() {
+	if EndgamePieces <= 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+
+		var slow []uint32
+		f.mx.Lock()
+		if f.offset+1 >= len(f.piecesList) && len(f.tasks) == 0 && len(f.requests) <= EndgamePieces {
+			for piece, req := range f.requests {
+				if req.requests < endgameMaxRequests && time.Since(req.startedAt) >= endgameDelay {
+					req.requests++
+					slow = append(slow, piece)
+				}
+			}
+		}
+		f.mx.Unlock()
+
+		if len(slow) > 0 {
+			Log.Debug("endgame, requesting slow pieces from other peers", compStorage, BagAttr(f.torrent.BagID), "pieces", len(slow))
+		}
+		for _, piece := range slow {
+			select {
+			case f.tasks <- piece:
+			default:
+				// all slots are busy, it will be requested on next check
+				f.mx.Lock()
+				if req := f.requests[piece]; req != nil {
+					req.requests--
+				}
+				f.mx.Unlock()
+			}
+		}
+	}
+}
+
+// downloadRequest - downloads piece for request slot, peers which are serving other requests of the piece are skipped
+func (f *PreFetcher) downloadRequest(ctx context.Context, piece uint32, slot int) ([]byte, []byte, error) {
+	d, ok := f.downloader.(peerAwareDownloader)
+	if !ok {
+		data, proof, _, _, err := f.downloader.DownloadPieceDetailed(ctx, piece)
+		return data, proof, err
+	}
+
+	exclude := func(peerId []byte) bool {
+		f.mx.RLock()
+		defer f.mx.RUnlock()
+
+		req := f.requests[piece]
+		if req == nil {
+			return false
+		}
+		for i, id := range req.peers {
+			if i != slot && id == string(peerId) {
+				return true
+			}
+		}
+		return false
+	}
+	assigned := func(peerId []byte) {
+		f.mx.Lock()
+		defer f.mx.Unlock()
+
+		if req := f.requests[piece]; req != nil && slot < len(req.peers) {
+			req.peers[slot] = string(peerId)
+		}
+	}
+
+	data, proof, _, _, err := d.downloadPieceFrom(ctx, piece, exclude, assigned)
+	return data, proof, err
+}
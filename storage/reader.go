@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrFileNotSelected - file is not downloaded and not selected for download, so it will never be available
+var ErrFileNotSelected = errors.New("file is not selected for download")
+
+// FileReader - reads file of the bag from its pieces, it can be used while bag is still downloading,
+// in this case read blocks till required piece is downloaded or context is done
+type FileReader struct {
+	t    *Torrent
+	ctx  context.Context
+	file *FileInfo
+	// from - offset of file in bag data (including header)
+	from uint64
+	pos  int64
+}
+
+// OpenFile - returns reader of bag file by its name from header
+func (t *Torrent) OpenFile(ctx context.Context, name string) (*FileReader, error) {
+	if t.Info == nil || t.Header == nil {
+		return nil, fmt.Errorf("bag header is not downloaded yet")
+	}
+
+	file, err := t.GetFileOffsets(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileReader{
+		t:    t,
+		ctx:  ctx,
+		file: file,
+		from: uint64(file.FromPiece)*uint64(t.Info.PieceSize) + uint64(file.FromPieceOffset),
+	}, nil
+}
+
+func (r *FileReader) Size() int64 {
+	return int64(r.file.Size)
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	if r.pos >= int64(r.file.Size) {
+		return 0, io.EOF
+	}
+	if left := int64(r.file.Size) - r.pos; int64(len(p)) > left {
+		p = p[:left]
+	}
+
+	abs := r.from + uint64(r.pos)
+	piece := uint32(abs / uint64(r.t.Info.PieceSize))
+	if err := r.waitPiece(piece); err != nil {
+		return 0, err
+	}
+
+	data, err := r.t.getPieceInternal(piece)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read piece %d: %w", piece, err)
+	}
+
+	off := abs - uint64(piece)*uint64(r.t.Info.PieceSize)
+	if off >= uint64(len(data.Data)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := copy(p, data.Data[off:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += int64(r.file.Size)
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *FileReader) waitPiece(piece uint32) error {
+	for {
+		mask := r.t.PiecesMask()
+		if int(piece/8) < len(mask) && mask[piece/8]&(1<<(piece%8)) != 0 {
+			return nil
+		}
+
+		if !r.t.isFileActive(r.file.Index) {
+			return ErrFileNotSelected
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"github.com/xssnick/tonutils-go/tl"
+	"sync/atomic"
+	"time"
+)
+
+// SendBandwidthReceipts - experimental, when enabled, we are sending signed receipts of bytes received
+// in the peer session to peers which served them, so seeders can prove delivery to third parties
+var SendBandwidthReceipts = false
+
+// receiptsTTL - how long receipts of closed sessions are kept
+const receiptsTTL = 24 * time.Hour
+
+// BandwidthReceipt - acknowledgment of bytes received by downloader from seeder in one session.
+// Bytes are total for session, so only the latest receipt of session is meaningful.
+type BandwidthReceipt struct {
+	BagID      []byte `tl:"int256"`
+	Seeder     []byte `tl:"int256"`
+	Downloader []byte `tl:"int256"`
+	SessionID  int64  `tl:"long"`
+	Bytes      int64  `tl:"long"`
+	CreatedAt  int32  `tl:"int"`
+}
+
+// SignedBandwidthReceipt - receipt with ed25519 signature of its boxed TL serialization by downloader key,
+// downloader's ADNL id is derived from its key, so receipt can be verified without us
+type SignedBandwidthReceipt struct {
+	Receipt   BandwidthReceipt `tl:"struct boxed"`
+	Signature []byte           `tl:"bytes"`
+}
+
+// Verify - checks downloader signature of the receipt
+func (r *SignedBandwidthReceipt) Verify() error {
+	if len(r.Receipt.Downloader) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid downloader key")
+	}
+
+	data, err := tl.Serialize(r.Receipt, true)
+	if err != nil {
+		return fmt.Errorf("failed to serialize receipt: %w", err)
+	}
+
+	if !ed25519.Verify(r.Receipt.Downloader, data, r.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// GetBandwidthReceipts - returns the latest receipt of each peer session, which was acknowledged by peers
+func (t *Torrent) GetBandwidthReceipts() []SignedBandwidthReceipt {
+	t.peersMx.RLock()
+	defer t.peersMx.RUnlock()
+
+	res := make([]SignedBandwidthReceipt, 0, len(t.receipts))
+	for _, r := range t.receipts {
+		res = append(res, *r)
+	}
+	return res
+}
+
+// addBandwidthReceipt - validates receipt from peer with given ADNL id and remembers it
+func (t *Torrent) addBandwidthReceipt(r *SignedBandwidthReceipt, peerId, ourId []byte) error {
+	if !bytes.Equal(r.Receipt.BagID, t.BagID) {
+		return fmt.Errorf("receipt is for another bag")
+	}
+	if !bytes.Equal(r.Receipt.Seeder, ourId) {
+		return fmt.Errorf("receipt is for another seeder")
+	}
+	if r.Receipt.Bytes < 0 {
+		return fmt.Errorf("invalid bytes number")
+	}
+
+	id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: r.Receipt.Downloader})
+	if err != nil || !bytes.Equal(id, peerId) {
+		return fmt.Errorf("receipt is not signed by peer")
+	}
+
+	if err = r.Verify(); err != nil {
+		return err
+	}
+
+	t.peersMx.Lock()
+	defer t.peersMx.Unlock()
+
+	if t.receipts == nil {
+		t.receipts = map[string]*SignedBandwidthReceipt{}
+	}
+
+	key := fmt.Sprintf("%s:%d", hex.EncodeToString(peerId), r.Receipt.SessionID)
+	if prev := t.receipts[key]; prev != nil && prev.Receipt.Bytes > r.Receipt.Bytes {
+		return fmt.Errorf("receipt is older than known")
+	}
+
+	now := time.Now()
+	for k, v := range t.receipts {
+		if now.Sub(time.Unix(int64(v.Receipt.CreatedAt), 0)) > receiptsTTL {
+			delete(t.receipts, k)
+		}
+	}
+	t.receipts[key] = r
+	return nil
+}
+
+// sendBandwidthReceipt - acknowledges bytes which we received from peer in this session,
+// after the first failure we stop sending receipts to this peer, because it does not support them
+func (s *storagePeer) sendBandwidthReceipt(srv *Server) {
+	if !SendBandwidthReceipts || atomic.LoadInt32(&s.receiptsUnsupported) != 0 {
+		return
+	}
+
+	received := atomic.LoadUint64(&s.received)
+	if received == atomic.LoadUint64(&s.receiptSent) {
+		return
+	}
+
+	receipt := BandwidthReceipt{
+		BagID:      s.torrent.BagID,
+		Seeder:     s.nodeId,
+		Downloader: srv.key.Public().(ed25519.PublicKey),
+		SessionID:  atomic.LoadInt64(&s.sessionId),
+		Bytes:      int64(received),
+		CreatedAt:  int32(time.Now().Unix()),
+	}
+
+	data, err := tl.Serialize(receipt, true)
+	if err != nil {
+		Log.Error("failed to serialize bandwidth receipt", compStorage, PeerAttr(s.nodeId), ErrAttr(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.globalCtx, 5*time.Second)
+	defer cancel()
+
+	var res Ok
+	err = s.conn.adnl.Query(ctx, overlay.WrapQuery(s.overlay, &SignedBandwidthReceipt{
+		Receipt:   receipt,
+		Signature: ed25519.Sign(srv.key, data),
+	}), &res)
+	if err != nil {
+		atomic.StoreInt32(&s.receiptsUnsupported, 1)
+		Log.Debug("peer not accepted bandwidth receipt, disabling it", compStorage, PeerAttr(s.nodeId), ErrAttr(err))
+		return
+	}
+	atomic.StoreUint64(&s.receiptSent, received)
+}
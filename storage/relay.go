@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"github.com/xssnick/tonutils-go/adnl/rldp"
+	"github.com/xssnick/tonutils-go/tl"
+	"github.com/xssnick/tonutils-go/tlb"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"math/rand"
+	"time"
+)
+
+// MaxRelayedBags - max number of bags of other nodes which are relayed by us in relay mode
+var MaxRelayedBags = 256
+
+// MaxRelayedBagsPerPeer - max number of bags relayed by us for a single seeder,
+// so one node cannot occupy all relay slots
+var MaxRelayedBagsPerPeer = 32
+
+// MaxRelaySessionsPerBag - max number of downloaders sessions routed for one relayed bag,
+// each downloader has only one session, new ones are rejected when limit is reached
+var MaxRelaySessionsPerBag = 256
+
+const (
+	// relayRegisterTTL - relayed bag is forgotten when seeder is not confirming it during this time
+	relayRegisterTTL = 5 * time.Minute
+	// relayRegisterInterval - how often seeder confirms its bags on relays
+	relayRegisterInterval = 1 * time.Minute
+	// relaySessionTTL - how long updates for downloader's session are routed after its last ping
+	relaySessionTTL = 10 * time.Minute
+	// relayVerifyTimeout - how long we wait for bag info and piece of registering seeder
+	relayVerifyTimeout = 30 * time.Second
+)
+
+// RelayRegister - seeder asks relay to forward swarm traffic of bag to it, sent in bag's overlay
+type RelayRegister struct {
+	BagID []byte `tl:"int256"`
+}
+
+type relayedBag struct {
+	bagId        []byte
+	overlayKey   []byte
+	seederId     []byte
+	expiresAt    time.Time
+	announcedAt  time.Time
+	sessionPeers map[int64]*relaySession
+	// verified - seeder proved that it has the bag, before it traffic is not relayed
+	verified bool
+}
+
+type relaySession struct {
+	peerId []byte
+	seenAt time.Time
+}
+
+type relayNode struct {
+	addr string
+	key  ed25519.PublicKey
+}
+
+// EnableRelayMode - allows unreachable nodes to register their bags on us, we announce ourselves
+// in overlays of these bags and forward queries of downloaders to seeders. Makes sense only in server mode.
+func (s *Server) EnableRelayMode() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.relayed != nil {
+		return
+	}
+	s.relayed = map[string]*relayedBag{}
+
+	s.wg.Add(1)
+	go s.relayAnnouncer()
+}
+
+// AddRelay - adds relay node which will forward traffic of our bags, when we are not reachable
+// (behind NAT without port forwarding). Only public bags are registered on relays.
+func (s *Server) AddRelay(key ed25519.PublicKey, addr string) error {
+	// address of relay is pinned, so we are not depending on DHT to reach it
+	if err := s.AddStaticPeer(key, addr); err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	start := len(s.relays) == 0
+	s.relays = append(s.relays, relayNode{addr: addr, key: key})
+	if start {
+		s.wg.Add(1)
+		go s.relayRegistrar()
+	}
+	return nil
+}
+
+// relayRegistrar - keeps connections to relays and registers our seeding bags on them
+func (s *Server) relayRegistrar() {
+	defer s.wg.Done()
+
+	wait := 5 * time.Second
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = relayRegisterInterval
+
+		if s.store == nil {
+			wait = 5 * time.Second
+			continue
+		}
+
+		s.mx.RLock()
+		relays := append([]relayNode{}, s.relays...)
+		s.mx.RUnlock()
+
+		for _, r := range relays {
+			if err := s.registerOnRelay(r); err != nil {
+				Log.Warn("failed to register on relay", compRelay, "addr", r.addr, ErrAttr(err))
+				wait = 10 * time.Second
+			}
+		}
+	}
+}
+
+func (s *Server) registerOnRelay(r relayNode) error {
+	id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: r.key})
+	if err != nil {
+		return err
+	}
+
+	peer := s.GetPeerIfActive(id)
+	if peer == nil {
+		ax, err := s.gate.RegisterClient(r.addr, r.key)
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		peer = s.bootstrapPeer(ax)
+	}
+
+	for _, t := range s.store.GetAll() {
+		if _, upl := t.IsActive(); !upl || t.IsRestricted() || t.Info == nil {
+			continue
+		}
+
+		over, err := adnl.ToKeyID(adnl.PublicKeyOverlay{Key: t.OverlayKey()})
+		if err != nil {
+			return err
+		}
+
+		var res Ok
+		ctx, cancel := context.WithTimeout(s.closeCtx, 10*time.Second)
+		err = peer.adnl.Query(ctx, overlay.WrapQuery(over, &RelayRegister{BagID: t.BagID}), &res)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to register bag %s: %w", hex.EncodeToString(t.BagID), err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleRelayRegister(peer *overlay.ADNLWrapper, query *adnl.MessageQuery, q RelayRegister, over []byte) error {
+	id, err := adnl.ToKeyID(adnl.PublicKeyOverlay{Key: q.BagID})
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(id, over) {
+		return fmt.Errorf("bag is not matching overlay")
+	}
+
+	s.mx.Lock()
+	if s.relayed == nil {
+		s.mx.Unlock()
+		return fmt.Errorf("relay mode is disabled")
+	}
+
+	now := time.Now()
+	rb := s.relayed[string(over)]
+	verify := false
+	if rb == nil || !bytes.Equal(rb.seederId, peer.GetID()) {
+		if rb != nil && now.Before(rb.expiresAt) {
+			// bag is relayed for another live seeder, which proved that it has the bag,
+			// we are not allowing to take it over, otherwise anyone could redirect its downloaders
+			s.mx.Unlock()
+			return fmt.Errorf("bag is already relayed for another seeder")
+		}
+		if rb == nil && len(s.relayed) >= MaxRelayedBags {
+			s.mx.Unlock()
+			return fmt.Errorf("too many relayed bags")
+		}
+		if s.countRelayedFor(peer.GetID(), now) >= MaxRelayedBagsPerPeer {
+			s.mx.Unlock()
+			return fmt.Errorf("too many relayed bags for peer")
+		}
+
+		rb = &relayedBag{
+			bagId:        q.BagID,
+			overlayKey:   q.BagID,
+			seederId:     peer.GetID(),
+			sessionPeers: map[int64]*relaySession{},
+		}
+		s.relayed[string(over)] = rb
+		verify = true
+	}
+	rb.expiresAt = now.Add(relayRegisterTTL)
+	s.mx.Unlock()
+
+	if verify {
+		// registration is kept while seeder is checked, so it can't be occupied by others meanwhile,
+		// check is done in background, because it needs queries to seeder over the same connection
+		s.wg.Add(1)
+		go s.verifyRelayed(rb, over)
+	}
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
+	defer cancel()
+	return peer.Answer(ctx, query.ID, Ok{})
+}
+
+// verifyRelayed - starts relaying bag when seeder proved that it has it, otherwise registration is removed,
+// so node which has no bag can't squat it on relay and block real seeder
+func (s *Server) verifyRelayed(rb *relayedBag, over []byte) {
+	defer s.wg.Done()
+
+	err := s.checkRelayedSeeder(rb, over)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if err != nil {
+		Log.Warn("seeder failed to prove that it has relayed bag", compRelay, BagAttr(rb.bagId), PeerAttr(rb.seederId), ErrAttr(err))
+		if s.relayed[string(over)] == rb {
+			delete(s.relayed, string(over))
+		}
+		return
+	}
+	rb.verified = true
+	Log.Info("relaying bag", compRelay, BagAttr(rb.bagId), PeerAttr(rb.seederId))
+}
+
+// checkRelayedSeeder - requests bag info and random piece from seeder and verifies them against bag id
+func (s *Server) checkRelayedSeeder(rb *relayedBag, over []byte) error {
+	ctx, cancel := context.WithTimeout(s.closeCtx, relayVerifyTimeout)
+	defer cancel()
+
+	seeder := s.GetPeerIfActive(rb.seederId)
+	if seeder == nil {
+		return fmt.Errorf("seeder is not connected")
+	}
+
+	var res TorrentInfoContainer
+	if err := seeder.rldp.DoQuery(ctx, 1<<25, overlay.WrapQuery(over, &GetTorrentInfo{}), &res); err != nil {
+		return fmt.Errorf("failed to get bag info: %w", err)
+	}
+
+	cl, err := cell.FromBOC(res.Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse bag info boc: %w", err)
+	}
+	if !bytes.Equal(cl.Hash(), rb.bagId) {
+		return fmt.Errorf("incorrect bag info")
+	}
+
+	var info TorrentInfo
+	if err = tlb.LoadFromCell(&info, cl.BeginParse()); err != nil {
+		return fmt.Errorf("invalid bag info cell")
+	}
+	if err = checkInfoLimits(&info); err != nil {
+		return fmt.Errorf("bag info rejected: %w", err)
+	}
+
+	t := &Torrent{BagID: rb.bagId, Info: &info}
+	piece := uint32(rand.Int63n(int64(t.PiecesNum())))
+
+	var p Piece
+	if err = seeder.rldp.DoQuery(ctx, 4096+int64(info.PieceSize)*3, overlay.WrapQuery(over, &GetPiece{int32(piece)}), &p); err != nil {
+		return fmt.Errorf("failed to get piece %d: %w", piece, err)
+	}
+	return t.verifyPiece(piece, p.Data, p.Proof)
+}
+
+// countRelayedFor - number of live relayed bags registered by seeder, must be called under lock
+func (s *Server) countRelayedFor(seederId []byte, now time.Time) int {
+	n := 0
+	for _, rb := range s.relayed {
+		if bytes.Equal(rb.seederId, seederId) && now.Before(rb.expiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) getRelayed(over []byte) *relayedBag {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if s.relayed == nil {
+		return nil
+	}
+
+	rb := s.relayed[string(over)]
+	if rb == nil || !rb.verified || time.Now().After(rb.expiresAt) {
+		return nil
+	}
+	return rb
+}
+
+// relayAnnouncer - announces us in overlays of relayed bags and forgets expired ones
+func (s *Server) relayAnnouncer() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+
+		var announce []*relayedBag
+		now := time.Now()
+
+		s.mx.Lock()
+		for k, rb := range s.relayed {
+			if now.After(rb.expiresAt) {
+				Log.Info("stopped relaying bag", compRelay, BagAttr(rb.bagId))
+				delete(s.relayed, k)
+				continue
+			}
+
+			for ses, sp := range rb.sessionPeers {
+				if now.Sub(sp.seenAt) > relaySessionTTL {
+					delete(rb.sessionPeers, ses)
+				}
+			}
+
+			if now.Sub(rb.announcedAt) > 3*time.Minute {
+				rb.announcedAt = now
+				announce = append(announce, rb)
+			}
+		}
+		s.mx.Unlock()
+
+		for _, rb := range announce {
+			ctx, cancel := context.WithTimeout(s.closeCtx, 45*time.Second)
+			_ = s.updateOverlay(ctx, rb.overlayKey, rb.bagId, true)
+			cancel()
+		}
+	}
+}
+
+// relayQuery - forwards ADNL query of downloader to seeder of relayed bag
+func (s *Server) relayQuery(peer *overlay.ADNLWrapper, query *adnl.MessageQuery, rb *relayedBag, req tl.Serializable, over []byte) error {
+	ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
+	defer cancel()
+
+	if bytes.Equal(peer.GetID(), rb.seederId) {
+		// seeder is asking us as a peer of its bag, we have nothing
+		switch req.(type) {
+		case Ping:
+			return peer.Answer(ctx, query.ID, Pong{})
+		case overlay.GetRandomPeers:
+			return peer.Answer(ctx, query.ID, overlay.NodesList{})
+		}
+		return fmt.Errorf("not supported by relay")
+	}
+
+	seeder := s.GetPeerIfActive(rb.seederId)
+	if seeder == nil {
+		return fmt.Errorf("relayed node is not connected")
+	}
+
+	var res tl.Serializable
+	if err := seeder.adnl.Query(ctx, overlay.WrapQuery(over, req), &res); err != nil {
+		return fmt.Errorf("relayed query failed: %w", err)
+	}
+	return peer.Answer(ctx, query.ID, res)
+}
+
+// relayRLDPQuery - forwards RLDP query of downloader to seeder of relayed bag,
+// and updates of seeder back to downloader, by session of downloader
+func (s *Server) relayRLDPQuery(peer *overlay.RLDPWrapper, transfer []byte, query *rldp.Query, rb *relayedBag, req tl.Serializable, over []byte) error {
+	ctx, cancel := context.WithTimeout(s.closeCtx, PeerPieceTimeout)
+	defer cancel()
+
+	fromId := peer.GetADNL().GetID()
+	if bytes.Equal(fromId, rb.seederId) {
+		switch q := req.(type) {
+		case Ping:
+			return peer.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, Pong{})
+		case AddUpdate:
+			s.mx.RLock()
+			sp := rb.sessionPeers[q.SessionID]
+			s.mx.RUnlock()
+			if sp == nil {
+				return fmt.Errorf("unknown session")
+			}
+
+			dst := s.GetPeerIfActive(sp.peerId)
+			if dst == nil {
+				return fmt.Errorf("downloader is not connected")
+			}
+
+			var res tl.Serializable
+			if err := dst.rldp.DoQuery(ctx, query.MaxAnswerSize, overlay.WrapQuery(over, q), &res); err != nil {
+				return fmt.Errorf("relayed update failed: %w", err)
+			}
+			return peer.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, res)
+		}
+		return fmt.Errorf("not supported by relay")
+	}
+
+	if q, ok := req.(Ping); ok {
+		// updates of seeder are sent for session, remember whom to route them
+		if err := s.relaySession(rb, q.SessionID, fromId); err != nil {
+			return err
+		}
+	}
+
+	seeder := s.GetPeerIfActive(rb.seederId)
+	if seeder == nil {
+		return fmt.Errorf("relayed node is not connected")
+	}
+
+	var res tl.Serializable
+	if err := seeder.rldp.DoQuery(ctx, query.MaxAnswerSize, overlay.WrapQuery(over, req), &res); err != nil {
+		return fmt.Errorf("relayed query failed: %w", err)
+	}
+	return peer.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, res)
+}
+
+// relaySession - remembers session of downloader, older sessions of the same downloader are forgotten,
+// so it can't fill sessions list by pings with new ids
+func (s *Server) relaySession(rb *relayedBag, sessionId int64, peerId []byte) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if sp := rb.sessionPeers[sessionId]; sp != nil {
+		if !bytes.Equal(sp.peerId, peerId) {
+			return fmt.Errorf("session belongs to another peer")
+		}
+		sp.seenAt = time.Now()
+		return nil
+	}
+
+	for ses, sp := range rb.sessionPeers {
+		if bytes.Equal(sp.peerId, peerId) {
+			delete(rb.sessionPeers, ses)
+		}
+	}
+
+	if len(rb.sessionPeers) >= MaxRelaySessionsPerBag {
+		return fmt.Errorf("too many relayed sessions")
+	}
+	rb.sessionPeers[sessionId] = &relaySession{peerId: peerId, seenAt: time.Now()}
+	return nil
+}
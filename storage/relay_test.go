@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestServer_RelaySession(t *testing.T) {
+	s := &Server{}
+	rb := &relayedBag{sessionPeers: map[int64]*relaySession{}}
+
+	if err := s.relaySession(rb, 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.relaySession(rb, 1, []byte("b")); err == nil {
+		t.Fatal("session of another peer is taken")
+	}
+
+	// new sessions of the same downloader are replacing old ones
+	for i := int64(2); i < 100; i++ {
+		if err := s.relaySession(rb, i, []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rb.sessionPeers) != 1 || rb.sessionPeers[99] == nil {
+		t.Fatalf("expected only last session, got %d", len(rb.sessionPeers))
+	}
+
+	old := MaxRelaySessionsPerBag
+	MaxRelaySessionsPerBag = 2
+	defer func() { MaxRelaySessionsPerBag = old }()
+
+	if err := s.relaySession(rb, 100, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.relaySession(rb, 101, []byte("c")); err == nil {
+		t.Fatal("sessions limit is not applied")
+	}
+}
@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const S3PieceStoreName = "s3"
+
+// S3Timeout - timeout of single request to object storage
+var S3Timeout = 30 * time.Second
+
+type S3Config struct {
+	// Endpoint - url of S3 compatible storage, like https://s3.eu-central-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix - prepended to object keys, pieces are stored as <prefix><bag id>/<piece id>
+	Prefix string
+}
+
+// S3PieceStore - keeps each piece as separate object in S3 compatible storage,
+// requests are path-style and signed with AWS signature v4.
+type S3PieceStore struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+func NewS3PieceStore(cfg S3Config) (*S3PieceStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("endpoint and bucket should be set")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+
+	return &S3PieceStore{
+		cfg:    cfg,
+		client: &http.Client{Timeout: S3Timeout},
+	}, nil
+}
+
+func (s *S3PieceStore) ReadPiece(t *Torrent, id uint32) ([]byte, error) {
+	data, err := s.do(http.MethodGet, s.key(t, id), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get piece %d: %w", id, err)
+	}
+	return data, nil
+}
+
+func (s *S3PieceStore) WritePiece(t *Torrent, id uint32, data []byte) error {
+	if _, err := s.do(http.MethodPut, s.key(t, id), nil, data); err != nil {
+		return fmt.Errorf("failed to put piece %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *S3PieceStore) VerifyPiece(t *Torrent, id uint32) error {
+	data, err := s.ReadPiece(t, id)
+	if err != nil {
+		return err
+	}
+	return t.VerifyPieceData(id, data)
+}
+
+func (s *S3PieceStore) ListPieces(t *Torrent) ([]uint32, error) {
+	prefix := s.cfg.Prefix + hex.EncodeToString(t.BagID) + "/"
+
+	var list []uint32
+	token := ""
+	for {
+		query := map[string]string{
+			"list-type": "2",
+			"prefix":    prefix,
+		}
+		if token != "" {
+			query["continuation-token"] = token
+		}
+
+		data, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pieces: %w", err)
+		}
+
+		var res struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err = xml.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("failed to parse list of pieces: %w", err)
+		}
+
+		for _, c := range res.Contents {
+			id, err := strconv.ParseUint(strings.TrimPrefix(c.Key, prefix), 10, 32)
+			if err != nil {
+				// not our object
+				continue
+			}
+			list = append(list, uint32(id))
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			break
+		}
+		token = res.NextContinuationToken
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i] < list[j]
+	})
+	return list, nil
+}
+
+func (s *S3PieceStore) DeletePiece(t *Torrent, id uint32) error {
+	if _, err := s.do(http.MethodDelete, s.key(t, id), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete piece %d: %w", id, err)
+	}
+	return nil
+}
+
+func (s *S3PieceStore) key(t *Torrent, id uint32) string {
+	return s.cfg.Prefix + hex.EncodeToString(t.BagID) + "/" + strconv.FormatUint(uint64(id), 10)
+}
+
+func (s *S3PieceStore) do(method, key string, query map[string]string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), S3Timeout)
+	defer cancel()
+
+	path := "/" + s3Escape(s.cfg.Bucket, false)
+	if key != "" {
+		path += "/" + s3Escape(key, false)
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		params = append(params, s3Escape(k, true)+"="+s3Escape(query[k], true))
+	}
+	rawQuery := strings.Join(params, "&")
+
+	u := s.cfg.Endpoint + path
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, path, rawQuery, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// sign - adds AWS signature v4 headers to request
+func (s *S3PieceStore) sign(req *http.Request, path, rawQuery string, body []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	payload := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payload)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		rawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payload + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signKey := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	signKey = hmacSHA256(signKey, s.cfg.Region)
+	signKey = hmacSHA256(signKey, "s3")
+	signKey = hmacSHA256(signKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signKey, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape - uri encoding as required by signature v4, slash is kept in paths
+func s3Escape(v string, query bool) string {
+	res := strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+	res = strings.ReplaceAll(res, "%7E", "~")
+	if !query {
+		res = strings.ReplaceAll(res, "%2F", "/")
+	}
+	return res
+}
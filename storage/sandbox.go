@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ErrMaliciousBag - bag header contains names which can escape bag directory, such bag is not downloaded
+var ErrMaliciousBag = errors.New("malicious bag")
+
+// validateFileName - checks name from bag header, it should be relative path inside bag directory on any OS.
+// Names are separated only by '/', so backslashes and ':' are rejected too, because on Windows they would be
+// treated as separators, absolute paths or data streams, and so are device names and trailing dots or spaces.
+func validateFileName(name string, isFile bool) error {
+	if err := validateNameCodePoints(name); err != nil {
+		return err
+	}
+
+	if name == "" {
+		if isFile {
+			return fmt.Errorf("file name cannot be empty")
+		}
+		return nil
+	}
+
+	if strings.HasPrefix(name, "/") {
+		return fmt.Errorf("name cannot start with '/'")
+	}
+	if strings.Contains(name, "\\") {
+		return fmt.Errorf("name cannot contain '\\'")
+	}
+	if strings.Contains(name, ":") {
+		// drive letter on Windows, or alternate data stream of NTFS in any other position
+		return fmt.Errorf("name cannot contain ':'")
+	}
+	if isFile && strings.HasSuffix(name, "/") {
+		return fmt.Errorf("file name cannot end with /")
+	}
+
+	// dir name can end with '/', then last part is empty
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	for _, p := range parts {
+		switch p {
+		case "":
+			return fmt.Errorf("name cannot contain empty path element")
+		case ".", "..":
+			return fmt.Errorf("name cannot contain traversal '%s'", p)
+		}
+
+		// Windows trims them, so different names would point to the same file
+		if strings.HasSuffix(p, ".") || strings.HasSuffix(p, " ") {
+			return fmt.Errorf("name element cannot end with dot or space")
+		}
+		if isReservedName(p) {
+			return fmt.Errorf("name cannot contain reserved device name '%s'", p)
+		}
+	}
+	return nil
+}
+
+// isReservedName - element is device name on Windows, with any extension it still opens the device
+func isReservedName(p string) bool {
+	if i := strings.IndexByte(p, '.'); i >= 0 {
+		p = p[:i]
+	}
+	p = strings.ToUpper(strings.TrimRight(p, " "))
+
+	switch p {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(p) == 4 && (strings.HasPrefix(p, "COM") || strings.HasPrefix(p, "LPT")) && p[3] >= '1' && p[3] <= '9' {
+		return true
+	}
+	return false
+}
+
+// validateHeaderNames - checks all names of bag header, one bad name is enough to reject the whole bag,
+// because header is made by its creator, and it has no legit reasons to contain such names
+func validateHeaderNames(header *TorrentHeader) error {
+	if err := validateFileName(string(header.DirName), false); err != nil {
+		return fmt.Errorf("%w: dir name %q: %v", ErrMaliciousBag, header.DirName, err)
+	}
+
+	var from uint64
+	for i, to := range header.NameIndex {
+		if to > uint64(len(header.Names)) || to < from {
+			return fmt.Errorf("%w: name %d is out of range", ErrMaliciousBag, i)
+		}
+
+		name := string(header.Names[from:to])
+		if err := validateFileName(name, true); err != nil {
+			return fmt.Errorf("%w: file %d name %q: %v", ErrMaliciousBag, i, name, err)
+		}
+		from = to
+	}
+	return nil
+}
+
+// checkSandboxed - last line of defence, verifies that resolved file path is inside bag directory
+// (or its staging directory) before anything is written there
+func (t *Torrent) checkSandboxed(path string) error {
+	roots := []string{t.Path}
+	if StagingDir != "" {
+		roots = append(roots, filepath.Join(StagingDir, hex.EncodeToString(t.BagID)))
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path %s: %w", path, err)
+	}
+
+	for _, root := range roots {
+		rootAbs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(rootAbs, abs)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: path %s is outside of bag directory", ErrMaliciousBag, path)
+}
+
+// rejectMalicious - reports malicious bag and stops it, reason is visible to user as bag error
+func (t *Torrent) rejectMalicious(err error) {
+	Log.Warn("bag is rejected", compStorage, BagAttr(t.BagID), ErrAttr(err))
+	t.logEvent("MALICIOUS_BAG", err)
+	t.stopWithError(err)
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestValidateFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		isFile bool
+		valid  bool
+	}{
+		{"file.txt", true, true},
+		{"dir/sub/file.txt", true, true},
+		{"..file", true, true},
+		{"file.tar.gz", true, true},
+		{"dir/.hidden", true, true},
+		{"CONFIG.txt", true, true},
+		{"com10", true, true},
+		{"nullable/file", true, true},
+		{"dir/C:file", true, false},
+		{"file.txt:stream", true, false},
+		{"file..", true, false},
+		{"dir/...", true, false},
+		{"file.txt.", true, false},
+		{"file ", true, false},
+		{"dir /file", true, false},
+		{"CON", true, false},
+		{"con.txt", true, false},
+		{"dir/NUL.tar.gz", true, false},
+		{"aux/file", true, false},
+		{"COM1", true, false},
+		{"lpt9.log", true, false},
+		{"PRN ", true, false},
+		{"", false, true},
+		{"dir/", false, true},
+		{"", true, false},
+		{"dir/", true, false},
+		{"..", true, false},
+		{".", true, false},
+		{"../file", true, false},
+		{"dir/../../file", true, false},
+		{"dir/./file", true, false},
+		{"dir/..", true, false},
+		{"../", false, false},
+		{"dir//file", true, false},
+		{"/etc/passwd", true, false},
+		{"/", false, false},
+		{"//server/share/file", true, false},
+		{"C:", true, false},
+		{"C:file", true, false},
+		{"a:b", true, false},
+		{"c:/windows/system32", true, false},
+		{"Z:\\file", true, false},
+		{"\\file", true, false},
+		{"dir\\file", true, false},
+		{"..\\..\\file", true, false},
+		{"\\\\server\\share\\file", true, false},
+		{"dir/file\x00.txt", true, false},
+		{"file\n.txt", true, false},
+		{"file\u202Etxt.exe", true, false},
+		{"\xff\xfe", true, false},
+	}
+
+	for _, tt := range tests {
+		err := validateFileName(tt.name, tt.isFile)
+		if tt.valid && err != nil {
+			t.Errorf("name %q (file %v) rejected: %v", tt.name, tt.isFile, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("name %q (file %v) accepted", tt.name, tt.isFile)
+		}
+	}
+}
+
+func TestValidateHeaderNames(t *testing.T) {
+	header := func(dir string, names ...string) *TorrentHeader {
+		h := &TorrentHeader{DirName: []byte(dir)}
+		for _, n := range names {
+			h.Names = append(h.Names, n...)
+			h.NameIndex = append(h.NameIndex, uint64(len(h.Names)))
+		}
+		return h
+	}
+
+	if err := validateHeaderNames(header("bag", "a.txt", "dir/b.txt")); err != nil {
+		t.Fatalf("valid header rejected: %v", err)
+	}
+
+	bad := map[string]*TorrentHeader{
+		"dir name traversal": header("..", "a.txt"),
+		"absolute dir name":  header("/tmp", "a.txt"),
+		"one bad file name":  header("bag", "a.txt", "../b.txt", "c.txt"),
+		"drive letter":       header("", "C:\\Windows\\file.dll"),
+		"index out of range": {Names: []byte("a.txt"), NameIndex: []uint64{10}},
+		"decreasing index":   {Names: []byte("a.txtb.txt"), NameIndex: []uint64{5, 3}},
+	}
+	for name, h := range bad {
+		if err := validateHeaderNames(h); !errors.Is(err, ErrMaliciousBag) {
+			t.Errorf("%s: expected ErrMaliciousBag, got %v", name, err)
+		}
+	}
+}
+
+func TestTorrent_CheckSandboxed(t *testing.T) {
+	oldStaging := StagingDir
+	defer func() {
+		StagingDir = oldStaging
+	}()
+
+	base := t.TempDir()
+	tor := NewTorrent(filepath.Join(base, "bag"), nil, nil)
+	tor.BagID = make([]byte, 32)
+	StagingDir = filepath.Join(base, "staging")
+
+	inside := []string{
+		filepath.Join(base, "bag", "file"),
+		filepath.Join(base, "bag", "dir", "file"),
+		filepath.Join(base, "bag", "dir", "..", "file"),
+		filepath.Join(base, "bag", "..file"),
+		filepath.Join(StagingDir, hex.EncodeToString(tor.BagID), "file"),
+	}
+	outside := []string{
+		filepath.Join(base, "bag"),
+		filepath.Join(base, "file"),
+		filepath.Join(base, "bag", "..", "file"),
+		filepath.Join(base, "bag", "dir", "..", "..", "file"),
+		filepath.Join(base, "bag2", "file"),
+		filepath.Join(StagingDir, "file"),
+		filepath.Join(StagingDir, "other", "file"),
+		base,
+	}
+
+	if runtime.GOOS == "windows" {
+		// backslash is separator and other drive can't be made relative to bag dir
+		outside = append(outside,
+			filepath.Join(base, "bag")+`\..\..\file`,
+			`Z:\bag\file`,
+			`\\server\share\file`,
+		)
+	} else {
+		// backslash is a part of file name, it stays inside
+		inside = append(inside, filepath.Join(base, "bag", `..\..\file`))
+		outside = append(outside, "/etc/passwd")
+	}
+
+	for _, p := range inside {
+		if err := tor.checkSandboxed(p); err != nil {
+			t.Errorf("path %s inside of bag rejected: %v", p, err)
+		}
+	}
+	for _, p := range outside {
+		if err := tor.checkSandboxed(p); !errors.Is(err, ErrMaliciousBag) {
+			t.Errorf("path %s outside of bag: expected ErrMaliciousBag, got %v", p, err)
+		}
+	}
+
+	// without staging dir, only bag dir is allowed
+	StagingDir = ""
+	if err := tor.checkSandboxed(filepath.Join(base, "staging", hex.EncodeToString(tor.BagID), "file")); err == nil {
+		t.Error("path in staging dir accepted when staging is disabled")
+	}
+}
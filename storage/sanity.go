@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"fmt"
+)
+
+// Limits for bags added by id, their info and header come from peers and cannot be trusted.
+// Absurd values are rejected before any memory is allocated for pieces mask or files index.
+// 0 = unlimited.
+var (
+	MaxBagSize   uint64 = 4 << 40
+	MaxPiecesNum uint32 = 16 << 20
+	MaxFilesNum  uint32 = 1_000_000
+)
+
+const (
+	maxHeaderSize = 20 << 20
+	maxPieceSize  = 64 << 20
+)
+
+func checkInfoLimits(info *TorrentInfo) error {
+	if info.PieceSize == 0 || info.HeaderSize == 0 {
+		return fmt.Errorf("incorrect torrent info sizes")
+	}
+	if info.HeaderSize > maxHeaderSize {
+		return fmt.Errorf("too big header > 20 MB, looks dangerous")
+	}
+	if info.PieceSize > maxPieceSize {
+		return fmt.Errorf("too big piece > 64 MB, looks dangerous")
+	}
+	if info.HeaderSize > info.FileSize {
+		return fmt.Errorf("header size %d is bigger than bag size %d", info.HeaderSize, info.FileSize)
+	}
+	if MaxBagSize > 0 && info.FileSize > MaxBagSize {
+		return fmt.Errorf("bag size %s is bigger than allowed %s", ToSz(info.FileSize), ToSz(MaxBagSize))
+	}
+
+	pieces := info.FileSize / uint64(info.PieceSize)
+	if info.FileSize%uint64(info.PieceSize) != 0 {
+		pieces++
+	}
+	if pieces > 0xFFFFFFFF || (MaxPiecesNum > 0 && pieces > uint64(MaxPiecesNum)) {
+		return fmt.Errorf("bag has %d pieces, more than allowed %d", pieces, MaxPiecesNum)
+	}
+	return nil
+}
+
+func checkHeaderLimits(header *TorrentHeader, info *TorrentInfo) error {
+	if MaxFilesNum > 0 && header.FilesCount > MaxFilesNum {
+		return fmt.Errorf("bag has %d files, more than allowed %d", header.FilesCount, MaxFilesNum)
+	}
+	if uint32(len(header.NameIndex)) != header.FilesCount ||
+		uint32(len(header.DataIndex)) != header.FilesCount {
+		return fmt.Errorf("corrupted header, lack of files info")
+	}
+
+	var prevData, prevName uint64
+	for i := range header.DataIndex {
+		if header.DataIndex[i] < prevData || header.NameIndex[i] < prevName {
+			return fmt.Errorf("corrupted header, files index is not sorted")
+		}
+		prevData, prevName = header.DataIndex[i], header.NameIndex[i]
+	}
+	if prevData > info.FileSize-info.HeaderSize {
+		return fmt.Errorf("corrupted header, files are bigger than bag")
+	}
+	if prevName > uint64(len(header.Names)) {
+		return fmt.Errorf("corrupted header, names are out of range")
+	}
+	return nil
+}
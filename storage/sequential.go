@@ -0,0 +1,44 @@
+package storage
+
+// SequentialPrefetch - how many pieces are downloading ahead in sequential mode,
+// small window keeps pieces arriving in file order, so media can be played while downloading
+var SequentialPrefetch = 16
+
+// SetSequential - enables downloading of pieces in files order, for streaming.
+// When bag is downloading, download is restarted with the new strategy.
+func (t *Torrent) SetSequential(sequential bool) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.sequential == sequential {
+		return nil
+	}
+	t.sequential = sequential
+
+	if active, _ := t.IsActive(); !active || t.stopDownload == nil {
+		return nil
+	}
+	return t.startDownload(t.stopOnError())
+}
+
+func (t *Torrent) IsSequential() bool {
+	return t.sequential
+}
+
+// downloadWindow - returns number of download threads and prefetch size for the current strategy
+func (t *Torrent) downloadWindow() (threads, prefetch int) {
+	c := t.downloadConcurrency()
+	if !t.sequential {
+		return c.Threads, c.Prefetch
+	}
+
+	prefetch = SequentialPrefetch
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+	threads = c.Threads
+	if threads > prefetch {
+		threads = prefetch
+	}
+	return threads, prefetch
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/pterm/pterm"
 	"github.com/xssnick/tonutils-go/adnl"
 	"github.com/xssnick/tonutils-go/adnl/dht"
 	"github.com/xssnick/tonutils-go/adnl/overlay"
@@ -29,41 +28,79 @@ type Server struct {
 	closeCtx context.Context
 
 	bootstrapped map[string]*PeerConnection
-	mx           sync.RWMutex
+	staticPeers  map[string]*staticPeer
+	// bannedNodes - nodes which are not connected for any bag, set by operator
+	bannedNodes map[string]bool
+	// relays - nodes which are forwarding traffic of our bags, relayed - bags of other nodes forwarded by us
+	relays  []relayNode
+	relayed map[string]*relayedBag
+	// pushHandler - adds bags pushed to us by nodes from pushTrusted
+	pushHandler PushHandler
+	pushTrusted map[string]bool
+	// standbyNodes - nodes which are allowed to mirror our bags
+	standbyNodes map[string]bool
+	// coSeeders - members of co-seeder group, they can request our catalog
+	coSeeders map[string]bool
+	mx        sync.RWMutex
+
+	dhtStats map[string]*dhtCounter
+	// announces - results of the last DHT announces of bags, by hex bag id
+	announces   map[string]AnnounceStatus
+	announcesMx sync.Mutex
+	serverMode  bool
+	// dials - slots of concurrent outbound connection attempts, nil = unlimited
+	dials chan struct{}
 
 	closer func()
+	wg     sync.WaitGroup
 }
 
 func NewServer(dht *dht.Client, gate *adnl.Gateway, key ed25519.PrivateKey, serverMode, seedMode bool) *Server {
+	return NewServerWithContext(context.Background(), dht, gate, key, serverMode, seedMode)
+}
+
+// NewServerWithContext - same as NewServer, but server is stopped when ctx is done
+func NewServerWithContext(ctx context.Context, dht *dht.Client, gate *adnl.Gateway, key ed25519.PrivateKey, serverMode, seedMode bool) *Server {
 	s := &Server{
 		key:          key,
 		dht:          dht,
 		gate:         gate,
 		bootstrapped: map[string]*PeerConnection{},
+		staticPeers:  map[string]*staticPeer{},
+		bannedNodes:  map[string]bool{},
+		dhtStats:     newDHTCounters(),
+		announces:    map[string]AnnounceStatus{},
+		serverMode:   serverMode,
 	}
-	s.closeCtx, s.closer = context.WithCancel(context.Background())
+	if MaxConcurrentDials > 0 {
+		s.dials = make(chan struct{}, MaxConcurrentDials)
+	}
+	s.closeCtx, s.closer = context.WithCancel(ctx)
 	s.gate.SetConnectionHandler(s.bootstrapPeerWrap)
 
 	if serverMode {
+		s.wg.Add(1)
 		go func() {
+			defer s.wg.Done()
+
 			wait := 1 * time.Second
 			// refresh dht records
 			for {
 				select {
 				case <-s.closeCtx.Done():
-					Logger("[STORAGE_DHT] STOPPED DHT UPDATER")
+					Log.Debug("stopped dht updater", compDHT)
 					return
 				case <-time.After(wait):
 				}
 
-				Logger("[STORAGE_DHT] UPDATING OUR ADDRESS RECORD...")
+				Log.Debug("updating our address record", compDHT)
 
 				ctx, cancel := context.WithTimeout(s.closeCtx, 100*time.Second)
 				err := s.updateDHT(ctx)
 				cancel()
 
 				if err != nil {
-					Logger("[STORAGE_DHT] FAILED TO UPDATE OUR ADDRESS RECORD", err.Error())
+					Log.Warn("failed to update our address record", compDHT, ErrAttr(err))
 
 					// on err, retry sooner
 					wait = 5 * time.Second
@@ -75,8 +112,10 @@ func NewServer(dht *dht.Client, gate *adnl.Gateway, key ed25519.PrivateKey, serv
 	}
 
 	if serverMode || seedMode {
+		s.wg.Add(1)
 		go func() {
-			lastUpdate := map[string]int64{}
+			defer s.wg.Done()
+
 			wait := 5 * time.Second
 			// refresh dht records
 			for {
@@ -92,16 +131,14 @@ func NewServer(dht *dht.Client, gate *adnl.Gateway, key ed25519.PrivateKey, serv
 
 				list := s.store.GetAll()
 				for _, torrent := range list {
-					if !torrent.activeUpload {
+					if _, upl := torrent.IsActive(); !upl {
 						continue
 					}
 
-					lastAt := lastUpdate[hex.EncodeToString(torrent.BagID)]
-					if lastAt+180 <= time.Now().Unix() {
+					if !torrent.IsUnlisted() && s.announceDue(torrent) {
 						ctx, cancel := context.WithTimeout(s.closeCtx, 45*time.Second)
-						_ = s.updateTorrent(ctx, torrent, serverMode)
+						_ = s.announce(ctx, torrent)
 						cancel()
-						lastUpdate[hex.EncodeToString(torrent.BagID)] = time.Now().Unix()
 					}
 				}
 			}
@@ -113,9 +150,22 @@ func NewServer(dht *dht.Client, gate *adnl.Gateway, key ed25519.PrivateKey, serv
 
 func (s *Server) SetStorage(store Storage) {
 	s.store = store
+	s.loadBannedNodes()
 }
 
 func (s *Server) bootstrapPeerWrap(client adnl.Peer) error {
+	if s.IsNodeBanned(client.GetID()) {
+		Log.Debug("rejecting connection, node is banned", compStorage, PeerAttr(client.GetID()), "addr", client.RemoteAddr())
+		return fmt.Errorf("node is banned")
+	}
+	if err := s.checkPinnedKey(client.RemoteAddr(), client.GetID()); err != nil {
+		Log.Warn("rejecting connection, key of pinned peer changed", compStorage, PeerAttr(client.GetID()), "addr", client.RemoteAddr(), ErrAttr(err))
+		return err
+	}
+	if s.GetPeerIfActive(client.GetID()) == nil && s.connectionsLimitReached() {
+		Log.Debug("rejecting connection, connections limit reached", compStorage, PeerAttr(client.GetID()), "addr", client.RemoteAddr())
+		return fmt.Errorf("too many connections")
+	}
 	s.bootstrapPeer(client)
 	return nil
 }
@@ -148,9 +198,10 @@ func (s *Server) bootstrapPeer(client adnl.Peer) *PeerConnection {
 	})
 
 	p := &PeerConnection{
-		rldp:       rl,
-		adnl:       client,
-		usedByBags: map[string]*storagePeer{},
+		rldp:           rl,
+		adnl:           client,
+		usedByBags:     map[string]*storagePeer{},
+		authorizedBags: map[string]bool{},
 	}
 	s.bootstrapped[hex.EncodeToString(client.GetID())] = p
 
@@ -165,8 +216,22 @@ func (s *Server) handleQuery(peer *overlay.ADNLWrapper) func(query *adnl.Message
 			return fmt.Errorf("storage is not yet initialized")
 		}
 
+		switch q := req.(type) {
+		case RelayRegister:
+			return s.handleRelayRegister(peer, query, q, over)
+		case PushBag:
+			return s.handlePushBag(peer, query, q, over)
+		case StandbySync:
+			return s.handleStandbySync(peer, query)
+		case CoSeedGetCatalog:
+			return s.handleCoSeedGetCatalog(peer, query)
+		}
+
 		t := s.store.GetTorrentByOverlay(over)
 		if t == nil {
+			if rb := s.getRelayed(over); rb != nil {
+				return s.relayQuery(peer, query, rb, req, over)
+			}
 			return fmt.Errorf("bag not found")
 		}
 
@@ -175,12 +240,22 @@ func (s *Server) handleQuery(peer *overlay.ADNLWrapper) func(query *adnl.Message
 			return fmt.Errorf("bag is not active")
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := t.checkAccess(peer.GetID()); err != nil {
+			return err
+		}
+
+		if t.IsPrivate() {
+			if p := s.GetPeerIfActive(peer.GetID()); p == nil || !p.isAuthorized(t.BagID) {
+				return fmt.Errorf("not authorized")
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
 		defer cancel()
 
-		switch req.(type) {
+		switch q := req.(type) {
 		case overlay.GetRandomPeers:
-			node, err := overlay.NewNode(t.BagID, s.key)
+			node, err := overlay.NewNode(t.OverlayKey(), s.key)
 			if err != nil {
 				return err
 			}
@@ -204,6 +279,37 @@ func (s *Server) handleQuery(peer *overlay.ADNLWrapper) func(query *adnl.Message
 			if err != nil {
 				return err
 			}
+		case CancelPieces:
+			if p := s.GetPeerIfActive(peer.GetID()); p != nil {
+				if stPeer := p.GetFor(t.BagID); stPeer != nil {
+					stPeer.markCancelled(q.PieceIDs)
+				}
+			}
+
+			err := peer.Answer(ctx, query.ID, Ok{})
+			if err != nil {
+				return err
+			}
+		case SignedBandwidthReceipt:
+			if err := t.addBandwidthReceipt(&q, peer.GetID(), s.gate.GetID()); err != nil {
+				Log.Debug("rejected bandwidth receipt", compStorage, BagAttr(t.BagID), PeerAttr(peer.GetID()), ErrAttr(err))
+				return err
+			}
+
+			err := peer.Answer(ctx, query.ID, Ok{})
+			if err != nil {
+				return err
+			}
+		case GetAnnounceData:
+			err := peer.Answer(ctx, query.ID, AnnounceData{Data: t.GetAnnounceData()})
+			if err != nil {
+				return err
+			}
+		case GetClientInfo:
+			err := peer.Answer(ctx, query.ID, ClientInfo{Name: ClientName})
+			if err != nil {
+				return err
+			}
 		}
 
 		return nil
@@ -220,6 +326,9 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 
 		t := s.store.GetTorrentByOverlay(over)
 		if t == nil {
+			if rb := s.getRelayed(over); rb != nil {
+				return s.relayRLDPQuery(peer, transfer, query, rb, req, over)
+			}
 			return fmt.Errorf("bag not found")
 		}
 
@@ -234,9 +343,36 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 		if p == nil {
 			return fmt.Errorf("peer disconnected")
 		}
+
+		if err := t.checkAccess(adnlId); err != nil {
+			return err
+		}
+
+		if t.IsPrivate() {
+			if auth, ok := req.(PrivateAuth); ok {
+				if !t.checkPrivateAuth(auth, adnlId, s.gate.GetID()) {
+					Log.Warn("incorrect private auth", compStorage, BagAttr(t.BagID), PeerAttr(adnlId))
+					return fmt.Errorf("incorrect auth proof")
+				}
+				p.setAuthorized(t.BagID)
+
+				ctx, cancel := context.WithTimeout(s.closeCtx, 10*time.Second)
+				defer cancel()
+				return peer.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, Ok{})
+			}
+
+			if !p.isAuthorized(t.BagID) {
+				return fmt.Errorf("not authorized")
+			}
+		}
+
 		stPeer := p.GetFor(t.BagID)
 
 		if stPeer == nil {
+			if t.peersLimitReached() {
+				return fmt.Errorf("too many peers")
+			}
+
 			var sesId = rand.Int63()
 			switch q := req.(type) {
 			case Ping:
@@ -249,16 +385,20 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 			t.mx.Unlock()
 
 			// prepare torrent info if needed
-			go stPeer.prepareTorrentInfo(t)
+			t.spawn(stPeer.globalCtx, func() {
+				if stPeer.authorize(s) == nil {
+					_ = stPeer.prepareTorrentInfo(t)
+				}
+			})
 		}
 		stPeer.touch()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(s.closeCtx, 10*time.Second)
 		defer cancel()
 
 		switch q := req.(type) {
 		case overlay.GetRandomPeers:
-			node, err := overlay.NewNode(t.BagID, s.key)
+			node, err := overlay.NewNode(t.OverlayKey(), s.key)
 			if err != nil {
 				return err
 			}
@@ -282,12 +422,22 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 				return fmt.Errorf("bag is not for upload")
 			}
 
-			err := t.GetConnector().ThrottleUpload(ctx, uint64(t.Info.PieceSize))
-			if err != nil {
-				return err
-			}
+			receivedAt := time.Now()
+			serve := t.pieceHandler(func(ctx context.Context, pr *PieceRequest) (*Piece, error) {
+				if stPeer.isCancelledSince(q.PieceID, receivedAt) {
+					// requester is not waiting for it anymore, save bandwidth
+					return nil, fmt.Errorf("piece request was cancelled")
+				}
+				return t.GetPiece(pr.Piece)
+			})
 
-			p, err := t.GetPiece(uint32(q.PieceID))
+			p, err := serve(ctx, &PieceRequest{
+				Op:       PieceServe,
+				Torrent:  t,
+				Piece:    uint32(q.PieceID),
+				PeerID:   adnlId,
+				PeerAddr: stPeer.nodeAddr,
+			})
 			if err != nil {
 				return err
 			}
@@ -302,7 +452,7 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 			if atomic.LoadInt64(&stPeer.sessionId) != q.SessionID {
 				atomic.StoreInt64(&stPeer.sessionId, q.SessionID)
 				atomic.StoreInt64(&stPeer.sessionSeqno, 0)
-				Logger("[STORAGE] NEW SESSION WITH", hex.EncodeToString(adnlId), q.SessionID)
+				Log.Debug("new session", compStorage, BagAttr(t.BagID), PeerAttr(adnlId), "session", q.SessionID)
 			}
 
 			err := peer.SendAnswer(ctx, query.MaxAnswerSize, query.ID, transfer, Pong{})
@@ -315,15 +465,14 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 			stPeer.piecesMx.RUnlock()
 
 			if atomic.LoadInt64(&stPeer.sessionSeqno) == 0 {
-				stPeer.piecesMx.Lock()
-				stPeer.lastSentPieces = t.PiecesMask()
-				stPeer.piecesMx.Unlock()
+				// copy, because mask is updated in place when new pieces are downloaded
+				sent := append([]byte{}, t.PiecesMask()...)
 
 				up := AddUpdate{
 					SessionID: atomic.LoadInt64(&stPeer.sessionId),
 					Seqno:     atomic.AddInt64(&stPeer.sessionSeqno, 1),
 					Update: UpdateInit{
-						HavePieces:       stPeer.lastSentPieces,
+						HavePieces:       sent,
 						HavePiecesOffset: 0,
 						State: State{
 							WillUpload:   isUpl,
@@ -335,37 +484,41 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 				var updRes Ok
 				err = peer.DoQuery(ctx, query.MaxAnswerSize, overlay.WrapQuery(over, up), &updRes)
 				if err != nil {
+					// init is sent again on next ping
+					atomic.StoreInt64(&stPeer.sessionSeqno, 0)
 					return err
 				}
+
+				// pieces are remembered as sent only when peer acknowledged them
+				stPeer.piecesMx.Lock()
+				stPeer.lastSentPieces = sent
+				stPeer.piecesMx.Unlock()
 			} else if len(lastPieces) > 0 {
-				mask := t.PiecesMask()
-				var newPieces []int32
-				for i := 0; i < len(mask); i++ {
-					for j := 0; j < 8; j++ {
-						if mask[i]&(1<<j) > 0 && (i >= len(lastPieces) || lastPieces[i]&(1<<j) == 0) {
-							newPieces = append(newPieces, int32(i*8+j))
+				mask := append([]byte{}, t.PiecesMask()...)
+				updates := diffPiecesUpdates(mask, lastPieces, State{
+					WillUpload:   isUpl,
+					WantDownload: true,
+				})
+
+				if len(updates) > 0 {
+					for _, u := range updates {
+						up := AddUpdate{
+							SessionID: q.SessionID,
+							Seqno:     atomic.AddInt64(&stPeer.sessionSeqno, 1),
+							Update:    u,
+						}
+
+						var res Ok
+						err = peer.DoQuery(ctx, query.MaxAnswerSize, overlay.WrapQuery(over, up), &res)
+						if err != nil {
+							// not acknowledged pieces are sent again on next ping
+							return err
 						}
 					}
-				}
 
-				if len(newPieces) > 0 {
 					stPeer.piecesMx.Lock()
-					lastPieces = mask
+					stPeer.lastSentPieces = mask
 					stPeer.piecesMx.Unlock()
-
-					up := AddUpdate{
-						SessionID: q.SessionID,
-						Seqno:     atomic.AddInt64(&stPeer.sessionSeqno, 1),
-						Update: UpdateHavePieces{
-							PieceIDs: newPieces,
-						},
-					}
-
-					var res Ok
-					err = peer.DoQuery(ctx, query.MaxAnswerSize, overlay.WrapQuery(over, up), &res)
-					if err != nil {
-						return err
-					}
 				}
 			}
 		case GetTorrentInfo:
@@ -392,7 +545,7 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 		case AddUpdate:
 			switch u := q.Update.(type) {
 			case UpdateInit:
-				Logger("[STORAGE] NODE REPORTED PIECES INFO", hex.EncodeToString(adnlId), q.SessionID, q.Seqno)
+				Log.Debug("node reported pieces info", compStorage, BagAttr(t.BagID), PeerAttr(adnlId), "session", q.SessionID, "seqno", q.Seqno)
 				stPeer.piecesMx.Lock()
 				off := uint32(u.HavePiecesOffset)
 				for i := 0; i < len(u.HavePieces); i++ {
@@ -404,7 +557,7 @@ func (s *Server) handleRLDPQuery(peer *overlay.RLDPWrapper) func(transfer []byte
 				}
 				stPeer.piecesMx.Unlock()
 			case UpdateHavePieces:
-				Logger("[STORAGE] NODE HAS NEW PIECES", hex.EncodeToString(adnlId))
+				Log.Debug("node has new pieces", compStorage, BagAttr(t.BagID), PeerAttr(adnlId))
 				stPeer.piecesMx.Lock()
 				for _, d := range u.PieceIDs {
 					stPeer.hasPieces[uint32(d)] = true
@@ -428,25 +581,29 @@ func (s *Server) updateDHT(ctx context.Context) error {
 	ctxStore, cancel := context.WithTimeout(ctx, 80*time.Second)
 	stored, id, err := s.dht.StoreAddress(ctxStore, addr, 10*time.Minute, s.key, 8)
 	cancel()
+	s.countDHT(DHTQueryStoreAddress, err)
 	if err != nil && stored == 0 {
 		return err
 	}
 
 	// make sure it was saved
 	_, _, err = s.dht.FindAddresses(ctx, id)
+	s.countDHT(DHTQueryFindAddresses, err)
 	if err != nil {
 		return err
 	}
 
-	Logger("[STORAGE_DHT] OUR NODE ADDRESS UPDATED ON", stored, "NODES")
+	Log.Debug("our node address is updated", compDHT, "nodes", stored)
 
 	return nil
 }
 
-func (s *Server) updateTorrent(ctx context.Context, torrent *Torrent, isServer bool) error {
-	Logger("[STORAGE_DHT] CHECKING BAG OVERLAY FOR", hex.EncodeToString(torrent.BagID))
+// updateOverlay - adds or refreshes our node in DHT record of bag's overlay
+func (s *Server) updateOverlay(ctx context.Context, overlayKey, bagId []byte, isServer bool) error {
+	Log.Debug("checking bag overlay", compDHT, BagAttr(bagId))
 
-	nodesList, _, err := s.dht.FindOverlayNodes(ctx, torrent.BagID)
+	nodesList, _, err := s.dht.FindOverlayNodes(ctx, overlayKey)
+	s.countDHT(DHTQueryFindOverlayNodes, err)
 	if err != nil && !errors.Is(err, dht.ErrDHTValueIsNotFound) {
 		println(err.Error())
 		return err
@@ -456,9 +613,9 @@ func (s *Server) updateTorrent(ctx context.Context, torrent *Torrent, isServer b
 		nodesList = &overlay.NodesList{}
 	}
 
-	node, err := overlay.NewNode(torrent.BagID, s.key)
+	node, err := overlay.NewNode(overlayKey, s.key)
 	if err != nil {
-		pterm.Warning.Printf("Failed to update DHT record for bag %s: %v", hex.EncodeToString(torrent.BagID), err)
+		Log.Warn("failed to update DHT record", compDHT, BagAttr(bagId), ErrAttr(err))
 		return err
 	}
 
@@ -503,20 +660,23 @@ func (s *Server) updateTorrent(ctx context.Context, torrent *Torrent, isServer b
 
 	if refreshed {
 		ctxStore, cancel := context.WithTimeout(ctx, 45*time.Second)
-		stored, _, err := s.dht.StoreOverlayNodes(ctxStore, torrent.BagID, nodesList, 60*time.Minute, 5)
+		stored, _, err := s.dht.StoreOverlayNodes(ctxStore, overlayKey, nodesList, 60*time.Minute, 5)
 		cancel()
+		s.countDHT(DHTQueryStoreOverlayNodes, err)
 		if err != nil && stored == 0 {
-			pterm.Warning.Printf("Failed to store DHT record for bag %s: %v", hex.EncodeToString(torrent.BagID), err)
+			Log.Warn("failed to store DHT record", compDHT, BagAttr(bagId), ErrAttr(err))
 			return err
 		}
-		Logger("[STORAGE_DHT] BAG OVERLAY UPDATED ON", stored, "NODES FOR", hex.EncodeToString(torrent.BagID))
+		Log.Debug("bag overlay is updated", compDHT, BagAttr(bagId), "nodes", stored)
 	}
 	return nil
 }
 
+// Stop - stops DHT updaters and cancels peers queries, waits till updaters are finished.
+// Bags should be stopped before, using storage.
 func (s *Server) Stop() {
 	s.closer()
-	return
+	s.wg.Wait()
 }
 
 func (s *Server) addTorrentNode(node *overlay.Node, t *Torrent) {
@@ -534,7 +694,7 @@ func (s *Server) addTorrentNode(node *overlay.Node, t *Torrent) {
 	defer t.peersMx.Unlock()
 
 	if t.knownNodes[hex.EncodeToString(nodeId)] == nil {
-		Logger("[STORAGE] ADD KNOWN NODE ", hex.EncodeToString(nodeId), "for", hex.EncodeToString(t.BagID))
+		Log.Debug("add known node", compStorage, BagAttr(t.BagID), PeerAttr(nodeId))
 		t.knownNodes[hex.EncodeToString(nodeId)] = node
 
 		go s.nodeConnector(nodeId, t, node, 1)
@@ -559,6 +719,33 @@ func (s *Server) nodeConnector(adnlID []byte, t *Torrent, node *overlay.Node, at
 		}
 	}
 
+	if till := t.peerBannedTill(adnlID); !till.IsZero() || s.IsNodeBanned(adnlID) {
+		// ban can be cleared by operator, so we are rechecking it
+		wait := time.Until(till)
+		if wait <= 0 || wait > banRecheckInterval {
+			wait = banRecheckInterval
+		}
+
+		select {
+		case <-t.globalCtx.Done():
+			onFail()
+		case <-time.After(wait):
+			go s.nodeConnector(adnlID, t, node, attempt)
+		}
+		return
+	}
+
+	if t.peersLimitReached() || (s.GetPeerIfActive(adnlID) == nil && s.connectionsLimitReached()) {
+		// node stays known, we will connect it when some peer disconnects
+		select {
+		case <-t.globalCtx.Done():
+			onFail()
+		case <-time.After(limitsRetryInterval):
+			go s.nodeConnector(adnlID, t, node, attempt)
+		}
+		return
+	}
+
 	scaleCtx, stopScale := context.WithTimeout(t.globalCtx, 120*time.Second)
 	stNode, err := s.connectToNode(scaleCtx, t, adnlID, node)
 	stopScale()
@@ -574,23 +761,37 @@ func (s *Server) nodeConnector(adnlID []byte, t *Torrent, node *overlay.Node, at
 		}
 	}()
 
-	Logger("[STORAGE] ADDED PEER", hex.EncodeToString(adnlID), "FOR", hex.EncodeToString(t.BagID))
+	Log.Debug("added peer", compStorage, BagAttr(t.BagID), PeerAttr(adnlID))
 }
 
 func (s *Server) connectToNode(ctx context.Context, t *Torrent, adnlID []byte, node *overlay.Node) (*storagePeer, error) {
+	cached := false
 	peer := s.GetPeerIfActive(adnlID)
 	if peer == nil {
-		lcCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		addrs, keyN, err := s.dht.FindAddresses(lcCtx, adnlID)
-		cancel()
+		// many bags can start at once, so dials are limited to not flood network and DHT
+		release, err := s.acquireDial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		var addr string
+		var keyN ed25519.PublicKey
+		addr, keyN, cached, err = s.resolveNodeAddress(ctx, adnlID)
 		if err != nil {
-			Logger("[STORAGE] NOT FOUND NODE ADDR OF", hex.EncodeToString(adnlID), "FOR", hex.EncodeToString(t.BagID))
+			Log.Debug("not found node address", compStorage, BagAttr(t.BagID), PeerAttr(adnlID))
 			return nil, fmt.Errorf("failed to find node address: %w", err)
 		}
 
-		Logger("[STORAGE] ADDR FOR NODE ", hex.EncodeToString(adnlID), "FOUND", addrs.Addresses[0].IP.String(), "FOR", hex.EncodeToString(t.BagID))
+		Log.Debug("found node address", compStorage, BagAttr(t.BagID), PeerAttr(adnlID), "addr", addr)
 
-		addr := addrs.Addresses[0].IP.String() + ":" + fmt.Sprint(addrs.Addresses[0].Port)
+		if err = s.checkPinnedKey(addr, adnlID); err != nil {
+			Log.Warn("refusing to connect, key of pinned peer changed", compStorage, PeerAttr(adnlID), ErrAttr(err))
+			if cached {
+				s.forgetNodeAddress(adnlID)
+			}
+			return nil, err
+		}
 
 		ax, err := s.gate.RegisterClient(addr, keyN)
 		if err != nil {
@@ -598,7 +799,7 @@ func (s *Server) connectToNode(ctx context.Context, t *Torrent, adnlID []byte, n
 		}
 		peer = s.bootstrapPeer(ax)
 	} else {
-		Logger("[STORAGE] HAS ALREADY ACTIVE PEER FOR NODE ", hex.EncodeToString(adnlID), "FOUND", peer.adnl.RemoteAddr(), "FOR", hex.EncodeToString(t.BagID))
+		Log.Debug("already has active peer for node", compStorage, BagAttr(t.BagID), PeerAttr(adnlID), "addr", peer.adnl.RemoteAddr())
 	}
 
 	addr := peer.adnl.RemoteAddr()
@@ -607,11 +808,20 @@ func (s *Server) connectToNode(ctx context.Context, t *Torrent, adnlID []byte, n
 	stNode := t.initStoragePeer(t.globalCtx, node.Overlay, s, peer, rand.Int63())
 	t.mx.Unlock()
 
+	if err := stNode.authorize(s); err != nil {
+		stNode.Close()
+		if cached {
+			// node could change address, it will be resolved using DHT next time
+			s.forgetNodeAddress(adnlID)
+		}
+		return nil, err
+	}
+
 	if err := stNode.prepareTorrentInfo(t); err != nil {
 		stNode.Close()
 	}
 
-	Logger("[STORAGE] PEER PREPARED", hex.EncodeToString(adnlID), addr, "FOR", hex.EncodeToString(t.BagID))
+	Log.Debug("peer prepared", compStorage, BagAttr(t.BagID), PeerAttr(adnlID), "addr", addr)
 
 	return stNode, nil
 }
@@ -619,17 +829,17 @@ func (s *Server) connectToNode(ctx context.Context, t *Torrent, adnlID []byte, n
 func (s *storagePeer) prepareTorrentInfo(t *Torrent) error {
 	if t.Info == nil {
 		tm := time.Now()
-		Logger("[STORAGE] REQUESTING TORRENT INFO FROM", hex.EncodeToString(s.nodeId), s.nodeAddr, "FOR", hex.EncodeToString(t.BagID))
+		Log.Debug("requesting bag info", compStorage, BagAttr(t.BagID), PeerAttr(s.nodeId), "addr", s.nodeAddr)
 
 		var res TorrentInfoContainer
 		infCtx, cancel := context.WithTimeout(s.globalCtx, 20*time.Second)
 		err := s.conn.rldp.DoQuery(infCtx, 1<<25, overlay.WrapQuery(s.overlay, &GetTorrentInfo{}), &res)
 		cancel()
 		if err != nil {
-			Logger("[STORAGE] ERR ", err.Error(), " REQUESTING TORRENT INFO FROM", hex.EncodeToString(s.nodeId), s.nodeAddr, "FOR", hex.EncodeToString(t.BagID))
+			Log.Debug("failed to request bag info", compStorage, BagAttr(t.BagID), PeerAttr(s.nodeId), "addr", s.nodeAddr, ErrAttr(err))
 			return err
 		}
-		Logger("[STORAGE] GOT TORRENT INFO TOOK", time.Since(tm).String(), "FROM", hex.EncodeToString(s.nodeId), s.nodeAddr, "FOR", hex.EncodeToString(t.BagID))
+		Log.Debug("got bag info", compStorage, BagAttr(t.BagID), PeerAttr(s.nodeId), "addr", s.nodeAddr, "took", time.Since(tm))
 
 		cl, err := cell.FromBOC(res.Data)
 		if err != nil {
@@ -646,17 +856,8 @@ func (s *storagePeer) prepareTorrentInfo(t *Torrent) error {
 			return fmt.Errorf("invalid torrent info cell")
 		}
 
-		if info.PieceSize == 0 || info.HeaderSize == 0 {
-			err = fmt.Errorf("incorrect torrent info sizes")
-			return err
-		}
-		if info.HeaderSize > 20*1024*1024 {
-			err = fmt.Errorf("too big header > 20 MB, looks dangerous")
-			return err
-		}
-		if info.PieceSize > 64*1024*1024 {
-			err = fmt.Errorf("too big piece > 64 MB, looks dangerous")
-			return err
+		if err = checkInfoLimits(&info); err != nil {
+			return fmt.Errorf("bag info rejected: %w", err)
 		}
 
 		t.mx.Lock()
@@ -671,19 +872,20 @@ func (s *Server) StartPeerSearcher(t *Torrent) {
 	for {
 		var err error
 		var nodes *overlay.NodesList
-		Logger("[STORAGE] SEARCHING PEERS FOR", hex.EncodeToString(t.BagID))
+		Log.Debug("searching peers", compStorage, BagAttr(t.BagID))
 
 		ctxFind, cancel := context.WithTimeout(t.globalCtx, time.Duration(45)*time.Second)
-		nodes, nodesDhtCont, err = s.dht.FindOverlayNodes(ctxFind, t.BagID, nodesDhtCont)
+		nodes, nodesDhtCont, err = s.dht.FindOverlayNodes(ctxFind, t.OverlayKey(), nodesDhtCont)
 		cancel()
+		s.countDHT(DHTQueryFindOverlayNodes, err)
 		if err != nil {
 			select {
 			case <-t.globalCtx.Done():
-				Logger("[STORAGE] DHT CONTEXT CANCEL", hex.EncodeToString(t.BagID))
+				Log.Debug("dht search is cancelled", compDHT, BagAttr(t.BagID))
 				return
 			case <-time.After(1 * time.Second):
 				nodesDhtCont = nil
-				Logger("[STORAGE] DHT RETRY", hex.EncodeToString(t.BagID))
+				Log.Debug("dht search retry", compDHT, BagAttr(t.BagID))
 				continue
 			}
 		}
@@ -728,7 +930,40 @@ func (t *Torrent) initStoragePeer(globalCtx context.Context, overlay []byte, srv
 
 	conn.UseFor(stNode)
 	stNode.globalCtx, stNode.stop = context.WithCancel(globalCtx)
-	go stNode.pinger(srv)
+	if !t.spawn(stNode.globalCtx, func() {
+		stNode.pinger(srv)
+	}) {
+		// bag is stopped already, closed outside of bag lock, which is held by caller
+		go stNode.Close()
+	}
 
 	return stNode
 }
+
+// WaitAnnounced - blocks till our node is resolvable in bag's overlay DHT record
+func (s *Server) WaitAnnounced(ctx context.Context, t *Torrent) error {
+	ourKey := s.key.Public().(ed25519.PublicKey)
+	for {
+		ctxFind, cancel := context.WithTimeout(ctx, 30*time.Second)
+		nodes, _, err := s.dht.FindOverlayNodes(ctxFind, t.OverlayKey())
+		cancel()
+		s.countDHT(DHTQueryFindOverlayNodes, err)
+		if err != nil && !errors.Is(err, dht.ErrDHTValueIsNotFound) {
+			Log.Warn("failed to check announce of bag", compDHT, BagAttr(t.BagID), ErrAttr(err))
+		}
+
+		if nodes != nil {
+			for _, n := range nodes.List {
+				if id, ok := n.ID.(adnl.PublicKeyED25519); ok && id.Key.Equal(ourKey) {
+					return nil
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SmallBagPieces - bags with up to this number of pieces are requested whole together with header,
+// so tiny bags, like configs or single documents, are ready right after header without scheduling, 0 = disabled
+var SmallBagPieces uint32 = 8
+
+type fetchedPiece struct {
+	data  []byte
+	proof []byte
+	peer  []byte
+	addr  string
+}
+
+// downloadSmallBag - requests all pieces at once, they are taken by the first peers which have them
+func (t *torrentDownloader) downloadSmallBag(ctx context.Context) (map[uint32]*fetchedPiece, error) {
+	var wg sync.WaitGroup
+	var mx sync.Mutex
+	var firstErr error
+	pieces := make(map[uint32]*fetchedPiece, t.piecesNum)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for i := uint32(0); i < t.piecesNum; i++ {
+		wg.Add(1)
+		go func(i uint32) {
+			defer wg.Done()
+
+			data, proof, peer, addr, err := t.DownloadPieceDetailed(ctx, i)
+
+			mx.Lock()
+			defer mx.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to get piece %d, err: %w", i, err)
+				}
+				cancel()
+				return
+			}
+			pieces[i] = &fetchedPiece{data: data, proof: proof, peer: peer, addr: addr}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return pieces, nil
+}
+
+// takePrefetched - returns piece downloaded together with header, it is given only once
+func (t *torrentDownloader) takePrefetched(piece uint32) *fetchedPiece {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	p := t.prefetched[piece]
+	if p != nil {
+		delete(t.prefetched, piece)
+	}
+	return p
+}
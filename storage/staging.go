@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const PartFileSuffix = ".part"
+
+// StagingDir - when set, incomplete files are downloaded into StagingDir/<bag_id>/ and moved to destination when complete.
+var StagingDir = ""
+
+// UsePartFiles - when set (and StagingDir is not), incomplete files are downloaded with .part suffix near destination,
+// and renamed when complete. So destination tree contains only completed content.
+var UsePartFiles = false
+
+func stagingEnabled() bool {
+	return StagingDir != "" || UsePartFiles
+}
+
+// GetIncompleteFilePath - returns path where file is kept till it is fully downloaded, empty when staging is disabled
+func (t *Torrent) GetIncompleteFilePath(index uint32) (string, error) {
+	if !stagingEnabled() {
+		return "", nil
+	}
+
+	name, err := t.GetLocalFileName(index)
+	if err != nil {
+		return "", err
+	}
+	return t.incompletePath(name), nil
+}
+
+func (t *Torrent) incompletePath(localName string) string {
+	if StagingDir != "" {
+		return filepath.Join(StagingDir, hex.EncodeToString(t.BagID), string(t.Header.DirName), localName)
+	}
+	return t.Path + "/" + string(t.Header.DirName) + "/" + localName + PartFileSuffix
+}
+
+func (t *Torrent) finalPath(localName string) string {
+	return t.Path + "/" + string(t.Header.DirName) + "/" + localName
+}
+
+// writeFilePath - file which is already moved to destination is written in place, otherwise to staging
+func (t *Torrent) writeFilePath(localName string) string {
+	final := t.finalPath(localName)
+	if !stagingEnabled() || t.db.GetFS().Exists(final) {
+		return final
+	}
+	return t.incompletePath(localName)
+}
+
+// readFilePath - returns path where file data currently is
+func (t *Torrent) readFilePath(localName string) string {
+	final := t.finalPath(localName)
+	if stagingEnabled() && !t.db.GetFS().Exists(final) {
+		if p := t.incompletePath(localName); t.db.GetFS().Exists(p) {
+			return p
+		}
+	}
+	return final
+}
+
+func (t *Torrent) fileExists(localName string) bool {
+	if t.db.GetFS().Exists(t.finalPath(localName)) {
+		return true
+	}
+	return stagingEnabled() && t.db.GetFS().Exists(t.incompletePath(localName))
+}
+
+// commitFile - moves fully downloaded file from staging to destination
+func (t *Torrent) commitFile(localName string) error {
+	if !stagingEnabled() {
+		return nil
+	}
+
+	from, to := t.incompletePath(localName), t.finalPath(localName)
+	if !t.db.GetFS().Exists(from) {
+		return nil
+	}
+
+	// cached descriptor should be closed before move, windows is not allowing to rename opened files
+	fs.Forget(from)
+
+	if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create dir for %s: %w", to, err)
+	}
+
+	if err := os.Rename(from, to); err != nil {
+		// staging dir can be on another device, then we need to copy
+		if err = copyFile(from, to); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %w", from, to, err)
+		}
+		_ = os.Remove(from)
+	}
+	Log.Debug("file completed and moved", compStorage, BagAttr(t.BagID), "to", to)
+	return nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := to + PartFileSuffix
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, to)
+}
+
+// filesCommitter - tracks left pieces of downloading files, to commit them when all pieces are stored
+type filesCommitter struct {
+	t    *Torrent
+	left map[uint32]int
+	mx   sync.Mutex
+}
+
+func newFilesCommitter(t *Torrent, list []fileInfo, piecesMap map[uint32]bool) *filesCommitter {
+	c := &filesCommitter{
+		t:    t,
+		left: map[uint32]int{},
+	}
+
+	if !stagingEnabled() {
+		return c
+	}
+
+	for _, f := range list {
+		n := 0
+		for i := f.info.FromPiece; i <= f.info.ToPiece; i++ {
+			if piecesMap[i] {
+				n++
+			}
+		}
+		c.left[f.info.Index] = n
+	}
+	return c
+}
+
+// pieceStored - should be called when piece is written and its metadata is saved
+func (c *filesCommitter) pieceStored(files []*FileInfo) error {
+	if !stagingEnabled() {
+		return nil
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	for _, f := range files {
+		n, ok := c.left[f.Index]
+		if !ok {
+			continue
+		}
+
+		n--
+		if n > 0 {
+			c.left[f.Index] = n
+			continue
+		}
+		delete(c.left, f.Index)
+
+		name, err := c.t.GetLocalFileName(f.Index)
+		if err != nil {
+			return err
+		}
+		if err = c.t.commitFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
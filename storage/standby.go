@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"github.com/xssnick/tonutils-go/adnl/overlay"
+	"github.com/xssnick/tonutils-go/tl"
+	"time"
+)
+
+// StandbySyncInterval - how often standby node asks primary for its bags, it is also a heartbeat
+var StandbySyncInterval = 10 * time.Second
+
+// StandbySync - standby node asks primary for bags which it is seeding, sent wrapped to overlay of primary id
+type StandbySync struct{}
+
+// StandbyState - bags seeded by primary node, secrets of private bags are included, so standby can serve them
+type StandbyState struct {
+	Bags []StandbyBag `tl:"vector struct"`
+}
+
+type StandbyBag struct {
+	BagID       []byte `tl:"int256"`
+	SwarmSecret []byte `tl:"bytes"`
+}
+
+// StandbyHandler - applies state of primary node on standby node
+type StandbyHandler interface {
+	// MirrorBags - called after each successful sync with bags seeded by primary, they should be downloaded,
+	// but seeded only when failover is active
+	MirrorBags(bags []StandbyBag)
+	// Failover - called with true when primary is not answering during heartbeat timeout,
+	// mirrored bags should be seeded, and with false when primary is back
+	Failover(active bool)
+}
+
+// SetStandbyNodes - allows nodes with the given ADNL ids to mirror our bags as warm standby
+func (s *Server) SetStandbyNodes(ids [][]byte) {
+	list := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		list[string(id)] = true
+	}
+
+	s.mx.Lock()
+	s.standbyNodes = list
+	s.mx.Unlock()
+}
+
+// StartStandby - makes us warm standby of primary node: its bags are synced and mirrored using handler,
+// when primary is not answering for heartbeatTimeout, failover is activated till it is back.
+func (s *Server) StartStandby(primaryKey ed25519.PublicKey, addr string, heartbeatTimeout time.Duration, handler StandbyHandler) error {
+	// address of primary is pinned, it also makes it preferred peer to download from
+	if err := s.AddStaticPeer(primaryKey, addr); err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go s.standbyWorker(primaryKey, addr, heartbeatTimeout, handler)
+	return nil
+}
+
+func (s *Server) standbyWorker(primaryKey ed25519.PublicKey, addr string, heartbeatTimeout time.Duration, handler StandbyHandler) {
+	defer s.wg.Done()
+
+	lastSeen := time.Now()
+	failover := false
+	for {
+		select {
+		case <-s.closeCtx.Done():
+			return
+		case <-time.After(StandbySyncInterval):
+		}
+
+		ctx, cancel := context.WithTimeout(s.closeCtx, StandbySyncInterval)
+		state, err := s.syncStandby(ctx, primaryKey, addr)
+		cancel()
+		if err != nil {
+			Log.Warn("primary is not answering", compStandby, ErrAttr(err))
+
+			if !failover && time.Since(lastSeen) > heartbeatTimeout {
+				failover = true
+				Log.Warn("primary is down, failover, serving its bags", compStandby, "down", time.Since(lastSeen))
+				handler.Failover(true)
+			}
+			continue
+		}
+
+		lastSeen = time.Now()
+		handler.MirrorBags(state.Bags)
+		if failover {
+			failover = false
+			Log.Info("primary is back, stopped serving its bags", compStandby)
+			handler.Failover(false)
+		}
+	}
+}
+
+func (s *Server) syncStandby(ctx context.Context, primaryKey ed25519.PublicKey, addr string) (*StandbyState, error) {
+	var res StandbyState
+	if err := s.queryNode(ctx, primaryKey, addr, &StandbySync{}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// queryNode - sends query wrapped to overlay of node id, connection is reused when node is already connected
+func (s *Server) queryNode(ctx context.Context, key ed25519.PublicKey, addr string, req, res tl.Serializable) error {
+	id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: key})
+	if err != nil {
+		return err
+	}
+
+	peer := s.GetPeerIfActive(id)
+	if peer == nil {
+		ax, err := s.gate.RegisterClient(addr, key)
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		peer = s.bootstrapPeer(ax)
+	}
+	return peer.adnl.Query(ctx, overlay.WrapQuery(id, req), res)
+}
+
+func (s *Server) handleStandbySync(peer *overlay.ADNLWrapper, query *adnl.MessageQuery) error {
+	s.mx.RLock()
+	allowed := s.standbyNodes[string(peer.GetID())]
+	s.mx.RUnlock()
+
+	if !allowed {
+		Log.Warn("rejected sync of node which is not standby", compStandby, PeerAttr(peer.GetID()))
+		return fmt.Errorf("standby is not allowed")
+	}
+
+	res := StandbyState{Bags: []StandbyBag{}}
+	for _, t := range s.store.GetAll() {
+		if _, upl := t.IsActive(); !upl {
+			continue
+		}
+		res.Bags = append(res.Bags, StandbyBag{
+			BagID:       t.BagID,
+			SwarmSecret: t.GetSwarmSecret(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(s.closeCtx, 3*time.Second)
+	defer cancel()
+	return peer.Answer(ctx, query.ID, res)
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/xssnick/tonutils-go/adnl"
+	"net"
+	"time"
+)
+
+type staticPeer struct {
+	addr   string
+	key    ed25519.PublicKey
+	pinned bool
+}
+
+// AddStaticPeer - pins address of the node with the given public key,
+// DHT address resolution will be skipped for it, useful for private swarms between known servers.
+func (s *Server) AddStaticPeer(key ed25519.PublicKey, addr string) error {
+	return s.addStaticPeer(key, addr, false)
+}
+
+// PinStaticPeer - same as AddStaticPeer, but also pins the key to the address: connections from and to
+// this address with any other key are refused, so replication link cannot be taken over by other node,
+// for example when DHT or address cache is poisoned.
+func (s *Server) PinStaticPeer(key ed25519.PublicKey, addr string) error {
+	return s.addStaticPeer(key, addr, true)
+}
+
+func (s *Server) addStaticPeer(key ed25519.PublicKey, addr string, pinned bool) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size")
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+
+	id, err := adnl.ToKeyID(adnl.PublicKeyED25519{Key: key})
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	s.staticPeers[hex.EncodeToString(id)] = &staticPeer{
+		addr:   addr,
+		key:    key,
+		pinned: pinned,
+	}
+	s.mx.Unlock()
+	return nil
+}
+
+func (s *Server) RemoveStaticPeer(adnlID []byte) {
+	s.mx.Lock()
+	delete(s.staticPeers, hex.EncodeToString(adnlID))
+	s.mx.Unlock()
+}
+
+func (s *Server) getStaticPeer(adnlID []byte) *staticPeer {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.staticPeers[hex.EncodeToString(adnlID)]
+}
+
+// checkPinnedKey - returns error when address is pinned to the key of another node
+func (s *Server) checkPinnedKey(addr string, adnlID []byte) error {
+	id := hex.EncodeToString(adnlID)
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	for pinnedID, sp := range s.staticPeers {
+		if sp.pinned && sp.addr == addr && pinnedID != id {
+			return fmt.Errorf("address %s is pinned to node %s, but key of node %s is used", addr, pinnedID, id)
+		}
+	}
+	return nil
+}
+
+// resolveNodeAddress - returns address and key of the node, pinned static records are preferred,
+// then addresses cached by storage, then DHT. Cached is true when address is taken from cache.
+func (s *Server) resolveNodeAddress(ctx context.Context, adnlID []byte) (addr string, key ed25519.PublicKey, cached bool, err error) {
+	if sp := s.getStaticPeer(adnlID); sp != nil {
+		Log.Debug("using static address of node", compStorage, PeerAttr(adnlID), "addr", sp.addr)
+		return sp.addr, sp.key, false, nil
+	}
+
+	cache := s.addressCache()
+	if cache != nil {
+		if addr, key, ok := cache.GetNodeAddress(adnlID); ok {
+			Log.Debug("using cached address of node", compStorage, PeerAttr(adnlID), "addr", addr)
+			return addr, key, true, nil
+		}
+	}
+
+	lcCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	addrs, keyN, err := s.dht.FindAddresses(lcCtx, adnlID)
+	cancel()
+	s.countDHT(DHTQueryFindAddresses, err)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if len(addrs.Addresses) == 0 {
+		return "", nil, false, fmt.Errorf("node has no addresses")
+	}
+	addr = net.JoinHostPort(addrs.Addresses[0].IP.String(), fmt.Sprint(addrs.Addresses[0].Port))
+
+	if cache != nil {
+		expireAt := time.Now().Add(AddressCacheTTL)
+		if addrs.ExpireAt > 0 && time.Unix(int64(addrs.ExpireAt), 0).Before(expireAt) {
+			expireAt = time.Unix(int64(addrs.ExpireAt), 0)
+		}
+		if err = cache.SetNodeAddress(adnlID, addr, keyN, expireAt); err != nil {
+			Log.Warn("failed to cache address of node", compStorage, PeerAttr(adnlID), ErrAttr(err))
+		}
+	}
+	return addr, keyN, false, nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"time"
+)
+
+// TorrentStats - snapshot of bag download progress, for progress bars and other frontends
+type TorrentStats struct {
+	// HeaderLoaded - false when bag info and header are not yet fetched from peers,
+	// sizes are unknown in this case
+	HeaderLoaded bool
+	// Size - bytes to download, only active files are counted when not all bag is downloading
+	Size       uint64
+	Downloaded uint64
+	// Progress - percent of downloaded bytes, from 0 to 100
+	Progress      float64
+	Completed     bool
+	Peers         int
+	DownloadSpeed uint64
+	UploadSpeed   uint64
+	// ETA - estimated time till download is completed, 0 when completed or when there is no download speed
+	ETA time.Duration
+}
+
+// Stats - returns progress of the bag download
+func (t *Torrent) Stats() TorrentStats {
+	var st TorrentStats
+	for _, p := range t.GetPeers() {
+		st.Peers++
+		st.DownloadSpeed += p.GetDownloadSpeed()
+		st.UploadSpeed += p.GetUploadSpeed()
+	}
+
+	if t.Info == nil || t.Header == nil {
+		return st
+	}
+	st.HeaderLoaded = true
+
+	mask := t.PiecesMask()
+	if t.IsDownloadAll() {
+		st.Size, st.Downloaded = t.rangeProgress(mask, t.Info.HeaderSize, t.Info.FileSize)
+	} else {
+		for _, id := range t.GetActiveFilesIDs() {
+			f, err := t.GetFileOffsetsByID(id)
+			if err != nil {
+				continue
+			}
+			from := uint64(f.FromPiece)*uint64(t.Info.PieceSize) + uint64(f.FromPieceOffset)
+			sz, have := t.rangeProgress(mask, from, from+f.Size)
+			st.Size += sz
+			st.Downloaded += have
+		}
+	}
+
+	st.Completed = st.Downloaded == st.Size
+	if st.Size > 0 {
+		st.Progress = float64(st.Downloaded) * 100 / float64(st.Size)
+	} else {
+		st.Progress = 100
+	}
+
+	if !st.Completed && st.DownloadSpeed > 0 {
+		st.ETA = time.Duration((st.Size-st.Downloaded)/st.DownloadSpeed) * time.Second
+	}
+	return st
+}
+
+// rangeProgress - calculates size of bag data range [from, to) and how many bytes of it are in downloaded pieces
+func (t *Torrent) rangeProgress(mask []byte, from, to uint64) (size, downloaded uint64) {
+	if to <= from {
+		return 0, 0
+	}
+
+	pieceSize := uint64(t.Info.PieceSize)
+	for p := from / pieceSize; p*pieceSize < to; p++ {
+		if int(p/8) >= len(mask) || mask[p/8]&(1<<(p%8)) == 0 {
+			continue
+		}
+
+		start, end := p*pieceSize, (p+1)*pieceSize
+		if start < from {
+			start = from
+		}
+		if end > to {
+			end = to
+		}
+		downloaded += end - start
+	}
+	return to - from, downloaded
+}
@@ -20,6 +20,22 @@ func init() {
 	tl.Register(UpdateHavePieces{}, "storage.updateHavePieces piece_id:(vector int) = storage.Update")
 	tl.Register(UpdateState{}, "storage.updateState state:storage.State = storage.Update")
 	tl.Register(Ok{}, "storage.ok = Ok")
+	tl.Register(PrivateAuth{}, "storage.privateAuth proof:int256 = Ok")
+	tl.Register(CancelPieces{}, "storage.cancelPieces piece_ids:(vector int) = Ok")
+	tl.Register(GetClientInfo{}, "storage.getClientInfo = storage.ClientInfo")
+	tl.Register(ClientInfo{}, "storage.clientInfo name:string = storage.ClientInfo")
+	tl.Register(GetAnnounceData{}, "storage.getAnnounceData = storage.AnnounceData")
+	tl.Register(AnnounceData{}, "storage.announceData data:bytes = storage.AnnounceData")
+	tl.Register(BandwidthReceipt{}, "storage.bandwidthReceipt bag_id:int256 seeder:int256 downloader:int256 session_id:long bytes:long created_at:int = storage.BandwidthReceipt")
+	tl.Register(SignedBandwidthReceipt{}, "storage.signedBandwidthReceipt receipt:storage.BandwidthReceipt signature:bytes = Ok")
+	tl.Register(RelayRegister{}, "storage.relayRegister bag_id:int256 = Ok")
+	tl.Register(PushBag{}, "storage.pushBag bag_id:int256 = Ok")
+	tl.Register(StandbySync{}, "storage.standbySync = storage.StandbyState")
+	tl.Register(StandbyBag{}, "storage.standbyBag bag_id:int256 swarm_secret:bytes = storage.StandbyBag")
+	tl.Register(StandbyState{}, "storage.standbyState bags:(vector storage.standbyBag) = storage.StandbyState")
+	tl.Register(CoSeedGetCatalog{}, "storage.coSeedGetCatalog = storage.CoSeedCatalog")
+	tl.Register(CoSeedBag{}, "storage.coSeedBag bag_id:int256 size:long description:string = storage.CoSeedBag")
+	tl.Register(CoSeedCatalog{}, "storage.coSeedCatalog bags:(vector storage.coSeedBag) = storage.CoSeedCatalog")
 
 	tl.Register(FECInfoNone{}, "fec_info_none#c82a1964 = FecInfo")
 	tl.Register(TorrentHeader{}, "torrent_header#9128aab7 files_count:uint32 "+
@@ -78,6 +94,29 @@ type UpdateState struct {
 
 type Ok struct{}
 
+type CancelPieces struct {
+	PieceIDs []int32 `tl:"vector int"`
+}
+
+type GetClientInfo struct{}
+
+// ClientInfo - name and version of peer's implementation
+type ClientInfo struct {
+	Name string `tl:"string"`
+}
+
+type GetAnnounceData struct{}
+
+// AnnounceData - application-defined data of the bag, set by node which is serving it
+type AnnounceData struct {
+	Data []byte `tl:"bytes"`
+}
+
+// PrivateAuth - proof of swarm secret knowledge, peers of private bags should send it before any other query
+type PrivateAuth struct {
+	Proof []byte `tl:"int256"`
+}
+
 type FECInfoNone struct{}
 
 type TorrentHeader struct {
@@ -0,0 +1,28 @@
+// Package storagetest - helpers for tests of packages which are using storage
+package storagetest
+
+import (
+	"context"
+	"crypto/ed25519"
+
+	"github.com/xssnick/tonutils-storage/storage"
+)
+
+// Server - torrent server without network, bags can be created and stored, but peers are never found
+type Server struct{}
+
+func (Server) StartPeerSearcher(t *storage.Torrent) {}
+func (Server) AddPeer(ctx context.Context, t *storage.Torrent, addr string, adnlID []byte) error {
+	return nil
+}
+func (Server) AddStaticPeer(key ed25519.PublicKey, addr string) error { return nil }
+func (Server) BanNode(adnlID []byte) error                            { return nil }
+func (Server) UnbanNode(adnlID []byte) error                          { return nil }
+func (Server) GetBannedNodes() [][]byte                               { return nil }
+func (Server) Reannounce(ctx context.Context, t *storage.Torrent) error {
+	return nil
+}
+func (Server) GetAnnounceStatus(bagId []byte) (storage.AnnounceStatus, bool) {
+	return storage.AnnounceStatus{}, false
+}
+func (Server) GetDHTNodesNum() int { return 0 }
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"github.com/xssnick/tonutils-go/adnl/overlay"
-	"github.com/xssnick/tonutils-go/tl"
 	"io"
 	"sync"
 	"time"
@@ -55,6 +54,8 @@ type Storage interface {
 	RemovePiece(bagId []byte, id uint32) error
 	SetPiece(bagId []byte, id uint32, p *PieceInfo) error
 	PiecesMask(bagId []byte, num uint32) []byte
+	// AcquireDownloadSlot - waits till bag can download its pieces, release should be called after download
+	AcquireDownloadSlot(ctx context.Context, t *Torrent) (release func(), err error)
 }
 
 type NetConnector interface {
@@ -64,6 +65,10 @@ type NetConnector interface {
 	GetDownloadLimit() uint64
 	ThrottleDownload(ctx context.Context, sz uint64) error
 	ThrottleUpload(ctx context.Context, sz uint64) error
+	AcquireDownloadMemory(ctx context.Context, sz uint64) error
+	ReleaseDownloadMemory(sz uint64)
+	GetHooks() Hooks
+	WrapPieceHandler(h PieceHandler) PieceHandler
 	CreateDownloader(ctx context.Context, t *Torrent, desiredMinPeersNum, threadsPerPeer int) (_ TorrentDownloader, err error)
 	TorrentServer
 }
@@ -75,32 +80,96 @@ type Torrent struct {
 	Header    *TorrentHeader
 	CreatedAt time.Time
 
+	// ExpiresAt - when not zero, bag will be stopped at this time, or removed if RemoveOnExpire is set
+	ExpiresAt           time.Time
+	RemoveOnExpire      bool
+	RemoveFilesOnExpire bool
+
 	activeFiles     []uint32
 	activeUpload    bool
 	downloadAll     bool
 	downloadOrdered bool
+	sequential      bool
+	checkExisting   bool
+	announceData    []byte
+
+	// concurrency - own download parallelism of bag, zero fields are defaults
+	concurrency DownloadConcurrency
 
 	connector  NetConnector
 	downloader TorrentDownloader
 
 	knownNodes map[string]*overlay.Node
 	peers      map[string]*PeerInfo
-	peersMx    sync.RWMutex
+	// receipts - the latest bandwidth receipts of peer sessions, by peer id and session
+	receipts map[string]*SignedBandwidthReceipt
+	peersMx  sync.RWMutex
+
+	// bannedPeers - peers which sent forged pieces, with time till they are ignored
+	bannedPeers map[string]time.Time
 
 	memCache map[uint32]*Piece
-	db       Storage
+	cacheMx  sync.RWMutex
+	// hotPieces - number of first pieces which are preloaded to memory cache, 0 = bag is not hot
+	hotPieces uint32
+	// announceInterval - own interval of DHT re-announce, 0 = AnnounceInterval
+	announceInterval time.Duration
+	db               Storage
 
 	globalCtx context.Context
 	pause     func()
 
 	filesIndex map[string]uint32
+	localNames []string
+	// sourceNames - names of files of created bag on disk, when some of them differ from names in header after normalization
+	sourceNames []string
+
+	swarmSecret []byte
+	// allowedPeers - ADNL ids of nodes which can access bag, nil = all
+	allowedPeers map[string]bool
+	// seedOnly - missing pieces are not downloaded, bag is only seeded
+	seedOnly bool
+	// priority - position in download queue, bags with higher priority are downloaded first
+	priority int
+	// unlisted - bag is not announced in DHT
+	unlisted   bool
+	pieceStore string
 
 	pieceMask []byte
 
+	sessionDownloaded uint64
+	sessionUploaded   uint64
+
+	downloadLimit *speedLimit
+	uploadLimit   *speedLimit
+
+	// lastErr - reason why bag was stopped by storage itself
+	lastErr  error
+	diskId   string
+	diskOnce sync.Once
+
+	// quarantine - reason why bag is stopped till operator confirmation
+	quarantine         string
+	quarantineReleased bool
+	verifyFailures     uint32
+
+	events    []BagEvent
+	eventsOff int
+	eventsMx  sync.Mutex
+
+	// progressAt - unix nano time of the last download progress, 0 when nothing is downloading
+	progressAt int64
+	restarts   uint32
+
 	mx sync.Mutex
 
 	currentDownloadFlag *bool
 	stopDownload        func()
+
+	// wg - tracks background routines of the bag, to wait for them on shutdown
+	wg sync.WaitGroup
+	// spawnMx - orders starts of peers routines with StopAndWait, so they are not added to wg while it is awaited
+	spawnMx sync.Mutex
 }
 
 var fs = NewFSController()
@@ -114,22 +183,23 @@ func (t *Torrent) GetConnector() NetConnector {
 }
 
 func (t *Torrent) BuildCache(cachePiecesNum int) error {
-	t.memCache = map[uint32]*Piece{}
-
 	num := t.PiecesNum()
 	if cachePiecesNum > int(num) {
 		cachePiecesNum = int(num)
 	}
 
-	t.memCache = map[uint32]*Piece{}
+	cache := map[uint32]*Piece{}
 	for i := 0; i < cachePiecesNum; i++ {
-		p, err := t.getPieceInternal(uint32(i))
+		p, err := t.readPiece(uint32(i))
 		if err != nil {
 			return err
 		}
-		t.memCache[uint32(i)] = p
+		cache[uint32(i)] = p
 	}
 
+	t.cacheMx.Lock()
+	t.memCache = cache
+	t.cacheMx.Unlock()
 	return nil
 }
 
@@ -142,6 +212,12 @@ func NewTorrent(path string, db Storage, connector NetConnector) *Torrent {
 		knownNodes: map[string]*overlay.Node{},
 		db:         db,
 		connector:  connector,
+
+		downloadLimit: &speedLimit{},
+		uploadLimit:   &speedLimit{},
+	}
+	if DownloadPieceStore != DefaultPieceStore {
+		t.pieceStore = DownloadPieceStore
 	}
 
 	// create as stopped
@@ -168,12 +244,67 @@ func (t *Torrent) IsActive() (activeDownload, activeUpload bool) {
 	}
 }
 
+// Stop - stops bag, it is not blocking, background routines are finishing asynchronously.
+// Upload and download settings are kept, so bag can be continued with Resume.
 func (t *Torrent) Stop() {
-	t.activeUpload = false
 	t.pause()
 }
 
+// Resume - starts stopped bag with the same settings which it had before stop
+func (t *Torrent) Resume() error {
+	return t.Start(t.activeUpload, t.downloadAll, t.downloadOrdered)
+}
+
+// SetStartOptions - sets settings which will be used by Resume, for bags which are loaded as stopped
+func (t *Torrent) SetStartOptions(withUpload, downloadAll, downloadOrdered bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.activeUpload = withUpload
+	t.downloadAll = downloadAll
+	t.downloadOrdered = downloadOrdered
+}
+
+// IsUploadEnabled - returns true when bag is seeding or will seed after Resume
+func (t *Torrent) IsUploadEnabled() bool {
+	return t.activeUpload
+}
+
+// StopAndWait - stops bag and waits till its peers searcher, monitor, download and peers connections are finished.
+// Should not be called from bag callbacks.
+func (t *Torrent) StopAndWait() {
+	t.Stop()
+	// peers routines are not started after stop, wait for ones which are starting right now
+	t.spawnMx.Lock()
+	t.spawnMx.Unlock()
+	t.wg.Wait()
+}
+
+// spawn - starts routine of peer connection which is tracked in wg, so StopAndWait waits for it too.
+// Routine is not started when ctx is already done, false is returned then.
+func (t *Torrent) spawn(ctx context.Context, f func()) bool {
+	t.spawnMx.Lock()
+	defer t.spawnMx.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		f()
+	}()
+	return true
+}
+
 func (t *Torrent) Start(withUpload, downloadAll, downloadOrdered bool) (err error) {
+	return t.StartWithContext(context.Background(), withUpload, downloadAll, downloadOrdered)
+}
+
+// StartWithContext - same as Start, but bag will be stopped when ctx is done,
+// with all its peers, downloads and announces
+func (t *Torrent) StartWithContext(ctx context.Context, withUpload, downloadAll, downloadOrdered bool) (err error) {
 	t.activeUpload = withUpload
 
 	t.mx.Lock()
@@ -185,18 +316,27 @@ func (t *Torrent) Start(withUpload, downloadAll, downloadOrdered bool) (err erro
 	if d, _ := t.IsActive(); d {
 		return nil
 	}
-
-	t.globalCtx, t.pause = context.WithCancel(context.Background())
-	go t.runPeersMonitor()
-	go t.connector.StartPeerSearcher(t)
-
-	currFlag := t.currentDownloadFlag
-	currPause := t.pause
-	return t.startDownload(func(event Event) {
-		if event.Name == EventErr && currFlag == t.currentDownloadFlag {
-			currPause()
-		}
-	})
+	if t.quarantine != "" {
+		return fmt.Errorf("bag is quarantined: %s, it should be released to start", t.quarantine)
+	}
+	t.resetDiskHealth()
+
+	t.globalCtx, t.pause = context.WithCancel(ctx)
+	t.wg.Add(3)
+	go func() {
+		defer t.wg.Done()
+		t.runPeersMonitor()
+	}()
+	go func() {
+		defer t.wg.Done()
+		t.connector.StartPeerSearcher(t)
+	}()
+	go func(ctx context.Context) {
+		defer t.wg.Done()
+		t.runWatchdog(ctx)
+	}(t.globalCtx)
+
+	return t.startDownload(t.stopOnError())
 }
 
 func (t *Torrent) PiecesNum() uint32 {
@@ -215,6 +355,10 @@ func (t *Torrent) removePiece(id uint32) error {
 	i := id / 8
 	y := id % 8
 	t.pieceMask[i] &= ^(1 << y)
+
+	t.cacheMx.Lock()
+	delete(t.memCache, id)
+	t.cacheMx.Unlock()
 	return t.db.RemovePiece(t.BagID, id)
 }
 
@@ -252,13 +396,16 @@ func (t *Torrent) SetActiveFilesIDs(ids []uint32) error {
 
 	t.downloadAll = false
 	t.activeFiles = ids
-	currFlag := t.currentDownloadFlag
-	currPause := t.pause
-	return t.startDownload(func(event Event) {
-		if event.Name == EventErr && currFlag == t.currentDownloadFlag {
-			currPause()
-		}
-	})
+	return t.startDownload(t.stopOnError())
+}
+
+// SetDownloadAll - switches bag to download all files, after download of only some of them
+func (t *Torrent) SetDownloadAll() error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.downloadAll = true
+	return t.startDownload(t.stopOnError())
 }
 
 func (t *Torrent) SetActiveFiles(names []string) error {
@@ -287,13 +434,17 @@ func (t *Torrent) GetPiece(id uint32) (*Piece, error) {
 }
 
 func (t *Torrent) getPieceInternal(id uint32) (*Piece, error) {
-	if t.memCache != nil {
-		p := t.memCache[id]
-		if p != nil {
-			return p, nil
-		}
+	t.cacheMx.RLock()
+	p := t.memCache[id]
+	t.cacheMx.RUnlock()
+	if p != nil {
+		return p, nil
 	}
+	return t.readPiece(id)
+}
 
+// readPiece - reads piece from its store, bypassing memory cache
+func (t *Torrent) readPiece(id uint32) (*Piece, error) {
 	if id >= t.PiecesNum() {
 		return nil, fmt.Errorf("piece %d not found, pieces count: %d", id, t.PiecesNum())
 	}
@@ -303,66 +454,14 @@ func (t *Torrent) getPieceInternal(id uint32) (*Piece, error) {
 		return nil, fmt.Errorf("piece %d is not downlaoded (%w)", id, err)
 	}
 
-	offset := 0
-	block := make([]byte, t.Info.PieceSize)
-
-	fileFrom := piece.StartFileIndex
-	for {
-		isHdr := t.Info.HeaderSize > uint64(id)*uint64(t.Info.PieceSize)+uint64(offset)
-
-		// header
-		if isHdr {
-			headerData, err := tl.Serialize(t.Header, true)
-			if err != nil {
-				return nil, fmt.Errorf("failed to serialize header: %w", err)
-			}
-			offset += copy(block[offset:], headerData[id*t.Info.PieceSize:])
-		} else {
-			f, err := t.GetFileOffsetsByID(fileFrom)
-			if err != nil {
-				return nil, fmt.Errorf("offsets for %d %d are not exists (%w)", id, fileFrom, err)
-			}
-
-			path := t.Path + "/" + string(t.Header.DirName) + "/" + f.Name
-			read := func(path string, from int64) error {
-				fd, err := fs.Acquire(path)
-				if err != nil {
-					return err
-				}
-				defer fd.Free()
-
-				n, err := fd.Get().ReadAt(block[offset:], from)
-				if err != nil && err != io.EOF {
-					return err
-				}
-
-				offset += n
-				return nil
-			}
-
-			fileOff := uint32(0)
-			if f.FromPiece != id {
-				fileOff = (id-f.FromPiece)*t.Info.PieceSize - f.FromPieceOffset
-			}
-			err = read(path, int64(fileOff))
-			if err != nil {
-				return nil, err
-			}
-			fileFrom++
-
-			if fileFrom >= uint32(len(t.Header.DataIndex)) {
-				// end reached
-				break
-			}
-		}
-
-		if offset == int(t.Info.PieceSize) {
-			break
-		}
+	store := t.GetPieceStore()
+	if store == nil {
+		return nil, fmt.Errorf("piece store %q is not registered", t.pieceStore)
 	}
 
-	if offset > 0 {
-		block = block[:offset]
+	block, err := store.ReadPiece(t, id)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Piece{
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waitGoroutines - waits till number of goroutines is back to n, finished routines may exit with a small delay
+func waitGoroutines(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("leaked goroutines: %d, expected %d\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTorrent_StopAndWaitPeerRoutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	tor := NewTorrent("", nil, nil)
+	tor.globalCtx, tor.pause = context.WithCancel(context.Background())
+
+	// the same way as peer connection, its context is derived from bag's one
+	peerCtx, closePeer := context.WithCancel(tor.globalCtx)
+	defer closePeer()
+
+	started := make(chan struct{}, 10)
+	for i := 0; i < 10; i++ {
+		if !tor.spawn(peerCtx, func() {
+			started <- struct{}{}
+			<-peerCtx.Done()
+		}) {
+			t.Fatal("routine is not started for active bag")
+		}
+	}
+	for i := 0; i < 10; i++ {
+		<-started
+	}
+
+	tor.StopAndWait()
+	waitGoroutines(t, before)
+
+	if tor.spawn(peerCtx, func() {}) {
+		t.Fatal("routine is started for stopped bag")
+	}
+}
+
+func TestTorrent_StopAndWaitConcurrentSpawn(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	tor := NewTorrent("", nil, nil)
+	tor.globalCtx, tor.pause = context.WithCancel(context.Background())
+	ctx := tor.globalCtx
+
+	// peers are connected while bag is stopping, none of their routines should survive it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for tor.spawn(ctx, func() {
+			<-ctx.Done()
+		}) {
+		}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	tor.StopAndWait()
+	<-done
+	waitGoroutines(t, before)
+}
@@ -0,0 +1,34 @@
+package storage
+
+// SetSeedOnly - in seed-only mode bag is only uploaded from stored data, missing pieces are never downloaded,
+// only header is fetched when it is not known yet. When bag is downloading, download is restarted.
+func (t *Torrent) SetSeedOnly(seedOnly bool) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.seedOnly == seedOnly {
+		return nil
+	}
+	t.seedOnly = seedOnly
+
+	if active, _ := t.IsActive(); !active || t.stopDownload == nil {
+		return nil
+	}
+	return t.startDownload(t.stopOnError())
+}
+
+func (t *Torrent) IsSeedOnly() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.seedOnly
+}
+
+// SetUploadEnabled - turns serving of pieces to peers on or off, disabled upload means download-only mode.
+// It is applied to active bag immediately and kept for Resume.
+func (t *Torrent) SetUploadEnabled(enabled bool) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	t.activeUpload = enabled
+}
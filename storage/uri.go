@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const URIScheme = "tonstorage"
+
+// BagURI - shareable link to bag: tonstorage://<bag_id>?files=0,2,5&name=Some%20Name
+// Files is optional selection of files to download, Name is only human-readable label of the link.
+type BagURI struct {
+	BagID []byte
+	Files []uint32
+	Name  string
+}
+
+// ParseBagURI - parses link or plain hex bag id
+func ParseBagURI(s string) (*BagURI, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToLower(s), URIScheme+"://") {
+		bag, err := parseBagID(s)
+		if err != nil {
+			return nil, err
+		}
+		return &BagURI{BagID: bag}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uri: %w", err)
+	}
+
+	// bag id is in host part, but accept it in path too, for links like tonstorage:///<bag_id>
+	id := u.Host
+	if id == "" {
+		id = strings.Trim(u.Path, "/")
+	}
+
+	bag, err := parseBagID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &BagURI{
+		BagID: bag,
+		Name:  u.Query().Get("name"),
+	}
+
+	if files := u.Query().Get("files"); files != "" {
+		for _, f := range strings.Split(files, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(f), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file index %q in uri", f)
+			}
+			res.Files = append(res.Files, uint32(id))
+		}
+	}
+	return res, nil
+}
+
+func (b *BagURI) String() string {
+	q := url.Values{}
+	if len(b.Files) > 0 {
+		files := make([]string, 0, len(b.Files))
+		for _, f := range b.Files {
+			files = append(files, strconv.FormatUint(uint64(f), 10))
+		}
+		q.Set("files", strings.Join(files, ","))
+	}
+	if b.Name != "" {
+		q.Set("name", b.Name)
+	}
+
+	u := url.URL{
+		Scheme:   URIScheme,
+		Host:     hex.EncodeToString(b.BagID),
+		RawQuery: q.Encode(),
+	}
+	// keep commas readable
+	return strings.ReplaceAll(u.String(), "%2C", ",")
+}
+
+func parseBagID(s string) ([]byte, error) {
+	bag, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bag id: %w", err)
+	}
+	if len(bag) != 32 {
+		return nil, fmt.Errorf("invalid bag id: should be 32 bytes hex")
+	}
+	return bag, nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/xssnick/tonutils-go/tvm/cell"
+	"time"
+)
+
+// PeerBanDuration - for how long peer which sent piece with forged proof or data is ignored by bag
+var PeerBanDuration = 30 * time.Minute
+
+const banRecheckInterval = 10 * time.Second
+
+// ErrPieceRejected - piece data or proof is not matching bag, it is wrapped into verification errors
+var ErrPieceRejected = errors.New("piece rejected")
+
+// verifyPiece - the only place where pieces are checked against bag root hash,
+// it is used for pieces received from peers and for pieces stored on disk.
+// Piece index is checked to be in range, data size to match piece position in bag,
+// proof to be valid merkle proof of the root hash and its leaf to be a hash of data.
+func (t *Torrent) verifyPiece(id uint32, data, proofBoc []byte) error {
+	if t.Info == nil {
+		return fmt.Errorf("bag info is not loaded")
+	}
+
+	piecesNum := t.PiecesNum()
+	if id >= piecesNum {
+		return fmt.Errorf("%w: piece %d is out of range %d", ErrPieceRejected, id, piecesNum)
+	}
+
+	expectedSize := uint64(t.Info.PieceSize)
+	if id == piecesNum-1 {
+		if tail := t.Info.FileSize - uint64(id)*uint64(t.Info.PieceSize); tail < expectedSize {
+			expectedSize = tail
+		}
+	}
+	if uint64(len(data)) != expectedSize {
+		return fmt.Errorf("%w: piece %d size is %d, expected %d", ErrPieceRejected, id, len(data), expectedSize)
+	}
+
+	proof, err := cell.FromBOC(proofBoc)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse proof of piece %d: %v", ErrPieceRejected, id, err)
+	}
+
+	if err = cell.CheckProof(proof, t.Info.RootHash); err != nil {
+		return fmt.Errorf("%w: proof check of piece %d failed: %v", ErrPieceRejected, id, err)
+	}
+
+	if err = t.checkProofBranch(proof, data, id); err != nil {
+		return fmt.Errorf("%w: proof branch check of piece %d failed: %v", ErrPieceRejected, id, err)
+	}
+	return nil
+}
+
+// rejectPeerPiece - called when piece from peer failed verification, peer is banned,
+// and failure is counted for bag quarantine
+func (t *Torrent) rejectPeerPiece(id []byte, reason error) {
+	t.banPeer(id, reason)
+	t.countVerifyFailure()
+}
+
+// banPeer - stops using peer for the bag for PeerBanDuration
+func (t *Torrent) banPeer(id []byte, reason error) {
+	Log.Warn("banning peer", compStorage, BagAttr(t.BagID), PeerAttr(id), "reason", reason.Error())
+	t.logEvent("PEER_BANNED", anonymize(eventsKey, hex.EncodeToString(id)), " ", reason)
+
+	t.peersMx.Lock()
+	defer t.peersMx.Unlock()
+
+	if t.bannedPeers == nil {
+		t.bannedPeers = map[string]time.Time{}
+	}
+	t.bannedPeers[hex.EncodeToString(id)] = time.Now().Add(PeerBanDuration)
+}
+
+// peerBannedTill - returns time till peer is banned for the bag, zero when peer is not banned
+func (t *Torrent) peerBannedTill(id []byte) time.Time {
+	t.peersMx.Lock()
+	defer t.peersMx.Unlock()
+
+	strId := hex.EncodeToString(id)
+	till, ok := t.bannedPeers[strId]
+	if !ok {
+		return time.Time{}
+	}
+
+	if time.Now().After(till) {
+		delete(t.bannedPeers, strId)
+		return time.Time{}
+	}
+	return till
+}
+
+// Validate - re-reads all stored pieces of the bag and checks them against its merkle tree,
+// corrupted and unreadable pieces are marked as missing, and when bag is active they are downloaded again.
+// Returns ids of corrupted pieces.
+func (t *Torrent) Validate(ctx context.Context) ([]uint32, error) {
+	corrupted, _, err := t.ValidateFrom(ctx, 0, nil)
+	return corrupted, err
+}
+
+// ValidateFrom - same as Validate, but starts from the given piece and reports progress to callback, it can be nil.
+// Returns next piece to check, when validation is interrupted it can be continued from it.
+// Corrupted pieces found before interruption are marked as missing and downloaded again anyway.
+func (t *Torrent) ValidateFrom(ctx context.Context, from uint32, progressCallback func(checked, total uint32)) (corrupted []uint32, next uint32, err error) {
+	if t.Info == nil || t.Header == nil {
+		return nil, from, fmt.Errorf("bag header is not downloaded yet")
+	}
+
+	store := t.GetPieceStore()
+	if store == nil {
+		return nil, from, fmt.Errorf("piece store %q is not registered", t.pieceStore)
+	}
+
+	num := t.PiecesNum()
+	mask := t.PiecesMask()
+	next = from
+	for ; next < num; next++ {
+		if progressCallback != nil && next%64 == 0 {
+			progressCallback(next, num)
+		}
+
+		if mask[next/8]&(1<<(next%8)) == 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		default:
+		}
+		if err != nil {
+			break
+		}
+
+		if vErr := store.VerifyPiece(t, next); vErr != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+
+			Log.Warn("piece is corrupted", compStorage, BagAttr(t.BagID), "piece", next, ErrAttr(vErr))
+			if rErr := t.removePiece(next); rErr != nil {
+				return corrupted, next, fmt.Errorf("failed to mark piece %d as missing: %w", next, rErr)
+			}
+			corrupted = append(corrupted, next)
+		}
+	}
+	if progressCallback != nil && err == nil {
+		progressCallback(num, num)
+	}
+
+	if len(corrupted) > 0 {
+		t.logEvent("CORRUPTED_PIECES", len(corrupted))
+
+		t.mx.Lock()
+		defer t.mx.Unlock()
+
+		if active, _ := t.IsActive(); active {
+			if rErr := t.startDownload(t.stopOnError()); rErr != nil {
+				return corrupted, next, fmt.Errorf("failed to restart download: %w", rErr)
+			}
+		}
+	}
+	return corrupted, next, err
+}
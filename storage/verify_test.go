@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xssnick/tonutils-go/tvm/cell"
+)
+
+type testPiece struct {
+	data  []byte
+	proof []byte
+}
+
+// newTestBag - bag info with merkle tree of pieces of data, and proofs of each piece, as created by CreateTorrent
+func newTestBag(t *testing.T, pieceSize uint32, data []byte) (*Torrent, []testPiece) {
+	t.Helper()
+
+	var pieces []testPiece
+	var hashes [][]byte
+	for off := 0; off < len(data); off += int(pieceSize) {
+		end := off + int(pieceSize)
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha256.Sum256(data[off:end])
+		hashes = append(hashes, h[:])
+		pieces = append(pieces, testPiece{data: data[off:end]})
+	}
+
+	tor := NewTorrent("", nil, nil)
+	root := buildHashTree(hashes)
+	tor.Info = &TorrentInfo{
+		PieceSize: pieceSize,
+		FileSize:  uint64(len(data)),
+		RootHash:  root.Hash(),
+	}
+	for i := range pieces {
+		pieces[i].proof = tor.fastProof(root, uint32(i), uint32(len(pieces))).ToBOCWithFlags(false)
+	}
+	return tor, pieces
+}
+
+func testData(n int, seed byte) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7) + seed
+	}
+	return data
+}
+
+func TestTorrent_VerifyPiece(t *testing.T) {
+	// 6 pieces, the last one is not full
+	tor, pieces := newTestBag(t, 16, testData(16*5+7, 1))
+	_, otherPieces := newTestBag(t, 16, testData(16*5+7, 2))
+	_, single := newTestBag(t, 16, testData(16, 1))
+
+	flip := func(b []byte, i int) []byte {
+		b = append([]byte{}, b...)
+		b[i] ^= 0xFF
+		return b
+	}
+
+	tests := []struct {
+		name  string
+		id    uint32
+		data  []byte
+		proof []byte
+		valid bool
+	}{
+		{"first piece", 0, pieces[0].data, pieces[0].proof, true},
+		{"middle piece", 3, pieces[3].data, pieces[3].proof, true},
+		{"last not full piece", 5, pieces[5].data, pieces[5].proof, true},
+		{"index out of range", 6, pieces[5].data, pieces[5].proof, false},
+		{"max index", ^uint32(0), pieces[0].data, pieces[0].proof, false},
+		{"piece with proof of another index", 2, pieces[1].data, pieces[1].proof, false},
+		{"data of another index", 2, pieces[1].data, pieces[2].proof, false},
+		{"proof of another index", 2, pieces[2].data, pieces[1].proof, false},
+		{"truncated data", 1, pieces[1].data[:15], pieces[1].proof, false},
+		{"extended data", 1, append(append([]byte{}, pieces[1].data...), 0), pieces[1].proof, false},
+		{"extended last piece", 5, append(append([]byte{}, pieces[5].data...), 0), pieces[5].proof, false},
+		{"empty data", 0, nil, pieces[0].proof, false},
+		{"forged data", 1, flip(pieces[1].data, 3), pieces[1].proof, false},
+		{"truncated proof", 1, pieces[1].data, pieces[1].proof[:len(pieces[1].proof)/2], false},
+		{"empty proof", 1, pieces[1].data, nil, false},
+		{"forged proof", 1, pieces[1].data, flip(pieces[1].proof, len(pieces[1].proof)-1), false},
+		{"proof of another bag", 1, otherPieces[1].data, otherPieces[1].proof, false},
+		{"proof of another bag with our data", 1, pieces[1].data, otherPieces[1].proof, false},
+		{"proof of single piece bag", 0, pieces[0].data, single[0].proof, false},
+		{"not a proof", 1, pieces[1].data, cell.BeginCell().MustStoreUInt(1, 8).EndCell().ToBOCWithFlags(false), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tor.verifyPiece(tt.id, tt.data, tt.proof)
+			if tt.valid && err != nil {
+				t.Fatalf("valid piece rejected: %v", err)
+			}
+			if !tt.valid {
+				if err == nil {
+					t.Fatal("invalid piece accepted")
+				}
+				if !errors.Is(err, ErrPieceRejected) {
+					t.Fatalf("error is not ErrPieceRejected: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTorrent_VerifyPieceWithoutInfo(t *testing.T) {
+	tor := NewTorrent("", nil, nil)
+	err := tor.verifyPiece(0, []byte{1}, nil)
+	if err == nil {
+		t.Fatal("piece accepted without bag info")
+	}
+	if errors.Is(err, ErrPieceRejected) {
+		t.Fatal("bag without info should not ban peers")
+	}
+}
+
+// quarantineDB - saves nothing, counts saves of bag state
+type quarantineDB struct {
+	Storage
+	saves int
+}
+
+func (d *quarantineDB) SetTorrent(*Torrent) error {
+	d.saves++
+	return nil
+}
+
+func TestTorrent_RejectPeerPiece(t *testing.T) {
+	oldDuration, oldFailures := PeerBanDuration, QuarantineVerifyFailures
+	defer func() {
+		PeerBanDuration, QuarantineVerifyFailures = oldDuration, oldFailures
+	}()
+	PeerBanDuration = time.Hour
+	QuarantineVerifyFailures = 3
+
+	db := &quarantineDB{}
+	tor, pieces := newTestBag(t, 16, testData(64, 1))
+	tor.db = db
+
+	bad, good := []byte{1, 2, 3}, []byte{4, 5, 6}
+	err := tor.verifyPiece(0, pieces[1].data, pieces[1].proof)
+	if err == nil {
+		t.Fatal("invalid piece accepted")
+	}
+	tor.rejectPeerPiece(bad, err)
+
+	if till := tor.peerBannedTill(bad); time.Until(till) < 59*time.Minute {
+		t.Fatalf("peer is not banned for PeerBanDuration, till %v", till)
+	}
+	if !tor.peerBannedTill(good).IsZero() {
+		t.Fatal("other peer is banned")
+	}
+	if _, ok := tor.GetBannedPeers()[hex.EncodeToString(bad)]; !ok {
+		t.Fatal("banned peer is not listed")
+	}
+	if tor.GetQuarantineReason() != "" {
+		t.Fatal("bag is quarantined after single failure")
+	}
+
+	// many peers are sending bad pieces, something is wrong with bag itself
+	tor.rejectPeerPiece([]byte{7}, err)
+	tor.rejectPeerPiece([]byte{8}, err)
+	if tor.GetQuarantineReason() == "" {
+		t.Fatal("bag is not quarantined after QuarantineVerifyFailures")
+	}
+	if db.saves != 1 {
+		t.Fatalf("quarantine state is saved %d times, expected once", db.saves)
+	}
+}
+
+func TestTorrent_PeerBanExpires(t *testing.T) {
+	old := PeerBanDuration
+	defer func() {
+		PeerBanDuration = old
+	}()
+	PeerBanDuration = 20 * time.Millisecond
+
+	tor := NewTorrent("", nil, nil)
+	id := []byte{1, 2, 3}
+	tor.banPeer(id, ErrPieceRejected)
+	if tor.peerBannedTill(id).IsZero() {
+		t.Fatal("peer is not banned")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !tor.peerBannedTill(id).IsZero() {
+		t.Fatal("ban is not expired")
+	}
+	if len(tor.GetBannedPeers()) != 0 {
+		t.Fatal("expired ban is still listed")
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadStallTimeout - when bag has peers, but its download is not making any progress for this time,
+// watchdog restarts download routines of the bag. 0 = disabled.
+var DownloadStallTimeout = 2 * time.Minute
+
+var watchdogCheckInterval = 10 * time.Second
+
+// markProgress - notifies watchdog that download of the bag is alive
+func (t *Torrent) markProgress() {
+	atomic.StoreInt64(&t.progressAt, time.Now().UnixNano())
+}
+
+// GetRestartsNum - returns how many times watchdog restarted stalled download of the bag since process start
+func (t *Torrent) GetRestartsNum() uint32 {
+	return atomic.LoadUint32(&t.restarts)
+}
+
+// runWatchdog - checks that download of the bag is not stuck, it is running till bag is stopped.
+// Each bag has its own watchdog, so stuck bag is restarted without touching others.
+func (t *Torrent) runWatchdog(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchdogCheckInterval):
+		}
+
+		at := atomic.LoadInt64(&t.progressAt)
+		if at == 0 || DownloadStallTimeout == 0 {
+			// download is not active
+			continue
+		}
+
+		stalled := time.Since(time.Unix(0, at))
+		if stalled < DownloadStallTimeout {
+			continue
+		}
+
+		if len(t.GetPeers()) == 0 {
+			// nobody to download from, restart will not help
+			continue
+		}
+
+		Log.Warn("download is stalled, restarting it", compStorage, BagAttr(t.BagID), "stalled", stalled)
+
+		t.mx.Lock()
+		select {
+		case <-ctx.Done():
+			// stopped while we were checking
+			t.mx.Unlock()
+			return
+		default:
+		}
+		atomic.AddUint32(&t.restarts, 1)
+		t.logEvent("WATCHDOG_RESTART", "stalled for ", stalled.String())
+		err := t.startDownload(t.stopOnError())
+		t.mx.Unlock()
+		if err != nil {
+			Log.Error("failed to restart download", compStorage, BagAttr(t.BagID), ErrAttr(err))
+		}
+	}
+}
+
+// stopOnError - returns download events handler which stops bag when download failed
+func (t *Torrent) stopOnError() func(Event) {
+	currFlag := t.currentDownloadFlag
+	currPause := t.pause
+	return func(event Event) {
+		if event.Name == EventErr && currFlag == t.currentDownloadFlag {
+			currPause()
+		}
+	}
+}
+
+// recoverPanic - should be deferred in bag routines, so bug triggered by one bag
+// (broken files, hostile peer) is not taking down the whole process with other bags
+func (t *Torrent) recoverPanic(where string, report func(Event)) {
+	if r := recover(); r != nil {
+		Log.Error("panic", compStorage, BagAttr(t.BagID), "where", where, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+		if report != nil {
+			report(Event{Name: EventErr, Value: fmt.Errorf("panic in %s: %v", where, r)})
+		}
+	}
+}